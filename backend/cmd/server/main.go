@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -47,6 +48,11 @@ func main() {
 	defer db.Close()
 
 	h := api.NewHandler(db)
+	workers := 2
+	if n, err := strconv.Atoi(os.Getenv("JOB_WORKERS")); err == nil && n > 0 {
+		workers = n
+	}
+	h.StartWorkers(workers)
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -57,6 +63,7 @@ func main() {
 		Handler:           h.Routes(),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
+	srv.RegisterOnShutdown(h.CloseStreams)
 
 	go func() {
 		slog.Info("backend started", "addr", "http://localhost:"+port)
@@ -71,10 +78,14 @@ func main() {
 	<-stop
 
 	slog.Info("shutting down gracefully")
-	h.Stop() // stop rate-limiter cleanup goroutine
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		slog.Error("graceful shutdown failed", "err", err)
 	}
+	// Stop rate-limiter cleanup goroutines and job workers once no request
+	// can queue more work, within what is left of the same deadline.
+	if err := h.Stop(shutdownCtx); err != nil {
+		slog.Error("job workers did not stop in time", "err", err)
+	}
 }
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// aerobicBackfillBatch bounds how many older activities one trend request
+// computes aerobic metrics for.
+const aerobicBackfillBatch = 200
+
+// aerobicTrend serves the caller's efficiency factor and decoupling over
+// time. Only steady sessions are included, grouped into series by sport and
+// decoupling type so each series compares like with like. Optional filters:
+// sport, type (pa:hr | pw:hr) and from/to as YYYY-MM-DD.
+func (h *Handler) aerobicTrend(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	filter := store.AerobicFilter{
+		SportType:      strings.TrimSpace(q.Get("sport")),
+		DecouplingType: q.Get("type"),
+	}
+	if filter.DecouplingType != "" && filter.DecouplingType != metrics.DecouplingPaHr && filter.DecouplingType != metrics.DecouplingPwHr {
+		writeErr(w, http.StatusBadRequest, "type must be pa:hr or pw:hr")
+		return
+	}
+	var err error
+	if filter.From, filter.To, err = parseDateRange(q); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.backfillAerobicMetrics(r.Context(), user.ID); err != nil {
+		slog.Warn("failed to backfill aerobic metrics", "userID", user.ID, "err", err)
+	}
+	sessions, err := h.store.ListAerobicSessions(r.Context(), user.ID, filter)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to load sessions")
+		return
+	}
+
+	type series struct {
+		SportType             string                 `json:"sportType"`
+		DecouplingType        string                 `json:"decouplingType"`
+		EfficiencyFactorTrend float64                `json:"efficiencyFactorPerMonth"`
+		DecouplingTrend       float64                `json:"decouplingPctPerMonth"`
+		Sessions              []store.AerobicSession `json:"sessions"`
+	}
+	out := []*series{}
+	byKey := map[string]*series{}
+	for _, s := range sessions {
+		key := s.SportType + "|" + s.DecouplingType
+		sr := byKey[key]
+		if sr == nil {
+			sr = &series{SportType: s.SportType, DecouplingType: s.DecouplingType}
+			byKey[key] = sr
+			out = append(out, sr)
+		}
+		sr.Sessions = append(sr.Sessions, s)
+	}
+	for _, sr := range out {
+		var efDates, dcDates []time.Time
+		var efs, dcs []float64
+		for _, s := range sr.Sessions {
+			efDates = append(efDates, s.ActivityDate)
+			efs = append(efs, s.EfficiencyFactor)
+			if s.DecouplingPct != nil {
+				dcDates = append(dcDates, s.ActivityDate)
+				dcs = append(dcs, *s.DecouplingPct)
+			}
+		}
+		sr.EfficiencyFactorTrend = metrics.TrendPerMonth(efDates, efs)
+		sr.DecouplingTrend = metrics.TrendPerMonth(dcDates, dcs)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"series": out})
+}
+
+// backfillAerobicMetrics computes aerobic metrics for activities uploaded
+// before they existed.
+func (h *Handler) backfillAerobicMetrics(ctx context.Context, userID int64) error {
+	pending, err := h.store.ListAerobicPending(ctx, userID, aerobicBackfillBatch)
+	if err != nil {
+		return err
+	}
+	for _, a := range pending {
+		if err := h.store.SaveAerobicMetrics(ctx, a.ID, metrics.Compute(a.Points)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"gpx-training-analyzer/backend/internal/blob"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+const (
+	// maxBulkUploadBytes caps the request body of a bulk upload.
+	maxBulkUploadBytes = 512 << 20
+)
+
+// Outcomes of a file in a bulk upload or import.
+const (
+	importPending   = "pending"
+	importCreated   = "created"
+	importDuplicate = "duplicate"
+	importFailed    = "failed"
+)
+
+// importResult is the outcome of one file of a bulk upload or import.
+type importResult struct {
+	File       string `json:"file"`
+	Status     string `json:"status"` // pending | created | duplicate | failed
+	ActivityID int64  `json:"activityId,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// bulkUpload takes every file of a multipart upload: GPX, TCX and FIT files
+// in "files" (or "file"), zip archives of them and gzipped files. The files
+// are streamed to blob storage as one zip, which an import job unpacks,
+// queueing a job per activity file so that a bad file only fails itself; the
+// request answers 202 with the import, whose progress is read from getImport.
+func (h *Handler) bulkUpload(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkUploadBytes)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid multipart form")
+		return
+	}
+	key, err := newImportArchiveKey()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to store upload")
+		return
+	}
+	upload := newBulkUploadWriter(h.blobs, key)
+	var names []string
+	var sportType, visibility string
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			upload.abort(err)
+			writeErr(w, http.StatusBadRequest, "invalid multipart form or upload larger than 512 MB")
+			return
+		}
+		switch part.FormName() {
+		case "sportType":
+			v, _ := io.ReadAll(io.LimitReader(part, 64))
+			sportType = strings.TrimSpace(string(v))
+		case "visibility":
+			v, _ := io.ReadAll(io.LimitReader(part, 64))
+			visibility = strings.TrimSpace(string(v))
+		case "files", "file":
+			name := path.Base(part.FileName())
+			if name == "." || name == "/" {
+				name = "upload"
+			}
+			if err := upload.add(name, part); err != nil {
+				upload.abort(err)
+				writeErr(w, http.StatusBadRequest, "failed to read upload or upload larger than 512 MB")
+				return
+			}
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		upload.abort(errors.New("no files"))
+		writeErr(w, http.StatusBadRequest, "missing files field")
+		return
+	}
+	if visibility != "" && !store.ValidVisibility(visibility) {
+		upload.abort(errors.New("invalid visibility"))
+		writeErr(w, http.StatusBadRequest, "visibility must be one of private, followers, community, public")
+		return
+	}
+	if err := upload.close(); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to store upload")
+		return
+	}
+
+	name := names[0]
+	if len(names) > 1 {
+		name = strconv.Itoa(len(names)) + " files"
+	}
+	im, err := h.store.CreateImport(r.Context(), user.ID, name, key)
+	if err != nil {
+		h.deleteImportArchive(key)
+		writeErr(w, http.StatusInternalServerError, "failed to queue upload")
+		return
+	}
+	if _, err := h.store.EnqueueJob(r.Context(), user.ID, jobImport, importJob{
+		ImportID:   im.ID,
+		Visibility: visibility,
+		Bulk:       true,
+		SportType:  sportType,
+	}, nil, jobMaxAttempts); err != nil {
+		h.failImport(r.Context(), im.ID, "failed to queue upload")
+		writeErr(w, http.StatusInternalServerError, "failed to queue upload")
+		return
+	}
+	h.wakeWorkers()
+	w.Header().Set("Location", "/api/imports/"+strconv.FormatInt(im.ID, 10))
+	writeJSON(w, http.StatusAccepted, im)
+}
+
+// bulkUploadWriter streams the files of a bulk upload into a zip in blob
+// storage, one stored entry per file, so that zipped files can be read in
+// place. A name given twice is kept apart by a numbered directory.
+type bulkUploadWriter struct {
+	pw     *io.PipeWriter
+	zw     *zip.Writer
+	stored chan error
+	names  map[string]bool
+}
+
+func newBulkUploadWriter(blobs *blob.Store, key string) *bulkUploadWriter {
+	pr, pw := io.Pipe()
+	u := &bulkUploadWriter{
+		pw:     pw,
+		zw:     zip.NewWriter(pw),
+		stored: make(chan error, 1),
+		names:  map[string]bool{},
+	}
+	go func() {
+		_, err := blobs.PutReader(key, pr)
+		// Unblocks add when storing failed.
+		pr.CloseWithError(err)
+		u.stored <- err
+	}()
+	return u
+}
+
+func (u *bulkUploadWriter) add(name string, r io.Reader) error {
+	if u.names[name] {
+		name = strconv.Itoa(len(u.names)) + "/" + name
+	}
+	u.names[name] = true
+	w, err := u.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// close finishes the zip and waits for it to be stored.
+func (u *bulkUploadWriter) close() error {
+	err := u.zw.Close()
+	u.pw.CloseWithError(err)
+	if storeErr := <-u.stored; err == nil {
+		err = storeErr
+	}
+	return err
+}
+
+// abort drops the upload. Blob storage keeps nothing of a failed write.
+func (u *bulkUploadWriter) abort(err error) {
+	u.pw.CloseWithError(err)
+	<-u.stored
+}
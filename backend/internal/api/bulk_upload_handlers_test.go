@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gpx-training-analyzer/backend/internal/archive"
+	"gpx-training-analyzer/backend/internal/blob"
+)
+
+func TestBulkUploadWriter_KeepsFilesApart(t *testing.T) {
+	blobs := blob.NewLocal(t.TempDir())
+	u := newBulkUploadWriter(blobs, "imports/bulk.zip")
+	for _, f := range []struct{ name, data string }{
+		{"ride.gpx", "<gpx>1</gpx>"},
+		{"ride.gpx", "<gpx>2</gpx>"},
+	} {
+		if err := u.add(f.name, strings.NewReader(f.data)); err != nil {
+			t.Fatalf("add %s: %v", f.name, err)
+		}
+	}
+	if err := u.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	f, err := blobs.Open("imports/bulk.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	got := map[string]string{}
+	err = archive.WalkUpload(f, info.Size(), archive.NewBudget(archive.MaxTotalSize), func(path string, file archive.File) {
+		got[path] = string(file.Data)
+	})
+	if err != nil {
+		t.Fatalf("WalkUpload: %v", err)
+	}
+	if got["ride.gpx"] != "<gpx>1</gpx>" || got["1/ride.gpx"] != "<gpx>2</gpx>" || len(got) != 2 {
+		t.Fatalf("stored files = %v", got)
+	}
+}
+
+func TestBulkUploadWriter_AbortStoresNothing(t *testing.T) {
+	blobs := blob.NewLocal(t.TempDir())
+	u := newBulkUploadWriter(blobs, "imports/bulk.zip")
+	if err := u.add("ride.gpx", strings.NewReader("<gpx/>")); err != nil {
+		t.Fatal(err)
+	}
+	u.abort(errors.New("client went away"))
+	if _, err := blobs.Open("imports/bulk.zip"); !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("Open after abort: %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// calorieBatch is how many activities are loaded at a time when calories are
+// recomputed.
+const calorieBatch = 50
+
+// athleteOn returns the athlete as of date: the weight entry in force then
+// (the earliest entry for activities that predate the history, the profile
+// weight without any history), the profile's gender and the age on that day.
+func athleteOn(profile store.AthleteProfile, history []store.WeightEntry, date time.Time) metrics.Athlete {
+	a := metrics.Athlete{Gender: profile.Gender}
+	if profile.Weight != nil {
+		a.WeightKg = *profile.Weight
+	}
+	if len(history) > 0 {
+		a.WeightKg = history[0].WeightKg
+		day := date.Format("2006-01-02")
+		for _, e := range history {
+			if e.Date > day {
+				break
+			}
+			a.WeightKg = e.WeightKg
+		}
+	}
+	if age, ok := ageFromBirthDate(profile.DateOfBirth, date); ok && age > 0 {
+		a.AgeYears = age
+	}
+	return a
+}
+
+// estimateCalories fills in the calorie fields of m for a new activity.
+func (h *Handler) estimateCalories(ctx context.Context, userID int64, sportType string, points []gpx.Point, m *metrics.Result) {
+	profile, err := h.store.GetProfile(ctx, userID)
+	if err != nil {
+		slog.Warn("failed to load profile for calories", "userID", userID, "err", err)
+		return
+	}
+	history, err := h.store.ListWeightHistory(ctx, userID)
+	if err != nil {
+		slog.Warn("failed to load weight history", "userID", userID, "err", err)
+		return
+	}
+	e := metrics.EstimateCalories(points, sportType, *m, athleteOn(profile, history, m.ActivityDate))
+	m.CaloriesKcal, m.CaloriesMethod = e.Kcal, e.Method
+}
+
+// recomputeCalories re-estimates the calories of the user's activities on or
+// after from (all of them when from is nil) and returns how many it scanned.
+func (h *Handler) recomputeCalories(ctx context.Context, userID int64, from *time.Time) (int, error) {
+	profile, err := h.store.GetProfile(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	history, err := h.store.ListWeightHistory(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	scanned := 0
+	var afterID int64
+	for {
+		batch, err := h.store.ListCalorieInputs(ctx, userID, from, afterID, calorieBatch)
+		if err != nil {
+			return scanned, err
+		}
+		for _, a := range batch {
+			e := metrics.EstimateCalories(a.Points, a.SportType, a.Metrics, athleteOn(profile, history, a.ActivityDate))
+			if err := h.store.SaveActivityCalories(ctx, a.ID, e); err != nil {
+				return scanned, err
+			}
+			afterID = a.ID
+			scanned++
+		}
+		if len(batch) < calorieBatch {
+			return scanned, nil
+		}
+	}
+}
+
+// calorieJob is the payload of a calorie recompute job.
+type calorieJob struct {
+	From *time.Time `json:"from,omitempty"`
+}
+
+// queueCalorieRecompute queues re-estimating calories from from on, as
+// recomputeCalories. A recompute asked for while the same one is queued
+// joins it.
+func (h *Handler) queueCalorieRecompute(ctx context.Context, userID int64, from *time.Time) {
+	key := "all"
+	if from != nil {
+		key = from.Format("2006-01-02")
+	}
+	if _, err := h.store.EnqueueUniqueJob(ctx, userID, jobCalorieRecompute, key, calorieJob{From: from}, time.Now(), jobMaxAttempts); err != nil {
+		slog.Warn("failed to queue calorie recompute", "userID", userID, "err", err)
+		return
+	}
+	h.wakeWorkers()
+}
+
+func (h *Handler) runCalorieJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	var p calorieJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return jobResult{}, fileError{errors.New("invalid calorie job")}
+	}
+	_, err := h.recomputeCalories(ctx, job.UserID, p.From)
+	return jobResult{}, err
+}
+
+// recomputeAllCalories re-estimates every activity of the caller, e.g. for
+// history uploaded before calories were estimated.
+func (h *Handler) recomputeAllCalories(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	scanned, err := h.recomputeCalories(r.Context(), user.ID, nil)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to recompute calories")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"scanned": scanned})
+}
+
+func (h *Handler) listWeightHistory(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	history, err := h.store.ListWeightHistory(r.Context(), user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list weight history")
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// saveWeightEntry records a weight, possibly backdated, and re-estimates the
+// calories of the activities it now applies to.
+func (h *Handler) saveWeightEntry(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req store.WeightEntry
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().UTC().Format("2006-01-02")
+	}
+	date, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+		return
+	}
+	if req.WeightKg <= 0 || req.WeightKg >= 1000 {
+		writeErr(w, http.StatusBadRequest, "weightKg must be between 0 and 1000")
+		return
+	}
+	from, err := h.weightChangeFrom(r.Context(), user.ID, date)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to read weight history")
+		return
+	}
+	if err := h.store.SaveWeightEntry(r.Context(), user.ID, req); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to save weight")
+		return
+	}
+	h.queueCalorieRecompute(r.Context(), user.ID, from)
+	writeJSON(w, http.StatusOK, req)
+}
+
+func (h *Handler) deleteWeightEntry(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	date, err := time.Parse("2006-01-02", r.PathValue("date"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+		return
+	}
+	from, err := h.weightChangeFrom(r.Context(), user.ID, date)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to read weight history")
+		return
+	}
+	if err := h.store.DeleteWeightEntry(r.Context(), user.ID, date.Format("2006-01-02")); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "weight entry not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to delete weight")
+		return
+	}
+	h.queueCalorieRecompute(r.Context(), user.ID, from)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// weightChangeFrom returns the earliest activity date affected by changing
+// the weight entry on date. Activities before the first entry use it too, so
+// changing the earliest entry affects everything.
+func (h *Handler) weightChangeFrom(ctx context.Context, userID int64, date time.Time) (*time.Time, error) {
+	history, err := h.store.ListWeightHistory(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 || date.Format("2006-01-02") <= history[0].Date {
+		return nil, nil
+	}
+	return &date, nil
+}
+
+func equalFloatPtr(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
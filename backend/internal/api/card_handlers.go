@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/auth"
+	"gpx-training-analyzer/backend/internal/blob"
+	"gpx-training-analyzer/backend/internal/card"
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/store"
+	"gpx-training-analyzer/backend/internal/units"
+)
+
+func cardBlobKey(activityID int64) string {
+	return "cards/activity-" + strconv.FormatInt(activityID, 10) + ".png"
+}
+
+// activityCardURL is the anonymous URL of a public activity's card.
+func activityCardURL(activityID int64) string {
+	return "/api/public/activities/" + strconv.FormatInt(activityID, 10) + "/card"
+}
+
+func renderActivityCard(a store.Activity, prefs units.Preferences) ([]byte, error) {
+	title := a.Name
+	if title == "" {
+		title = a.SportType + " " + a.ActivityDate.Format("2006-01-02")
+	}
+	elevations := make([]float64, len(a.Points))
+	for i, p := range a.Points {
+		elevations[i] = p.Ele
+	}
+	m := a.Metrics
+	system := prefs.System
+	stats := []card.Stat{
+		{Label: "Distance", Value: units.FormatDistance(m.DistanceKM, system, 2)},
+		{Label: "Time", Value: formatClock(m.DurationSec)},
+		{Label: "Elevation", Value: units.FormatElevation(m.ElevGainM, system)},
+	}
+	if prefs.Display(a.SportType) == units.ShowPace {
+		if m.PaceMinPerKM > 0 {
+			stats = append(stats, card.Stat{Label: "Pace", Value: units.FormatPace(m.PaceMinPerKM, system)})
+		}
+	} else if m.AvgSpeedKMH > 0 {
+		stats = append(stats, card.Stat{Label: "Avg speed", Value: units.FormatSpeed(m.AvgSpeedKMH, system)})
+	}
+	return card.Render(card.Card{
+		Title:      title,
+		Track:      geo.FromPoints(a.Points),
+		Elevations: elevations,
+		Stats:      stats,
+	})
+}
+
+// formatClock formats seconds as h:mm:ss, or m:ss below an hour.
+func formatClock(sec int) string {
+	if sec < 3600 {
+		return fmt.Sprintf("%d:%02d", sec/60, sec%60)
+	}
+	return fmt.Sprintf("%d:%02d:%02d", sec/3600, sec/60%60, sec%60)
+}
+
+// generateActivityCard renders an activity's card in the owner's units and
+// stores it in blob storage. It runs once after upload.
+func (h *Handler) generateActivityCard(ctx context.Context, activity store.Activity) ([]byte, error) {
+	prefs := units.Preferences{System: units.Metric}
+	if activity.UserID != nil {
+		prefs = h.profileUnits(ctx, *activity.UserID)
+	}
+	data, err := renderActivityCard(activity, prefs)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.blobs.Put(cardBlobKey(activity.ID), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// activityCardJob is the payload of a job rendering an activity's card.
+type activityCardJob struct {
+	ActivityID int64 `json:"activityId"`
+}
+
+// queueActivityCard queues rendering the card after upload. A render asked
+// for while one is queued joins it.
+func (h *Handler) queueActivityCard(ctx context.Context, activity store.Activity) {
+	if activity.UserID == nil {
+		return
+	}
+	key := strconv.FormatInt(activity.ID, 10)
+	if _, err := h.store.EnqueueUniqueJob(ctx, *activity.UserID, jobActivityCard, key, activityCardJob{ActivityID: activity.ID}, time.Now(), jobMaxAttempts); err != nil {
+		slog.Warn("failed to queue activity card", "activityID", activity.ID, "err", err)
+		return
+	}
+	h.wakeWorkers()
+}
+
+func (h *Handler) runActivityCardJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	var p activityCardJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return jobResult{}, fileError{errors.New("invalid activity card job")}
+	}
+	activity, err := h.store.GetActivity(ctx, p.ActivityID, job.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		// Deleted before the job ran.
+		return jobResult{}, nil
+	}
+	if err != nil {
+		return jobResult{}, err
+	}
+	_, err = h.generateActivityCard(ctx, activity)
+	return jobResult{}, err
+}
+
+// serveActivityCard writes the stored card, generating it first for
+// activities uploaded before cards existed.
+func (h *Handler) serveActivityCard(w http.ResponseWriter, activity store.Activity, cacheControl string) {
+	data, err := h.blobs.Get(cardBlobKey(activity.ID))
+	if errors.Is(err, blob.ErrNotFound) {
+		data, err = h.generateActivityCard(context.Background(), activity)
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to load activity card")
+		return
+	}
+	writePNG(w, data, cacheControl)
+}
+
+func (h *Handler) activityCard(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetVisibleActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	h.serveActivityCard(w, activity, "private, max-age=3600")
+}
+
+func (h *Handler) publicActivityCard(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetPublicActivity(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	h.serveActivityCard(w, activity, "public, max-age=3600")
+}
+
+func (h *Handler) publicSharedActivityCard(w http.ResponseWriter, r *http.Request) {
+	linkID, err := auth.ParseShareToken(shareTokenKind, r.PathValue("token"))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "share link not found")
+		return
+	}
+	activity, _, err := h.store.GetSharedActivity(r.Context(), linkID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "share link not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	// Revoking a link must take effect, so shared cards are not cached.
+	h.serveActivityCard(w, activity, "no-store")
+}
+
+func writePNG(w http.ResponseWriter, data []byte, cacheControl string) {
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+
+	"gpx-training-analyzer/backend/internal/store"
 )
 
 func (h *Handler) communityListPosts(w http.ResponseWriter, r *http.Request) {
@@ -46,6 +48,7 @@ func (h *Handler) communityListPosts(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, "failed to list posts")
 		return
 	}
+	h.addPostPhotos(r.Context(), result.Posts)
 	writeJSON(w, http.StatusOK, result)
 }
 
@@ -81,6 +84,12 @@ func (h *Handler) communityCreatePost(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "post content must be at most 5000 characters")
 		return
 	}
+	if req.ActivityID != nil {
+		if _, err := h.store.GetActivity(r.Context(), *req.ActivityID, user.ID); err != nil {
+			writeErr(w, http.StatusBadRequest, "activityId must reference one of your activities")
+			return
+		}
+	}
 
 	post, err := h.store.CreatePost(r.Context(), user.ID, req.Content, req.ActivityID)
 	if err != nil {
@@ -121,7 +130,7 @@ func (h *Handler) communityGetPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post, err := h.store.GetPost(r.Context(), id)
+	post, err := h.store.GetPost(r.Context(), id, user.ID)
 	if err != nil {
 		writeErr(w, http.StatusNotFound, "post not found")
 		return
@@ -134,6 +143,10 @@ func (h *Handler) communityGetPost(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	posts := []store.CommunityPost{post}
+	h.addPostPhotos(r.Context(), posts)
+	post = posts[0]
+
 	comments, err := h.store.ListComments(r.Context(), id)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "failed to list comments")
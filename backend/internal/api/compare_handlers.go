@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// compareActivities aligns two activities by distance along the route and
+// returns per-distance deltas plus a ghost replay series. Both activities must
+// be visible to the caller.
+func (h *Handler) compareActivities(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	idA, errA := strconv.ParseInt(r.URL.Query().Get("a"), 10, 64)
+	idB, errB := strconv.ParseInt(r.URL.Query().Get("b"), 10, 64)
+	if errA != nil || errB != nil {
+		writeErr(w, http.StatusBadRequest, "query parameters a and b must be activity ids")
+		return
+	}
+
+	activities := make([]store.Activity, 0, 2)
+	for _, id := range []int64{idA, idB} {
+		activity, err := h.store.GetVisibleActivity(r.Context(), id, user.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "activity not found")
+				return
+			}
+			writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+			return
+		}
+		activities = append(activities, activity)
+	}
+
+	cmp, err := metrics.Compare(activities[0].Points, activities[1].Points)
+	if err != nil {
+		if errors.Is(err, metrics.ErrNoTimestamps) {
+			writeErr(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to compare activities")
+		return
+	}
+
+	summary := func(a store.Activity) map[string]any {
+		return map[string]any{
+			"id":           a.ID,
+			"name":         a.Name,
+			"sportType":    a.SportType,
+			"activityDate": a.ActivityDate,
+			"metrics":      a.Metrics,
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"a":          summary(activities[0]),
+		"b":          summary(activities[1]),
+		"comparison": cmp,
+	})
+}
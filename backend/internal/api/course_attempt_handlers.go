@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/course"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+type courseAttemptResponse struct {
+	Attempt   store.CourseAttempt `json:"attempt"`
+	Course    store.Course        `json:"course"`
+	Adherence course.Adherence    `json:"adherence"`
+}
+
+// linkActivityCourse links an activity to the course it was meant to follow
+// `{courseId}` and answers with how closely it did.
+func (h *Handler) linkActivityCourse(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	var req struct {
+		CourseID int64 `json:"courseId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	activity, err := h.store.GetActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	if len(activity.Points) == 0 {
+		writeErr(w, http.StatusBadRequest, "activity has no track")
+		return
+	}
+	c, err := h.store.GetCourse(r.Context(), req.CourseID, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "course not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch course")
+		return
+	}
+	adherence := course.Compare(c.Points, activity.Points, course.DefaultToleranceM)
+	attempt, err := h.store.SaveCourseAttempt(r.Context(), user.ID, id, c.ID, adherence)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to link course")
+		return
+	}
+	writeJSON(w, http.StatusOK, courseAttemptResponse{Attempt: attempt, Course: c, Adherence: adherence})
+}
+
+// getActivityCourse compares an activity with its linked course point by
+// point. ?toleranceM= widens or narrows the corridor counted as on course.
+func (h *Handler) getActivityCourse(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	tolerance := course.DefaultToleranceM
+	if raw := r.URL.Query().Get("toleranceM"); raw != "" {
+		tolerance, err = strconv.ParseFloat(raw, 64)
+		if err != nil || tolerance < 5 || tolerance > 1000 {
+			writeErr(w, http.StatusBadRequest, "toleranceM must be between 5 and 1000")
+			return
+		}
+	}
+	attempt, err := h.store.GetCourseAttempt(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity is not linked to a course")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch course link")
+		return
+	}
+	activity, err := h.store.GetActivity(r.Context(), id, user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	c, err := h.store.GetCourse(r.Context(), attempt.CourseID, user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to fetch course")
+		return
+	}
+	writeJSON(w, http.StatusOK, courseAttemptResponse{
+		Attempt:   attempt,
+		Course:    c,
+		Adherence: course.Compare(c.Points, activity.Points, tolerance),
+	})
+}
+
+func (h *Handler) unlinkActivityCourse(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	if err := h.store.DeleteCourseAttempt(r.Context(), id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity is not linked to a course")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to unlink course")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listCourseAttempts(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	c, ok := h.loadCourse(w, r, user.ID)
+	if !ok {
+		return
+	}
+	attempts, err := h.store.ListCourseAttempts(r.Context(), c.ID, user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list course attempts")
+		return
+	}
+	writeJSON(w, http.StatusOK, attempts)
+}
+
+// refreshCourseAttempt compares an activity whose track changed with its
+// linked course again.
+func (h *Handler) refreshCourseAttempt(ctx context.Context, activity store.Activity) {
+	if activity.UserID == nil {
+		return
+	}
+	userID := *activity.UserID
+	attempt, err := h.store.GetCourseAttempt(ctx, activity.ID, userID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			slog.Warn("failed to fetch course link", "activityID", activity.ID, "err", err)
+		}
+		return
+	}
+	c, err := h.store.GetCourse(ctx, attempt.CourseID, userID)
+	if err != nil {
+		slog.Warn("failed to fetch linked course", "courseID", attempt.CourseID, "err", err)
+		return
+	}
+	adherence := course.Compare(c.Points, activity.Points, course.DefaultToleranceM)
+	if _, err := h.store.SaveCourseAttempt(ctx, userID, activity.ID, c.ID, adherence); err != nil {
+		slog.Warn("failed to refresh course link", "activityID", activity.ID, "err", err)
+	}
+}
+
+// courseAttemptsJob is the payload of a job comparing a redrawn course with
+// its linked activities again.
+type courseAttemptsJob struct {
+	CourseID int64 `json:"courseId"`
+}
+
+// queueCourseAttemptsRefresh queues comparing the activities linked to a
+// redrawn course with it again. Redrawing again while it is queued joins it.
+func (h *Handler) queueCourseAttemptsRefresh(ctx context.Context, c store.Course) {
+	key := strconv.FormatInt(c.ID, 10)
+	if _, err := h.store.EnqueueUniqueJob(ctx, c.UserID, jobCourseAttempts, key, courseAttemptsJob{CourseID: c.ID}, time.Now(), jobMaxAttempts); err != nil {
+		slog.Warn("failed to queue course link refresh", "courseID", c.ID, "err", err)
+		return
+	}
+	h.wakeWorkers()
+}
+
+func (h *Handler) runCourseAttemptsJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	var p courseAttemptsJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return jobResult{}, fileError{errors.New("invalid course job")}
+	}
+	c, err := h.store.GetCourse(ctx, p.CourseID, job.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		// Deleted before the job ran.
+		return jobResult{}, nil
+	}
+	if err != nil {
+		return jobResult{}, err
+	}
+	attempts, err := h.store.ListCourseAttempts(ctx, c.ID, c.UserID)
+	if err != nil {
+		return jobResult{}, err
+	}
+	for i, attempt := range attempts {
+		activity, err := h.store.GetActivity(ctx, attempt.ActivityID, c.UserID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return jobResult{}, err
+		}
+		adherence := course.Compare(c.Points, activity.Points, course.DefaultToleranceM)
+		if _, err := h.store.SaveCourseAttempt(ctx, c.UserID, activity.ID, c.ID, adherence); err != nil {
+			return jobResult{}, err
+		}
+		progress((i+1)*100/len(attempts), "comparing")
+	}
+	return jobResult{}, nil
+}
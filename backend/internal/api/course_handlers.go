@@ -0,0 +1,339 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/course"
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// courseProfileSamples is how many points the elevation profile of a course
+// is sampled at.
+const courseProfileSamples = 200
+
+type courseEstimate struct {
+	PaceMinPerKM float64 `json:"paceMinPerKm"`
+	DurationSec  int     `json:"durationSec"`
+}
+
+type courseResponse struct {
+	store.Course
+	Profile  []course.ProfileSample `json:"profile"`
+	Estimate courseEstimate         `json:"estimate"`
+}
+
+func newCourseResponse(c store.Course, pace float64) courseResponse {
+	return courseResponse{
+		Course:  c,
+		Profile: course.Profile(c.Points, courseProfileSamples),
+		Estimate: courseEstimate{
+			PaceMinPerKM: pace,
+			DurationSec:  course.EstimateDurationSec(c.Points, pace),
+		},
+	}
+}
+
+// coursePace reads the flat pace of an estimate from ?pace= (min/km) or
+// ?speedKmh=, defaulting to a typical pace for the sport.
+func coursePace(r *http.Request, sportType string) (float64, error) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("pace") != "":
+		pace, err := strconv.ParseFloat(q.Get("pace"), 64)
+		if err != nil || pace <= 0 || pace > 120 {
+			return 0, errors.New("pace must be minutes per km between 0 and 120")
+		}
+		return pace, nil
+	case q.Get("speedKmh") != "":
+		speed, err := strconv.ParseFloat(q.Get("speedKmh"), 64)
+		if err != nil || speed < 0.5 || speed > 200 {
+			return 0, errors.New("speedKmh must be between 0.5 and 200")
+		}
+		return 60 / speed, nil
+	}
+	return course.DefaultPace(sportType), nil
+}
+
+// setCourseTrack fills the points and summary of a course.
+func setCourseTrack(c *store.Course, points []gpx.Point) {
+	c.Points = points
+	c.Summary = course.Summarize(points)
+}
+
+func (h *Handler) listCourses(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	courses, err := h.store.ListCourses(r.Context(), user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list courses")
+		return
+	}
+	writeJSON(w, http.StatusOK, courses)
+}
+
+// createCourse plans a course through waypoints, or copies the track of an
+// activity the user can see.
+func (h *Handler) createCourse(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name       string            `json:"name"`
+		SportType  string            `json:"sportType"`
+		Waypoints  []course.Waypoint `json:"waypoints"`
+		ActivityID *int64            `json:"activityId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	c := store.Course{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(req.Name),
+		SportType: strings.ToLower(strings.TrimSpace(req.SportType)),
+	}
+
+	switch {
+	case req.ActivityID != nil && len(req.Waypoints) > 0:
+		writeErr(w, http.StatusBadRequest, "give either waypoints or activityId")
+		return
+	case req.ActivityID != nil:
+		activity, err := h.store.GetVisibleActivity(r.Context(), *req.ActivityID, user.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "activity not found")
+				return
+			}
+			writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+			return
+		}
+		points, err := course.FromTrack(activity.Points)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "activity has no track")
+			return
+		}
+		setCourseTrack(&c, points)
+		c.ActivityID = &activity.ID
+		if c.Name == "" {
+			c.Name = activity.Name
+		}
+		if c.SportType == "" {
+			c.SportType = activity.SportType
+		}
+	default:
+		points, err := course.FromWaypoints(req.Waypoints)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		setCourseTrack(&c, points)
+		c.Waypoints = req.Waypoints
+	}
+	if c.SportType == "" {
+		writeErr(w, http.StatusBadRequest, "sportType is required")
+		return
+	}
+	if c.Name == "" {
+		c.Name = "Course"
+	}
+
+	pace, err := coursePace(r, c.SportType)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	created, err := h.store.CreateCourse(r.Context(), c)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to create course")
+		return
+	}
+	writeJSON(w, http.StatusCreated, newCourseResponse(created, pace))
+}
+
+// loadCourse fetches an owned course from the {id} path value, writing the
+// error response when it cannot.
+func (h *Handler) loadCourse(w http.ResponseWriter, r *http.Request, userID int64) (store.Course, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid course id")
+		return store.Course{}, false
+	}
+	c, err := h.store.GetCourse(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "course not found")
+			return store.Course{}, false
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch course")
+		return store.Course{}, false
+	}
+	return c, true
+}
+
+func (h *Handler) getCourse(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	c, ok := h.loadCourse(w, r, user.ID)
+	if !ok {
+		return
+	}
+	pace, err := coursePace(r, c.SportType)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newCourseResponse(c, pace))
+}
+
+// updateCourse renames a course or changes its sport; drawn courses may also
+// be redrawn with new waypoints.
+func (h *Handler) updateCourse(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name      *string           `json:"name"`
+		SportType *string           `json:"sportType"`
+		Waypoints []course.Waypoint `json:"waypoints"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	c, ok := h.loadCourse(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if req.Name != nil {
+		if c.Name = strings.TrimSpace(*req.Name); c.Name == "" {
+			writeErr(w, http.StatusBadRequest, "name is required")
+			return
+		}
+	}
+	if req.SportType != nil {
+		if c.SportType = strings.ToLower(strings.TrimSpace(*req.SportType)); c.SportType == "" {
+			writeErr(w, http.StatusBadRequest, "sportType is required")
+			return
+		}
+	}
+	if req.Waypoints != nil {
+		if len(c.Waypoints) == 0 {
+			writeErr(w, http.StatusBadRequest, "a course copied from an activity has no waypoints")
+			return
+		}
+		points, err := course.FromWaypoints(req.Waypoints)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		setCourseTrack(&c, points)
+		c.Waypoints = req.Waypoints
+	}
+	pace, err := coursePace(r, c.SportType)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated, err := h.store.UpdateCourse(r.Context(), c)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to update course")
+		return
+	}
+	if req.Waypoints != nil {
+		h.queueCourseAttemptsRefresh(r.Context(), updated)
+	}
+	writeJSON(w, http.StatusOK, newCourseResponse(updated, pace))
+}
+
+func (h *Handler) deleteCourse(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid course id")
+		return
+	}
+	if err := h.store.DeleteCourse(r.Context(), id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "course not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to delete course")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+func courseFileName(name, ext string) string {
+	base := strings.Trim(unsafeFileChars.ReplaceAllString(name, "-"), "-")
+	if base == "" {
+		base = "course"
+	}
+	return base + ext
+}
+
+// exportCourse downloads a course for a device: ?format=gpx (a track to
+// follow, the default), gpx-route (waypoints to navigate between) or tcx (a
+// course with a virtual partner at the estimate's pace).
+func (h *Handler) exportCourse(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	c, ok := h.loadCourse(w, r, user.ID)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	var contentType, ext string
+	var err error
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "gpx":
+		contentType, ext = "application/gpx+xml", ".gpx"
+		err = course.WriteGPXTrack(&buf, c.Name, c.SportType, c.Points)
+	case "gpx-route":
+		waypoints := c.Waypoints
+		if len(waypoints) == 0 {
+			waypoints = course.TrackWaypoints(c.Points)
+		}
+		contentType, ext = "application/gpx+xml", ".gpx"
+		err = course.WriteGPXRoute(&buf, c.Name, waypoints)
+	case "tcx":
+		pace, paceErr := coursePace(r, c.SportType)
+		if paceErr != nil {
+			writeErr(w, http.StatusBadRequest, paceErr.Error())
+			return
+		}
+		contentType, ext = "application/vnd.garmin.tcx+xml", ".tcx"
+		err = course.WriteTCX(&buf, c.Name, c.Points, pace, time.Now().UTC().Truncate(time.Second))
+	default:
+		writeErr(w, http.StatusBadRequest, "format must be one of gpx, gpx-route, tcx")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to export course")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+courseFileName(c.Name, ext)+`"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
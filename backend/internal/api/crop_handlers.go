@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// cropActivity hides the ends of an activity's track, e.g. a walk around the
+// car park after forgetting to stop the watch. Offsets always apply to the
+// original track, so cropping again replaces the previous crop.
+func (h *Handler) cropActivity(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	var crop metrics.Crop
+	if err := json.NewDecoder(r.Body).Decode(&crop); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	activity, original, ok := h.loadTrackForCrop(w, r, id, user.ID)
+	if !ok {
+		return
+	}
+	from, to, err := metrics.CropRange(original, crop)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	crop.StartIndex, crop.EndIndex = from, to
+	points := append([]gpx.Point(nil), original[from:to+1]...)
+	points[0].Gap = false
+	h.replaceActivityTrack(w, r, activity, points, &crop)
+}
+
+// uncropActivity brings back the original track.
+func (h *Handler) uncropActivity(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, original, ok := h.loadTrackForCrop(w, r, id, user.ID)
+	if !ok {
+		return
+	}
+	if activity.Crop == nil {
+		writeErr(w, http.StatusBadRequest, "activity is not cropped")
+		return
+	}
+	h.replaceActivityTrack(w, r, activity, original, nil)
+}
+
+// loadTrackForCrop fetches an owned activity and its original track, writing
+// the error response when it cannot.
+func (h *Handler) loadTrackForCrop(w http.ResponseWriter, r *http.Request, id, userID int64) (store.Activity, []gpx.Point, bool) {
+	activity, err := h.store.GetActivity(r.Context(), id, userID)
+	if err == nil {
+		var original []gpx.Point
+		original, err = h.store.GetOriginalTrack(r.Context(), id, userID)
+		if err == nil {
+			return activity, original, true
+		}
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "activity not found")
+		return store.Activity{}, nil, false
+	}
+	writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+	return store.Activity{}, nil, false
+}
+
+// replaceActivityTrack stores points as the activity's visible track with
+// recomputed metrics and responds with the updated activity.
+func (h *Handler) replaceActivityTrack(w http.ResponseWriter, r *http.Request, activity store.Activity, points []gpx.Point, crop *metrics.Crop) {
+	userID := *activity.UserID
+	m := metrics.Compute(points)
+	h.estimateCalories(r.Context(), userID, activity.SportType, points, &m)
+	if err := h.store.UpdateActivityTrack(r.Context(), activity.ID, userID, points, m, crop); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to update activity track")
+		return
+	}
+	updated, err := h.store.GetActivity(r.Context(), activity.ID, userID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	h.afterActivityTrackChanged(r.Context(), &updated, activity.Points)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// afterActivityTrackChanged redoes the post-upload steps that depend on the
+// track after it was cropped or restored. previous is the track before.
+func (h *Handler) afterActivityTrackChanged(ctx context.Context, activity *store.Activity, previous []gpx.Point) {
+	if activity.UserID != nil {
+		h.invalidateHeatmap(ctx, *activity.UserID, geo.FromPoints(previous))
+		h.invalidateHeatmap(ctx, *activity.UserID, geo.FromPoints(activity.Points))
+	}
+	h.matchActivityRoute(ctx, activity)
+	h.placeActivityPhotos(ctx, []store.Activity{*activity})
+	h.refreshCourseAttempt(ctx, *activity)
+	h.queueActivitySegmentMatch(ctx, *activity)
+	h.queueActivityCard(ctx, *activity)
+	h.queueRacePrediction(ctx, *activity)
+}
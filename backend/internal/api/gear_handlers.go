@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gpx-training-analyzer/backend/internal/store"
+	"gpx-training-analyzer/backend/internal/units"
+)
+
+type gearRequest struct {
+	GearType        string   `json:"gearType"`
+	Name            string   `json:"name"`
+	Brand           string   `json:"brand"`
+	Model           string   `json:"model"`
+	StartDistanceKM float64  `json:"startDistanceKm"`
+	RetireAtKM      *float64 `json:"retireAtKm"`
+	Retired         bool     `json:"retired"`
+}
+
+func (req gearRequest) validate() (store.Gear, error) {
+	g := store.Gear{
+		GearType:        strings.ToLower(strings.TrimSpace(req.GearType)),
+		Name:            strings.TrimSpace(req.Name),
+		Brand:           strings.TrimSpace(req.Brand),
+		Model:           strings.TrimSpace(req.Model),
+		StartDistanceKM: req.StartDistanceKM,
+		RetireAtKM:      req.RetireAtKM,
+		Retired:         req.Retired,
+	}
+	switch g.GearType {
+	case "shoes", "bike", "other":
+	default:
+		return store.Gear{}, errors.New("gearType must be one of shoes, bike, other")
+	}
+	if g.Name == "" {
+		g.Name = strings.TrimSpace(g.Brand + " " + g.Model)
+	}
+	if g.Name == "" {
+		return store.Gear{}, errors.New("name is required")
+	}
+	if len(g.Name) > 100 || len(g.Brand) > 100 || len(g.Model) > 100 {
+		return store.Gear{}, errors.New("name, brand and model must be at most 100 characters")
+	}
+	if g.StartDistanceKM < 0 {
+		return store.Gear{}, errors.New("startDistanceKm must not be negative")
+	}
+	if g.RetireAtKM != nil && *g.RetireAtKM <= 0 {
+		return store.Gear{}, errors.New("retireAtKm must be positive")
+	}
+	return g, nil
+}
+
+func (h *Handler) listGear(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	items, err := h.store.ListGear(r.Context(), user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list gear")
+		return
+	}
+	defaults, err := h.store.ListGearDefaults(r.Context(), user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list gear defaults")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"items":    items,
+		"defaults": defaults,
+	})
+}
+
+func (h *Handler) createGear(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req gearRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	g, err := req.validate()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	created, err := h.store.CreateGear(r.Context(), user.ID, g)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to create gear")
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) updateGear(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid gear id")
+		return
+	}
+	var req gearRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	g, err := req.validate()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	updated, err := h.store.UpdateGear(r.Context(), id, user.ID, g)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "gear not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to update gear")
+		return
+	}
+	h.notifyGearRetirement(r.Context(), user.ID, updated)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) deleteGear(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid gear id")
+		return
+	}
+	if err := h.store.DeleteGear(r.Context(), id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "gear not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to delete gear")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "gear deleted"})
+}
+
+func (h *Handler) setGearDefault(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		SportType string `json:"sportType"`
+		GearID    *int64 `json:"gearId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	sportType := strings.ToLower(strings.TrimSpace(req.SportType))
+	if sportType == "" {
+		writeErr(w, http.StatusBadRequest, "sportType is required")
+		return
+	}
+	if err := h.store.SetGearDefault(r.Context(), user.ID, sportType, req.GearID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "gear not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to set default gear")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "default gear updated"})
+}
+
+func (h *Handler) setActivityGear(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	activityID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	var req struct {
+		GearID *int64 `json:"gearId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	g, err := h.store.SetActivityGear(r.Context(), activityID, user.ID, req.GearID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity or gear not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to assign gear")
+		return
+	}
+	if g != nil {
+		h.notifyGearRetirement(r.Context(), user.ID, *g)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"activityId": activityID,
+		"gear":       g,
+	})
+}
+
+// applyDefaultGear assigns the default gear for the activity's sport after an
+// upload, unless the activity already has gear. Failures are logged and never
+// fail the upload itself.
+func (h *Handler) applyDefaultGear(ctx context.Context, activity *store.Activity) {
+	if activity.UserID == nil || activity.GearID != nil {
+		return
+	}
+	g, err := h.store.ApplyDefaultGear(ctx, activity.ID, *activity.UserID, activity.SportType)
+	if err != nil {
+		slog.Warn("failed to apply default gear", "activityID", activity.ID, "err", err)
+		return
+	}
+	if g == nil {
+		return
+	}
+	activity.GearID = &g.ID
+	h.notifyGearRetirement(ctx, *activity.UserID, *g)
+}
+
+// notifyGearRetirement sends a one-off notification once a shoe passes its
+// retirement distance.
+func (h *Handler) notifyGearRetirement(ctx context.Context, userID int64, g store.Gear) {
+	claimed, err := h.store.ClaimGearRetirementAlert(ctx, g.ID)
+	if err != nil {
+		slog.Warn("failed to check gear retirement", "gearID", g.ID, "err", err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	system := h.profileUnits(ctx, userID).System
+	_ = h.store.CreateNotification(ctx, userID,
+		"Time to retire "+g.Name,
+		fmt.Sprintf("Your %s have reached %s, past their retirement distance of %s.",
+			g.Name, units.FormatDistance(g.TotalDistanceKM, system, 0), units.FormatDistance(*g.RetireAtKM, system, 0)),
+	)
+}
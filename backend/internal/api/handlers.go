@@ -1,25 +1,37 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gpx-training-analyzer/backend/internal/auth"
-	"gpx-training-analyzer/backend/internal/gpx"
-	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/blob"
+	"gpx-training-analyzer/backend/internal/resumable"
 	"gpx-training-analyzer/backend/internal/store"
 )
 
 type Handler struct {
-	store  *store.Store
-	authRL *rateLimiter
+	store    *store.Store
+	blobs    *blob.Store
+	authRL   *rateLimiter
+	publicRL *rateLimiter
+	liveRL   *rateLimiter
+	uploads  *resumable.Store
+	workers  *jobWorkers
+	// closing is closed when the server shuts down, ending long-lived
+	// responses such as live streams.
+	closing     chan struct{}
+	closingOnce sync.Once
 }
 
 type registerRequest struct {
@@ -41,13 +53,29 @@ type authResponse struct {
 
 func NewHandler(store *store.Store) *Handler {
 	return &Handler{
-		store:  store,
-		authRL: newRateLimiter(10),
+		store:    store,
+		blobs:    blob.NewFromEnv(),
+		uploads:  resumable.NewFromEnv(),
+		authRL:   newRateLimiter(10),
+		publicRL: newRateLimiter(60),
+		liveRL:   newRateLimiter(liveBatchesPerMinute),
+		closing:  make(chan struct{}),
 	}
 }
 
-func (h *Handler) Stop() {
+// CloseStreams ends open live streams so that a server shutdown does not wait
+// for them; clients reconnect to another instance. Register it with
+// http.Server.RegisterOnShutdown.
+func (h *Handler) CloseStreams() {
+	h.closingOnce.Do(func() { close(h.closing) })
+}
+
+func (h *Handler) Stop(ctx context.Context) error {
+	h.CloseStreams()
 	h.authRL.stop()
+	h.publicRL.stop()
+	h.liveRL.stop()
+	return h.stopWorkers(ctx)
 }
 
 func (h *Handler) Routes() http.Handler {
@@ -55,7 +83,18 @@ func (h *Handler) Routes() http.Handler {
 
 	mux.HandleFunc("GET /api/health", h.health)
 	mux.HandleFunc("GET /api/stats/public", h.publicStats)
+	mux.HandleFunc("GET /api/stats/aerobic", h.aerobicTrend)
+	mux.HandleFunc("GET /api/predictions", h.racePredictions)
+	mux.HandleFunc("GET /api/predictions/history", h.racePredictionHistory)
 	mux.HandleFunc("GET /api/public/config", h.publicConfig)
+	mux.HandleFunc("GET /api/public/share/{token}", h.publicRL.limit(h.publicSharedActivity))
+	mux.HandleFunc("GET /api/public/activities/{id}", h.publicRL.limit(h.publicActivity))
+	mux.HandleFunc("GET /api/public/share/{token}/card", h.publicRL.limit(h.publicSharedActivityCard))
+	mux.HandleFunc("GET /api/public/activities/{id}/card", h.publicRL.limit(h.publicActivityCard))
+	mux.HandleFunc("GET /api/public/share/{token}/photos/{photoId}/{variant}", h.publicRL.limit(h.publicSharedActivityPhotoFile))
+	mux.HandleFunc("GET /api/public/activities/{id}/photos/{photoId}/{variant}", h.publicRL.limit(h.publicActivityPhotoFile))
+	mux.HandleFunc("GET /api/public/live/{token}", h.publicRL.limit(h.followLiveSession))
+	mux.HandleFunc("GET /api/public/live/{token}/stream", h.publicRL.limit(h.streamLiveSession))
 
 	mux.HandleFunc("POST /api/auth/register", h.authRL.limit(h.register))
 	mux.HandleFunc("POST /api/auth/login", h.authRL.limit(h.login))
@@ -70,8 +109,8 @@ func (h *Handler) Routes() http.Handler {
 	mux.HandleFunc("PUT /api/account/password", h.changePassword)
 	mux.HandleFunc("DELETE /api/account/google", h.unlinkGoogle)
 	mux.HandleFunc("GET /api/account/subscription", h.getMySubscription)
-	mux.HandleFunc("POST /api/account/subscription/request", h.requestSubscriptionUpgrade)
-	mux.HandleFunc("POST /api/account/subscription/checkout", h.stripeCreateCheckout)
+	mux.HandleFunc("POST /api/account/subscription/request", h.idempotent(h.requestSubscriptionUpgrade))
+	mux.HandleFunc("POST /api/account/subscription/checkout", h.idempotent(h.stripeCreateCheckout))
 
 	mux.HandleFunc("POST /stripe/webhook", h.stripeWebhook)
 
@@ -90,26 +129,107 @@ func (h *Handler) Routes() http.Handler {
 	mux.HandleFunc("GET /api/admin/subscriptions", h.adminListSubscriptions)
 	mux.HandleFunc("PUT /api/admin/subscriptions/", h.adminUpdateSubscription)
 
-	mux.HandleFunc("POST /api/activities/upload", h.upload)
+	mux.HandleFunc("POST /api/activities/upload", h.idempotent(h.upload))
+	mux.HandleFunc("POST /api/activities/upload/bulk", h.bulkUpload)
+	mux.HandleFunc("OPTIONS /api/uploads", h.resumableUploadOptions)
+	mux.HandleFunc("POST /api/uploads", h.idempotent(h.createResumableUpload))
+	mux.HandleFunc("HEAD /api/uploads/{id}", h.resumableUploadOffset)
+	mux.HandleFunc("PATCH /api/uploads/{id}", h.patchResumableUpload)
+	mux.HandleFunc("DELETE /api/uploads/{id}", h.deleteResumableUpload)
+	mux.HandleFunc("POST /api/activities/manual", h.idempotent(h.createManualActivity))
+	mux.HandleFunc("POST /api/activities/calories/recompute", h.recomputeAllCalories)
+	mux.HandleFunc("POST /api/activities/merge", h.idempotent(h.mergeActivities))
+	mux.HandleFunc("POST /api/activities/{id}/split", h.splitActivity)
+	mux.HandleFunc("GET /api/activities/{id}/originals", h.listActivityOriginals)
+	mux.HandleFunc("PATCH /api/activities/{id}/crop", h.cropActivity)
+	mux.HandleFunc("DELETE /api/activities/{id}/crop", h.uncropActivity)
+	mux.HandleFunc("POST /api/activities/{id}/restore", h.restoreActivityOriginals)
 	mux.HandleFunc("GET /api/activities", h.list)
 	mux.HandleFunc("GET /api/activities/", h.getByID)
+	mux.HandleFunc("GET /api/activities/compare", h.compareActivities)
+	mux.HandleFunc("PUT /api/activities/{id}/gear", h.setActivityGear)
+	mux.HandleFunc("PUT /api/activities/{id}/visibility", h.setActivityVisibility)
+	mux.HandleFunc("GET /api/activities/{id}/share-links", h.listShareLinks)
+	mux.HandleFunc("POST /api/activities/{id}/share-links", h.idempotent(h.createShareLink))
+	mux.HandleFunc("DELETE /api/activities/{id}/share-links/{linkId}", h.revokeShareLink)
+	mux.HandleFunc("GET /api/activities/{id}/segments", h.listActivitySegmentEfforts)
+	mux.HandleFunc("GET /api/activities/{id}/card", h.activityCard)
+	mux.HandleFunc("GET /api/activities/{id}/photos", h.listActivityPhotos)
+	mux.HandleFunc("POST /api/activities/{id}/photos", h.idempotent(h.uploadActivityPhotos))
+	mux.HandleFunc("GET /api/activities/{id}/photos/{photoId}/{variant}", h.activityPhotoFile)
+	mux.HandleFunc("DELETE /api/activities/{id}/photos/{photoId}", h.deleteActivityPhoto)
+	mux.HandleFunc("GET /api/activities/{id}/intervals", h.getActivityIntervals)
+	mux.HandleFunc("PUT /api/activities/{id}/intervals", h.saveActivityIntervals)
+	mux.HandleFunc("DELETE /api/activities/{id}/intervals", h.resetActivityIntervals)
+	mux.HandleFunc("GET /api/activities/{id}/legs", h.getActivityLegs)
+	mux.HandleFunc("PUT /api/activities/{id}/legs", h.saveActivityLegs)
+	mux.HandleFunc("DELETE /api/activities/{id}/legs", h.resetActivityLegs)
+	mux.HandleFunc("GET /api/activities/{id}/course", h.getActivityCourse)
+	mux.HandleFunc("PUT /api/activities/{id}/course", h.linkActivityCourse)
+	mux.HandleFunc("DELETE /api/activities/{id}/course", h.unlinkActivityCourse)
+
+	mux.HandleFunc("GET /api/jobs/{id}", h.getJob)
+
+	mux.HandleFunc("GET /api/imports", h.listImports)
+	mux.HandleFunc("POST /api/imports", h.createImport)
+	mux.HandleFunc("GET /api/imports/{id}", h.getImport)
+
+	mux.HandleFunc("GET /api/gear", h.listGear)
+	mux.HandleFunc("POST /api/gear", h.idempotent(h.createGear))
+	mux.HandleFunc("PUT /api/gear/defaults", h.setGearDefault)
+	mux.HandleFunc("PUT /api/gear/{id}", h.updateGear)
+	mux.HandleFunc("DELETE /api/gear/{id}", h.deleteGear)
+
+	mux.HandleFunc("GET /api/routes", h.listRoutes)
+	mux.HandleFunc("POST /api/routes/rebuild", h.rebuildRoutes)
+	mux.HandleFunc("GET /api/routes/{id}", h.getRoute)
+	mux.HandleFunc("PUT /api/routes/{id}", h.renameRoute)
+
+	mux.HandleFunc("GET /api/courses", h.listCourses)
+	mux.HandleFunc("POST /api/courses", h.idempotent(h.createCourse))
+	mux.HandleFunc("GET /api/courses/{id}", h.getCourse)
+	mux.HandleFunc("PUT /api/courses/{id}", h.updateCourse)
+	mux.HandleFunc("DELETE /api/courses/{id}", h.deleteCourse)
+	mux.HandleFunc("GET /api/courses/{id}/export", h.exportCourse)
+	mux.HandleFunc("GET /api/courses/{id}/attempts", h.listCourseAttempts)
+
+	mux.HandleFunc("GET /api/live", h.listLiveSessions)
+	mux.HandleFunc("POST /api/live", h.idempotent(h.createLiveSession))
+	mux.HandleFunc("GET /api/live/{id}", h.getLiveSession)
+	mux.HandleFunc("POST /api/live/{id}/finish", h.finishLiveSession)
+	mux.HandleFunc("DELETE /api/live/{id}", h.deleteLiveSession)
+	mux.HandleFunc("POST /api/live/ingest/{token}/points", h.liveRL.limitBy(liveIngestKey, h.ingestLivePoints))
+	mux.HandleFunc("POST /api/live/ingest/{token}/finish", h.finishLiveSessionByToken)
+
+	mux.HandleFunc("GET /api/heatmap/{z}/{x}/{tile}", h.heatmapTile)
+
+	mux.HandleFunc("GET /api/segments", h.listSegments)
+	mux.HandleFunc("POST /api/segments", h.idempotent(h.createSegment))
+	mux.HandleFunc("GET /api/segments/{id}", h.getSegment)
+	mux.HandleFunc("DELETE /api/segments/{id}", h.deleteSegment)
+	mux.HandleFunc("GET /api/segments/{id}/leaderboard", h.segmentLeaderboard)
 
 	mux.HandleFunc("GET /api/users/approved", h.listApprovedUsers)
 	mux.HandleFunc("PUT /api/users/avatar", h.updateAvatar)
 	mux.HandleFunc("DELETE /api/users/me", h.deleteAccount)
 	mux.HandleFunc("GET /api/users/me/export", h.exportMyData)
 	mux.HandleFunc("GET /api/users/{id}/profile", h.getPublicProfile)
+	mux.HandleFunc("POST /api/users/{id}/follow", h.followUser)
+	mux.HandleFunc("DELETE /api/users/{id}/follow", h.unfollowUser)
 
 	mux.HandleFunc("GET /api/profile", h.getProfile)
 	mux.HandleFunc("PUT /api/profile", h.updateProfile)
+	mux.HandleFunc("GET /api/profile/weight", h.listWeightHistory)
+	mux.HandleFunc("POST /api/profile/weight", h.idempotent(h.saveWeightEntry))
+	mux.HandleFunc("DELETE /api/profile/weight/{date}", h.deleteWeightEntry)
 
 	mux.HandleFunc("GET /api/community/posts", h.communityListPosts)
-	mux.HandleFunc("POST /api/community/posts", h.communityCreatePost)
+	mux.HandleFunc("POST /api/community/posts", h.idempotent(h.communityCreatePost))
 	mux.HandleFunc("GET /api/community/posts/", h.communityGetPost)
 	mux.HandleFunc("DELETE /api/community/posts/", h.communityDeletePost)
-	mux.HandleFunc("POST /api/community/posts/{id}/comments", h.communityAddComment)
+	mux.HandleFunc("POST /api/community/posts/{id}/comments", h.idempotent(h.communityAddComment))
 	mux.HandleFunc("DELETE /api/community/comments/", h.communityDeleteComment)
-	mux.HandleFunc("POST /api/community/posts/{id}/reactions", h.communityToggleReaction)
+	mux.HandleFunc("POST /api/community/posts/{id}/reactions", h.idempotent(h.communityToggleReaction))
 	mux.HandleFunc("PUT /api/community/posts/{id}/pin", h.communityPinPost)
 
 	mux.HandleFunc("POST /api/community/bans", h.communityBanUser)
@@ -117,16 +237,20 @@ func (h *Handler) Routes() http.Handler {
 	mux.HandleFunc("GET /api/community/bans", h.communityListBans)
 
 	mux.HandleFunc("GET /api/messages/conversations", h.messagingListConversations)
-	mux.HandleFunc("POST /api/messages/conversations", h.messagingCreateConversation)
+	mux.HandleFunc("POST /api/messages/conversations", h.idempotent(h.messagingCreateConversation))
 	mux.HandleFunc("GET /api/messages/conversations/{id}/messages", h.messagingListMessages)
-	mux.HandleFunc("POST /api/messages/conversations/{id}/messages", h.messagingSendMessage)
+	mux.HandleFunc("POST /api/messages/conversations/{id}/messages", h.idempotent(h.messagingSendMessage))
 	mux.HandleFunc("POST /api/messages/conversations/{id}/read", h.messagingMarkRead)
 	mux.HandleFunc("POST /api/messages/conversations/{id}/clear", h.messagingClearConversation)
 	mux.HandleFunc("DELETE /api/messages/conversations/{id}", h.messagingDeleteConversation)
 	mux.HandleFunc("GET /api/messages/unread-count", h.messagingUnreadCount)
 
 	const maxBodyBytes = 32 << 20
-	return bodySizeLimitMiddleware(maxBodyBytes)(requestIDMiddleware(requestLogger(cors(mux))))
+	largeBodies := map[string]int64{
+		"/api/activities/upload/bulk": maxBulkUploadBytes,
+		"/api/imports":                maxExportBytes,
+	}
+	return bodySizeLimitMiddleware(maxBodyBytes, largeBodies)(requestIDMiddleware(requestLogger(cors(mux))))
 }
 
 func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
@@ -402,25 +526,27 @@ func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	parsed, err := gpx.Parse(payload)
-	if err != nil {
-		writeErr(w, http.StatusBadRequest, err.Error())
+	visibility := strings.TrimSpace(r.FormValue("visibility"))
+	if visibility != "" && !store.ValidVisibility(visibility) {
+		writeErr(w, http.StatusBadRequest, "visibility must be one of private, followers, community, public")
 		return
 	}
 
-	sportType := strings.TrimSpace(r.FormValue("sportType"))
-	if sportType == "" {
-		sportType = "unknown"
-	}
-
-	computed := metrics.Compute(parsed.Points)
-	activity, err := h.store.CreateActivity(r.Context(), user.ID, fileHeader.Filename, sportType, parsed, computed)
+	// Parsing and analysis run in a job so that big files do not hold the
+	// request; the client follows GET /api/jobs/{id}.
+	job, err := h.store.EnqueueJob(r.Context(), user.ID, jobUpload, uploadJob{
+		FileName:   fileHeader.Filename,
+		SportType:  strings.TrimSpace(r.FormValue("sportType")),
+		Visibility: visibility,
+	}, payload, jobMaxAttempts)
 	if err != nil {
-		writeErr(w, http.StatusInternalServerError, "failed to persist activity")
+		writeErr(w, http.StatusInternalServerError, "failed to queue upload")
 		return
 	}
+	h.wakeWorkers()
 
-	writeJSON(w, http.StatusCreated, activity)
+	w.Header().Set("Location", "/api/jobs/"+strconv.FormatInt(job.ID, 10))
+	writeJSON(w, http.StatusAccepted, job)
 }
 
 func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
@@ -429,13 +555,24 @@ func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	prefs, convert, err := h.requestedUnits(r, user.ID)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	page, pageSize := parsePageParams(r)
 	result, err := h.store.ListActivities(r.Context(), user.ID, page, pageSize)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "failed to list activities")
 		return
 	}
-	writeJSON(w, http.StatusOK, result)
+	writeJSON(w, http.StatusOK, store.PaginatedResult[activityView]{
+		Items:      withDisplay(result.Items, prefs, convert),
+		Total:      result.Total,
+		Page:       result.Page,
+		PageSize:   result.PageSize,
+		TotalPages: result.TotalPages,
+	})
 }
 
 func (h *Handler) getByID(w http.ResponseWriter, r *http.Request) {
@@ -455,7 +592,7 @@ func (h *Handler) getByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	activity, err := h.store.GetActivity(r.Context(), id, user.ID)
+	activity, err := h.store.GetVisibleActivity(r.Context(), id, user.ID)
 	if err != nil {
 		if strings.Contains(err.Error(), "no rows") || errors.Is(err, io.EOF) {
 			writeErr(w, http.StatusNotFound, "activity not found")
@@ -465,7 +602,14 @@ func (h *Handler) getByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, activity)
+	prefs, convert, err := h.requestedUnits(r, user.ID)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	view := withDisplay([]store.Activity{activity}, prefs, convert)[0]
+	view.Photos = h.activityPhotos(r.Context(), id, activityURL(id))
+	writeJSON(w, http.StatusOK, view)
 }
 
 func (h *Handler) updateAvatar(w http.ResponseWriter, r *http.Request) {
@@ -527,16 +671,43 @@ func (h *Handler) updateProfile(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	if req.DefaultActivityVisibility != "" && !store.ValidVisibility(req.DefaultActivityVisibility) {
+		writeErr(w, http.StatusBadRequest, "defaultActivityVisibility must be one of private, followers, community, public")
+		return
+	}
+	if !validUnitPreferences(req) {
+		writeErr(w, http.StatusBadRequest, "unitSystem must be metric or imperial and sportDisplay values pace or speed")
+		return
+	}
+	previous, err := h.store.GetProfile(r.Context(), user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to get profile")
+		return
+	}
 	profile, err := h.store.UpsertProfile(r.Context(), user.ID, req)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "failed to update profile")
 		return
 	}
+	if profile.UnitSystem != previous.UnitSystem || !maps.Equal(profile.SportDisplay, previous.SportDisplay) {
+		h.queueActivityCardsReset(r.Context(), user.ID)
+	}
+	// Calories depend on weight, gender and age.
+	if profile.Gender != previous.Gender || profile.DateOfBirth != previous.DateOfBirth {
+		h.queueCalorieRecompute(r.Context(), user.ID, nil)
+	} else if !equalFloatPtr(profile.Weight, previous.Weight) {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		if previous.Weight == nil {
+			h.queueCalorieRecompute(r.Context(), user.ID, nil)
+		} else {
+			h.queueCalorieRecompute(r.Context(), user.ID, &today)
+		}
+	}
 	writeJSON(w, http.StatusOK, profile)
 }
 
 func (h *Handler) getPublicProfile(w http.ResponseWriter, r *http.Request) {
-	_, ok := h.requireApprovedUser(w, r)
+	viewer, ok := h.requireApprovedUser(w, r)
 	if !ok {
 		return
 	}
@@ -556,11 +727,16 @@ func (h *Handler) getPublicProfile(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, "failed to get profile")
 		return
 	}
-	stats, err := h.store.GetUserPublicStats(r.Context(), userID)
+	stats, err := h.store.GetUserPublicStats(r.Context(), userID, viewer.ID)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "failed to get stats")
 		return
 	}
+	follows, err := h.store.GetFollowCounts(r.Context(), userID, viewer.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to get follow counts")
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
 		"id":              target.ID,
 		"name":            target.FirstName + " " + target.LastName,
@@ -581,6 +757,9 @@ func (h *Handler) getPublicProfile(w http.ResponseWriter, r *http.Request) {
 		"linkedinUrl":     profile.LinkedinURL,
 		"activityCount":   stats.ActivityCount,
 		"totalDistanceKm": stats.TotalDistanceKm,
+		"followerCount":   follows.Followers,
+		"followingCount":  follows.Following,
+		"isFollowing":     follows.IsFollow,
 	})
 }
 
@@ -591,8 +770,10 @@ func (h *Handler) exportMyData(w http.ResponseWriter, r *http.Request) {
 	}
 	profile, _ := h.store.GetProfile(r.Context(), user.ID)
 	activities, _ := h.store.ListActivities(r.Context(), user.ID, 1, 1000)
+	prefs := unitPreferences(profile)
 	export := map[string]any{
 		"exportedAt": time.Now().UTC(),
+		"units":      prefs.System,
 		"user": map[string]any{
 			"id":        user.ID,
 			"firstName": user.FirstName,
@@ -601,7 +782,7 @@ func (h *Handler) exportMyData(w http.ResponseWriter, r *http.Request) {
 			"createdAt": user.CreatedAt,
 		},
 		"profile":    profile,
-		"activities": activities.Items,
+		"activities": withDisplay(activities.Items, prefs, true),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Disposition", `attachment; filename="gpx-trackpro-export.json"`)
@@ -821,9 +1002,12 @@ func cors(next http.Handler) http.Handler {
 
 		w.Header().Set("Vary", "Origin")
 		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET,HEAD,POST,PUT,PATCH,DELETE,OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Units, Idempotency-Key, Last-Event-ID, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Job-Location, Idempotent-Replayed, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires")
+		// Preflights are answered here; other OPTIONS requests, such as tus
+		// discovery, reach the routes.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -867,6 +1051,12 @@ func (rr *responseRecorder) WriteHeader(code int) {
 	rr.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the flusher of streamed
+// responses.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
 func requestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
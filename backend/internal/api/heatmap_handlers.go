@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/heatmap"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// heatmapTile serves /api/heatmap/{z}/{x}/{y}.png for the caller's own
+// activities, optionally filtered by sport and date range (from/to as
+// YYYY-MM-DD, both inclusive). Rendered tiles are cached until an activity
+// overlapping them changes.
+func (h *Handler) heatmapTile(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	z, errZ := strconv.Atoi(r.PathValue("z"))
+	x, errX := strconv.Atoi(r.PathValue("x"))
+	yRaw, isPNG := strings.CutSuffix(r.PathValue("tile"), ".png")
+	y, errY := strconv.Atoi(yRaw)
+	if errZ != nil || errX != nil || errY != nil || !isPNG || !heatmap.ValidTile(z, x, y) {
+		writeErr(w, http.StatusBadRequest, "invalid tile coordinates")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := store.HeatmapFilter{SportType: strings.TrimSpace(q.Get("sport"))}
+	var err error
+	if filter.From, filter.To, err = parseDateRange(q); err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key := strconv.Itoa(z) + "/" + strconv.Itoa(x) + "/" + strconv.Itoa(y) + "?" + url.Values{
+		"sport": {filter.SportType},
+		"from":  {q.Get("from")},
+		"to":    {q.Get("to")},
+	}.Encode()
+
+	data, err := h.store.GetHeatmapTile(r.Context(), user.ID, key)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusInternalServerError, "failed to read tile cache")
+			return
+		}
+		box := heatmap.QueryBounds(z, x, y)
+		tracks, err := h.store.ListHeatmapTracks(r.Context(), user.ID, box, filter)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "failed to load activities")
+			return
+		}
+		data, err = heatmap.Render(z, x, y, tracks)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "failed to render tile")
+			return
+		}
+		if err := h.store.SaveHeatmapTile(r.Context(), user.ID, key, box, data); err != nil {
+			slog.Warn("failed to cache heatmap tile", "userID", user.ID, "tile", key, "err", err)
+		}
+	}
+
+	writePNG(w, data, "private, max-age=300")
+}
+
+// invalidateHeatmap drops the owner's cached heatmap tiles that overlap an
+// activity whose track was added or changed.
+func (h *Handler) invalidateHeatmap(ctx context.Context, userID int64, coords []geo.Coord) {
+	if len(coords) == 0 {
+		return
+	}
+	if err := h.store.InvalidateHeatmapTiles(ctx, userID, geo.Bounds(coords)); err != nil {
+		slog.Warn("failed to invalidate heatmap tiles", "userID", userID, "err", err)
+	}
+}
+
+// parseDateRange reads the from/to query parameters as YYYY-MM-DD dates, both
+// inclusive. The returned to is the start of the following day, for use as an
+// exclusive bound.
+func parseDateRange(q url.Values) (from, to *time.Time, err error) {
+	for _, p := range []struct {
+		name   string
+		target **time.Time
+		days   int
+	}{{"from", &from, 0}, {"to", &to, 1}} {
+		raw := q.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return nil, nil, errors.New(p.name + " must be a date (YYYY-MM-DD)")
+		}
+		t = t.AddDate(0, 0, p.days)
+		*p.target = &t
+	}
+	return from, to, nil
+}
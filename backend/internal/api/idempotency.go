@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+const (
+	// idempotencyTTL is how long a response is replayed for its key.
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyLease is how long a running request holds its key without
+	// renewing it. A retry takes over the key of a request that stopped
+	// renewing, e.g. because the server crashed.
+	idempotencyLease  = 2 * time.Minute
+	maxIdempotencyKey = 255
+)
+
+// idempotentHeaders are the response headers replayed with the body.
+var idempotentHeaders = []string{"Content-Type", "Location", "Tus-Resumable"}
+
+// fingerprintHeaders are the request headers that carry content and so are
+// part of the fingerprint, as for the creation of a resumable upload.
+var fingerprintHeaders = []string{"Upload-Length", "Upload-Metadata"}
+
+// idempotent makes a POST handler honour the Idempotency-Key header. The
+// first response for a user and key is stored and replayed to retries; the
+// same key with another request body, or while the first request is still
+// running, is a conflict. The running request renews a short lease on the key
+// so that a crash does not block retries until the key expires. Server errors, panics and responses that could not
+// be stored release the key so the request can be retried.
+func (h *Handler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeErr(w, http.StatusBadRequest, "Idempotency-Key is limited to 255 characters")
+			return
+		}
+		user, ok := h.requireAuthenticated(w, r)
+		if !ok {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+
+		prior, reserved, err := h.store.ReserveIdempotencyKey(r.Context(), user.ID, key, fingerprint, idempotencyLease, idempotencyTTL)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeErrCode(w, http.StatusConflict, "idempotency_key_in_use", "a request with this Idempotency-Key is in progress")
+			return
+		case err != nil:
+			writeErr(w, http.StatusInternalServerError, "failed to check Idempotency-Key")
+			return
+		case !reserved && prior.Fingerprint != fingerprint:
+			writeErrCode(w, http.StatusConflict, "idempotency_key_reused", "Idempotency-Key was used with a different request")
+			return
+		case !reserved && !prior.Completed:
+			writeErrCode(w, http.StatusConflict, "idempotency_key_in_use", "a request with this Idempotency-Key is in progress")
+			return
+		case !reserved:
+			for name, value := range prior.Header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(prior.Status)
+			_, _ = w.Write(prior.Body)
+			return
+		}
+
+		// The client may be gone; the outcome is stored regardless.
+		ctx := context.WithoutCancel(r.Context())
+		stored := false
+		defer func() {
+			// Unless the response was stored, free the key for a retry,
+			// also when next panicked.
+			if stored {
+				return
+			}
+			if err := h.store.ReleaseIdempotencyKey(ctx, user.ID, key); err != nil {
+				slog.Warn("failed to release idempotency key", "userID", user.ID, "err", err)
+			}
+		}()
+
+		renewing := make(chan struct{})
+		defer close(renewing)
+		go h.renewIdempotencyKey(ctx, user.ID, key, renewing)
+
+		rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		if rec.status >= 500 {
+			return
+		}
+		header := map[string]string{}
+		for _, name := range idempotentHeaders {
+			if v := w.Header().Get(name); v != "" {
+				header[name] = v
+			}
+		}
+		if err := h.store.CompleteIdempotencyKey(ctx, user.ID, key, rec.status, header, rec.body.Bytes()); err != nil {
+			slog.Warn("failed to store idempotent response", "userID", user.ID, "err", err)
+			return
+		}
+		stored = true
+	}
+}
+
+// renewIdempotencyKey extends the lease on key until done is closed.
+func (h *Handler) renewIdempotencyKey(ctx context.Context, userID int64, key string, done <-chan struct{}) {
+	ticker := time.NewTicker(idempotencyLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if err := h.store.RenewIdempotencyKey(ctx, userID, key, idempotencyLease); err != nil {
+			slog.Warn("failed to renew idempotency key", "userID", userID, "err", err)
+		}
+	}
+}
+
+// requestFingerprint hashes the method, path, content headers and body of a
+// request. The parts
+// of a multipart body are hashed rather than its bytes, as clients pick a new
+// boundary on every retry.
+func requestFingerprint(r *http.Request, body []byte) string {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s %s\n", r.Method, r.URL.Path)
+	for _, name := range fingerprintHeaders {
+		fmt.Fprintf(sum, "%s: %q\n", name, r.Header.Get(name))
+	}
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "multipart/") || !hashMultipart(sum, body, params["boundary"]) {
+		sum.Write(body)
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func hashMultipart(w io.Writer, body []byte, boundary string) bool {
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		fmt.Fprintf(w, "%q %q\n", part.FormName(), part.FileName())
+		if _, err := io.Copy(w, part); err != nil {
+			return false
+		}
+		fmt.Fprint(w, "\n")
+	}
+}
+
+// bodyRecorder passes a response through while keeping a copy.
+type bodyRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *bodyRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *bodyRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys every hour.
+func (h *Handler) purgeIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if _, err := h.store.DeleteExpiredIdempotencyKeys(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("failed to purge idempotency keys", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/auth"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// testHandler connects a handler to the migrated database in
+// TEST_DATABASE_URL, skipping the test when it is not set, and returns it with
+// the bearer token of a new user.
+func testHandler(t *testing.T) (*Handler, string) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	t.Setenv("DATABASE_URL", dsn)
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("BLOB_DIR", t.TempDir())
+	t.Setenv("UPLOAD_DIR", t.TempDir())
+	s, err := store.New(context.Background())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(s.Close)
+
+	ctx := context.Background()
+	email := fmt.Sprintf("idem-%d@example.com", time.Now().UnixNano())
+	user, err := s.CreateUser(ctx, "Idem", "Test", email, "x")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { _ = s.DeleteUser(context.Background(), user.ID) })
+	token, err := auth.IssueToken(user.ID, user.Role, user.Status, email, time.Hour)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	return NewHandler(s), token
+}
+
+// serveIdempotent sends a POST with key and body through handler.
+func serveIdempotent(handler http.HandlerFunc, token, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/things", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestIdempotent_ReplaysResponse(t *testing.T) {
+	h, token := testHandler(t)
+	calls := 0
+	handler := h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Location", fmt.Sprintf("/api/things/%d", calls))
+		writeJSON(w, http.StatusCreated, map[string]int{"id": calls})
+	})
+
+	first := serveIdempotent(handler, token, "replay", `{"name":"a"}`)
+	again := serveIdempotent(handler, token, "replay", `{"name":"a"}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want once", calls)
+	}
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() {
+		t.Fatalf("retry = %d %q, want %d %q", again.Code, again.Body, first.Code, first.Body)
+	}
+	if again.Header().Get("Location") != "/api/things/1" || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry headers = %v, want the stored Location and Idempotent-Replayed", again.Header())
+	}
+}
+
+func TestIdempotent_RejectsOtherRequestWithSameKey(t *testing.T) {
+	h, token := testHandler(t)
+	calls := 0
+	handler := h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeJSON(w, http.StatusCreated, map[string]int{"id": calls})
+	})
+
+	serveIdempotent(handler, token, "reused", `{"name":"a"}`)
+	other := serveIdempotent(handler, token, "reused", `{"name":"b"}`)
+	if other.Code != http.StatusConflict || !strings.Contains(other.Body.String(), "idempotency_key_reused") {
+		t.Fatalf("other request = %d %q, want 409 idempotency_key_reused", other.Code, other.Body)
+	}
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want once", calls)
+	}
+}
+
+func TestIdempotent_ServerErrorFreesKey(t *testing.T) {
+	h, token := testHandler(t)
+	calls := 0
+	handler := h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			writeErr(w, http.StatusInternalServerError, "failed")
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]int{"id": calls})
+	})
+
+	if rec := serveIdempotent(handler, token, "error", `{}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("first request = %d, want 500", rec.Code)
+	}
+	retry := serveIdempotent(handler, token, "error", `{}`)
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry = %d %v, want a fresh 201", retry.Code, retry.Header())
+	}
+	if calls != 2 {
+		t.Fatalf("handler ran %d times, want twice", calls)
+	}
+}
+
+func TestIdempotent_PanicFreesKey(t *testing.T) {
+	h, token := testHandler(t)
+	calls := 0
+	handler := h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		writeJSON(w, http.StatusCreated, map[string]int{"id": calls})
+	})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("first request did not panic")
+			}
+		}()
+		serveIdempotent(handler, token, "panic", `{}`)
+	}()
+	retry := serveIdempotent(handler, token, "panic", `{}`)
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry = %d %v, want a fresh 201", retry.Code, retry.Header())
+	}
+	if calls != 2 {
+		t.Fatalf("handler ran %d times, want twice", calls)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// fileError is an import failure caused by the file rather than the server.
+type fileError struct {
+	err error
+}
+
+func (e fileError) Error() string { return e.err.Error() }
+func (e fileError) Unwrap() error { return e.err }
+
+// duplicateError reports a file that was imported before.
+type duplicateError struct {
+	ActivityID int64
+}
+
+func (e duplicateError) Error() string {
+	return "already imported as activity " + strconv.FormatInt(e.ActivityID, 10)
+}
+
+// importOptions are what the athlete set for an imported file. Empty fields
+// take what the file says.
+type importOptions struct {
+	SportType  string
+	Visibility string
+	Name       string
+	Notes      string
+	GearID     *int64
+	// StartTime dates files whose track has no timestamps.
+	StartTime *time.Time
+	// SkipDuplicates rejects files imported before, found by content hash or
+	// start time.
+	SkipDuplicates bool
+	// Progress, when set, is told how far the import got.
+	Progress func(percent int, stage string)
+}
+
+func (o importOptions) progress(percent int, stage string) {
+	if o.Progress != nil {
+		o.Progress(percent, stage)
+	}
+}
+
+// importActivity parses, stores and enriches one GPX, TCX or FIT file. It is
+// the pipeline behind every way of getting a file in.
+func (h *Handler) importActivity(ctx context.Context, userID int64, fileName string, payload []byte, opts importOptions) (store.Activity, error) {
+	opts.progress(10, "parsing")
+	parsed, err := gpx.ParseFile(fileName, payload)
+	if err != nil {
+		return store.Activity{}, fileError{err}
+	}
+	sum := sha256.Sum256(payload)
+	hash := hex.EncodeToString(sum[:])
+	if opts.SkipDuplicates {
+		start := opts.StartTime
+		if len(parsed.Points) > 0 && parsed.Points[0].Time != nil {
+			start = parsed.Points[0].Time
+		}
+		id, err := h.store.FindDuplicateActivity(ctx, userID, hash, start)
+		if err == nil {
+			return store.Activity{}, duplicateError{ActivityID: id}
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return store.Activity{}, err
+		}
+	}
+
+	a := store.Activity{
+		FileName:   fileName,
+		SportType:  opts.SportType,
+		Name:       opts.Name,
+		Notes:      opts.Notes,
+		GearID:     opts.GearID,
+		Visibility: opts.Visibility,
+		FileHash:   hash,
+		Points:     parsed.Points,
+	}
+	if a.Name == "" {
+		a.Name = parsed.Name
+	}
+	if a.SportType == "" {
+		a.SportType = parsed.Sport
+	}
+	if a.SportType == "" {
+		a.SportType = "unknown"
+	}
+	opts.progress(40, "computing metrics")
+	a.Metrics = metrics.Compute(parsed.Points)
+	if opts.StartTime != nil && (len(parsed.Points) == 0 || parsed.Points[0].Time == nil) {
+		a.Metrics.ActivityDate = opts.StartTime.UTC()
+	}
+	h.estimateCalories(ctx, userID, a.SportType, parsed.Points, &a.Metrics)
+
+	opts.progress(70, "saving")
+	activity, err := h.store.CreateActivity(ctx, userID, a)
+	if err != nil {
+		return store.Activity{}, err
+	}
+	opts.progress(90, "analysing")
+	h.saveFileLegs(ctx, activity, parsed)
+	h.afterActivityCreated(ctx, &activity)
+	return activity, nil
+}
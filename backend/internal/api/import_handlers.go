@@ -0,0 +1,474 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/archive"
+	"gpx-training-analyzer/backend/internal/blob"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+const (
+	// maxExportBytes caps the size of an uploaded account export.
+	maxExportBytes = 8 << 30
+)
+
+// createImport takes a Strava or Garmin account export (or any zip of
+// activity files) in the "file" field and keeps it in blob storage, where an
+// import job lists its activities and queues a job for each. It answers 202
+// with the import, whose progress is read from getImport.
+func (h *Handler) createImport(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxExportBytes)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid multipart form")
+		return
+	}
+	// The export is streamed to storage: it can be gigabytes and is read
+	// through the zip index rather than as a whole.
+	var archiveKey, fileName, visibility string
+	queued := false
+	defer func() {
+		if archiveKey != "" && !queued {
+			h.deleteImportArchive(archiveKey)
+		}
+	}()
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid multipart form or export larger than 8 GB")
+			return
+		}
+		switch part.FormName() {
+		case "visibility":
+			v, _ := io.ReadAll(io.LimitReader(part, 64))
+			visibility = strings.TrimSpace(string(v))
+		case "file":
+			if archiveKey != "" {
+				continue
+			}
+			fileName = path.Base(part.FileName())
+			key, err := newImportArchiveKey()
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "failed to store export")
+				return
+			}
+			if _, err := h.blobs.PutReader(key, part); err != nil {
+				writeErr(w, http.StatusBadRequest, "failed to read export or export larger than 8 GB")
+				return
+			}
+			archiveKey = key
+		}
+	}
+	if archiveKey == "" {
+		writeErr(w, http.StatusBadRequest, "missing file field")
+		return
+	}
+	if visibility != "" && !store.ValidVisibility(visibility) {
+		writeErr(w, http.StatusBadRequest, "visibility must be one of private, followers, community, public")
+		return
+	}
+
+	im, err := h.store.CreateImport(r.Context(), user.ID, fileName, archiveKey)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to create import")
+		return
+	}
+	queued = true
+	if _, err := h.store.EnqueueJob(r.Context(), user.ID, jobImport, importJob{ImportID: im.ID, Visibility: visibility}, nil, jobMaxAttempts); err != nil {
+		h.failImport(r.Context(), im.ID, "failed to queue import")
+		writeErr(w, http.StatusInternalServerError, "failed to queue import")
+		return
+	}
+	h.wakeWorkers()
+	w.Header().Set("Location", "/api/imports/"+strconv.FormatInt(im.ID, 10))
+	writeJSON(w, http.StatusAccepted, im)
+}
+
+func newImportArchiveKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "imports/" + hex.EncodeToString(b) + ".zip", nil
+}
+
+// importJob is the payload of the job starting an account import or bulk
+// upload.
+type importJob struct {
+	ImportID   int64  `json:"importId"`
+	Visibility string `json:"visibility,omitempty"`
+	// Bulk marks a bulk upload, whose files all get SportType.
+	Bulk      bool   `json:"bulk,omitempty"`
+	SportType string `json:"sportType,omitempty"`
+}
+
+// importActivityJob is the payload of the job importing one activity of an
+// account export or bulk upload, read from the archive in blob storage.
+type importActivityJob struct {
+	ArchiveKey  string     `json:"archiveKey"`
+	FileName    string     `json:"fileName"` // path within the archive
+	Name        string     `json:"name,omitempty"`
+	Description string     `json:"description,omitempty"`
+	SportType   string     `json:"sportType,omitempty"`
+	GearID      *int64     `json:"gearId,omitempty"`
+	Start       *time.Time `json:"start,omitempty"`
+	Visibility  string     `json:"visibility,omitempty"`
+}
+
+// runImportJob lists the activities of an export, or the files of a bulk
+// upload, and queues a job for each.
+func (h *Handler) runImportJob(ctx context.Context, job store.Job, _ func(int, string)) (jobResult, error) {
+	var p importJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return jobResult{}, fileError{errors.New("invalid import job")}
+	}
+	im, err := h.store.GetImport(ctx, job.UserID, p.ImportID)
+	if errors.Is(err, store.ErrNotFound) {
+		return jobResult{}, nil
+	}
+	if err != nil {
+		return jobResult{}, err
+	}
+	if im.Status != store.ImportPending {
+		// An earlier attempt queued the activities already.
+		return jobResult{}, nil
+	}
+	f, err := h.blobs.Open(im.ArchiveKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		return jobResult{}, fileError{errors.New("the export is no longer available")}
+	}
+	if err != nil {
+		return jobResult{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return jobResult{}, err
+	}
+
+	format := archive.ExportFiles
+	rejected := make([]importResult, 0)
+	var jobs []store.NewJob
+	if p.Bulk {
+		rejected, jobs, err = listBulkUpload(im.ArchiveKey, p, f, info.Size())
+	} else {
+		format, jobs, err = h.listExport(ctx, job.UserID, im.ArchiveKey, p, f, info.Size())
+	}
+	if err != nil {
+		return jobResult{}, fileError{err}
+	}
+	started, err := h.store.StartImport(ctx, job.UserID, im.ID, format, rejected, jobs, jobMaxAttempts)
+	if err != nil {
+		return jobResult{}, err
+	}
+	if started {
+		h.wakeWorkers()
+		// An import without activities is done already.
+		h.finishImport(ctx, im.ID)
+	}
+	return jobResult{}, nil
+}
+
+// listExport returns the format of an export and a job for each of its
+// activities, with its name, description, sport and gear. Gear is resolved
+// here, once per name, so that the activity jobs do not race to add it.
+// Activities without a file, such as manual entries, have no track to import.
+func (h *Handler) listExport(ctx context.Context, userID int64, archiveKey string, p importJob, r io.ReaderAt, size int64) (string, []store.NewJob, error) {
+	export, err := archive.ReadExport(r, size)
+	if err != nil {
+		return "", nil, err
+	}
+	gear := &importGear{h: h, userID: userID}
+	var jobs []store.NewJob
+	for _, a := range export.Activities {
+		if a.FileName == "" {
+			continue
+		}
+		payload := importActivityJob{
+			ArchiveKey:  archiveKey,
+			FileName:    a.FileName,
+			Name:        a.Name,
+			Description: a.Description,
+			SportType:   a.SportType,
+			Start:       a.Start,
+			Visibility:  p.Visibility,
+		}
+		if a.Gear != "" {
+			id, err := gear.resolve(ctx, a.Gear, a.SportType)
+			if err != nil {
+				slog.Warn("failed to resolve imported gear", "userID", userID, "gear", a.Gear, "err", err)
+			} else {
+				payload.GearID = &id
+			}
+		}
+		jobs = append(jobs, store.NewJob{Kind: jobImportActivity, Payload: payload})
+	}
+	return export.Format, jobs, nil
+}
+
+// listBulkUpload returns a job for each activity file of a bulk upload. Files
+// that cannot be unpacked, and copies of a file already in the batch, are
+// turned down instead.
+func listBulkUpload(archiveKey string, p importJob, r io.ReaderAt, size int64) ([]importResult, []store.NewJob, error) {
+	rejected := make([]importResult, 0)
+	var jobs []store.NewJob
+	seen := map[[sha256.Size]byte]string{}
+	err := archive.WalkUpload(r, size, archive.NewBudget(archive.MaxTotalSize), func(name string, f archive.File) {
+		if f.Err != nil {
+			rejected = append(rejected, importResult{File: name, Status: importFailed, Error: f.Err.Error()})
+			return
+		}
+		sum := sha256.Sum256(f.Data)
+		if first, ok := seen[sum]; ok {
+			rejected = append(rejected, importResult{File: name, Status: importDuplicate, Error: "same file as " + first})
+			return
+		}
+		seen[sum] = name
+		jobs = append(jobs, store.NewJob{Kind: jobImportActivity, Payload: importActivityJob{
+			ArchiveKey: archiveKey,
+			FileName:   name,
+			SportType:  p.SportType,
+			Visibility: p.Visibility,
+		}})
+	})
+	return rejected, jobs, err
+}
+
+// runImportActivityJob imports one activity of an export, dated from the
+// export when its file has no timestamps. Files imported before are reported
+// as duplicates.
+func (h *Handler) runImportActivityJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	var p importActivityJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return jobResult{}, fileError{errors.New("invalid import job")}
+	}
+	f, err := h.blobs.Open(p.ArchiveKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		return jobResult{}, fileError{errors.New("the export is no longer available")}
+	}
+	if err != nil {
+		return jobResult{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return jobResult{}, err
+	}
+	name, data, err := archive.ReadExportFile(f, info.Size(), p.FileName)
+	if err != nil {
+		return jobResult{}, fileError{err}
+	}
+	activity, err := h.importActivity(ctx, job.UserID, path.Base(name), data, importOptions{
+		SportType:      p.SportType,
+		Visibility:     p.Visibility,
+		Name:           p.Name,
+		Notes:          p.Description,
+		GearID:         p.GearID,
+		StartTime:      p.Start,
+		SkipDuplicates: true,
+		Progress:       progress,
+	})
+	return importJobResult(activity, err, true)
+}
+
+// finishImport ends an import whose last job ended and deletes its export.
+func (h *Handler) finishImport(ctx context.Context, id int64) {
+	key, done, err := h.store.FinishImport(ctx, id)
+	if err != nil {
+		slog.Warn("failed to finish import", "importID", id, "err", err)
+		return
+	}
+	if done && key != "" {
+		h.deleteImportArchive(key)
+	}
+}
+
+// finishDoneImports ends the imports left running after their last job, if
+// the server stopped in between.
+func (h *Handler) finishDoneImports(ctx context.Context) {
+	keys, err := h.store.FinishDoneImports(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("failed to finish imports", "err", err)
+		}
+		return
+	}
+	for _, key := range keys {
+		if key != "" {
+			h.deleteImportArchive(key)
+		}
+	}
+}
+
+// failImport ends an import that could not be started and deletes its
+// export.
+func (h *Handler) failImport(ctx context.Context, id int64, msg string) {
+	key, err := h.store.FailImport(ctx, id, msg)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			slog.Warn("failed to fail import", "importID", id, "err", err)
+		}
+		return
+	}
+	if key != "" {
+		h.deleteImportArchive(key)
+	}
+}
+
+// failImportJob fails the import of an import job that will not run again.
+func (h *Handler) failImportJob(ctx context.Context, job store.Job, msg string) {
+	var p importJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return
+	}
+	h.failImport(ctx, p.ImportID, msg)
+}
+
+func (h *Handler) deleteImportArchive(key string) {
+	if err := h.blobs.Delete(key); err != nil {
+		slog.Warn("failed to delete import archive", "key", key, "err", err)
+	}
+}
+
+// importGear finds the athlete's gear by name for an import, adding gear the
+// athlete does not have yet.
+type importGear struct {
+	h      *Handler
+	userID int64
+
+	mu     sync.Mutex
+	byName map[string]int64
+}
+
+func (g *importGear) resolve(ctx context.Context, name, sportType string) (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.byName == nil {
+		items, err := g.h.store.ListGear(ctx, g.userID)
+		if err != nil {
+			return 0, err
+		}
+		g.byName = map[string]int64{}
+		for _, item := range items {
+			g.byName[strings.ToLower(item.Name)] = item.ID
+		}
+	}
+	key := strings.ToLower(name)
+	if id, ok := g.byName[key]; ok {
+		return id, nil
+	}
+	gearType := "other"
+	switch sportType {
+	case "running", "walking", "hiking":
+		gearType = "shoes"
+	case "cycling":
+		gearType = "bike"
+	}
+	created, err := g.h.store.CreateGear(ctx, g.userID, store.Gear{GearType: gearType, Name: name})
+	if err != nil {
+		return 0, err
+	}
+	g.byName[key] = created.ID
+	return created.ID, nil
+}
+
+// importResponse is an import with the outcome of every file: those turned
+// down at intake, then one per job.
+type importResponse struct {
+	store.Import
+	Results []importResult `json:"results"`
+}
+
+func (h *Handler) getImport(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid import id")
+		return
+	}
+	im, err := h.store.GetImport(r.Context(), user.ID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "import not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to fetch import")
+		return
+	}
+	jobs, err := h.store.ListImportJobs(r.Context(), im.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to fetch import")
+		return
+	}
+	resp := importResponse{Import: im, Results: []importResult{}}
+	if len(im.Results) > 0 {
+		if err := json.Unmarshal(im.Results, &resp.Results); err != nil {
+			writeErr(w, http.StatusInternalServerError, "failed to fetch import")
+			return
+		}
+	}
+	for _, job := range jobs {
+		resp.Results = append(resp.Results, jobImportResult(job))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// jobImportResult is the outcome of the file of an import job so far.
+func jobImportResult(job store.Job) importResult {
+	var p struct {
+		FileName string `json:"fileName"`
+	}
+	_ = json.Unmarshal(job.Payload, &p)
+	res := importResult{File: p.FileName, Status: importPending}
+	switch job.Status {
+	case store.JobSucceeded:
+		res.Status = importCreated
+		if job.Duplicate {
+			res.Status = importDuplicate
+		}
+		if job.ActivityID != nil {
+			res.ActivityID = *job.ActivityID
+		}
+	case store.JobFailed, store.JobDead:
+		res.Status, res.Error = importFailed, job.LastError
+	}
+	return res
+}
+
+func (h *Handler) listImports(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	items, err := h.store.ListImports(r.Context(), user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list imports")
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// activityIntervals returns the athlete's saved correction if there is one,
+// otherwise the automatically detected structure. source tells which.
+func (h *Handler) activityIntervals(ctx context.Context, activity store.Activity) (metrics.Intervals, string, error) {
+	boundaries, err := h.store.GetIntervalCorrection(ctx, activity.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return metrics.DetectIntervals(activity.Points), "detected", nil
+	}
+	if err != nil {
+		return metrics.Intervals{}, "", err
+	}
+	reps, err := metrics.BuildReps(activity.Points, boundaries)
+	if err != nil {
+		// The track changed since the correction was saved.
+		return metrics.DetectIntervals(activity.Points), "detected", nil
+	}
+	return metrics.Intervals{Summary: metrics.SummarizeReps(reps), Reps: reps}, "manual", nil
+}
+
+func writeIntervals(w http.ResponseWriter, activityID int64, iv metrics.Intervals, source string) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"activityId": activityID,
+		"source":     source,
+		"signal":     iv.Signal,
+		"summary":    iv.Summary,
+		"reps":       iv.Reps,
+	})
+}
+
+func (h *Handler) getActivityIntervals(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetVisibleActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	iv, source, err := h.activityIntervals(r.Context(), activity)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to load intervals")
+		return
+	}
+	writeIntervals(w, id, iv, source)
+}
+
+// saveActivityIntervals stores the athlete's corrected reps. The stats of each
+// rep are recomputed from the track.
+func (h *Handler) saveActivityIntervals(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	var req struct {
+		Reps []metrics.RepBoundary `json:"reps"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	activity, err := h.store.GetActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	reps, err := metrics.BuildReps(activity.Points, req.Reps)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.store.SaveIntervalCorrection(r.Context(), id, req.Reps); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to save intervals")
+		return
+	}
+	writeIntervals(w, id, metrics.Intervals{Summary: metrics.SummarizeReps(reps), Reps: reps}, "manual")
+}
+
+// resetActivityIntervals discards the correction and returns to detection.
+func (h *Handler) resetActivityIntervals(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	if err := h.store.DeleteIntervalCorrection(r.Context(), id); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to reset intervals")
+		return
+	}
+	writeIntervals(w, id, metrics.DetectIntervals(activity.Points), "detected")
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// Job kinds.
+const (
+	jobUpload             = "upload"
+	jobSegmentMatch       = "segment_match"
+	jobImport             = "import"
+	jobImportActivity     = "import_activity"
+	jobRouteRebuild       = "route_rebuild"
+	jobActivityCard       = "activity_card"
+	jobCalorieRecompute   = "calorie_recompute"
+	jobActivityCardsReset = "activity_cards_reset"
+	jobCourseAttempts     = "course_attempts"
+	jobRacePrediction     = "race_prediction"
+)
+
+const (
+	jobMaxAttempts = 5
+	// jobPollInterval is how often idle workers look for due jobs, such as
+	// retries or jobs queued by another server.
+	jobPollInterval = 2 * time.Second
+	// jobStaleAfter is how long a running job may go without reporting
+	// progress before it is given to another worker.
+	jobStaleAfter   = 10 * time.Minute
+	jobBackoffFirst = 10 * time.Second
+	jobBackoffMax   = 30 * time.Minute
+)
+
+// jobResult is what a job produced: the activity it created or, for a
+// duplicate, the one imported before.
+type jobResult struct {
+	ActivityID *int64
+	Duplicate  bool
+}
+
+// jobRunner runs one kind of job. fileError results fail the job for good;
+// other errors are retried.
+type jobRunner func(h *Handler, ctx context.Context, job store.Job, progress func(percent int, stage string)) (jobResult, error)
+
+var jobRunners = map[string]jobRunner{
+	jobUpload:             (*Handler).runUploadJob,
+	jobSegmentMatch:       (*Handler).runSegmentMatchJob,
+	jobImport:             (*Handler).runImportJob,
+	jobImportActivity:     (*Handler).runImportActivityJob,
+	jobRouteRebuild:       (*Handler).runRouteRebuildJob,
+	jobActivityCard:       (*Handler).runActivityCardJob,
+	jobCalorieRecompute:   (*Handler).runCalorieJob,
+	jobActivityCardsReset: (*Handler).runActivityCardsResetJob,
+	jobCourseAttempts:     (*Handler).runCourseAttemptsJob,
+	jobRacePrediction:     (*Handler).runRacePredictionJob,
+}
+
+// jobErrors is what a job reports, by kind, when an attempt fails on our
+// side; the cause goes to the log.
+var jobErrors = map[string]string{
+	jobUpload:             "failed to process the upload",
+	jobSegmentMatch:       "failed to match segments",
+	jobImport:             "failed to read the upload",
+	jobImportActivity:     "failed to import the activity",
+	jobRouteRebuild:       "failed to rebuild routes",
+	jobActivityCard:       "failed to render the activity card",
+	jobCalorieRecompute:   "failed to recompute calories",
+	jobActivityCardsReset: "failed to refresh activity cards",
+	jobCourseAttempts:     "failed to compare activities with the course",
+	jobRacePrediction:     "failed to update race predictions",
+}
+
+type jobWorkers struct {
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// StartWorkers starts n goroutines running queued jobs, plus housekeeping
+// ones returning abandoned jobs to the queue, removing expired resumable
+// uploads and idempotency keys and finishing idle live sessions, until Stop.
+func (h *Handler) StartWorkers(n int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &jobWorkers{wake: make(chan struct{}, 1), cancel: cancel}
+	h.workers = w
+	for range n {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			h.jobWorker(ctx)
+		}()
+	}
+	w.wg.Add(4)
+	go func() {
+		defer w.wg.Done()
+		h.requeueStaleJobs(ctx)
+	}()
+	go func() {
+		defer w.wg.Done()
+		h.sweepResumableUploads(ctx)
+	}()
+	go func() {
+		defer w.wg.Done()
+		h.purgeIdempotencyKeys(ctx)
+	}()
+	go func() {
+		defer w.wg.Done()
+		h.finishIdleLiveSessions(ctx)
+	}()
+}
+
+// stopWorkers stops the workers once their current jobs are done, or gives
+// up waiting when ctx ends; jobs still running then are queued again once
+// their lock goes stale.
+func (h *Handler) stopWorkers(ctx context.Context) error {
+	if h.workers == nil {
+		return nil
+	}
+	h.workers.cancel()
+	done := make(chan struct{})
+	go func() {
+		h.workers.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wakeWorkers tells an idle worker a job was queued.
+func (h *Handler) wakeWorkers() {
+	if h.workers == nil {
+		return
+	}
+	select {
+	case h.workers.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (h *Handler) jobWorker(ctx context.Context) {
+	for {
+		job, err := h.store.ClaimJob(ctx)
+		switch {
+		case err == nil:
+			// Pass the wake-up on in case more jobs are waiting, then run
+			// the job to the end even if the server is stopping.
+			h.wakeWorkers()
+			h.runJob(context.WithoutCancel(ctx), job)
+			continue
+		case ctx.Err() != nil:
+			return
+		case !errors.Is(err, store.ErrNotFound):
+			slog.Warn("failed to claim job", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.workers.wake:
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
+func (h *Handler) requeueStaleJobs(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		n, err := h.store.RequeueStaleJobs(ctx, time.Now().Add(-jobStaleAfter))
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("failed to requeue stale jobs", "err", err)
+		} else if n > 0 {
+			slog.Info("requeued stale jobs", "count", n)
+			h.wakeWorkers()
+		}
+		h.finishDoneImports(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runJob runs a claimed job and records its outcome. Retries back off
+// exponentially; a job out of attempts is left dead.
+func (h *Handler) runJob(ctx context.Context, job store.Job) {
+	run, ok := jobRunners[job.Kind]
+	if !ok {
+		slog.Warn("unknown job kind", "jobID", job.ID, "kind", job.Kind)
+		if _, err := h.store.FailJob(ctx, job.ID, "unknown job kind", false, time.Now()); err != nil {
+			slog.Warn("failed to fail job", "jobID", job.ID, "err", err)
+		}
+		return
+	}
+	if job.ImportID != nil {
+		defer h.finishImport(ctx, *job.ImportID)
+	}
+	progress := func(percent int, stage string) {
+		if err := h.store.SetJobProgress(ctx, job.ID, percent, stage); err != nil {
+			slog.Warn("failed to save job progress", "jobID", job.ID, "err", err)
+		}
+	}
+
+	res, err := run(h, ctx, job, progress)
+	if err == nil {
+		if err := h.store.CompleteJob(ctx, job.ID, res.ActivityID, res.Duplicate); err != nil {
+			slog.Warn("failed to complete job", "jobID", job.ID, "err", err)
+		}
+		return
+	}
+	msg, retryable := err.Error(), true
+	var fe fileError
+	if errors.As(err, &fe) {
+		retryable = false
+	} else {
+		slog.Warn("job attempt failed", "jobID", job.ID, "kind", job.Kind, "attempt", job.Attempts, "err", err)
+		msg = jobErrors[job.Kind]
+	}
+	status, err := h.store.FailJob(ctx, job.ID, msg, retryable, time.Now().Add(jobBackoff(job.Attempts)))
+	if err != nil {
+		slog.Warn("failed to fail job", "jobID", job.ID, "err", err)
+		return
+	}
+	if job.Kind == jobImport && status != store.JobQueued {
+		h.failImportJob(ctx, job, msg)
+	}
+}
+
+// jobBackoff is the wait before retrying a job that failed attempt times:
+// 10s, 20s, 40s… up to 30 minutes.
+func jobBackoff(attempt int) time.Duration {
+	d := jobBackoffFirst
+	for i := 1; i < attempt && d < jobBackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, jobBackoffMax)
+}
+
+// uploadJob is the payload of an upload job; the file is the job's data.
+type uploadJob struct {
+	FileName   string `json:"fileName"`
+	SportType  string `json:"sportType,omitempty"`
+	Visibility string `json:"visibility,omitempty"`
+	// SkipDuplicates reports files imported before as duplicates.
+	SkipDuplicates bool `json:"skipDuplicates,omitempty"`
+}
+
+func (h *Handler) runUploadJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	var p uploadJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return jobResult{}, fileError{errors.New("invalid upload job")}
+	}
+	activity, err := h.importActivity(ctx, job.UserID, path.Base(p.FileName), job.Data, importOptions{
+		SportType:  p.SportType,
+		Visibility: p.Visibility,
+		// An earlier attempt may have stored the activity before failing.
+		SkipDuplicates: p.SkipDuplicates || job.Attempts > 1,
+		Progress:       progress,
+	})
+	return importJobResult(activity, err, p.SkipDuplicates)
+}
+
+// importJobResult turns the outcome of importActivity into a job's. A
+// duplicate counts as one only when the job asked to skip them; otherwise it
+// is the activity stored by an earlier attempt.
+func importJobResult(activity store.Activity, err error, skipDuplicates bool) (jobResult, error) {
+	var dup duplicateError
+	if errors.As(err, &dup) {
+		return jobResult{ActivityID: &dup.ActivityID, Duplicate: skipDuplicates}, nil
+	}
+	if err != nil {
+		return jobResult{}, err
+	}
+	return jobResult{ActivityID: &activity.ID}, nil
+}
+
+// jobResponse is a job with the activity it produced.
+type jobResponse struct {
+	store.Job
+	Activity *store.Activity `json:"activity,omitempty"`
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid job id")
+		return
+	}
+	job, err := h.store.GetJob(r.Context(), user.ID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to fetch job")
+		return
+	}
+	resp := jobResponse{Job: job}
+	if job.ActivityID != nil {
+		activity, err := h.store.GetActivity(r.Context(), *job.ActivityID, user.ID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+			return
+		}
+		if err == nil {
+			resp.Activity = &activity
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
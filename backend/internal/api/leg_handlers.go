@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// activityLegs returns the legs of a multisport activity with their metrics,
+// or no legs for a single-sport activity. source tells where they came from.
+func (h *Handler) activityLegs(ctx context.Context, activity store.Activity) ([]metrics.Leg, string, error) {
+	splits, source, err := h.store.GetActivityLegs(ctx, activity.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	legs, err := metrics.BuildLegs(activity.Points, splits)
+	if err != nil {
+		// The track changed since the legs were saved.
+		return nil, "", nil
+	}
+	return legs, source, nil
+}
+
+func writeLegs(w http.ResponseWriter, activity store.Activity, legs []metrics.Leg, source string) {
+	if legs == nil {
+		legs = []metrics.Leg{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"activityId": activity.ID,
+		"sportType":  activity.SportType,
+		"source":     source,
+		"legs":       legs,
+		"totals":     metrics.SumLegs(legs),
+	})
+}
+
+// saveFileLegs stores the sport changes found in an uploaded file. Markers
+// that do not fit the track are dropped with a warning.
+func (h *Handler) saveFileLegs(ctx context.Context, activity store.Activity, parsed gpx.ParsedActivity) {
+	if len(parsed.Legs) == 0 {
+		return
+	}
+	if _, err := metrics.BuildLegs(activity.Points, parsed.Legs); err != nil {
+		slog.Warn("ignoring multisport legs from file", "activityID", activity.ID, "err", err)
+		return
+	}
+	if err := h.store.SaveActivityLegs(ctx, activity.ID, activity.SportType, parsed.Legs, store.LegsFromFile); err != nil {
+		slog.Warn("failed to save multisport legs", "activityID", activity.ID, "err", err)
+	}
+}
+
+func (h *Handler) getActivityLegs(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetVisibleActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	legs, source, err := h.activityLegs(r.Context(), activity)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to load legs")
+		return
+	}
+	writeLegs(w, activity, legs, source)
+}
+
+// saveActivityLegs splits an activity into legs at the athlete's times. The
+// sport type becomes the one given, or is named from the legs.
+func (h *Handler) saveActivityLegs(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	var req struct {
+		SportType string    `json:"sportType"`
+		Legs      []gpx.Leg `json:"legs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	for i := range req.Legs {
+		req.Legs[i].Sport = strings.ToLower(strings.TrimSpace(req.Legs[i].Sport))
+		req.Legs[i].Start = req.Legs[i].Start.UTC()
+	}
+	activity, err := h.store.GetActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	legs, err := metrics.BuildLegs(activity.Points, req.Legs)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	previous := activity.SportType
+	activity.SportType = strings.TrimSpace(req.SportType)
+	if activity.SportType == "" {
+		activity.SportType = gpx.MultisportType(req.Legs)
+	}
+	if err := h.store.SaveActivityLegs(r.Context(), id, activity.SportType, req.Legs, store.LegsManual); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to save legs")
+		return
+	}
+	if activity.SportType != previous {
+		h.afterActivitySportChanged(r.Context(), &activity, previous)
+	}
+	writeLegs(w, activity, legs, store.LegsManual)
+}
+
+// afterActivitySportChanged redoes what depends on the sport type once legs
+// renamed it: the calorie estimate, the card (pace or speed), the sport's
+// heatmap tiles, segment efforts and, for runs, the race prediction.
+func (h *Handler) afterActivitySportChanged(ctx context.Context, activity *store.Activity, previous string) {
+	userID := *activity.UserID
+	h.estimateCalories(ctx, userID, activity.SportType, activity.Points, &activity.Metrics)
+	e := metrics.Energy{Kcal: activity.Metrics.CaloriesKcal, Method: activity.Metrics.CaloriesMethod}
+	if err := h.store.SaveActivityCalories(ctx, activity.ID, e); err != nil {
+		slog.Warn("failed to save calories", "activityID", activity.ID, "err", err)
+	}
+	h.invalidateHeatmap(ctx, userID, geo.FromPoints(activity.Points))
+	h.queueActivityCard(ctx, *activity)
+	h.queueActivitySegmentMatch(ctx, *activity)
+	run := *activity
+	if metrics.IsRunning(previous) {
+		// A run that is no longer one drops out of the prediction.
+		run.SportType = previous
+	}
+	h.queueRacePrediction(ctx, run)
+}
+
+// resetActivityLegs makes an activity single-sport again. Its sport type is
+// left as it is.
+func (h *Handler) resetActivityLegs(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	if err := h.store.DeleteActivityLegs(r.Context(), id); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to reset legs")
+		return
+	}
+	writeLegs(w, activity, nil, "")
+}
@@ -0,0 +1,576 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/auth"
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/live"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// Live session tokens: the device posts with the ingest token, followers
+// read with the share token.
+const (
+	liveIngestTokenKind = "live-ingest"
+	liveShareTokenKind  = "live-share"
+)
+
+const (
+	// liveBatchesPerMinute is how many batches one session may post.
+	liveBatchesPerMinute = 30
+	maxLiveBatchBytes    = 256 << 10
+	// liveTrailMax caps the positions sent to a follower catching up.
+	liveTrailMax = 2000
+	// liveStreamInterval is how often a stream checks its session for news;
+	// liveStreamPing keeps idle streams open through proxies.
+	liveStreamInterval = 3 * time.Second
+	liveStreamPing     = 30 * time.Second
+	// liveStreamMaxDuration ends streams, which reconnect with Last-Event-ID.
+	liveStreamMaxDuration = 30 * time.Minute
+	// liveIdleAfter is how long an active session may go without a batch
+	// before it is finished for the device; liveKeepPoints is how long the
+	// batches of an ended session are kept for its followers.
+	liveIdleAfter  = 6 * time.Hour
+	liveKeepPoints = 7 * 24 * time.Hour
+)
+
+type liveSessionResponse struct {
+	store.LiveSession
+	Estimate live.Estimate `json:"estimate"`
+	// IngestToken is only given while the session is active.
+	IngestToken string `json:"ingestToken,omitempty"`
+	ShareToken  string `json:"shareToken"`
+	ShareURL    string `json:"shareUrl"`
+}
+
+func newLiveSessionResponse(l store.LiveSession) (liveSessionResponse, error) {
+	resp := liveSessionResponse{LiveSession: l, Estimate: liveEstimate(l)}
+	var err error
+	if l.Status == store.LiveActive {
+		if resp.IngestToken, err = auth.IssueShareToken(liveIngestTokenKind, l.ID); err != nil {
+			return liveSessionResponse{}, err
+		}
+	}
+	if resp.ShareToken, err = auth.IssueShareToken(liveShareTokenKind, l.ID); err != nil {
+		return liveSessionResponse{}, err
+	}
+	resp.ShareURL = frontendBaseURL() + "/live/" + resp.ShareToken
+	return resp, nil
+}
+
+func liveEstimate(l store.LiveSession) live.Estimate {
+	var targetM float64
+	if l.TargetKM != nil {
+		targetM = *l.TargetKM * 1000
+	}
+	return l.Progress.Estimate(targetM)
+}
+
+// writeLiveSession answers with a session and its tokens.
+func writeLiveSession(w http.ResponseWriter, status int, l store.LiveSession) {
+	resp, err := newLiveSessionResponse(l)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to issue live session tokens")
+		return
+	}
+	writeJSON(w, status, resp)
+}
+
+// createLiveSession starts a session, optionally towards a course or a target
+// distance that arrival is estimated against.
+func (h *Handler) createLiveSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name       string   `json:"name"`
+		SportType  string   `json:"sportType"`
+		Visibility string   `json:"visibility"`
+		CourseID   *int64   `json:"courseId"`
+		TargetKM   *float64 `json:"targetKm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	l := store.LiveSession{
+		UserID:     user.ID,
+		Name:       strings.TrimSpace(req.Name),
+		SportType:  strings.ToLower(strings.TrimSpace(req.SportType)),
+		Visibility: strings.TrimSpace(req.Visibility),
+		TargetKM:   req.TargetKM,
+	}
+	if l.Visibility != "" && !store.ValidVisibility(l.Visibility) {
+		writeErr(w, http.StatusBadRequest, "visibility must be one of private, followers, community, public")
+		return
+	}
+	switch {
+	case req.CourseID != nil && req.TargetKM != nil:
+		writeErr(w, http.StatusBadRequest, "give either courseId or targetKm")
+		return
+	case req.CourseID != nil:
+		c, err := h.store.GetCourse(r.Context(), *req.CourseID, user.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "course not found")
+				return
+			}
+			writeErr(w, http.StatusInternalServerError, "failed to fetch course")
+			return
+		}
+		l.CourseID, l.TargetKM = &c.ID, &c.DistanceKM
+		if l.Name == "" {
+			l.Name = c.Name
+		}
+		if l.SportType == "" {
+			l.SportType = c.SportType
+		}
+	case req.TargetKM != nil:
+		if *req.TargetKM <= 0 || *req.TargetKM > 10000 {
+			writeErr(w, http.StatusBadRequest, "targetKm must be between 0 and 10000")
+			return
+		}
+	}
+	if l.SportType == "" {
+		writeErr(w, http.StatusBadRequest, "sportType is required")
+		return
+	}
+	if l.Name == "" {
+		l.Name = "Live activity"
+	}
+	created, err := h.store.CreateLiveSession(r.Context(), l)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to start live session")
+		return
+	}
+	writeLiveSession(w, http.StatusCreated, created)
+}
+
+func (h *Handler) listLiveSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	sessions, err := h.store.ListLiveSessions(r.Context(), user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list live sessions")
+		return
+	}
+	items := make([]liveSessionResponse, 0, len(sessions))
+	for _, l := range sessions {
+		resp, err := newLiveSessionResponse(l)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "failed to issue live session tokens")
+			return
+		}
+		items = append(items, resp)
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// loadLiveSession fetches an owned session from the {id} path value, writing
+// the error response when it cannot.
+func (h *Handler) loadLiveSession(w http.ResponseWriter, r *http.Request, userID int64) (store.LiveSession, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid live session id")
+		return store.LiveSession{}, false
+	}
+	l, err := h.store.GetLiveSession(r.Context(), id)
+	if err == nil && l.UserID != userID {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "live session not found")
+			return store.LiveSession{}, false
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch live session")
+		return store.LiveSession{}, false
+	}
+	return l, true
+}
+
+func (h *Handler) getLiveSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	l, ok := h.loadLiveSession(w, r, user.ID)
+	if !ok {
+		return
+	}
+	writeLiveSession(w, http.StatusOK, l)
+}
+
+func (h *Handler) finishLiveSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	l, ok := h.loadLiveSession(w, r, user.ID)
+	if !ok {
+		return
+	}
+	h.writeFinishedLiveSession(w, r, l.ID)
+}
+
+// deleteLiveSession drops a session and its points; a finished session's
+// activity is kept.
+func (h *Handler) deleteLiveSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid live session id")
+		return
+	}
+	if err := h.store.DeleteLiveSession(r.Context(), id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "live session not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to delete live session")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// liveIngestKey rate limits ingestion by session token.
+func liveIngestKey(r *http.Request) string {
+	return r.PathValue("token")
+}
+
+// liveIngestSession reads the session of the ingest token in the path.
+// Forged tokens are turned away without touching the database.
+func liveIngestSession(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := auth.ParseShareToken(liveIngestTokenKind, r.PathValue("token"))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "live session not found")
+		return 0, false
+	}
+	return id, true
+}
+
+func liveSessionEnded(w http.ResponseWriter) {
+	writeErrCode(w, http.StatusConflict, "live_session_ended", "live session has ended")
+}
+
+// ingestLivePoints appends a device's batch of fixes, {"points": [...]},
+// each with lat, lon and time and optionally ele, hr, cadence and power.
+// Batches sent again are harmless: fixes not newer than the last one kept
+// are dropped.
+func (h *Handler) ingestLivePoints(w http.ResponseWriter, r *http.Request) {
+	id, ok := liveIngestSession(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Points []gpx.Point `json:"points"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLiveBatchBytes)).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	var accepted int
+	var invalid error
+	l, err := h.store.AppendLivePoints(r.Context(), id, func(p live.Progress) (live.Progress, []gpx.Point, error) {
+		p, kept, err := p.Append(req.Points, time.Now())
+		if err != nil {
+			invalid = err
+			return p, nil, err
+		}
+		accepted = len(kept)
+		return p, kept, nil
+	})
+	switch {
+	case invalid != nil:
+		writeErr(w, http.StatusBadRequest, invalid.Error())
+		return
+	case errors.Is(err, store.ErrNotFound):
+		writeErr(w, http.StatusNotFound, "live session not found")
+		return
+	case errors.Is(err, store.ErrLiveSessionEnded):
+		liveSessionEnded(w)
+		return
+	case err != nil:
+		writeErr(w, http.StatusInternalServerError, "failed to save live points")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"accepted":   accepted,
+		"points":     l.Points,
+		"distanceKm": liveEstimate(l).DistanceKM,
+		"seq":        l.Batches,
+	})
+}
+
+// finishLiveSessionByToken lets the device end its session.
+func (h *Handler) finishLiveSessionByToken(w http.ResponseWriter, r *http.Request) {
+	id, ok := liveIngestSession(w, r)
+	if !ok {
+		return
+	}
+	h.writeFinishedLiveSession(w, r, id)
+}
+
+// writeFinishedLiveSession ends a session and answers like an upload: 202
+// with the import job to follow, or 200 when the session had too little
+// track and was discarded.
+func (h *Handler) writeFinishedLiveSession(w http.ResponseWriter, r *http.Request, id int64) {
+	l, err := h.finishLive(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeErr(w, http.StatusNotFound, "live session not found")
+		case errors.Is(err, store.ErrLiveSessionEnded):
+			liveSessionEnded(w)
+		default:
+			writeErr(w, http.StatusInternalServerError, "failed to finish live session")
+		}
+		return
+	}
+	if l.JobID == nil {
+		writeLiveSession(w, http.StatusOK, l)
+		return
+	}
+	w.Header().Set("Location", "/api/jobs/"+strconv.FormatInt(*l.JobID, 10))
+	writeLiveSession(w, http.StatusAccepted, l)
+}
+
+// finishLive ends a session, queueing its track as a GPX upload so it goes
+// through the same import as any other file.
+func (h *Handler) finishLive(ctx context.Context, id int64) (store.LiveSession, error) {
+	l, err := h.store.FinishLiveSession(ctx, id, jobUpload, jobMaxAttempts, func(l store.LiveSession, points []gpx.Point) (any, []byte, error) {
+		if len(points) < 2 {
+			return nil, nil, nil
+		}
+		var buf bytes.Buffer
+		if err := live.WriteGPX(&buf, l.Name, l.SportType, points); err != nil {
+			return nil, nil, err
+		}
+		return uploadJob{
+			FileName:   fmt.Sprintf("live-%d.gpx", l.ID),
+			SportType:  l.SportType,
+			Visibility: l.Visibility,
+		}, buf.Bytes(), nil
+	})
+	if err == nil && l.JobID != nil {
+		h.wakeWorkers()
+	}
+	return l, err
+}
+
+// liveFollowView is what followers see of a session. Trail holds the
+// positions received after the seq they asked from; Seq is the one to ask
+// from next.
+type liveFollowView struct {
+	Name       string          `json:"name"`
+	SportType  string          `json:"sportType"`
+	OwnerName  string          `json:"ownerName"`
+	Status     string          `json:"status"`
+	TargetKM   *float64        `json:"targetKm"`
+	StartedAt  *time.Time      `json:"startedAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+	FinishedAt *time.Time      `json:"finishedAt"`
+	Estimate   live.Estimate   `json:"estimate"`
+	Position   *live.Position  `json:"position"`
+	Trail      []live.Position `json:"trail"`
+	Seq        int             `json:"seq"`
+}
+
+// liveFollowView builds the view of l for a follower who has the batches up
+// to after; the points table is only read when there are newer ones.
+func (h *Handler) liveFollowView(ctx context.Context, l store.LiveSession, after int) (liveFollowView, error) {
+	v := liveFollowView{
+		Name:       l.Name,
+		SportType:  l.SportType,
+		OwnerName:  l.OwnerName,
+		Status:     l.Status,
+		TargetKM:   l.TargetKM,
+		StartedAt:  l.StartedAt,
+		UpdatedAt:  l.UpdatedAt,
+		FinishedAt: l.FinishedAt,
+		Estimate:   liveEstimate(l),
+		Trail:      []live.Position{},
+		Seq:        l.Batches,
+	}
+	if l.Last != nil {
+		pos := live.NewPosition(*l.Last)
+		v.Position = &pos
+	}
+	if after >= l.Batches {
+		return v, nil
+	}
+	points, err := h.store.ListLivePoints(ctx, l.ID, after)
+	if err != nil {
+		return liveFollowView{}, err
+	}
+	v.Trail = live.Trail(points, liveTrailMax)
+	return v, nil
+}
+
+// publicLiveSession reads the share token in the path and the ?after= seq of
+// a follower, writing the error response when it cannot.
+func (h *Handler) publicLiveSession(w http.ResponseWriter, r *http.Request, after string) (store.LiveSession, int, bool) {
+	id, err := auth.ParseShareToken(liveShareTokenKind, r.PathValue("token"))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "live session not found")
+		return store.LiveSession{}, 0, false
+	}
+	seq := 0
+	if after != "" {
+		if seq, err = strconv.Atoi(after); err != nil || seq < 0 {
+			writeErr(w, http.StatusBadRequest, "after must be a batch sequence number")
+			return store.LiveSession{}, 0, false
+		}
+	}
+	l, err := h.store.GetLiveSession(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "live session not found")
+			return store.LiveSession{}, 0, false
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch live session")
+		return store.LiveSession{}, 0, false
+	}
+	return l, seq, true
+}
+
+// followLiveSession answers a follower's poll. ?after= is the seq of the
+// previous answer, so only new positions are sent.
+func (h *Handler) followLiveSession(w http.ResponseWriter, r *http.Request) {
+	l, after, ok := h.publicLiveSession(w, r, r.URL.Query().Get("after"))
+	if !ok {
+		return
+	}
+	view, err := h.liveFollowView(r.Context(), l, after)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to fetch live points")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, view)
+}
+
+// streamLiveSession sends a follower server-sent "update" events carrying
+// the same view as a poll whenever the session changes, and an "end" event
+// when it ends. Event ids are seqs, so a reconnecting EventSource resumes
+// where it left off.
+func (h *Handler) streamLiveSession(w http.ResponseWriter, r *http.Request) {
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	l, seq, ok := h.publicLiveSession(w, r, after)
+	if !ok {
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+	send := func(l store.LiveSession) error {
+		view, err := h.liveFollowView(ctx, l, seq)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(view)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: update\ndata: %s\n\n", view.Seq, data); err != nil {
+			return err
+		}
+		seq = view.Seq
+		return rc.Flush()
+	}
+	if err := send(l); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(liveStreamInterval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(liveStreamMaxDuration)
+	lastWrite := time.Now()
+	for l.Status == store.LiveActive && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.closing:
+			// The server is shutting down; the client reconnects with
+			// Last-Event-ID.
+			return
+		case <-ticker.C:
+		}
+		next, err := h.store.GetLiveSession(ctx, l.ID)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("failed to poll live session", "sessionID", l.ID, "err", err)
+			}
+			return
+		}
+		switch {
+		case next.Batches != seq || next.Status != l.Status:
+			err = send(next)
+		case time.Since(lastWrite) >= liveStreamPing:
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return
+		}
+		l, lastWrite = next, time.Now()
+	}
+	if l.Status != store.LiveActive {
+		fmt.Fprintf(w, "event: end\ndata: {\"status\":%q}\n\n", l.Status)
+		_ = rc.Flush()
+	}
+}
+
+// finishIdleLiveSessions finishes sessions whose device stopped sending
+// without ending them, and drops the batches of sessions long ended.
+func (h *Handler) finishIdleLiveSessions(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		ids, err := h.store.ListIdleLiveSessions(ctx, time.Now().Add(-liveIdleAfter))
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("failed to list idle live sessions", "err", err)
+		}
+		for _, id := range ids {
+			if _, err := h.finishLive(ctx, id); err != nil && !errors.Is(err, store.ErrLiveSessionEnded) {
+				slog.Warn("failed to finish idle live session", "sessionID", id, "err", err)
+			}
+		}
+		if n, err := h.store.PurgeLivePoints(ctx, time.Now().Add(-liveKeepPoints)); err != nil && ctx.Err() == nil {
+			slog.Warn("failed to purge live points", "err", err)
+		} else if n > 0 {
+			slog.Info("purged live points", "batches", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
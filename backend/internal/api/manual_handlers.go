@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+const (
+	maxManualDurationSec = 7 * 24 * 3600
+	maxManualDistanceKM  = 1000
+	maxNotesLength       = 5000
+)
+
+// createManualActivity logs an activity without a GPS track, e.g. a swim,
+// gym session or treadmill run. It goes through the same post-upload steps
+// as a file upload, so gear, stats and calories treat it alike.
+func (h *Handler) createManualActivity(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		SportType   string     `json:"sportType"`
+		Name        string     `json:"name"`
+		StartTime   *time.Time `json:"startTime"`
+		DurationSec int        `json:"durationSec"`
+		DistanceKM  float64    `json:"distanceKm"`
+		ElevGainM   float64    `json:"elevGainM"`
+		AvgHR       float64    `json:"avgHr"`
+		MaxHR       int        `json:"maxHr"`
+		AvgPowerW   float64    `json:"avgPowerW"`
+		Notes       string     `json:"notes"`
+		Visibility  string     `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.SportType = strings.TrimSpace(req.SportType)
+	req.Name = strings.TrimSpace(req.Name)
+	switch {
+	case req.SportType == "":
+		writeErr(w, http.StatusBadRequest, "sportType is required")
+		return
+	case req.DurationSec <= 0 || req.DurationSec > maxManualDurationSec:
+		writeErr(w, http.StatusBadRequest, "durationSec must be between 1 and 604800")
+		return
+	case req.DistanceKM < 0 || req.DistanceKM > maxManualDistanceKM:
+		writeErr(w, http.StatusBadRequest, "distanceKm must be between 0 and 1000")
+		return
+	case req.ElevGainM < 0 || req.AvgPowerW < 0:
+		writeErr(w, http.StatusBadRequest, "elevGainM and avgPowerW cannot be negative")
+		return
+	case req.AvgHR < 0 || req.AvgHR > 250 || req.MaxHR < 0 || req.MaxHR > 250:
+		writeErr(w, http.StatusBadRequest, "heart rates must be between 0 and 250")
+		return
+	case len(req.Notes) > maxNotesLength:
+		writeErr(w, http.StatusBadRequest, "notes are limited to 5000 characters")
+		return
+	case req.Visibility != "" && !store.ValidVisibility(req.Visibility):
+		writeErr(w, http.StatusBadRequest, "visibility must be one of private, followers, community, public")
+		return
+	}
+	start := time.Now().UTC().Add(-time.Duration(req.DurationSec) * time.Second)
+	if req.StartTime != nil {
+		start = *req.StartTime
+	}
+	if req.Name == "" {
+		req.Name = req.SportType + " " + start.Format("2006-01-02")
+	}
+
+	computed := metrics.FromTotals(metrics.Totals{
+		Start:       start,
+		DurationSec: req.DurationSec,
+		DistanceKM:  req.DistanceKM,
+		ElevGainM:   req.ElevGainM,
+		AvgHR:       req.AvgHR,
+		MaxHR:       req.MaxHR,
+		AvgPowerW:   req.AvgPowerW,
+	})
+	h.estimateCalories(r.Context(), user.ID, req.SportType, nil, &computed)
+	activity, err := h.store.CreateManualActivity(r.Context(), user.ID, req.SportType, req.Name, req.Notes, req.Visibility, computed)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to persist activity")
+		return
+	}
+	h.afterActivityCreated(r.Context(), &activity)
+
+	writeJSON(w, http.StatusCreated, activity)
+}
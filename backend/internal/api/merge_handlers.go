@@ -0,0 +1,263 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+const maxMergeActivities = 20
+
+// mergeActivities joins activities recorded one after another, e.g. before
+// and after a watch reboot, into one. The result takes the name, sport,
+// visibility and gear of the earliest unless given; the originals are
+// archived and can be restored.
+func (h *Handler) mergeActivities(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		ActivityIDs []int64 `json:"activityIds"`
+		Name        string  `json:"name"`
+		SportType   string  `json:"sportType"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	seen := map[int64]bool{}
+	for _, id := range req.ActivityIDs {
+		seen[id] = true
+	}
+	if len(seen) < 2 || len(seen) != len(req.ActivityIDs) || len(seen) > maxMergeActivities {
+		writeErr(w, http.StatusBadRequest, "activityIds must list 2 to 20 different activities")
+		return
+	}
+
+	originals := make([]store.Activity, 0, len(req.ActivityIDs))
+	for _, id := range req.ActivityIDs {
+		a, err := h.store.GetActivity(r.Context(), id, user.ID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "activity not found")
+				return
+			}
+			writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+			return
+		}
+		if len(a.Points) < 2 {
+			writeErr(w, http.StatusBadRequest, "only activities with a track can be merged")
+			return
+		}
+		originals = append(originals, a)
+	}
+	sort.SliceStable(originals, func(i, j int) bool {
+		return originals[i].ActivityDate.Before(originals[j].ActivityDate)
+	})
+
+	tracks := make([][]gpx.Point, len(originals))
+	for i, a := range originals {
+		tracks[i] = a.Points
+	}
+	points, err := gpx.MergeTracks(tracks...)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	first := originals[0]
+	merged := store.Activity{
+		FileName:   first.FileName,
+		SportType:  first.SportType,
+		Name:       first.Name,
+		Visibility: first.Visibility,
+		GearID:     first.GearID,
+		Source:     store.SourceFile,
+		Notes:      first.Notes,
+		Points:     points,
+	}
+	if s := strings.TrimSpace(req.SportType); s != "" {
+		merged.SportType = s
+	}
+	if n := strings.TrimSpace(req.Name); n != "" {
+		merged.Name = n
+	}
+	merged.Metrics = metrics.Compute(points)
+	h.estimateCalories(r.Context(), user.ID, merged.SportType, points, &merged.Metrics)
+
+	created, err := h.store.ReplaceActivities(r.Context(), user.ID, store.ArchivedByMerge, originals, []store.Activity{merged})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to merge activities")
+		return
+	}
+	archived := make([]int64, len(originals))
+	for i, a := range originals {
+		archived[i] = a.ID
+	}
+	h.afterActivitiesReplaced(r.Context(), archived, created)
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"activity": created[0],
+		"archived": archived,
+	})
+}
+
+// splitActivity cuts an activity in two at a timestamp or point index, e.g.
+// to separate the drive home from a run. The point at the cut ends the first
+// part and starts the second. The original is archived and can be restored.
+func (h *Handler) splitActivity(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	var req struct {
+		At         *time.Time `json:"at"`
+		Index      *int       `json:"index"`
+		SportTypes []string   `json:"sportTypes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if (req.At == nil) == (req.Index == nil) {
+		writeErr(w, http.StatusBadRequest, "give either at or index")
+		return
+	}
+	if len(req.SportTypes) != 0 && len(req.SportTypes) != 2 {
+		writeErr(w, http.StatusBadRequest, "sportTypes must name both parts")
+		return
+	}
+
+	original, err := h.store.GetActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	var index int
+	if req.Index != nil {
+		index = *req.Index
+	} else {
+		index = gpx.IndexAt(original.Points, *req.At)
+	}
+	firstPart, secondPart, err := gpx.SplitTrack(original.Points, index)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	parts := make([]store.Activity, 2)
+	for i, points := range [][]gpx.Point{firstPart, secondPart} {
+		part := store.Activity{
+			FileName:   original.FileName,
+			SportType:  original.SportType,
+			Name:       fmt.Sprintf("%s (%d/2)", original.Name, i+1),
+			Visibility: original.Visibility,
+			GearID:     original.GearID,
+			Source:     store.SourceFile,
+			Points:     points,
+		}
+		if len(req.SportTypes) == 2 {
+			if s := strings.TrimSpace(req.SportTypes[i]); s != "" {
+				part.SportType = s
+			}
+		}
+		if i == 0 {
+			part.Notes = original.Notes
+		}
+		if part.SportType != original.SportType {
+			// Gear is sport-specific; the new sport's default applies.
+			part.GearID = nil
+		}
+		part.Metrics = metrics.Compute(points)
+		h.estimateCalories(r.Context(), user.ID, part.SportType, points, &part.Metrics)
+		parts[i] = part
+	}
+
+	created, err := h.store.ReplaceActivities(r.Context(), user.ID, store.ArchivedBySplit, []store.Activity{original}, parts)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to split activity")
+		return
+	}
+	h.afterActivitiesReplaced(r.Context(), []int64{original.ID}, created)
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"activities": created,
+		"archived":   []int64{original.ID},
+	})
+}
+
+// listActivityOriginals returns summaries of the archived activities an
+// activity was merged or split from.
+func (h *Handler) listActivityOriginals(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	archived, err := h.store.ListArchivedActivities(r.Context(), user.ID, id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list originals")
+		return
+	}
+	for i := range archived {
+		archived[i].Activity.Points = nil
+	}
+	if archived == nil {
+		archived = []store.ArchivedActivity{}
+	}
+	writeJSON(w, http.StatusOK, archived)
+}
+
+// restoreActivityOriginals undoes the merge or split that made an activity.
+func (h *Handler) restoreActivityOriginals(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	restored, removed, err := h.store.RestoreActivities(r.Context(), user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeErr(w, http.StatusNotFound, "activity was not made by a merge or split")
+		case errors.Is(err, store.ErrArchiveSuperseded):
+			writeErr(w, http.StatusConflict, err.Error())
+		default:
+			writeErr(w, http.StatusInternalServerError, "failed to restore activities")
+		}
+		return
+	}
+	h.afterActivitiesReplaced(r.Context(), removed, restored)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"activities": restored,
+		"removed":    removed,
+	})
+}
@@ -7,10 +7,16 @@ import (
 	"net/http"
 )
 
-func bodySizeLimitMiddleware(limit int64) func(http.Handler) http.Handler {
+// bodySizeLimitMiddleware caps request bodies at limit, or at the limit
+// given for the request's path in overrides.
+func bodySizeLimitMiddleware(limit int64, overrides map[string]int64) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			n := limit
+			if o, ok := overrides[r.URL.Path]; ok {
+				n = o
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
 			next.ServeHTTP(w, r)
 		})
 	}
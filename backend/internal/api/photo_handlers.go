@@ -0,0 +1,415 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gpx-training-analyzer/backend/internal/auth"
+	"gpx-training-analyzer/backend/internal/blob"
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/photo"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+const (
+	// maxPhotoBytes caps a single photo; the request as a whole is held to
+	// the default body limit.
+	maxPhotoBytes = 20 << 20
+	// photoThumbSize is the longest side of a thumbnail in pixels.
+	photoThumbSize = 320
+	// photoPreviewsPerPost is how many thumbnails a feed post carries.
+	photoPreviewsPerPost = 4
+)
+
+// Stored copies of a photo. The original keeps its EXIF and is only served
+// to the owner; everyone else gets the public copy, stripped of location.
+const (
+	photoOriginal = "original"
+	photoPublic   = "public"
+	photoThumb    = "thumb"
+)
+
+func photoBlobKey(photoID int64, variant string) string {
+	return "photos/" + strconv.FormatInt(photoID, 10) + "/" + variant
+}
+
+// withPhotoURLs points photos at the variant routes under prefix, the URL of
+// the activity as the viewer reaches it.
+func withPhotoURLs(photos []store.ActivityPhoto, prefix string) []store.ActivityPhoto {
+	for i := range photos {
+		base := prefix + "/photos/" + strconv.FormatInt(photos[i].ID, 10) + "/"
+		photos[i].URL = base + photoPublic
+		photos[i].ThumbnailURL = base + photoThumb
+	}
+	return photos
+}
+
+func activityURL(activityID int64) string {
+	return "/api/activities/" + strconv.FormatInt(activityID, 10)
+}
+
+// uploadActivityPhotos attaches the photos in the multipart "photos" (or
+// "photo") fields to an activity, placing each on the track by its EXIF time
+// or position.
+func (h *Handler) uploadActivityPhotos(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid multipart form")
+		return
+	}
+	files := append(r.MultipartForm.File["photos"], r.MultipartForm.File["photo"]...)
+	if len(files) == 0 {
+		writeErr(w, http.StatusBadRequest, "missing photos field")
+		return
+	}
+	caption := strings.TrimSpace(r.FormValue("caption"))
+
+	created := make([]store.ActivityPhoto, 0, len(files))
+	for _, fh := range files {
+		data, err := readPhoto(fh)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fh.Filename+": "+err.Error())
+			return
+		}
+		p, err := h.storeActivityPhoto(r.Context(), activity, caption, data)
+		if err != nil {
+			var bad badPhotoError
+			if errors.As(err, &bad) {
+				writeErr(w, http.StatusBadRequest, fh.Filename+": "+bad.Error())
+				return
+			}
+			writeErr(w, http.StatusInternalServerError, "failed to save photo")
+			return
+		}
+		created = append(created, p)
+	}
+	writeJSON(w, http.StatusCreated, withPhotoURLs(created, activityURL(id)))
+}
+
+// badPhotoError is a photo that cannot be used, as opposed to a failure to
+// store it.
+type badPhotoError struct{ msg string }
+
+func (e badPhotoError) Error() string { return e.msg }
+
+func readPhoto(fh *multipart.FileHeader) ([]byte, error) {
+	if fh.Size > maxPhotoBytes {
+		return nil, badPhotoError{"photo is larger than 20 MB"}
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// storeActivityPhoto saves a photo's row and its three copies.
+func (h *Handler) storeActivityPhoto(ctx context.Context, activity store.Activity, caption string, data []byte) (store.ActivityPhoto, error) {
+	contentType := http.DetectContentType(data)
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		return store.ActivityPhoto{}, badPhotoError{"photos must be JPEG or PNG"}
+	}
+	meta, err := photo.ReadEXIF(data)
+	if err != nil && !errors.Is(err, photo.ErrNoEXIF) {
+		return store.ActivityPhoto{}, badPhotoError{"unreadable EXIF data"}
+	}
+	thumb, size, err := photo.Thumbnail(data, photoThumbSize)
+	if err != nil {
+		return store.ActivityPhoto{}, badPhotoError{"could not decode photo"}
+	}
+	public, err := photo.StripLocation(data)
+	if err != nil {
+		return store.ActivityPhoto{}, badPhotoError{"could not decode photo"}
+	}
+
+	p := store.ActivityPhoto{
+		ActivityID:  activity.ID,
+		UserID:      *activity.UserID,
+		Caption:     caption,
+		ContentType: contentType,
+		Width:       size.Width,
+		Height:      size.Height,
+		TakenAt:     meta.Time,
+		TakenZoned:  meta.Zoned,
+		ExifLat:     meta.Lat,
+		ExifLon:     meta.Lon,
+	}
+	if placement, ok := photo.Place(activity.Points, meta); ok {
+		setPlacement(&p, activity.Points, placement)
+	}
+	p, err = h.store.CreateActivityPhoto(ctx, p)
+	if err != nil {
+		return store.ActivityPhoto{}, err
+	}
+	copies := map[string][]byte{photoOriginal: data, photoPublic: public, photoThumb: thumb}
+	for variant, b := range copies {
+		if err := h.blobs.Put(photoBlobKey(p.ID, variant), b); err != nil {
+			h.deletePhotoBlobs(p.ID)
+			if delErr := h.store.DeleteActivityPhoto(ctx, p.UserID, p.ActivityID, p.ID); delErr != nil {
+				slog.Warn("failed to remove photo after storage failure", "photoID", p.ID, "err", delErr)
+			}
+			return store.ActivityPhoto{}, err
+		}
+	}
+	return p, nil
+}
+
+func setPlacement(p *store.ActivityPhoto, points []gpx.Point, placement photo.Placement) {
+	pt := points[placement.Index]
+	p.PointIndex = &placement.Index
+	p.Lat, p.Lon = &pt.Lat, &pt.Lon
+	p.PlacedBy = placement.By
+}
+
+func (h *Handler) deletePhotoBlobs(photoID int64) {
+	for _, variant := range []string{photoOriginal, photoPublic, photoThumb} {
+		if err := h.blobs.Delete(photoBlobKey(photoID, variant)); err != nil && !errors.Is(err, blob.ErrNotFound) {
+			slog.Warn("failed to delete photo", "photoID", photoID, "variant", variant, "err", err)
+		}
+	}
+}
+
+// placeActivityPhotos places the photos of activities again after their
+// tracks changed. Photos of a merge, split or restore are searched across
+// all of the new activities and move to the one they were taken on.
+func (h *Handler) placeActivityPhotos(ctx context.Context, activities []store.Activity) {
+	if len(activities) == 0 {
+		return
+	}
+	ids := make([]int64, len(activities))
+	tracks := make([][]gpx.Point, len(activities))
+	for i, a := range activities {
+		ids[i], tracks[i] = a.ID, a.Points
+	}
+	photos, err := h.store.ListActivityPhotos(ctx, ids...)
+	if err != nil {
+		slog.Warn("failed to list photos for placement", "activityIDs", ids, "err", err)
+		return
+	}
+	for _, p := range photos {
+		meta := photo.Meta{Time: p.TakenAt, Zoned: p.TakenZoned, Lat: p.ExifLat, Lon: p.ExifLon}
+		placed := store.ActivityPhoto{ActivityID: p.ActivityID}
+		if track, placement, ok := photo.PlaceOn(tracks, meta); ok {
+			placed.ActivityID = activities[track].ID
+			setPlacement(&placed, tracks[track], placement)
+		}
+		if err := h.store.PlacePhoto(ctx, p.ID, placed.ActivityID, placed.PointIndex, placed.Lat, placed.Lon, placed.PlacedBy); err != nil {
+			slog.Warn("failed to place photo", "photoID", p.ID, "err", err)
+		}
+	}
+}
+
+func (h *Handler) listActivityPhotos(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	if _, err := h.store.GetVisibleActivity(r.Context(), id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	photos, err := h.store.ListActivityPhotos(r.Context(), id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list photos")
+		return
+	}
+	writeJSON(w, http.StatusOK, withPhotoURLs(photos, activityURL(id)))
+}
+
+func (h *Handler) deleteActivityPhoto(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	photoID, err := strconv.ParseInt(r.PathValue("photoId"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid photo id")
+		return
+	}
+	if err := h.store.DeleteActivityPhoto(r.Context(), user.ID, id, photoID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "photo not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to delete photo")
+		return
+	}
+	h.deletePhotoBlobs(photoID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// activityPhotoFile serves a copy of a photo to a signed-in viewer of the
+// activity. Only the owner may fetch the original.
+func (h *Handler) activityPhotoFile(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetVisibleActivity(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	owner := activity.UserID != nil && *activity.UserID == user.ID
+	h.servePhoto(w, r, id, owner, "private, max-age=3600")
+}
+
+func (h *Handler) publicActivityPhotoFile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	if _, err := h.store.GetPublicActivity(r.Context(), id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	h.servePhoto(w, r, id, false, "public, max-age=3600")
+}
+
+func (h *Handler) publicSharedActivityPhotoFile(w http.ResponseWriter, r *http.Request) {
+	linkID, err := auth.ParseShareToken(shareTokenKind, r.PathValue("token"))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "share link not found")
+		return
+	}
+	activity, _, err := h.store.GetSharedActivity(r.Context(), linkID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "share link not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	// Revoking a link must take effect, so shared photos are not cached.
+	h.servePhoto(w, r, activity.ID, false, "no-store")
+}
+
+// servePhoto writes the {variant} copy of the {photoId} photo of an activity
+// the caller has already been allowed to see.
+func (h *Handler) servePhoto(w http.ResponseWriter, r *http.Request, activityID int64, owner bool, cacheControl string) {
+	photoID, err := strconv.ParseInt(r.PathValue("photoId"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid photo id")
+		return
+	}
+	variant := r.PathValue("variant")
+	switch {
+	case variant == photoOriginal && !owner:
+		writeErr(w, http.StatusNotFound, "photo not found")
+		return
+	case variant != photoOriginal && variant != photoPublic && variant != photoThumb:
+		writeErr(w, http.StatusNotFound, "photo not found")
+		return
+	}
+	p, err := h.store.GetActivityPhoto(r.Context(), activityID, photoID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "photo not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch photo")
+		return
+	}
+	data, err := h.blobs.Get(photoBlobKey(p.ID, variant))
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to load photo")
+		return
+	}
+	contentType := p.ContentType
+	if variant == photoThumb {
+		contentType = "image/jpeg"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// activityPhotos returns an activity's photos with URLs under prefix, or an
+// empty list when they cannot be read; photos never fail the activity.
+func (h *Handler) activityPhotos(ctx context.Context, activityID int64, prefix string) []store.ActivityPhoto {
+	photos, err := h.store.ListActivityPhotos(ctx, activityID)
+	if err != nil {
+		slog.Warn("failed to list activity photos", "activityID", activityID, "err", err)
+		return []store.ActivityPhoto{}
+	}
+	return withPhotoURLs(photos, prefix)
+}
+
+// addPostPhotos fills the photo previews of feed posts whose activity the
+// viewer may see.
+func (h *Handler) addPostPhotos(ctx context.Context, posts []store.CommunityPost) {
+	var ids []int64
+	for i := range posts {
+		posts[i].Photos = []store.ActivityPhoto{}
+		if posts[i].ActivityID != nil {
+			ids = append(ids, *posts[i].ActivityID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	previews, err := h.store.ListPhotoPreviews(ctx, ids, photoPreviewsPerPost)
+	if err != nil {
+		slog.Warn("failed to load photo previews", "err", err)
+		return
+	}
+	for i := range posts {
+		if id := posts[i].ActivityID; id != nil && previews[*id] != nil {
+			posts[i].Photos = withPhotoURLs(previews[*id], activityURL(*id))
+		}
+	}
+}
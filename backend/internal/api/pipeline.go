@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// afterActivityCreated runs the post-upload enrichment steps on a newly stored
+// activity. Each step logs its own failures and never fails the upload.
+func (h *Handler) afterActivityCreated(ctx context.Context, activity *store.Activity) {
+	h.applyDefaultGear(ctx, activity)
+	if activity.UserID != nil {
+		h.invalidateHeatmap(ctx, *activity.UserID, geo.FromPoints(activity.Points))
+	}
+	h.matchActivityRoute(ctx, activity)
+	h.queueActivitySegmentMatch(ctx, *activity)
+	h.queueActivityCard(ctx, *activity)
+	h.queueRacePrediction(ctx, *activity)
+}
+
+// afterActivitiesReplaced tidies up after a merge, split or restore replaced
+// the activities removedIDs with created. Both cover the same ground, so the
+// heatmap refresh of the created activities covers the removed ones.
+func (h *Handler) afterActivitiesReplaced(ctx context.Context, removedIDs []int64, created []store.Activity) {
+	for _, id := range removedIDs {
+		if err := h.blobs.Delete(cardBlobKey(id)); err != nil {
+			slog.Warn("failed to delete activity card", "activityID", id, "err", err)
+		}
+	}
+	h.placeActivityPhotos(ctx, created)
+	for i := range created {
+		h.afterActivityCreated(ctx, &created[i])
+	}
+}
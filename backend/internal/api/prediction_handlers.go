@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+const (
+	defaultPredictionDays = 90
+	maxPredictionDays     = 365
+	// racePredictionDelay is how long the snapshot waits after a run, so
+	// that a bulk or account import refreshes it once rather than per file.
+	racePredictionDelay = time.Minute
+)
+
+// computeRacePrediction estimates the runner's fitness from the best efforts
+// in their runs of the last days: the effort with the highest VDOT is the
+// reference both models predict from.
+func (h *Handler) computeRacePrediction(ctx context.Context, userID int64, days int) (store.RacePrediction, error) {
+	now := time.Now().UTC()
+	runs, err := h.store.ListRunTracksSince(ctx, userID, now.AddDate(0, 0, -days))
+	if err != nil {
+		return store.RacePrediction{}, err
+	}
+	p := store.RacePrediction{
+		ComputedOn:  now.Format("2006-01-02"),
+		WindowDays:  days,
+		Predictions: []metrics.Prediction{},
+	}
+	var all []metrics.Effort
+	var bestVDOT float64
+	for _, run := range runs {
+		efforts := metrics.BestEfforts(run.Points)
+		all = append(all, efforts...)
+		if effort, vdot, ok := metrics.BestVDOT(efforts); ok && vdot > bestVDOT {
+			bestVDOT = vdot
+			id := run.ID
+			p.ReferenceActivityID = &id
+			p.ReferenceEffort = &effort
+		}
+	}
+	p.SparseReason = metrics.SparseReason(len(runs), all)
+	p.Sparse = p.SparseReason != ""
+	if p.ReferenceEffort != nil {
+		p.VDOT = math.Round(bestVDOT*10) / 10
+		p.Predictions = metrics.PredictRaces(*p.ReferenceEffort)
+	}
+	return p, nil
+}
+
+// racePredictions returns the current VDOT and predicted 5K, 10K, half and
+// full marathon times from the runs of the last days (default 90). sparse is
+// set when there is too little data to trust them. Each call records the
+// day's snapshot.
+func (h *Handler) racePredictions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	days := defaultPredictionDays
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 7 || n > maxPredictionDays {
+			writeErr(w, http.StatusBadRequest, "days must be between 7 and 365")
+			return
+		}
+		days = n
+	}
+	p, err := h.computeRacePrediction(r.Context(), user.ID, days)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to compute predictions")
+		return
+	}
+	if days == defaultPredictionDays {
+		if err := h.store.SaveRacePrediction(r.Context(), user.ID, p); err != nil {
+			slog.Warn("failed to save race prediction", "userID", user.ID, "err", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// racePredictionHistory lists the daily snapshots, optionally between from
+// and to (YYYY-MM-DD, inclusive).
+func (h *Handler) racePredictionHistory(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	from, to, err := parseDateRange(r.URL.Query())
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	history, err := h.store.ListRacePredictions(r.Context(), user.ID, from, to)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list predictions")
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// queueRacePrediction queues refreshing the day's snapshot after a run in
+// the window. Runs stored while it is queued join it.
+func (h *Handler) queueRacePrediction(ctx context.Context, activity store.Activity) {
+	if activity.UserID == nil || !metrics.IsRunning(activity.SportType) {
+		return
+	}
+	if activity.ActivityDate.Before(time.Now().AddDate(0, 0, -defaultPredictionDays)) {
+		return
+	}
+	userID := *activity.UserID
+	if _, err := h.store.EnqueueUniqueJob(ctx, userID, jobRacePrediction, "", nil, time.Now().Add(racePredictionDelay), jobMaxAttempts); err != nil {
+		slog.Warn("failed to queue race prediction", "userID", userID, "err", err)
+	}
+}
+
+func (h *Handler) runRacePredictionJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	p, err := h.computeRacePrediction(ctx, job.UserID, defaultPredictionDays)
+	if err != nil {
+		return jobResult{}, err
+	}
+	return jobResult{}, h.store.SaveRacePrediction(ctx, job.UserID, p)
+}
@@ -101,9 +101,14 @@ func (rl *rateLimiter) getBucket(ip string) *tokenBucket {
 }
 
 func (rl *rateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	return rl.limitBy(realIP, next)
+}
+
+// limitBy limits requests sharing the key returned for them, such as the
+// token of a live session, rather than the client address.
+func (rl *rateLimiter) limitBy(key func(*http.Request) string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ip := realIP(r)
-		if !rl.getBucket(ip).allow() {
+		if !rl.getBucket(key(r)).allow() {
 			w.Header().Set("Retry-After", "60")
 			writeErr(w, http.StatusTooManyRequests, "too many requests, please try again later")
 			return
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/archive"
+	"gpx-training-analyzer/backend/internal/resumable"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+// Resumable uploads follow tus 1.0 (https://tus.io/protocols/resumable-upload)
+// with the creation, expiration and termination extensions. Chunks are
+// capped by the default request body limit.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+	// maxResumableUploadBytes caps the length of a resumable upload at the
+	// largest single file the import reads.
+	maxResumableUploadBytes = archive.MaxFileSize
+	tusContentType          = "application/offset+octet-stream"
+)
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// setUploadHeaders reports where an upload stands and, once complete, the
+// job importing it.
+func setUploadHeaders(w http.ResponseWriter, u resumable.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if !u.Complete() {
+		w.Header().Set("Upload-Expires", u.ExpiresAt.Format(http.TimeFormat))
+	}
+	if u.JobID != 0 {
+		w.Header().Set("Job-Location", "/api/jobs/"+strconv.FormatInt(u.JobID, 10))
+	}
+}
+
+// requireTus rejects requests made for another protocol version.
+func requireTus(w http.ResponseWriter, r *http.Request) bool {
+	setTusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		writeErr(w, http.StatusPreconditionFailed, "unsupported Tus-Resumable version")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) resumableUploadOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(maxResumableUploadBytes))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createResumableUpload starts an upload of Upload-Length bytes. The
+// Upload-Metadata header may carry filename, sportType and visibility, as
+// in a plain upload.
+func (h *Handler) createResumableUpload(w http.ResponseWriter, r *http.Request) {
+	if !requireTus(w, r) {
+		return
+	}
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		writeErr(w, http.StatusBadRequest, "Upload-Length must be a positive number of bytes")
+		return
+	}
+	if length > maxResumableUploadBytes {
+		writeErr(w, http.StatusRequestEntityTooLarge, "uploads are limited to 64 MB")
+		return
+	}
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if v := meta["visibility"]; v != "" && !store.ValidVisibility(v) {
+		writeErr(w, http.StatusBadRequest, "visibility must be one of private, followers, community, public")
+		return
+	}
+
+	u, err := h.uploads.Create(user.ID, length, meta)
+	if errors.Is(err, resumable.ErrLimit) {
+		writeErr(w, http.StatusTooManyRequests, "too many unfinished uploads: finish or delete one first")
+		return
+	}
+	if err != nil {
+		slog.Warn("failed to create resumable upload", "userID", user.ID, "err", err)
+		writeErr(w, http.StatusInternalServerError, "failed to create upload")
+		return
+	}
+	setUploadHeaders(w, u)
+	w.Header().Set("Location", "/api/uploads/"+u.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// resumableUpload loads an upload of the user, answering 404 for others'.
+func (h *Handler) resumableUpload(w http.ResponseWriter, r *http.Request, userID int64) (resumable.Upload, bool) {
+	u, err := h.uploads.Get(r.PathValue("id"))
+	if errors.Is(err, resumable.ErrNotFound) || (err == nil && u.UserID != userID) {
+		writeErr(w, http.StatusNotFound, "upload not found or expired")
+		return resumable.Upload{}, false
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to read upload")
+		return resumable.Upload{}, false
+	}
+	return u, true
+}
+
+func (h *Handler) resumableUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !requireTus(w, r) {
+		return
+	}
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	u, ok := h.resumableUpload(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if u.Complete() && u.JobID == 0 {
+		// Queueing failed after the last chunk; try again.
+		var err error
+		u, err = h.finishResumableUpload(r.Context(), u)
+		if err != nil && !errors.Is(err, resumable.ErrBusy) {
+			slog.Warn("failed to queue resumable upload", "uploadID", u.ID, "err", err)
+			writeErr(w, http.StatusInternalServerError, "failed to queue upload")
+			return
+		}
+	}
+	setUploadHeaders(w, u)
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchResumableUpload appends a chunk at Upload-Offset. Once the last byte
+// is in, the file goes to the import pipeline as an upload job, announced in
+// the Job-Location header.
+func (h *Handler) patchResumableUpload(w http.ResponseWriter, r *http.Request) {
+	if !requireTus(w, r) {
+		return
+	}
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != tusContentType {
+		writeErr(w, http.StatusUnsupportedMediaType, "Content-Type must be "+tusContentType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeErr(w, http.StatusBadRequest, "invalid Upload-Offset")
+		return
+	}
+	if _, ok := h.resumableUpload(w, r, user.ID); !ok {
+		return
+	}
+
+	u, err := h.uploads.Append(r.PathValue("id"), offset, r.Body)
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.Is(err, resumable.ErrNotFound):
+		writeErr(w, http.StatusNotFound, "upload not found or expired")
+		return
+	case errors.Is(err, resumable.ErrOffsetMismatch):
+		setUploadHeaders(w, u)
+		writeErr(w, http.StatusConflict, "Upload-Offset does not match the bytes received")
+		return
+	case errors.Is(err, resumable.ErrBusy):
+		writeErr(w, http.StatusLocked, err.Error())
+		return
+	case errors.Is(err, resumable.ErrTooLarge):
+		setUploadHeaders(w, u)
+		writeErr(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	case errors.As(err, &tooBig):
+		setUploadHeaders(w, u)
+		writeErr(w, http.StatusRequestEntityTooLarge, "chunks are limited to 32 MB")
+		return
+	case err != nil:
+		// Usually a dropped connection: what arrived is kept and the
+		// client resumes from the offset it reads with HEAD.
+		slog.Info("resumable upload chunk interrupted", "uploadID", u.ID, "offset", u.Offset, "err", err)
+		setUploadHeaders(w, u)
+		writeErr(w, http.StatusBadRequest, "failed to read chunk")
+		return
+	}
+
+	if u.Complete() && u.JobID == 0 {
+		u, err = h.finishResumableUpload(r.Context(), u)
+		// ErrBusy: a concurrent request is queueing it.
+		if err != nil && !errors.Is(err, resumable.ErrBusy) {
+			slog.Warn("failed to queue resumable upload", "uploadID", u.ID, "err", err)
+			writeErr(w, http.StatusInternalServerError, "failed to queue upload")
+			return
+		}
+	}
+	setUploadHeaders(w, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishResumableUpload queues a complete upload for import, unless another
+// request did. The job keeps the file, so the upload's own copy is dropped.
+// On ErrBusy it returns u unchanged.
+func (h *Handler) finishResumableUpload(ctx context.Context, u resumable.Upload) (resumable.Upload, error) {
+	finished, err := h.uploads.Finish(u.ID, func(u resumable.Upload, data []byte) (int64, error) {
+		fileName := path.Base(u.Metadata["filename"])
+		if fileName == "." || fileName == "/" {
+			fileName = "upload"
+		}
+		job, err := h.store.EnqueueJob(ctx, u.UserID, jobUpload, uploadJob{
+			FileName:   fileName,
+			SportType:  strings.TrimSpace(u.Metadata["sportType"]),
+			Visibility: strings.TrimSpace(u.Metadata["visibility"]),
+		}, data, jobMaxAttempts)
+		if err != nil {
+			return 0, err
+		}
+		h.wakeWorkers()
+		return job.ID, nil
+	})
+	if errors.Is(err, resumable.ErrBusy) {
+		return u, err
+	}
+	return finished, err
+}
+
+func (h *Handler) deleteResumableUpload(w http.ResponseWriter, r *http.Request) {
+	if !requireTus(w, r) {
+		return
+	}
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	u, ok := h.resumableUpload(w, r, user.ID)
+	if !ok {
+		return
+	}
+	if err := h.uploads.Remove(u.ID); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to delete upload")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sweepResumableUploads removes expired uploads, and queues complete uploads
+// whose queueing failed, every hour.
+func (h *Handler) sweepResumableUploads(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if n, err := h.uploads.Sweep(); err != nil {
+			slog.Warn("failed to sweep resumable uploads", "err", err)
+		} else if n > 0 {
+			slog.Info("removed expired resumable uploads", "count", n)
+		}
+		unfinished, err := h.uploads.Unfinished()
+		if err != nil {
+			slog.Warn("failed to list unfinished resumable uploads", "err", err)
+		}
+		for _, u := range unfinished {
+			if _, err := h.finishResumableUpload(ctx, u); err != nil && !errors.Is(err, resumable.ErrBusy) && ctx.Err() == nil {
+				slog.Warn("failed to queue resumable upload", "uploadID", u.ID, "err", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header: comma-separated
+// keys, each followed by a space and its base64 value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("invalid Upload-Metadata: values must be base64")
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/store"
+	"gpx-training-analyzer/backend/internal/units"
+)
+
+func (h *Handler) listRoutes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	items, err := h.store.ListRoutes(r.Context(), user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list routes")
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (h *Handler) getRoute(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid route id")
+		return
+	}
+	route, err := h.store.GetRoute(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "route not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch route")
+		return
+	}
+	efforts, err := h.store.ListRouteEfforts(r.Context(), id, user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list route efforts")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"route":   route,
+		"efforts": efforts,
+	})
+}
+
+func (h *Handler) renameRoute(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid route id")
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		writeErr(w, http.StatusBadRequest, "name must be 1 to 100 characters")
+		return
+	}
+	route, err := h.store.RenameRoute(r.Context(), id, user.ID, name)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "route not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to rename route")
+		return
+	}
+	writeJSON(w, http.StatusOK, route)
+}
+
+// rebuildRoutes queues route detection over the caller's activities that are
+// not on a route yet, so history uploaded before route detection existed gets
+// grouped too. A rebuild asked for while one is still queued joins it.
+func (h *Handler) rebuildRoutes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	job, err := h.store.EnqueueUniqueJob(r.Context(), user.ID, jobRouteRebuild, "", nil, time.Now(), jobMaxAttempts)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to queue route rebuild")
+		return
+	}
+	h.wakeWorkers()
+
+	w.Header().Set("Location", "/api/jobs/"+strconv.FormatInt(job.ID, 10))
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// runRouteRebuildJob matches the user's unrouted activities, oldest first.
+// Matching is idempotent, so a retried job skips what is already grouped.
+func (h *Handler) runRouteRebuildJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	ids, err := h.store.ListUnroutedActivityIDs(ctx, job.UserID)
+	if err != nil {
+		return jobResult{}, err
+	}
+	matched := 0
+	for i, id := range ids {
+		activity, err := h.store.GetActivity(ctx, id, job.UserID)
+		if err != nil {
+			continue
+		}
+		// An earlier iteration may already have grouped this activity.
+		if activity.RouteID == nil {
+			h.matchActivityRoute(ctx, &activity)
+		}
+		if activity.RouteID != nil {
+			matched++
+		}
+		progress((i+1)*100/len(ids), fmt.Sprintf("%d of %d activities on a route", matched, len(ids)))
+	}
+	return jobResult{}, nil
+}
+
+// matchActivityRoute assigns an activity to the closest of the owner's
+// existing routes. Failing that, it looks for earlier activities on the same
+// path that are not on a route yet and starts a new route with them.
+func (h *Handler) matchActivityRoute(ctx context.Context, activity *store.Activity) {
+	if activity.UserID == nil || len(activity.Points) < 2 {
+		return
+	}
+	opts := geo.DefaultRouteMatch
+	shape := geo.RouteShape(geo.FromPoints(activity.Points), opts)
+	if len(shape) < 2 {
+		return
+	}
+	userID := *activity.UserID
+	distanceKM := activity.Metrics.DistanceKM
+
+	routes, err := h.store.ListRouteCandidates(ctx, userID, activity.SportType, shape[0], distanceKM, opts.EndpointToleranceM)
+	if err != nil {
+		slog.Warn("failed to list route candidates", "activityID", activity.ID, "err", err)
+		return
+	}
+	var best *store.Route
+	bestScore := 0.0
+	for i := range routes {
+		score, ok := geo.MatchRoute(shape, routes[i].Shape, opts)
+		if ok && (best == nil || score < bestScore) {
+			best, bestScore = &routes[i], score
+		}
+	}
+	if best != nil {
+		if err := h.store.AssignActivityRoute(ctx, activity.ID, best.ID); err != nil {
+			slog.Warn("failed to assign route", "activityID", activity.ID, "routeID", best.ID, "err", err)
+			return
+		}
+		activity.RouteID = &best.ID
+		return
+	}
+
+	candidates, err := h.store.ListUnroutedCandidates(ctx, userID, activity.ID, activity.SportType, shape[0], distanceKM, opts.EndpointToleranceM)
+	if err != nil {
+		slog.Warn("failed to list unrouted activities", "activityID", activity.ID, "err", err)
+		return
+	}
+	ids := []int64{activity.ID}
+	name := ""
+	for _, c := range candidates {
+		if _, ok := geo.MatchRoute(shape, geo.RouteShape(geo.FromPoints(c.Points), opts), opts); ok {
+			ids = append(ids, c.ActivityID)
+			if name == "" {
+				name = c.Name
+			}
+		}
+	}
+	if len(ids) < 2 {
+		return
+	}
+	if name == "" {
+		name = activity.Name
+	}
+	if name == "" {
+		name = units.FormatDistance(distanceKM, h.profileUnits(ctx, userID).System, 1) + " " + activity.SportType + " route"
+	}
+	route, err := h.store.CreateRoute(ctx, userID, store.Route{
+		Name:       name,
+		SportType:  activity.SportType,
+		DistanceKM: distanceKM,
+		Start:      shape[0],
+		End:        shape[len(shape)-1],
+		Shape:      shape,
+	}, ids)
+	if err != nil {
+		slog.Warn("failed to create route", "activityID", activity.ID, "err", err)
+		return
+	}
+	activity.RouteID = &route.ID
+}
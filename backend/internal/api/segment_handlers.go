@@ -0,0 +1,444 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/store"
+)
+
+const (
+	minSegmentLengthM      = 100
+	segmentSimplifyM       = 5
+	defaultLeaderboardSize = 50
+	maxLeaderboardSize     = 200
+)
+
+// ageGroups are the leaderboard age brackets. max 0 means open-ended.
+var ageGroups = []struct {
+	label    string
+	min, max int
+}{
+	{"0-19", 0, 19},
+	{"20-24", 20, 24},
+	{"25-34", 25, 34},
+	{"35-44", 35, 44},
+	{"45-54", 45, 54},
+	{"55-64", 55, 64},
+	{"65+", 65, 0},
+}
+
+func ageGroupFor(age int) string {
+	for _, g := range ageGroups {
+		if age >= g.min && (g.max == 0 || age <= g.max) {
+			return g.label
+		}
+	}
+	return ""
+}
+
+func ageFromBirthDate(dob string, now time.Time) (int, bool) {
+	born, err := time.Parse("2006-01-02", dob)
+	if err != nil {
+		return 0, false
+	}
+	age := now.Year() - born.Year()
+	if now.Month() < born.Month() || (now.Month() == born.Month() && now.Day() < born.Day()) {
+		age--
+	}
+	return age, true
+}
+
+func (h *Handler) createSegment(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		ActivityID int64  `json:"activityId"`
+		StartIndex int    `json:"startIndex"`
+		EndIndex   int    `json:"endIndex"`
+		Name       string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		writeErr(w, http.StatusBadRequest, "name must be 1 to 100 characters")
+		return
+	}
+	activity, err := h.store.GetActivity(r.Context(), req.ActivityID, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	if req.StartIndex < 0 || req.EndIndex >= len(activity.Points) || req.StartIndex >= req.EndIndex {
+		writeErr(w, http.StatusBadRequest, "startIndex and endIndex must select a range of the activity's points")
+		return
+	}
+
+	points := activity.Points[req.StartIndex : req.EndIndex+1]
+	coords := geo.FromPoints(points)
+	length := geo.PathLength(coords)
+	if length < minSegmentLengthM {
+		writeErr(w, http.StatusBadRequest, "segments must be at least 100 m long")
+		return
+	}
+	var gain float64
+	for i := 1; i < len(points); i++ {
+		if d := points[i].Ele - points[i-1].Ele; d > 0 {
+			gain += d
+		}
+	}
+	path := geo.Simplify(coords, segmentSimplifyM)
+
+	segment, err := h.store.CreateSegment(r.Context(), store.Segment{
+		UserID:           user.ID,
+		SourceActivityID: &activity.ID,
+		Name:             name,
+		SportType:        activity.SportType,
+		DistanceM:        length,
+		ElevGainM:        gain,
+		Start:            path[0],
+		End:              path[len(path)-1],
+		Path:             path,
+	})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to create segment")
+		return
+	}
+	// Matching the segment against past uploads runs as a job; efforts show
+	// up on the leaderboard as it goes.
+	if _, err := h.store.EnqueueJob(r.Context(), user.ID, jobSegmentMatch, segmentMatchJob{SegmentID: segment.ID}, nil, jobMaxAttempts); err != nil {
+		slog.Warn("failed to queue segment matching", "segmentID", segment.ID, "err", err)
+	} else {
+		h.wakeWorkers()
+	}
+	writeJSON(w, http.StatusCreated, segment)
+}
+
+func (h *Handler) listSegments(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	var box *geo.BBox
+	if raw := r.URL.Query().Get("bbox"); raw != "" {
+		parts := strings.Split(raw, ",")
+		vals := make([]float64, 0, 4)
+		for _, p := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				break
+			}
+			vals = append(vals, v)
+		}
+		if len(parts) != 4 || len(vals) != 4 {
+			writeErr(w, http.StatusBadRequest, "bbox must be minLat,minLon,maxLat,maxLon")
+			return
+		}
+		box = &geo.BBox{MinLat: vals[0], MinLon: vals[1], MaxLat: vals[2], MaxLon: vals[3]}
+	}
+	items, err := h.store.ListSegments(r.Context(), user.ID, box)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list segments")
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (h *Handler) getSegment(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid segment id")
+		return
+	}
+	segment, err := h.store.GetSegment(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "segment not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch segment")
+		return
+	}
+	efforts, err := h.store.ListUserSegmentEfforts(r.Context(), id, user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list efforts")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"segment":   segment,
+		"myEfforts": efforts,
+	})
+}
+
+func (h *Handler) deleteSegment(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid segment id")
+		return
+	}
+	if err := h.store.DeleteSegment(r.Context(), id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "segment not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to delete segment")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "segment deleted"})
+}
+
+// segmentLeaderboard ranks athletes by their best effort. scope selects the
+// board: overall (default), gender, age or following. The gender and age
+// boards default to the caller's own profile values and can be overridden with
+// the gender and ageGroup query parameters.
+func (h *Handler) segmentLeaderboard(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid segment id")
+		return
+	}
+	if _, err := h.store.GetSegment(r.Context(), id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "segment not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch segment")
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultLeaderboardSize
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		limit = min(v, maxLeaderboardSize)
+	}
+
+	scope := q.Get("scope")
+	if scope == "" {
+		scope = "overall"
+	}
+	var filter store.LeaderboardFilter
+	response := map[string]any{"scope": scope}
+	switch scope {
+	case "overall":
+	case "gender":
+		gender := strings.TrimSpace(q.Get("gender"))
+		if gender == "" {
+			profile, err := h.store.GetProfile(r.Context(), user.ID)
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "failed to fetch profile")
+				return
+			}
+			gender = profile.Gender
+		}
+		if gender == "" {
+			writeErr(w, http.StatusBadRequest, "set a gender on your profile or pass the gender parameter")
+			return
+		}
+		filter.Gender = gender
+		response["gender"] = gender
+	case "age":
+		label := q.Get("ageGroup")
+		if label == "" {
+			profile, err := h.store.GetProfile(r.Context(), user.ID)
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "failed to fetch profile")
+				return
+			}
+			age, ok := ageFromBirthDate(profile.DateOfBirth, time.Now())
+			if !ok {
+				writeErr(w, http.StatusBadRequest, "set a date of birth on your profile or pass the ageGroup parameter")
+				return
+			}
+			label = ageGroupFor(age)
+		}
+		found := false
+		for _, g := range ageGroups {
+			if g.label == label {
+				filter.MinAge, filter.MaxAge, found = g.min, g.max, true
+			}
+		}
+		if !found {
+			writeErr(w, http.StatusBadRequest, "ageGroup must be one of 0-19, 20-24, 25-34, 35-44, 45-54, 55-64, 65+")
+			return
+		}
+		response["ageGroup"] = label
+	case "following":
+		filter.FollowersOf = user.ID
+	default:
+		writeErr(w, http.StatusBadRequest, "scope must be one of overall, gender, age, following")
+		return
+	}
+
+	entries, err := h.store.SegmentLeaderboard(r.Context(), id, user.ID, filter, limit)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to load leaderboard")
+		return
+	}
+	response["entries"] = entries
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) listActivitySegmentEfforts(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	if _, err := h.store.GetVisibleActivity(r.Context(), id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	efforts, err := h.store.ListActivitySegmentEfforts(r.Context(), id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list segment efforts")
+		return
+	}
+	writeJSON(w, http.StatusOK, efforts)
+}
+
+// queueActivitySegmentMatch queues matching a new upload against nearby
+// segments so the upload does not wait for it.
+func (h *Handler) queueActivitySegmentMatch(ctx context.Context, activity store.Activity) {
+	if activity.UserID == nil || len(activity.Points) < 2 {
+		return
+	}
+	if _, err := h.store.EnqueueJob(ctx, *activity.UserID, jobSegmentMatch, segmentMatchJob{ActivityID: activity.ID}, nil, jobMaxAttempts); err != nil {
+		slog.Warn("failed to queue segment matching", "activityID", activity.ID, "err", err)
+		return
+	}
+	h.wakeWorkers()
+}
+
+// segmentMatchJob is the payload of a segment matching job: either a new
+// segment to match against past activities, or a new activity to match
+// against nearby segments.
+type segmentMatchJob struct {
+	SegmentID  int64 `json:"segmentId,omitempty"`
+	ActivityID int64 `json:"activityId,omitempty"`
+}
+
+// runSegmentMatchJob matches a newly created segment against every existing
+// activity that overlaps it, or a new activity against the segments it
+// crosses. Efforts are upserted, so a retried job picks up where the last
+// attempt stopped.
+func (h *Handler) runSegmentMatchJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	var p segmentMatchJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return jobResult{}, fileError{errors.New("invalid segment matching job")}
+	}
+	if p.ActivityID != 0 {
+		return jobResult{}, h.matchActivitySegments(ctx, job.UserID, p.ActivityID)
+	}
+	sg, err := h.store.GetSegment(ctx, p.SegmentID, job.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		// Deleted before the job ran.
+		return jobResult{}, nil
+	}
+	if err != nil {
+		return jobResult{}, err
+	}
+	box := geo.Bounds(sg.Path).Expand(geo.DefaultSegmentMatch.CorridorM)
+	refs, err := h.store.ListActivitiesInBounds(ctx, box, sg.ID)
+	if err != nil {
+		return jobResult{}, err
+	}
+	for i, ref := range refs {
+		activity, err := h.store.GetActivity(ctx, ref.ID, ref.UserID)
+		if err != nil {
+			continue
+		}
+		h.recordSegmentEfforts(ctx, sg, activity)
+		progress((i+1)*100/len(refs), "matching")
+	}
+	return jobResult{}, nil
+}
+
+// matchActivitySegments records the efforts of an activity on the segments
+// around it that its owner may see.
+func (h *Handler) matchActivitySegments(ctx context.Context, userID, activityID int64) error {
+	activity, err := h.store.GetActivity(ctx, activityID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		// Deleted before the job ran.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(activity.Points) < 2 {
+		return nil
+	}
+	box := geo.Bounds(geo.FromPoints(activity.Points)).Expand(geo.DefaultSegmentMatch.CorridorM)
+	segments, err := h.store.ListSegmentsInBounds(ctx, box, activity.SportType, userID)
+	if err != nil {
+		return err
+	}
+	for _, sg := range segments {
+		h.recordSegmentEfforts(ctx, sg, activity)
+	}
+	return nil
+}
+
+func (h *Handler) recordSegmentEfforts(ctx context.Context, sg store.Segment, activity store.Activity) {
+	for _, m := range geo.MatchSegment(geo.FromPoints(activity.Points), sg.Path, geo.DefaultSegmentMatch) {
+		elapsed, ok := elapsedBetween(activity.Points[m.StartIndex], activity.Points[m.EndIndex])
+		if !ok {
+			continue
+		}
+		err := h.store.SaveSegmentEffort(ctx, store.SegmentEffort{
+			SegmentID:    sg.ID,
+			ActivityID:   activity.ID,
+			UserID:       *activity.UserID,
+			ElapsedSec:   elapsed,
+			StartIndex:   m.StartIndex,
+			EndIndex:     m.EndIndex,
+			ActivityDate: activity.ActivityDate,
+		})
+		if err != nil {
+			slog.Warn("failed to save segment effort", "segmentID", sg.ID, "activityID", activity.ID, "err", err)
+		}
+	}
+}
+
+func elapsedBetween(a, b gpx.Point) (float64, bool) {
+	if a.Time == nil || b.Time == nil || !b.Time.After(*a.Time) {
+		return 0, false
+	}
+	return b.Time.Sub(*a.Time).Seconds(), true
+}
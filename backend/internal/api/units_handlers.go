@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/store"
+	"gpx-training-analyzer/backend/internal/units"
+)
+
+// unitsHeader is the header alternative to the units query parameter.
+const unitsHeader = "X-Units"
+
+// errInvalidUnits is returned for a units value other than metric, imperial
+// or preferred.
+var errInvalidUnits = errors.New("units must be metric, imperial or preferred")
+
+// activityView is an activity plus its metrics in the requested units.
+type activityView struct {
+	store.Activity
+	Display *units.Summary        `json:"display,omitempty"`
+	Photos  []store.ActivityPhoto `json:"photos,omitempty"`
+}
+
+func unitPreferences(p store.AthleteProfile) units.Preferences {
+	system, _ := units.ParseSystem(p.UnitSystem)
+	return units.Preferences{System: system, SportDisplay: p.SportDisplay}
+}
+
+// profileUnits returns a user's unit preferences, metric when the profile
+// cannot be read.
+func (h *Handler) profileUnits(ctx context.Context, userID int64) units.Preferences {
+	profile, err := h.store.GetProfile(ctx, userID)
+	if err != nil {
+		slog.Warn("failed to load unit preferences", "userID", userID, "err", err)
+		return units.Preferences{System: units.Metric}
+	}
+	return unitPreferences(profile)
+}
+
+// requestedUnits reads the units query parameter, or the X-Units header:
+// "metric" or "imperial" select a system, "preferred" the one on prefsUserID's
+// profile. Sport pace/speed choices always come from that profile. ok is false
+// when neither is given; responses then carry only the canonical SI metrics.
+func (h *Handler) requestedUnits(r *http.Request, prefsUserID int64) (prefs units.Preferences, ok bool, err error) {
+	raw := r.URL.Query().Get("units")
+	if raw == "" {
+		raw = r.Header.Get(unitsHeader)
+	}
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	if raw == "" {
+		return units.Preferences{}, false, nil
+	}
+	system, known := units.ParseSystem(raw)
+	if !known && raw != "preferred" {
+		return units.Preferences{}, false, errInvalidUnits
+	}
+	prefs = units.Preferences{System: units.Metric}
+	if prefsUserID != 0 {
+		prefs = h.profileUnits(r.Context(), prefsUserID)
+	}
+	if known {
+		prefs.System = system
+	}
+	return prefs, true, nil
+}
+
+// withDisplay wraps activities with their metrics converted to prefs.
+func withDisplay(activities []store.Activity, prefs units.Preferences, convert bool) []activityView {
+	out := make([]activityView, len(activities))
+	for i, a := range activities {
+		out[i] = activityView{Activity: a}
+		if convert {
+			summary := units.Summarize(a.Metrics, a.SportType, prefs)
+			out[i].Display = &summary
+		}
+	}
+	return out
+}
+
+// validUnitPreferences checks the unit fields of a profile update.
+func validUnitPreferences(p store.AthleteProfile) bool {
+	if p.UnitSystem != "" {
+		if _, ok := units.ParseSystem(p.UnitSystem); !ok {
+			return false
+		}
+	}
+	for _, d := range p.SportDisplay {
+		if d != units.ShowPace && d != units.ShowSpeed {
+			return false
+		}
+	}
+	return true
+}
+
+// queueActivityCardsReset queues dropping a user's stored cards so they are
+// rendered again, in the new units, when next requested.
+func (h *Handler) queueActivityCardsReset(ctx context.Context, userID int64) {
+	if _, err := h.store.EnqueueUniqueJob(ctx, userID, jobActivityCardsReset, "", nil, time.Now(), jobMaxAttempts); err != nil {
+		slog.Warn("failed to queue card refresh", "userID", userID, "err", err)
+		return
+	}
+	h.wakeWorkers()
+}
+
+func (h *Handler) runActivityCardsResetJob(ctx context.Context, job store.Job, progress func(int, string)) (jobResult, error) {
+	ids, err := h.store.ListActivityIDs(ctx, job.UserID)
+	if err != nil {
+		return jobResult{}, err
+	}
+	for _, id := range ids {
+		if err := h.blobs.Delete(cardBlobKey(id)); err != nil {
+			return jobResult{}, err
+		}
+	}
+	return jobResult{}, nil
+}
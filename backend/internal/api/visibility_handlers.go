@@ -0,0 +1,281 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"gpx-training-analyzer/backend/internal/auth"
+	"gpx-training-analyzer/backend/internal/store"
+	"gpx-training-analyzer/backend/internal/units"
+)
+
+const shareTokenKind = "activity-share"
+
+func frontendBaseURL() string {
+	if u := os.Getenv("FRONTEND_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:5173"
+}
+
+func (h *Handler) setActivityVisibility(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	var req struct {
+		Visibility string `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !store.ValidVisibility(req.Visibility) {
+		writeErr(w, http.StatusBadRequest, "visibility must be one of private, followers, community, public")
+		return
+	}
+	if err := h.store.SetActivityVisibility(r.Context(), id, user.ID, req.Visibility); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to update visibility")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"visibility": req.Visibility})
+}
+
+type shareLinkResponse struct {
+	store.ShareLink
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+func newShareLinkResponse(l store.ShareLink) (shareLinkResponse, error) {
+	token, err := auth.IssueShareToken(shareTokenKind, l.ID)
+	if err != nil {
+		return shareLinkResponse{}, err
+	}
+	return shareLinkResponse{
+		ShareLink: l,
+		Token:     token,
+		URL:       frontendBaseURL() + "/share/" + token,
+	}, nil
+}
+
+func (h *Handler) createShareLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	link, err := h.store.CreateShareLink(r.Context(), id, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to create share link")
+		return
+	}
+	resp, err := newShareLinkResponse(link)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to sign share link")
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (h *Handler) listShareLinks(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	links, err := h.store.ListShareLinks(r.Context(), id, user.ID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to list share links")
+		return
+	}
+	items := make([]shareLinkResponse, 0, len(links))
+	for _, l := range links {
+		resp, err := newShareLinkResponse(l)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "failed to sign share link")
+			return
+		}
+		items = append(items, resp)
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (h *Handler) revokeShareLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	linkID, err := strconv.ParseInt(r.PathValue("linkId"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid share link id")
+		return
+	}
+	if err := h.store.RevokeShareLink(r.Context(), linkID, id, user.ID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "share link not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to revoke share link")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "share link revoked"})
+}
+
+// publicSharedActivity serves the read-only view behind a share link. No
+// account is required.
+func (h *Handler) publicSharedActivity(w http.ResponseWriter, r *http.Request) {
+	linkID, err := auth.ParseShareToken(shareTokenKind, r.PathValue("token"))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "share link not found")
+		return
+	}
+	activity, ownerName, err := h.store.GetSharedActivity(r.Context(), linkID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "share link not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	view := publicActivityView(activity, ownerName)
+	view["cardUrl"] = "/api/public/share/" + r.PathValue("token") + "/card"
+	view["photos"] = h.activityPhotos(r.Context(), activity.ID, "/api/public/share/"+r.PathValue("token"))
+	if !h.addPublicDisplay(w, r, activity, view) {
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// publicActivity serves the read-only view of an activity whose visibility is
+// public. No account is required.
+func (h *Handler) publicActivity(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid activity id")
+		return
+	}
+	activity, err := h.store.GetPublicActivity(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "activity not found")
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, "failed to fetch activity")
+		return
+	}
+	ownerName, _ := h.store.GetActivityOwnerName(r.Context(), id)
+	view := publicActivityView(activity, ownerName)
+	view["cardUrl"] = activityCardURL(id)
+	view["photos"] = h.activityPhotos(r.Context(), id, "/api/public/activities/"+strconv.FormatInt(id, 10))
+	if !h.addPublicDisplay(w, r, activity, view) {
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// publicActivityView is the read-only summary shown to anonymous viewers. It
+// deliberately omits the owner id, file name and gear.
+func publicActivityView(a store.Activity, ownerName string) map[string]any {
+	return map[string]any{
+		"name":         a.Name,
+		"sportType":    a.SportType,
+		"activityDate": a.ActivityDate,
+		"athleteName":  ownerName,
+		"metrics":      a.Metrics,
+		"points":       a.Points,
+	}
+}
+
+// addPublicDisplay adds converted metrics to a public view when units are
+// requested; "preferred" means the owner's preference, as the viewer may be
+// anonymous. It writes the error response and returns false on a bad value.
+func (h *Handler) addPublicDisplay(w http.ResponseWriter, r *http.Request, a store.Activity, view map[string]any) bool {
+	var ownerID int64
+	if a.UserID != nil {
+		ownerID = *a.UserID
+	}
+	prefs, convert, err := h.requestedUnits(r, ownerID)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if convert {
+		view["display"] = units.Summarize(a.Metrics, a.SportType, prefs)
+	}
+	return true
+}
+
+func (h *Handler) followUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	targetID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || targetID == user.ID {
+		writeErr(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	target, err := h.store.GetUserByID(r.Context(), targetID)
+	if err != nil || target.Status != "approved" {
+		writeErr(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err := h.store.Follow(r.Context(), user.ID, targetID); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to follow user")
+		return
+	}
+	_ = h.store.CreateNotification(r.Context(), targetID,
+		"New follower",
+		user.FirstName+" "+user.LastName+" started following you.",
+	)
+	writeJSON(w, http.StatusOK, map[string]string{"message": "following"})
+}
+
+func (h *Handler) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSubscribedUser(w, r)
+	if !ok {
+		return
+	}
+	targetID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if err := h.store.Unfollow(r.Context(), user.ID, targetID); err != nil {
+		writeErr(w, http.StatusInternalServerError, "failed to unfollow user")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "unfollowed"})
+}
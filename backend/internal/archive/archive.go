@@ -0,0 +1,234 @@
+// Package archive unpacks uploaded zip and gzip archives into the activity
+// files they contain.
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	// MaxFileSize caps each unpacked file, guarding against archive bombs.
+	MaxFileSize = 64 << 20
+	// MaxTotalSize is the default cap on everything unpacked from one
+	// upload; see Budget.
+	MaxTotalSize = 512 << 20
+	// MaxFiles caps the number of activity files taken from one archive.
+	MaxFiles = 5000
+)
+
+var (
+	ErrTooLarge      = errors.New("file is larger than 64 MB when unpacked")
+	ErrTotalTooLarge = errors.New("upload is larger than 512 MB when unpacked")
+	ErrTooMany       = errors.New("archive holds more than 5000 activity files")
+	ErrUnsupported   = errors.New("unsupported file type; expected .gpx, .tcx or .fit")
+)
+
+// Budget bounds the bytes unpacked across the files and archives of one
+// upload, so that a small archive cannot expand into more memory than the
+// upload limit suggests. It is not safe for concurrent use.
+type Budget struct {
+	left int64
+}
+
+// NewBudget returns a budget of n unpacked bytes.
+func NewBudget(n int64) *Budget {
+	return &Budget{left: n}
+}
+
+// read reads r whole, failing with ErrTooLarge past MaxFileSize and with
+// ErrTotalTooLarge once the budget is spent.
+func (b *Budget) read(r io.Reader) ([]byte, error) {
+	limit := min(int64(MaxFileSize), b.left)
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		if limit < MaxFileSize {
+			b.left = 0
+			return nil, ErrTotalTooLarge
+		}
+		return nil, ErrTooLarge
+	}
+	b.left -= int64(len(data))
+	return data, nil
+}
+
+// File is an unpacked file. Err is set when that file alone could not be
+// read; the rest of the archive is still usable.
+type File struct {
+	Name string
+	Data []byte
+	Err  error
+}
+
+// IsActivityFile reports whether name is a GPX, TCX or FIT file, optionally
+// gzipped.
+func IsActivityFile(name string) bool {
+	switch strings.ToLower(path.Ext(strings.TrimSuffix(strings.ToLower(name), ".gz"))) {
+	case ".gpx", ".tcx", ".fit":
+		return true
+	}
+	return false
+}
+
+// Expand returns the activity files in an upload: the file itself, the
+// contents of a gzip, or every activity file in a zip, gunzipping entries such
+// as Strava's "activities/123.fit.gz". Other zip entries are skipped without
+// being unpacked. A single file of an unsupported type is returned with
+// ErrUnsupported. Unpacked bytes are taken from b.
+func Expand(name string, data []byte, b *Budget) ([]File, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return ReadZip(data, b)
+	case strings.HasSuffix(lower, ".gz"):
+		f := gunzipFile(File{Name: name, Data: data}, b)
+		if f.Err == nil && !IsActivityFile(f.Name) {
+			f.Err = ErrUnsupported
+		}
+		return []File{f}, nil
+	case IsActivityFile(name):
+		return []File{{Name: name, Data: data}}, nil
+	}
+	return []File{{Name: name, Err: ErrUnsupported}}, nil
+}
+
+// ReadZip returns the activity files in a zip archive, gunzipped. Other
+// entries are neither opened nor counted towards MaxFiles. Entries that cannot
+// be read carry their error; running out of budget fails the whole archive.
+func ReadZip(data []byte, b *Budget) ([]File, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	var files []File
+	for _, entry := range r.File {
+		if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") || !IsActivityFile(entry.Name) {
+			continue
+		}
+		if len(files) == MaxFiles {
+			return nil, ErrTooMany
+		}
+		f := File{Name: entry.Name}
+		rc, err := entry.Open()
+		if err == nil {
+			f.Data, err = b.read(rc)
+			rc.Close()
+		}
+		f.Err = err
+		f = gunzipFile(f, b)
+		if errors.Is(f.Err, ErrTotalTooLarge) {
+			return nil, ErrTotalTooLarge
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// WalkUpload calls fn with each activity file of a bulk upload kept as a zip
+// holding one entry per uploaded file: files are unpacked as Expand does, and
+// the activity files of zipped entries are listed as ReadZip does. fn gets a
+// file's path, which ReadExportFile takes to read it again, with the file
+// read; only that file is held in memory. Unpacked bytes are taken from b.
+func WalkUpload(r io.ReaderAt, size int64, b *Budget, fn func(path string, f File)) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(strings.ToLower(entry.Name), ".zip") {
+			if !IsActivityFile(entry.Name) {
+				// Not read: Expand turns it down by name.
+				fn(entry.Name, File{Name: entry.Name, Err: ErrUnsupported})
+				continue
+			}
+			data, err := readEntry(entry, b)
+			if err != nil {
+				fn(entry.Name, File{Name: entry.Name, Err: err})
+				continue
+			}
+			files, _ := Expand(entry.Name, data, b)
+			fn(entry.Name, files[0])
+			continue
+		}
+
+		inner, err := openZipEntry(r, entry)
+		if err != nil {
+			fn(entry.Name, File{Name: entry.Name, Err: fmt.Errorf("invalid zip archive: %w", err)})
+			continue
+		}
+		var files []*zip.File
+		for _, e := range inner.File {
+			if !e.FileInfo().IsDir() && !strings.HasPrefix(e.Name, "__MACOSX/") && IsActivityFile(e.Name) {
+				files = append(files, e)
+			}
+		}
+		if len(files) > MaxFiles {
+			fn(entry.Name, File{Name: entry.Name, Err: ErrTooMany})
+			continue
+		}
+		for _, e := range files {
+			f := File{Name: e.Name}
+			f.Data, f.Err = readEntry(e, b)
+			fn(entry.Name+"/"+e.Name, gunzipFile(f, b))
+		}
+	}
+	return nil
+}
+
+// readEntry reads a zip entry whole, taking its bytes from b.
+func readEntry(e *zip.File, b *Budget) ([]byte, error) {
+	rc, err := e.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return b.read(rc)
+}
+
+// openZipEntry opens a zip nested in the zip r as entry e. A stored entry is
+// read in place, so that it need not fit in memory; a compressed one is read
+// whole, up to MaxFileSize.
+func openZipEntry(r io.ReaderAt, e *zip.File) (*zip.Reader, error) {
+	if e.Method == zip.Store {
+		offset, err := e.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		size := int64(e.CompressedSize64)
+		return zip.NewReader(io.NewSectionReader(r, offset, size), size)
+	}
+	data, err := entryReader(e)()
+	if err != nil {
+		return nil, err
+	}
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// gunzipFile unpacks a ".gz" file and drops the suffix from its name. Other
+// files are returned unchanged.
+func gunzipFile(f File, b *Budget) File {
+	if f.Err != nil || !strings.HasSuffix(strings.ToLower(f.Name), ".gz") {
+		return f
+	}
+	out := File{Name: f.Name[:len(f.Name)-len(".gz")]}
+	zr, err := gzip.NewReader(bytes.NewReader(f.Data))
+	if err != nil {
+		out.Err = fmt.Errorf("invalid gzip file: %w", err)
+		return out
+	}
+	defer zr.Close()
+	out.Data, out.Err = b.read(zr)
+	return out
+}
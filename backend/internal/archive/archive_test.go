@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipped(t *testing.T, entries map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExpand_Zip(t *testing.T) {
+	data := zipped(t, map[string][]byte{
+		"activities.csv":            []byte("Activity ID,Activity Name\n"),
+		"activities/1.gpx":          []byte("<gpx/>"),
+		"activities/2.fit.gz":       gzipped(t, "fit"),
+		"activities/3.tcx.gz":       []byte("not gzip"),
+		"__MACOSX/activities/1.gpx": []byte("resource fork"),
+	})
+	files, err := Expand("export.zip", data, NewBudget(MaxTotalSize))
+	if err != nil {
+		t.Fatalf("Expand: %v", err)
+	}
+	got := map[string]File{}
+	for _, f := range files {
+		got[f.Name] = f
+	}
+	if len(got) != 3 {
+		t.Fatalf("files = %v, want 3 activity files", files)
+	}
+	if string(got["activities/1.gpx"].Data) != "<gpx/>" {
+		t.Errorf("gpx entry = %q", got["activities/1.gpx"].Data)
+	}
+	if f := got["activities/2.fit"]; f.Err != nil || string(f.Data) != "fit" {
+		t.Errorf("gzipped entry = %+v", f)
+	}
+	if got["activities/3.tcx"].Err == nil {
+		t.Error("expected an error for a corrupt gzip entry")
+	}
+}
+
+func TestExpand_SingleFiles(t *testing.T) {
+	files, err := Expand("ride.gpx.gz", gzipped(t, "<gpx/>"), NewBudget(MaxTotalSize))
+	if err != nil || len(files) != 1 || files[0].Name != "ride.gpx" || string(files[0].Data) != "<gpx/>" {
+		t.Fatalf("gzip: %+v, %v", files, err)
+	}
+	files, _ = Expand("notes.txt", []byte("hello"), NewBudget(MaxTotalSize))
+	if len(files) != 1 || !errors.Is(files[0].Err, ErrUnsupported) {
+		t.Errorf("unsupported file: %+v", files)
+	}
+	files, _ = Expand("run.FIT", []byte("fit"), NewBudget(MaxTotalSize))
+	if len(files) != 1 || files[0].Err != nil {
+		t.Errorf("plain file: %+v", files)
+	}
+}
+
+func TestExpand_InvalidZip(t *testing.T) {
+	if _, err := Expand("export.zip", []byte("nope"), NewBudget(MaxTotalSize)); err == nil {
+		t.Error("expected an error for an invalid zip")
+	}
+}
+
+func TestExpand_SkipsOtherEntries(t *testing.T) {
+	entries := map[string][]byte{"activities/1.gpx": []byte("<gpx/>")}
+	for i := range MaxFiles + 1 {
+		entries["photos/"+strconv.Itoa(i)+".jpg"] = []byte("jpg")
+	}
+	files, err := Expand("export.zip", zipped(t, entries), NewBudget(MaxTotalSize))
+	if err != nil || len(files) != 1 || files[0].Name != "activities/1.gpx" {
+		t.Fatalf("files = %v, err = %v; want the gpx file only", files, err)
+	}
+}
+
+func TestExpand_Budget(t *testing.T) {
+	data := zipped(t, map[string][]byte{
+		"a.gpx":    []byte(strings.Repeat("a", 60)),
+		"b.gpx.gz": gzipped(t, strings.Repeat("b", 60)),
+	})
+	if _, err := Expand("export.zip", data, NewBudget(100)); !errors.Is(err, ErrTotalTooLarge) {
+		t.Fatalf("err = %v, want ErrTotalTooLarge", err)
+	}
+	if _, err := Expand("export.zip", data, NewBudget(1000)); err != nil {
+		t.Fatalf("within budget: %v", err)
+	}
+
+	b := NewBudget(10)
+	files, err := Expand("ride.gpx.gz", gzipped(t, strings.Repeat("c", 20)), b)
+	if err != nil || len(files) != 1 || !errors.Is(files[0].Err, ErrTotalTooLarge) {
+		t.Fatalf("gzip over budget: %+v, %v", files, err)
+	}
+}
+
+func TestWalkUpload(t *testing.T) {
+	// Stored entries, as bulk uploads are kept.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{
+		{"ride.gpx", []byte("<gpx/>")},
+		{"run.fit.gz", gzipped(t, "fit")},
+		{"notes.txt", []byte("text")},
+		{"rides.zip", zipped(t, map[string][]byte{"a/1.gpx": []byte("<gpx>1</gpx>"), "a/readme.md": []byte("skip")})},
+		{"broken.zip", []byte("not a zip")},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+
+	got := map[string]File{}
+	err := WalkUpload(r, r.Size(), NewBudget(MaxTotalSize), func(path string, f File) {
+		got[path] = f
+	})
+	if err != nil {
+		t.Fatalf("WalkUpload: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("walked %d files, want 5: %v", len(got), got)
+	}
+	for path, want := range map[string]string{"ride.gpx": "<gpx/>", "run.fit.gz": "fit", "rides.zip/a/1.gpx": "<gpx>1</gpx>"} {
+		if f := got[path]; f.Err != nil || string(f.Data) != want {
+			t.Errorf("%s = %q, %v; want %q", path, f.Data, f.Err, want)
+		}
+		// The path reads the file again.
+		if _, data, err := ReadExportFile(r, r.Size(), path); err != nil || string(data) != want {
+			t.Errorf("ReadExportFile(%s) = %q, %v; want %q", path, data, err, want)
+		}
+	}
+	if f := got["notes.txt"]; !errors.Is(f.Err, ErrUnsupported) {
+		t.Errorf("notes.txt: err = %v, want ErrUnsupported", f.Err)
+	}
+	if f := got["broken.zip"]; f.Err == nil {
+		t.Error("broken.zip: want an error")
+	}
+}
@@ -0,0 +1,328 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Account export layouts read by ReadExport.
+const (
+	ExportStrava = "strava" // activities.csv plus activities/ files
+	ExportGarmin = "garmin" // DI_CONNECT/ with zipped uploads and JSON summaries
+	ExportFiles  = "files"  // any other zip of activity files
+)
+
+// ErrNoFile is returned by ExportActivity.Read for activities the export
+// lists without a file, such as manual entries.
+var ErrNoFile = errors.New("the export has no file for this activity")
+
+// stravaDateLayout is how activities.csv writes start times, in UTC.
+const stravaDateLayout = "Jan 2, 2006, 3:04:05 PM"
+
+// ExportActivity is an activity found in an account export, with the metadata
+// the export keeps beside the file.
+type ExportActivity struct {
+	ID          string
+	FileName    string // path within the archive
+	Name        string
+	Description string
+	SportType   string
+	Gear        string
+	Start       *time.Time
+	read        func() ([]byte, error)
+}
+
+// Read returns the activity's file, gunzipped, and its name without ".gz".
+func (a ExportActivity) Read() (string, []byte, error) {
+	if a.read == nil {
+		return "", nil, ErrNoFile
+	}
+	data, err := a.read()
+	if err != nil {
+		return "", nil, err
+	}
+	f := gunzipFile(File{Name: a.FileName, Data: data}, NewBudget(MaxFileSize))
+	return f.Name, f.Data, f.Err
+}
+
+// ReadExportFile reads the file of an export listed as name in
+// ExportActivity.FileName, without listing the export again, so that its
+// activities can be imported one at a time. It returns the file gunzipped and
+// its name without ".gz", or ErrNoFile when the export has no such file.
+func ReadExportFile(r io.ReaderAt, size int64, name string) (string, []byte, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	e := findEntry(zr.File, name)
+	if e == nil {
+		// Files of Garmin upload parts are listed as "part.zip/file.fit".
+		i := strings.Index(strings.ToLower(name), ".zip/")
+		if i < 0 {
+			return "", nil, ErrNoFile
+		}
+		part := findEntry(zr.File, name[:i+len(".zip")])
+		if part == nil {
+			return "", nil, ErrNoFile
+		}
+		inner, err := openZipEntry(r, part)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: invalid zip archive: %w", path.Base(part.Name), err)
+		}
+		if e = findEntry(inner.File, name[i+len(".zip/"):]); e == nil {
+			return "", nil, ErrNoFile
+		}
+	}
+	return ExportActivity{FileName: name, read: entryReader(e)}.Read()
+}
+
+func findEntry(files []*zip.File, name string) *zip.File {
+	for _, f := range files {
+		if f.Name == name && !f.FileInfo().IsDir() {
+			return f
+		}
+	}
+	return nil
+}
+
+// Export is the content of a Strava or Garmin account export.
+type Export struct {
+	Format     string
+	Activities []ExportActivity
+}
+
+// ReadExport lists the activities of an account export zip. Files are read
+// lazily through ExportActivity.Read.
+func ReadExport(r io.ReaderAt, size int64) (*Export, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	entries := map[string]*zip.File{}
+	var stravaCSV *zip.File
+	garmin := false
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		entries[f.Name] = f
+		switch {
+		case path.Base(f.Name) == "activities.csv":
+			stravaCSV = f
+		case strings.Contains(f.Name, "DI_CONNECT/"):
+			garmin = true
+		}
+	}
+
+	switch {
+	case stravaCSV != nil:
+		activities, err := readStrava(stravaCSV, entries)
+		if err != nil {
+			return nil, err
+		}
+		return &Export{Format: ExportStrava, Activities: activities}, nil
+	case garmin:
+		activities, err := readGarmin(entries)
+		if err != nil {
+			return nil, err
+		}
+		return &Export{Format: ExportGarmin, Activities: activities}, nil
+	}
+	export := &Export{Format: ExportFiles}
+	for _, f := range zr.File {
+		if e, ok := entries[f.Name]; ok && IsActivityFile(f.Name) {
+			export.Activities = append(export.Activities, ExportActivity{FileName: f.Name, read: entryReader(e)})
+		}
+	}
+	return export, nil
+}
+
+// readStrava maps the rows of Strava's activities.csv onto the files they
+// name, relative to the CSV. Columns are found by header; Strava repeats some
+// names, and the first occurrence is used.
+func readStrava(csvFile *zip.File, entries map[string]*zip.File) ([]ExportActivity, error) {
+	data, err := entryReader(csvFile)()
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(strings.NewReader(string(data)))
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid activities.csv: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("activities.csv is empty")
+	}
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if _, dup := col[name]; !dup {
+			col[name] = i
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	dir := path.Dir(csvFile.Name)
+	var out []ExportActivity
+	for _, row := range rows[1:] {
+		a := ExportActivity{
+			ID:          field(row, "Activity ID"),
+			Name:        field(row, "Activity Name"),
+			Description: field(row, "Activity Description"),
+			SportType:   ExportSport(field(row, "Activity Type")),
+			Gear:        field(row, "Activity Gear"),
+		}
+		if t, err := time.Parse(stravaDateLayout, field(row, "Activity Date")); err == nil {
+			a.Start = &t
+		}
+		if name := field(row, "Filename"); name != "" {
+			a.FileName = path.Join(dir, name)
+			if e, ok := entries[a.FileName]; ok {
+				a.read = entryReader(e)
+			}
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// garminSummary is an activity of Garmin's summarizedActivities JSON.
+type garminSummary struct {
+	ActivityID     json.Number     `json:"activityId"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	ActivityType   json.RawMessage `json:"activityType"`   // "running" or {"typeKey": "running"}
+	BeginTimestamp float64         `json:"beginTimestamp"` // ms since the epoch
+}
+
+func (g garminSummary) sport() string {
+	var key string
+	if json.Unmarshal(g.ActivityType, &key) != nil {
+		var typed struct {
+			TypeKey string `json:"typeKey"`
+		}
+		_ = json.Unmarshal(g.ActivityType, &typed)
+		key = typed.TypeKey
+	}
+	return ExportSport(key)
+}
+
+// garminFileID finds the activity id Garmin puts at the end of uploaded file
+// names, e.g. "name@example.com_1234567890.fit".
+var garminFileID = regexp.MustCompile(`(\d+)(?:_ACTIVITY)?\.(?i:fit|gpx|tcx)(?:\.gz)?$`)
+
+// readGarmin collects the activity files of a Garmin export, including those
+// in the zipped upload parts, and names them from the activity summaries.
+func readGarmin(entries map[string]*zip.File) ([]ExportActivity, error) {
+	summaries := map[string]garminSummary{}
+	for name, e := range entries {
+		if !strings.HasSuffix(name, "_summarizedActivities.json") {
+			continue
+		}
+		data, err := entryReader(e)()
+		if err != nil {
+			return nil, err
+		}
+		var doc []struct {
+			Activities []garminSummary `json:"summarizedActivitiesExport"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path.Base(name), err)
+		}
+		for _, d := range doc {
+			for _, s := range d.Activities {
+				summaries[s.ActivityID.String()] = s
+			}
+		}
+	}
+
+	var out []ExportActivity
+	add := func(name string, read func() ([]byte, error)) {
+		a := ExportActivity{FileName: name, read: read}
+		if m := garminFileID.FindStringSubmatch(path.Base(name)); m != nil {
+			a.ID = m[1]
+			if s, ok := summaries[a.ID]; ok {
+				a.Name, a.Description, a.SportType = s.Name, s.Description, s.sport()
+				if s.BeginTimestamp > 0 {
+					t := time.UnixMilli(int64(s.BeginTimestamp)).UTC()
+					a.Start = &t
+				}
+			}
+		}
+		out = append(out, a)
+	}
+	for name, e := range entries {
+		switch {
+		case IsActivityFile(name):
+			add(name, entryReader(e))
+		case strings.HasSuffix(strings.ToLower(name), ".zip"):
+			// Upload parts are kept compressed; their files are only
+			// unpacked when read.
+			data, err := entryReader(e)()
+			if err != nil {
+				return nil, err
+			}
+			inner, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid zip archive: %w", path.Base(name), err)
+			}
+			for _, f := range inner.File {
+				if f.FileInfo().IsDir() || !IsActivityFile(f.Name) {
+					continue
+				}
+				add(path.Join(name, f.Name), entryReader(f))
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FileName < out[j].FileName })
+	return out, nil
+}
+
+// ExportSport maps the activity types of Strava and Garmin onto this app's
+// sport types. Unknown types are kept, lower-cased.
+func ExportSport(raw string) string {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	switch {
+	case s == "":
+		return ""
+	case strings.Contains(s, "run"):
+		return "running"
+	case strings.Contains(s, "ride"), strings.Contains(s, "cycl"), strings.Contains(s, "bik"):
+		return "cycling"
+	case strings.Contains(s, "swim"):
+		return "swimming"
+	case strings.Contains(s, "walk"):
+		return "walking"
+	case strings.Contains(s, "hik"):
+		return "hiking"
+	}
+	return s
+}
+
+func entryReader(f *zip.File) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return NewBudget(MaxFileSize).read(rc)
+	}
+}
@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func readExport(t *testing.T, entries map[string][]byte) *Export {
+	t.Helper()
+	data := zipped(t, entries)
+	export, err := ReadExport(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("ReadExport: %v", err)
+	}
+	return export
+}
+
+func TestReadExport_Strava(t *testing.T) {
+	csv := "Activity ID,Activity Date,Activity Name,Activity Type,Activity Description,Elapsed Time,Activity Gear,Filename,Elapsed Time\n" +
+		"101,\"Mar 4, 2023, 7:15:00 AM\",Morning Run,Run,\"Easy, with strides\",3600,Pegasus 40,activities/101.gpx.gz,3500\n" +
+		"102,\"Mar 5, 2023, 5:00:00 PM\",Commute,Ride,,1800,,activities/102.fit,\n" +
+		"103,\"Mar 6, 2023, 6:00:00 PM\",Gym,Weight Training,,2700,,,\n"
+	export := readExport(t, map[string][]byte{
+		"export_123/activities.csv":        []byte(csv),
+		"export_123/activities/101.gpx.gz": gzipped(t, "<gpx/>"),
+		"export_123/activities/102.fit":    []byte("fit"),
+		"export_123/profile.csv":           []byte("x"),
+	})
+	if export.Format != ExportStrava {
+		t.Fatalf("Format = %q, want %q", export.Format, ExportStrava)
+	}
+	if len(export.Activities) != 3 {
+		t.Fatalf("activities = %d, want 3", len(export.Activities))
+	}
+
+	run := export.Activities[0]
+	want := time.Date(2023, 3, 4, 7, 15, 0, 0, time.UTC)
+	if run.ID != "101" || run.Name != "Morning Run" || run.Description != "Easy, with strides" ||
+		run.SportType != "running" || run.Gear != "Pegasus 40" || run.Start == nil || !run.Start.Equal(want) {
+		t.Fatalf("run = %+v", run)
+	}
+	name, data, err := run.Read()
+	if err != nil || name != "export_123/activities/101.gpx" || string(data) != "<gpx/>" {
+		t.Fatalf("Read = %q, %q, %v", name, data, err)
+	}
+	if ride := export.Activities[1]; ride.SportType != "cycling" || ride.Gear != "" {
+		t.Fatalf("ride = %+v", ride)
+	}
+	if _, _, err := export.Activities[2].Read(); !errors.Is(err, ErrNoFile) {
+		t.Fatalf("Read of a manual entry = %v, want ErrNoFile", err)
+	}
+}
+
+func TestReadExport_Garmin(t *testing.T) {
+	uploads := zipped(t, map[string][]byte{
+		"me@example.com_9001.fit": []byte("fit"),
+		"me@example.com_9002.tcx": []byte("<TrainingCenterDatabase/>"),
+		"notes.txt":               []byte("x"),
+	})
+	summaries := `[{"summarizedActivitiesExport":[
+		{"activityId":9001,"name":"Tempo","activityType":"running","beginTimestamp":1.6779e12},
+		{"activityId":9002,"name":"Lap swim","activityType":{"typeKey":"lap_swimming"},"description":"2k"}
+	]}]`
+	export := readExport(t, map[string][]byte{
+		"DI_CONNECT/DI-Connect-Fitness/me@example.com_0_summarizedActivities.json": []byte(summaries),
+		"DI_CONNECT/DI-Connect-Uploaded-Files/UploadedFiles_0-_Part1.zip":          uploads,
+	})
+	if export.Format != ExportGarmin {
+		t.Fatalf("Format = %q, want %q", export.Format, ExportGarmin)
+	}
+	if len(export.Activities) != 2 {
+		t.Fatalf("activities = %+v, want 2", export.Activities)
+	}
+	tempo, swim := export.Activities[0], export.Activities[1]
+	if tempo.ID != "9001" || tempo.Name != "Tempo" || tempo.SportType != "running" ||
+		tempo.Start == nil || tempo.Start.UnixMilli() != 1677900000000 {
+		t.Fatalf("tempo = %+v", tempo)
+	}
+	if swim.Name != "Lap swim" || swim.Description != "2k" || swim.SportType != "swimming" || swim.Start != nil {
+		t.Fatalf("swim = %+v", swim)
+	}
+	if _, data, err := swim.Read(); err != nil || string(data) != "<TrainingCenterDatabase/>" {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+}
+
+func TestReadExport_Files(t *testing.T) {
+	export := readExport(t, map[string][]byte{
+		"rides/a.gpx": []byte("<gpx/>"),
+		"readme.md":   []byte("x"),
+	})
+	if export.Format != ExportFiles || len(export.Activities) != 1 || export.Activities[0].FileName != "rides/a.gpx" {
+		t.Fatalf("export = %+v", export)
+	}
+}
+
+func TestExportSport(t *testing.T) {
+	for raw, want := range map[string]string{
+		"Run":                 "running",
+		"Trail Run":           "running",
+		"Virtual Ride":        "cycling",
+		"road_biking":         "cycling",
+		"open_water_swimming": "swimming",
+		"Hike":                "hiking",
+		"Walk":                "walking",
+		"Weight Training":     "weight training",
+		"":                    "",
+	} {
+		if got := ExportSport(raw); got != want {
+			t.Errorf("ExportSport(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestReadExportFile(t *testing.T) {
+	uploads := zipped(t, map[string][]byte{"me@example.com_9001.fit": []byte("fit")})
+	data := zipped(t, map[string][]byte{
+		"export_123/activities/101.gpx.gz":                                gzipped(t, "<gpx/>"),
+		"DI_CONNECT/DI-Connect-Uploaded-Files/UploadedFiles_0-_Part1.zip": uploads,
+	})
+	r := bytes.NewReader(data)
+
+	name, got, err := ReadExportFile(r, r.Size(), "export_123/activities/101.gpx.gz")
+	if err != nil || name != "export_123/activities/101.gpx" || string(got) != "<gpx/>" {
+		t.Fatalf("gzipped file = %q, %q, %v", name, got, err)
+	}
+	const nested = "DI_CONNECT/DI-Connect-Uploaded-Files/UploadedFiles_0-_Part1.zip/me@example.com_9001.fit"
+	if _, got, err := ReadExportFile(r, r.Size(), nested); err != nil || string(got) != "fit" {
+		t.Fatalf("file in an upload part = %q, %v", got, err)
+	}
+	for _, missing := range []string{"export_123/activities/102.fit", "DI_CONNECT/DI-Connect-Uploaded-Files/UploadedFiles_0-_Part1.zip/x.fit"} {
+		if _, _, err := ReadExportFile(r, r.Size(), missing); !errors.Is(err, ErrNoFile) {
+			t.Fatalf("%s: err = %v, want ErrNoFile", missing, err)
+		}
+	}
+}
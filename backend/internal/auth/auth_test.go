@@ -147,3 +147,36 @@ func TestIssueToken_NoSecret(t *testing.T) {
 		t.Fatal("expected error when JWT_SECRET is not set")
 	}
 }
+
+func TestShareToken_RoundTrip(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test-secret-key-123")
+	defer os.Unsetenv("JWT_SECRET")
+
+	token, err := IssueShareToken("activity-share", 77)
+	if err != nil {
+		t.Fatalf("IssueShareToken() error: %v", err)
+	}
+	id, err := ParseShareToken("activity-share", token)
+	if err != nil {
+		t.Fatalf("ParseShareToken() error: %v", err)
+	}
+	if id != 77 {
+		t.Fatalf("expected id 77, got %d", id)
+	}
+}
+
+func TestShareToken_RejectsOtherKindAndTampering(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test-secret-key-123")
+	defer os.Unsetenv("JWT_SECRET")
+
+	token, _ := IssueShareToken("activity-share", 77)
+	if _, err := ParseShareToken("live-session", token); err == nil {
+		t.Fatal("expected error when parsing token for a different kind")
+	}
+	if _, err := ParseShareToken("activity-share", "78"+token[2:]); err == nil {
+		t.Fatal("expected error for tampered id")
+	}
+	if _, err := ParseShareToken("activity-share", "no-dot"); err == nil {
+		t.Fatal("expected error for invalid format")
+	}
+}
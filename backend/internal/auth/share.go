@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// IssueShareToken returns a signed, non-expiring token for a shareable
+// resource of the given kind (e.g. "activity-share"). Revocation is handled by
+// the caller, which must check that the referenced row is still active.
+func IssueShareToken(kind string, id int64) (string, error) {
+	secret := jwtSecret()
+	if secret == "" {
+		return "", errors.New("JWT_SECRET is not configured")
+	}
+	raw := strconv.FormatInt(id, 10)
+	return raw + "." + sign(kind+":"+raw, secret), nil
+}
+
+// ParseShareToken verifies a token issued by IssueShareToken for the same kind
+// and returns the id it references.
+func ParseShareToken(kind, token string) (int64, error) {
+	secret := jwtSecret()
+	if secret == "" {
+		return 0, errors.New("JWT_SECRET is not configured")
+	}
+	raw, sig, ok := strings.Cut(token, ".")
+	if !ok || raw == "" || sig == "" {
+		return 0, errors.New("invalid share token format")
+	}
+	if !hmac.Equal([]byte(sig), []byte(sign(kind+":"+raw, secret))) {
+		return 0, errors.New("invalid share token signature")
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid share token id")
+	}
+	return id, nil
+}
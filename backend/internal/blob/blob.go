@@ -0,0 +1,116 @@
+// Package blob stores binary objects such as rendered images and photos on
+// the local filesystem under a root directory.
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+type Store struct {
+	root string
+}
+
+// NewLocal returns a store rooted at dir. The directory is created on the
+// first write.
+func NewLocal(dir string) *Store {
+	return &Store{root: dir}
+}
+
+// NewFromEnv uses BLOB_DIR, defaulting to ./data/blobs.
+func NewFromEnv() *Store {
+	dir := strings.TrimSpace(os.Getenv("BLOB_DIR"))
+	if dir == "" {
+		dir = filepath.Join("data", "blobs")
+	}
+	return NewLocal(dir)
+}
+
+// path maps a slash-separated key such as "cards/42.png" to a file below the
+// root, rejecting keys that would escape it.
+func (s *Store) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || !fs.ValidPath(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes data under key, replacing any previous object atomically.
+func (s *Store) Put(key string, data []byte) error {
+	_, err := s.PutReader(key, bytes.NewReader(data))
+	return err
+}
+
+// PutReader streams r under key, replacing any previous object atomically,
+// and returns the number of bytes written. Nothing is stored when r fails.
+func (s *Store) PutReader(key string, r io.Reader) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), p)
+}
+
+func (s *Store) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Open opens the object under key for reading, for objects too large to
+// hold in memory.
+func (s *Store) Open(key string) (*os.File, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes key. Deleting a missing object is not an error.
+func (s *Store) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
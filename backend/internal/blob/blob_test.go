@@ -0,0 +1,67 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestStore_PutGetDelete(t *testing.T) {
+	s := NewLocal(t.TempDir())
+	if err := s.Put("cards/1.png", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("cards/1.png", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get("cards/1.png")
+	if err != nil || !bytes.Equal(got, []byte("two")) {
+		t.Fatalf("expected the replaced content, got %q, %v", got, err)
+	}
+	if err := s.Delete("cards/1.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("cards/1.png"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := s.Delete("cards/1.png"); err != nil {
+		t.Fatalf("deleting a missing blob must succeed, got %v", err)
+	}
+}
+
+func TestStore_RejectsEscapingKeys(t *testing.T) {
+	s := NewLocal(t.TempDir())
+	for _, key := range []string{"", "/etc/passwd", "../x", "a/../../x"} {
+		if err := s.Put(key, []byte("x")); err != ErrInvalidKey {
+			t.Fatalf("key %q: expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+}
+
+func TestStore_PutReaderOpen(t *testing.T) {
+	s := NewLocal(t.TempDir())
+	n, err := s.PutReader("imports/1.zip", strings.NewReader("export"))
+	if err != nil || n != 6 {
+		t.Fatalf("PutReader = %d, %v", n, err)
+	}
+	f, err := s.Open("imports/1.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(got) != "export" {
+		t.Fatalf("expected the streamed content, got %q, %v", got, err)
+	}
+
+	failing := io.MultiReader(strings.NewReader("part"), iotest.ErrReader(errors.New("connection reset")))
+	if _, err := s.PutReader("imports/2.zip", failing); err == nil {
+		t.Fatal("expected the reader's error")
+	}
+	if _, err := s.Open("imports/2.zip"); err != ErrNotFound {
+		t.Fatalf("a failed write must store nothing, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Package card renders activity preview images: the track drawn on a plain
+// background, an elevation profile strip and a row of key stats. Output is a
+// 1200×630 PNG, the common size for link previews, rendered in pure Go.
+package card
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	"gpx-training-analyzer/backend/internal/geo"
+)
+
+const (
+	Width  = 1200
+	Height = 630
+
+	margin     = 40
+	titleScale = 5
+	labelScale = 2
+	valueScale = 5
+	trackTop   = 100
+	trackBot   = 400
+	elevTop    = 420
+	elevBot    = 500
+	statsTop   = 530
+	simplifyM  = 3
+	lineRadius = 3
+)
+
+var (
+	background = color.NRGBA{R: 247, G: 246, B: 242, A: 255}
+	ink        = color.NRGBA{R: 30, G: 32, B: 36, A: 255}
+	muted      = color.NRGBA{R: 120, G: 124, B: 130, A: 255}
+	accent     = color.NRGBA{R: 252, G: 82, B: 0, A: 255}
+	elevFill   = color.NRGBA{R: 190, G: 196, B: 204, A: 255}
+)
+
+// Stat is one labelled value printed along the bottom of the card.
+type Stat struct {
+	Label string
+	Value string
+}
+
+type Card struct {
+	Title      string
+	Track      []geo.Coord
+	Elevations []float64 // one per track point; empty hides the strip
+	Stats      []Stat
+}
+
+// Render draws the card and encodes it as PNG.
+func Render(c Card) ([]byte, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, Width, Height))
+	fillRect(img, 0, 0, Width, Height, background)
+
+	drawText(img, margin, margin, fitText(c.Title, titleScale, Width-2*margin), titleScale, ink)
+	drawTrack(img, c.Track, image.Rect(margin, trackTop, Width-margin, trackBot))
+	drawElevation(img, c.Elevations, image.Rect(margin, elevTop, Width-margin, elevBot))
+
+	if n := len(c.Stats); n > 0 {
+		colW := (Width - 2*margin) / n
+		for i, s := range c.Stats {
+			x := margin + i*colW
+			drawText(img, x, statsTop, fitText(s.Label, labelScale, colW-10), labelScale, muted)
+			drawText(img, x, statsTop+glyphH*labelScale+10, fitText(s.Value, valueScale, colW-10), valueScale, ink)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawTrack fits the simplified track into box, keeping its aspect ratio.
+func drawTrack(img *image.NRGBA, track []geo.Coord, box image.Rectangle) {
+	track = geo.Simplify(track, simplifyM)
+	if len(track) < 2 {
+		return
+	}
+	b := geo.Bounds(track)
+	cosLat := math.Cos((b.MinLat + b.MaxLat) / 2 * math.Pi / 180)
+	spanX := (b.MaxLon - b.MinLon) * cosLat
+	spanY := b.MaxLat - b.MinLat
+	inner := box.Inset(lineRadius)
+	scale := math.Inf(1)
+	if spanX > 0 {
+		scale = float64(inner.Dx()) / spanX
+	}
+	if spanY > 0 {
+		scale = math.Min(scale, float64(inner.Dy())/spanY)
+	}
+	if math.IsInf(scale, 1) {
+		return
+	}
+	offX := float64(inner.Min.X) + (float64(inner.Dx())-spanX*scale)/2
+	offY := float64(inner.Min.Y) + (float64(inner.Dy())-spanY*scale)/2
+	project := func(c geo.Coord) (float64, float64) {
+		return offX + (c.Lon-b.MinLon)*cosLat*scale, offY + (b.MaxLat-c.Lat)*scale
+	}
+
+	for i := 1; i < len(track); i++ {
+		ax, ay := project(track[i-1])
+		bx, by := project(track[i])
+		steps := int(math.Ceil(math.Max(math.Abs(bx-ax), math.Abs(by-ay))))
+		for s := 0; s <= steps; s++ {
+			t := 0.0
+			if steps > 0 {
+				t = float64(s) / float64(steps)
+			}
+			fillDisc(img, int(ax+(bx-ax)*t), int(ay+(by-ay)*t), lineRadius, accent)
+		}
+	}
+	sx, sy := project(track[0])
+	ex, ey := project(track[len(track)-1])
+	fillDisc(img, int(ex), int(ey), lineRadius+4, ink)
+	fillDisc(img, int(sx), int(sy), lineRadius+4, color.NRGBA{R: 40, G: 160, B: 80, A: 255})
+}
+
+// drawElevation draws the elevation profile as a filled area chart, one
+// column per pixel.
+func drawElevation(img *image.NRGBA, elevations []float64, box image.Rectangle) {
+	if len(elevations) < 2 {
+		return
+	}
+	lo, hi := elevations[0], elevations[0]
+	for _, e := range elevations {
+		lo, hi = math.Min(lo, e), math.Max(hi, e)
+	}
+	span := hi - lo
+	if span < 10 {
+		// Keep nearly flat courses looking flat instead of stretching noise.
+		lo, span = lo-(10-span)/2, 10
+	}
+	for x := box.Min.X; x < box.Max.X; x++ {
+		f := float64(x-box.Min.X) / float64(box.Dx()-1) * float64(len(elevations)-1)
+		i := int(f)
+		e := elevations[i]
+		if i+1 < len(elevations) {
+			e += (elevations[i+1] - e) * (f - float64(i))
+		}
+		top := box.Max.Y - int(math.Round((e-lo)/span*float64(box.Dy())))
+		fillRect(img, x, top, 1, box.Max.Y-top, elevFill)
+	}
+}
+
+func fillRect(img *image.NRGBA, x, y, w, h int, c color.NRGBA) {
+	r := image.Rect(x, y, x+w, y+h).Intersect(img.Bounds())
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			img.SetNRGBA(px, py, c)
+		}
+	}
+}
+
+func fillDisc(img *image.NRGBA, cx, cy, radius int, c color.NRGBA) {
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if dx*dx+dy*dy <= radius*radius {
+				if image.Pt(cx+dx, cy+dy).In(img.Bounds()) {
+					img.SetNRGBA(cx+dx, cy+dy, c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package card
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"gpx-training-analyzer/backend/internal/geo"
+)
+
+func TestGlyphsAreWellFormed(t *testing.T) {
+	for r, g := range glyphs {
+		for _, row := range g {
+			if len(row) != glyphW {
+				t.Fatalf("glyph %q has a row of width %d", r, len(row))
+			}
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	track := []geo.Coord{{Lat: 33.59, Lon: -7.62}, {Lat: 33.60, Lon: -7.61}, {Lat: 33.59, Lon: -7.60}}
+	data, err := Render(Card{
+		Title:      "Morning run along the corniche",
+		Track:      track,
+		Elevations: []float64{10, 40, 20},
+		Stats:      []Stat{{"Distance", "12.34 km"}, {"Time", "1:02:03"}, {"Elevation", "345 m"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, Width, Height) {
+		t.Fatalf("unexpected card size %v", img.Bounds())
+	}
+	found := false
+	for y := trackTop; y < trackBot && !found; y++ {
+		for x := 0; x < Width; x++ {
+			if r, g, b, _ := img.At(x, y).RGBA(); r>>8 == uint32(accent.R) && g>>8 == uint32(accent.G) && b>>8 == uint32(accent.B) {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		t.Fatal("expected the track to be drawn in the accent colour")
+	}
+
+	if _, err := Render(Card{Title: "Treadmill"}); err != nil {
+		t.Fatalf("a card without a track must still render: %v", err)
+	}
+}
+
+func TestFitText(t *testing.T) {
+	s := fitText("A VERY LONG ACTIVITY NAME THAT DOES NOT FIT", 5, 300)
+	if textWidth(s, 5) > 300 {
+		t.Fatalf("%q is still too wide", s)
+	}
+}
@@ -0,0 +1,305 @@
+package course
+
+import (
+	"math"
+	"sort"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+const (
+	// DefaultToleranceM is how far from a course a track may stray and still
+	// be on it; GPS error and the width of a road fit within it.
+	DefaultToleranceM = 50.0
+	// minOffCourseM drops off-course sections whose points span less than
+	// this along the track, which are GPS noise such as a single stray fix.
+	minOffCourseM = 25.0
+	// progressWeight breaks ties between parts of a course that lie on top
+	// of each other, as on an out-and-back, in favour of the part the track
+	// is expected to be on: metres of deviation per metre along the course.
+	progressWeight = 0.2
+	// searchBehindM and searchAheadM bound the stretch of course searched
+	// around the last match, so that a course crossing or doubling back on
+	// itself is followed in order.
+	searchBehindM = 500.0
+	searchAheadM  = 2000.0
+	// coverageBinM is the resolution of the completed share of a course.
+	coverageBinM = 10.0
+)
+
+// Deviation is how far one track point is from the course, and where along
+// the course its nearest point lies.
+type Deviation struct {
+	DistanceM float64 `json:"distanceM"`
+	AlongKM   float64 `json:"alongKm"`
+	OffCourse bool    `json:"offCourse,omitempty"`
+}
+
+// OffCourseSection is a stretch of track away from the course. LeftAtKM and
+// RejoinedAtKM are where on the course it was left and joined again; they
+// are nil when the track starts or ends off course.
+type OffCourseSection struct {
+	StartIndex    int      `json:"startIndex"`
+	EndIndex      int      `json:"endIndex"`
+	LengthM       float64  `json:"lengthM"`
+	MaxDeviationM float64  `json:"maxDeviationM"`
+	LeftAtKM      *float64 `json:"leftAtKm"`
+	RejoinedAtKM  *float64 `json:"rejoinedAtKm"`
+}
+
+// Adherence is how closely a track followed a course.
+type Adherence struct {
+	ToleranceM    float64            `json:"toleranceM"`
+	CourseKM      float64            `json:"courseKm"`
+	CoveredKM     float64            `json:"coveredKm"`
+	CompletedPct  float64            `json:"completedPct"`
+	OffCourseKM   float64            `json:"offCourseKm"`
+	MaxDeviationM float64            `json:"maxDeviationM"`
+	Sections      []OffCourseSection `json:"sections"`
+	// Deviations has one entry per track point.
+	Deviations []Deviation `json:"deviations"`
+}
+
+// courseLine is a course prepared for nearest-point searches.
+type courseLine struct {
+	coords []geo.Coord
+	cum    []float64
+	// boxes bound the course in runs of chunkSegments segments, so that
+	// searches over the whole course skip the runs too far away.
+	boxes []courseBox
+}
+
+type courseBox struct {
+	geo.BBox
+	// cosLat shrinks longitudes as at the box's latitude furthest from the
+	// equator, where segments are measured with the smallest factor.
+	cosLat float64
+}
+
+// chunkSegments is how many course segments each of a courseLine's boxes
+// covers.
+const chunkSegments = 32
+
+func newCourseLine(points []gpx.Point) courseLine {
+	l := courseLine{coords: geo.FromPoints(points)}
+	l.cum = geo.CumulativeDistances(l.coords)
+	for first := 0; first+1 < len(l.coords); first += chunkSegments {
+		b := geo.Bounds(l.coords[first:min(first+chunkSegments+1, len(l.coords))])
+		cosLat := math.Cos(max(math.Abs(b.MinLat), math.Abs(b.MaxLat)) * math.Pi / 180)
+		l.boxes = append(l.boxes, courseBox{BBox: b, cosLat: cosLat})
+	}
+	return l
+}
+
+// chunk returns the segments i (from coords[i-1] to coords[i]) in box k.
+func (l courseLine) chunk(k int) (first, last int) {
+	return k*chunkSegments + 1, min((k+1)*chunkSegments, len(l.coords)-1)
+}
+
+// segment returns the distance from c to segment i and the position of the
+// nearest point along the course.
+func (l courseLine) segment(c geo.Coord, i int) (dist, along float64) {
+	d, t := geo.PointSegmentDistance(c, l.coords[i-1], l.coords[i])
+	return d, l.cum[i-1] + t*(l.cum[i]-l.cum[i-1])
+}
+
+// follow returns the distance from c to the course and the position of the
+// matching point along it, searching the segments that overlap [from, to]
+// metres along the course. Among segments about as close, the one nearest
+// expected metres along wins.
+func (l courseLine) follow(c geo.Coord, from, to, expected float64) (dist, along float64) {
+	dist, best := math.Inf(1), math.Inf(1)
+	// The first segment ending at or after from.
+	start := max(1, sort.SearchFloat64s(l.cum, from))
+	for i := start; i < len(l.coords) && l.cum[i-1] <= to; i++ {
+		d, at := l.segment(c, i)
+		if score := d + progressWeight*math.Abs(at-expected); score < best {
+			best, dist, along = score, d, at
+		}
+	}
+	return dist, along
+}
+
+// rejoin finds where a track comes back to the course: the first point
+// within tolerance at or after after metres along it, or else the nearest
+// point of the whole course. Only the boxes that could hold a closer point
+// are searched.
+func (l courseLine) rejoin(c geo.Coord, tolerance, after float64) (dist, along float64) {
+	near := make([]float64, len(l.boxes))
+	nearest := 0
+	for k, b := range l.boxes {
+		near[k] = b.distance(c)
+		if near[k] < near[nearest] {
+			nearest = k
+		}
+		if near[k] > tolerance {
+			continue
+		}
+		first, last := l.chunk(k)
+		if l.cum[last] < after {
+			continue
+		}
+		for i := first; i <= last; i++ {
+			if d, at := l.segment(c, i); d <= tolerance && at >= after {
+				return d, at
+			}
+		}
+	}
+
+	dist = math.Inf(1)
+	scan := func(k int) {
+		first, last := l.chunk(k)
+		for i := first; i <= last; i++ {
+			if d, at := l.segment(c, i); d < dist {
+				dist, along = d, at
+			}
+		}
+	}
+	if len(l.boxes) > 0 {
+		scan(nearest)
+	}
+	for k := range l.boxes {
+		if k != nearest && near[k] < dist {
+			scan(k)
+		}
+	}
+	return dist, along
+}
+
+// distance is a lower bound of the distance from c to any segment in the
+// box, as geo.PointSegmentDistance measures it.
+func (b courseBox) distance(c geo.Coord) float64 {
+	const mPerDeg = 6371000.0 * math.Pi / 180
+	dLat := max(0, b.MinLat-c.Lat, c.Lat-b.MaxLat)
+	dLon := max(0, b.MinLon-c.Lon, c.Lon-b.MaxLon)
+	return math.Hypot(dLat, dLon*b.cosLat) * mPerDeg
+}
+
+// Compare measures a track against a course point by point. Each point is
+// matched near where the previous one was, falling back to the next part of
+// the course within tolerance when the track strays, so shortcuts and missed
+// loops show as gaps in the completed share.
+func Compare(coursePoints, track []gpx.Point, toleranceM float64) Adherence {
+	if toleranceM <= 0 {
+		toleranceM = DefaultToleranceM
+	}
+	line := newCourseLine(coursePoints)
+	total := 0.0
+	if len(line.cum) > 0 {
+		total = line.cum[len(line.cum)-1]
+	}
+	a := Adherence{
+		ToleranceM: toleranceM,
+		CourseKM:   round2(total / 1000),
+		Sections:   []OffCourseSection{},
+		Deviations: make([]Deviation, len(track)),
+	}
+	if len(coursePoints) < 2 || len(track) == 0 {
+		return a
+	}
+
+	coords := geo.FromPoints(track)
+	trackCum := geo.CumulativeDistances(coords)
+	covered := make([]bool, int(total/coverageBinM)+1)
+
+	matched := false
+	var lastAlong float64
+	lastOn := -1
+	for i, c := range coords {
+		dist, along := math.Inf(1), 0.0
+		if matched {
+			expected := lastAlong + trackCum[i] - trackCum[lastOn]
+			dist, along = line.follow(c, lastAlong-searchBehindM, lastAlong+searchAheadM, expected)
+		}
+		if dist > toleranceM {
+			dist, along = line.rejoin(c, toleranceM, lastAlong-searchBehindM)
+		}
+		off := dist > toleranceM
+		a.Deviations[i] = Deviation{DistanceM: math.Round(dist*10) / 10, AlongKM: round3(along / 1000), OffCourse: off}
+		a.MaxDeviationM = math.Max(a.MaxDeviationM, a.Deviations[i].DistanceM)
+		if off {
+			continue
+		}
+		// Cover the course between consecutive points on it unless the track
+		// jumped along it, as where two parts of a course meet.
+		if i > 0 && lastOn == i-1 && !track[i].Gap {
+			step := trackCum[i] - trackCum[lastOn]
+			if math.Abs(along-lastAlong) <= step+2*toleranceM {
+				markCovered(covered, lastAlong, along)
+			}
+		}
+		matched, lastAlong, lastOn = true, along, i
+	}
+
+	a.Sections = offCourseSections(a.Deviations, trackCum)
+	for _, s := range a.Sections {
+		a.OffCourseKM += s.LengthM / 1000
+	}
+	a.OffCourseKM = round2(a.OffCourseKM)
+
+	n := 0
+	for _, c := range covered {
+		if c {
+			n++
+		}
+	}
+	share := float64(n) / float64(len(covered))
+	a.CoveredKM = round2(share * total / 1000)
+	a.CompletedPct = math.Round(share*1000) / 10
+	return a
+}
+
+func markCovered(covered []bool, from, to float64) {
+	if from > to {
+		from, to = to, from
+	}
+	last := len(covered) - 1
+	for b := int(from / coverageBinM); b <= int(to/coverageBinM) && b <= last; b++ {
+		covered[b] = true
+	}
+}
+
+// offCourseSections groups consecutive off-course points. A section's
+// length runs from the last point on course to the first one back on it.
+func offCourseSections(devs []Deviation, trackCum []float64) []OffCourseSection {
+	sections := []OffCourseSection{}
+	for i := 0; i < len(devs); i++ {
+		if !devs[i].OffCourse {
+			continue
+		}
+		j := i
+		for j+1 < len(devs) && devs[j+1].OffCourse {
+			j++
+		}
+		s := OffCourseSection{StartIndex: i, EndIndex: j}
+		from, to := i, j
+		if i > 0 {
+			from = i - 1
+			left := devs[i-1].AlongKM
+			s.LeftAtKM = &left
+		}
+		if j+1 < len(devs) {
+			to = j + 1
+			rejoined := devs[j+1].AlongKM
+			s.RejoinedAtKM = &rejoined
+		}
+		s.LengthM = math.Round(trackCum[to] - trackCum[from])
+		for k := i; k <= j; k++ {
+			s.MaxDeviationM = math.Max(s.MaxDeviationM, devs[k].DistanceM)
+		}
+		if trackCum[j]-trackCum[i] < minOffCourseM {
+			for k := i; k <= j; k++ {
+				devs[k].OffCourse = false
+			}
+		} else {
+			sections = append(sections, s)
+		}
+		i = j
+	}
+	return sections
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
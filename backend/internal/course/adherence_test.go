@@ -0,0 +1,138 @@
+package course
+
+import (
+	"math"
+	"testing"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// line returns points every 10 m going north from 45°N, shifted eastM east.
+func line(fromM, toM, eastM float64) []gpx.Point {
+	const mPerDegLat = 111195.0
+	mPerDegLon := mPerDegLat * math.Cos(45*math.Pi/180)
+	var out []gpx.Point
+	for m := fromM; m <= toM+1e-6; m += 10 {
+		out = append(out, gpx.Point{Lat: 45 + m/mPerDegLat, Lon: 6 + eastM/mPerDegLon})
+	}
+	return out
+}
+
+func TestCompareOnCourse(t *testing.T) {
+	course := line(0, 2000, 0)
+	track := line(0, 2000, 8) // GPS drift well inside the tolerance
+	a := Compare(course, track, 0)
+	if a.CompletedPct != 100 || len(a.Sections) != 0 || a.OffCourseKM != 0 {
+		t.Fatalf("on course: %+v", a.Sections)
+	}
+	if len(a.Deviations) != len(track) || math.Abs(a.Deviations[50].DistanceM-8) > 0.5 || math.Abs(a.Deviations[50].AlongKM-0.5) > 0.01 {
+		t.Fatalf("deviation of point 50 = %+v", a.Deviations[50])
+	}
+}
+
+func TestCompareDetour(t *testing.T) {
+	course := line(0, 2000, 0)
+	var track []gpx.Point
+	track = append(track, line(0, 800, 0)...)
+	track = append(track, line(810, 1200, 200)...) // 200 m east for 400 m
+	track = append(track, line(1210, 2000, 0)...)
+
+	a := Compare(course, track, 0)
+	if len(a.Sections) != 1 {
+		t.Fatalf("sections = %+v, want 1", a.Sections)
+	}
+	s := a.Sections[0]
+	if s.StartIndex != 81 || s.EndIndex != 120 || s.MaxDeviationM < 199 || s.MaxDeviationM > 201 {
+		t.Errorf("section = %+v", s)
+	}
+	if s.LeftAtKM == nil || *s.LeftAtKM != 0.8 || s.RejoinedAtKM == nil || *s.RejoinedAtKM != 1.21 {
+		t.Errorf("left at %v, rejoined at %v; want 0.8 and 1.21", s.LeftAtKM, s.RejoinedAtKM)
+	}
+	// 390 m along the detour plus two ~200 m legs out and back.
+	if s.LengthM < 780 || s.LengthM > 800 {
+		t.Errorf("section length = %v m", s.LengthM)
+	}
+	// The 410 m of course skipped are not completed.
+	if a.CompletedPct < 78 || a.CompletedPct > 81 {
+		t.Errorf("completed = %v%%, want about 79.5", a.CompletedPct)
+	}
+}
+
+func TestCompareOutAndBack(t *testing.T) {
+	out := line(0, 1000, 0)
+	course := append(append([]gpx.Point(nil), out...), reversed(out)[1:]...)
+
+	// Turning back halfway completes only the first and last quarters.
+	half := line(0, 500, 0)
+	track := append(append([]gpx.Point(nil), half...), reversed(half)[1:]...)
+	a := Compare(course, track, 0)
+	if a.CompletedPct < 24 || a.CompletedPct > 27 {
+		t.Errorf("halfway turn completed %v%%, want about 25", a.CompletedPct)
+	}
+	if a.Deviations[0].AlongKM != 0 {
+		t.Errorf("first point matched at %v km, want the start", a.Deviations[0].AlongKM)
+	}
+
+	a = Compare(course, course, 0)
+	if a.CompletedPct != 100 {
+		t.Errorf("whole course completed %v%%", a.CompletedPct)
+	}
+}
+
+func TestCompareNoiseIsNotOffCourse(t *testing.T) {
+	course := line(0, 1000, 0)
+	track := line(0, 1000, 0)
+	track[50] = line(500, 500, 60)[0] // one point 60 m out
+	a := Compare(course, track, 0)
+	if len(a.Sections) != 0 || a.Deviations[50].OffCourse {
+		t.Fatalf("a single stray point made %+v", a.Sections)
+	}
+}
+
+func reversed(points []gpx.Point) []gpx.Point {
+	out := make([]gpx.Point, len(points))
+	for i, p := range points {
+		out[len(points)-1-i] = p
+	}
+	return out
+}
+
+func TestRejoinMatchesFullScan(t *testing.T) {
+	// A course winding back and forth, so that far-off boxes still come
+	// close to many points.
+	var course []gpx.Point
+	for leg := 0; leg < 8; leg++ {
+		seg := line(0, 2000, float64(leg)*300)
+		if leg%2 == 1 {
+			seg = reversed(seg)
+		}
+		course = append(course, seg...)
+	}
+	l := newCourseLine(course)
+	for _, p := range []gpx.Point{
+		line(700, 700, 150)[0],
+		line(1900, 1900, 2500)[0],
+		line(-400, -400, 1000)[0],
+		line(1000, 1000, 910)[0],
+	} {
+		c := geo.FromPoints([]gpx.Point{p})[0]
+		for _, after := range []float64{0, 5000, 15900} {
+			dist, along := l.rejoin(c, DefaultToleranceM, after)
+			wantDist, wantAlong := math.Inf(1), 0.0
+			found := false
+			for i := 1; i < len(l.coords) && !found; i++ {
+				d, at := l.segment(c, i)
+				switch {
+				case d <= DefaultToleranceM && at >= after:
+					wantDist, wantAlong, found = d, at, true
+				case d < wantDist:
+					wantDist, wantAlong = d, at
+				}
+			}
+			if math.Abs(dist-wantDist) > 1e-6 || math.Abs(along-wantAlong) > 1e-6 {
+				t.Errorf("rejoin(%+v, after %v) = %.1f m at %.1f, want %.1f m at %.1f", c, after, dist, along, wantDist, wantAlong)
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Package course plans routes that have not been covered yet: courses drawn
+// through waypoints or copied from a recorded track, their elevation profile,
+// a time estimate and the GPX and TCX files devices follow. There is no
+// routing engine, so legs between waypoints are straight lines.
+package course
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// MaxWaypoints caps the waypoints of a drawn course.
+const MaxWaypoints = 1000
+
+// climbFlatEquivalent is how many metres of flat one metre of climbing costs
+// (Naismith's rule: 600 m of ascent take as long as 5 km on the flat).
+const climbFlatEquivalent = 8.0
+
+var ErrTooFewPoints = errors.New("a course needs at least 2 points")
+
+// Waypoint is a point a drawn course passes through. Ele is optional;
+// missing elevations are interpolated from the waypoints around.
+type Waypoint struct {
+	Lat  float64  `json:"lat"`
+	Lon  float64  `json:"lon"`
+	Ele  *float64 `json:"ele,omitempty"`
+	Name string   `json:"name,omitempty"`
+}
+
+// FromWaypoints builds the track of a course joining waypoints by straight
+// lines.
+func FromWaypoints(waypoints []Waypoint) ([]gpx.Point, error) {
+	if len(waypoints) < 2 {
+		return nil, ErrTooFewPoints
+	}
+	if len(waypoints) > MaxWaypoints {
+		return nil, fmt.Errorf("a course has at most %d waypoints", MaxWaypoints)
+	}
+	points := make([]gpx.Point, len(waypoints))
+	for i, w := range waypoints {
+		if w.Lat < -90 || w.Lat > 90 || w.Lon < -180 || w.Lon > 180 {
+			return nil, fmt.Errorf("waypoint %d is not a valid position", i+1)
+		}
+		points[i] = gpx.Point{Lat: w.Lat, Lon: w.Lon}
+	}
+	cum := geo.CumulativeDistances(geo.FromPoints(points))
+
+	// Fill each elevation from the nearest known ones by distance, holding
+	// the first and last known values beyond them.
+	prev := -1
+	for i, w := range waypoints {
+		if w.Ele == nil {
+			continue
+		}
+		points[i].Ele = *w.Ele
+		for j := prev + 1; j < i; j++ {
+			if prev < 0 {
+				points[j].Ele = *w.Ele
+				continue
+			}
+			f := 0.0
+			if span := cum[i] - cum[prev]; span > 0 {
+				f = (cum[j] - cum[prev]) / span
+			}
+			points[j].Ele = points[prev].Ele + (*w.Ele-points[prev].Ele)*f
+		}
+		prev = i
+	}
+	for j := prev + 1; prev >= 0 && j < len(points); j++ {
+		points[j].Ele = points[prev].Ele
+	}
+	return points, nil
+}
+
+// FromTrack copies the positions and elevations of a recorded track,
+// dropping times, sensor data and repeated positions.
+func FromTrack(track []gpx.Point) ([]gpx.Point, error) {
+	points := make([]gpx.Point, 0, len(track))
+	for _, p := range track {
+		if n := len(points); n > 0 && points[n-1].Lat == p.Lat && points[n-1].Lon == p.Lon {
+			continue
+		}
+		points = append(points, gpx.Point{Lat: p.Lat, Lon: p.Lon, Ele: p.Ele})
+	}
+	if len(points) < 2 {
+		return nil, ErrTooFewPoints
+	}
+	return points, nil
+}
+
+// Summary is the size of a course.
+type Summary struct {
+	DistanceKM float64 `json:"distanceKm"`
+	ElevGainM  float64 `json:"elevGainM"`
+	ElevLossM  float64 `json:"elevLossM"`
+	MinElevM   float64 `json:"minElevM"`
+	MaxElevM   float64 `json:"maxElevM"`
+}
+
+func Summarize(points []gpx.Point) Summary {
+	if len(points) == 0 {
+		return Summary{}
+	}
+	s := Summary{MinElevM: points[0].Ele, MaxElevM: points[0].Ele}
+	var dist float64
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		dist += geo.Distance(geo.Coord{Lat: a.Lat, Lon: a.Lon}, geo.Coord{Lat: b.Lat, Lon: b.Lon})
+		if d := b.Ele - a.Ele; d > 0 {
+			s.ElevGainM += d
+		} else {
+			s.ElevLossM -= d
+		}
+		s.MinElevM = math.Min(s.MinElevM, b.Ele)
+		s.MaxElevM = math.Max(s.MaxElevM, b.Ele)
+	}
+	s.DistanceKM = round2(dist / 1000)
+	s.ElevGainM = math.Round(s.ElevGainM)
+	s.ElevLossM = math.Round(s.ElevLossM)
+	s.MinElevM = math.Round(s.MinElevM)
+	s.MaxElevM = math.Round(s.MaxElevM)
+	return s
+}
+
+// ProfileSample is the elevation at a distance along a course.
+type ProfileSample struct {
+	DistanceKM float64 `json:"distanceKm"`
+	EleM       float64 `json:"eleM"`
+}
+
+// Profile samples the elevation of a course at n points evenly spaced by
+// distance.
+func Profile(points []gpx.Point, n int) []ProfileSample {
+	if len(points) == 0 || n <= 0 {
+		return nil
+	}
+	cum := geo.CumulativeDistances(geo.FromPoints(points))
+	total := cum[len(cum)-1]
+	if n == 1 || total == 0 {
+		return []ProfileSample{{EleM: points[0].Ele}}
+	}
+	out := make([]ProfileSample, 0, n)
+	j := 1
+	for i := 0; i < n; i++ {
+		target := total * float64(i) / float64(n-1)
+		for j < len(points)-1 && cum[j] < target {
+			j++
+		}
+		f := 0.0
+		if span := cum[j] - cum[j-1]; span > 0 {
+			f = math.Max(0, math.Min(1, (target-cum[j-1])/span))
+		}
+		ele := points[j-1].Ele + (points[j].Ele-points[j-1].Ele)*f
+		out = append(out, ProfileSample{DistanceKM: round2(target / 1000), EleM: math.Round(ele*10) / 10})
+	}
+	return out
+}
+
+// Schedule returns the seconds from the start at which each point of a
+// course is reached at paceMinPerKM on the flat. Climbs are slowed down by
+// Naismith's rule; descents are taken at the flat pace.
+func Schedule(points []gpx.Point, paceMinPerKM float64) []float64 {
+	out := make([]float64, len(points))
+	secPerM := paceMinPerKM * 60 / 1000
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		d := geo.Distance(geo.Coord{Lat: a.Lat, Lon: a.Lon}, geo.Coord{Lat: b.Lat, Lon: b.Lon})
+		if climb := b.Ele - a.Ele; climb > 0 {
+			d += climb * climbFlatEquivalent
+		}
+		out[i] = out[i-1] + d*secPerM
+	}
+	return out
+}
+
+// DefaultPace is the flat pace assumed for a sport when none is given, in
+// minutes per kilometre.
+func DefaultPace(sportType string) float64 {
+	switch sportType {
+	case "cycling":
+		return 2.4 // 25 km/h
+	case "walking", "hiking":
+		return 12 // 5 km/h
+	case "swimming":
+		return 20
+	default:
+		return 6
+	}
+}
+
+// EstimateDurationSec is the time a course takes at paceMinPerKM on the flat.
+func EstimateDurationSec(points []gpx.Point, paceMinPerKM float64) int {
+	if len(points) == 0 {
+		return 0
+	}
+	s := Schedule(points, paceMinPerKM)
+	return int(math.Round(s[len(s)-1]))
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,149 @@
+package course
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+func ptr(v float64) *float64 { return &v }
+
+// north returns waypoints going due north from 45°N in steps of about 1 km.
+func north(eles ...*float64) []Waypoint {
+	out := make([]Waypoint, len(eles))
+	for i, e := range eles {
+		out[i] = Waypoint{Lat: 45 + float64(i)*0.008993, Lon: 6, Ele: e}
+	}
+	return out
+}
+
+func TestFromWaypoints(t *testing.T) {
+	points, err := FromWaypoints(north(nil, ptr(100), nil, ptr(300), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{100, 100, 200, 300, 300}
+	for i, p := range points {
+		if math.Abs(p.Ele-want[i]) > 0.5 {
+			t.Errorf("point %d ele = %.1f, want %.0f", i, p.Ele, want[i])
+		}
+	}
+
+	if _, err := FromWaypoints(north(nil)); err != ErrTooFewPoints {
+		t.Errorf("one waypoint: err = %v, want ErrTooFewPoints", err)
+	}
+	if _, err := FromWaypoints([]Waypoint{{Lat: 91}, {Lat: 0}}); err == nil {
+		t.Error("latitude 91 accepted")
+	}
+}
+
+func TestFromTrack(t *testing.T) {
+	now := time.Now()
+	hr := 150
+	track := []gpx.Point{
+		{Lat: 45, Lon: 6, Ele: 10, Time: &now, HR: &hr},
+		{Lat: 45, Lon: 6, Ele: 10, Time: &now},
+		{Lat: 45.001, Lon: 6, Ele: 12, Time: &now},
+	}
+	points, err := FromTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Time != nil || points[0].HR != nil {
+		t.Fatalf("FromTrack = %+v; want 2 untimed points", points)
+	}
+}
+
+func TestSummarizeAndProfile(t *testing.T) {
+	points, _ := FromWaypoints(north(ptr(100), ptr(150), ptr(120)))
+	s := Summarize(points)
+	if math.Abs(s.DistanceKM-2) > 0.01 || s.ElevGainM != 50 || s.ElevLossM != 30 || s.MinElevM != 100 || s.MaxElevM != 150 {
+		t.Fatalf("Summarize = %+v", s)
+	}
+	profile := Profile(points, 5)
+	if len(profile) != 5 {
+		t.Fatalf("profile has %d samples", len(profile))
+	}
+	if p := profile[1]; math.Abs(p.DistanceKM-0.5) > 0.01 || math.Abs(p.EleM-125) > 0.5 {
+		t.Errorf("sample at 0.5 km = %+v, want ele 125", p)
+	}
+	if p := profile[4]; p.EleM != 120 {
+		t.Errorf("last sample = %+v, want ele 120", p)
+	}
+}
+
+func TestEstimateDuration(t *testing.T) {
+	flat, _ := FromWaypoints(north(ptr(0), ptr(0)))
+	if got := EstimateDurationSec(flat, 5); math.Abs(float64(got)-300) > 1 {
+		t.Errorf("1 km flat at 5:00/km = %d s, want 300", got)
+	}
+	// 100 m of climbing counts as 800 m more.
+	hill, _ := FromWaypoints(north(ptr(0), ptr(100)))
+	if got := EstimateDurationSec(hill, 5); math.Abs(float64(got)-540) > 1 {
+		t.Errorf("1 km climbing 100 m at 5:00/km = %d s, want 540", got)
+	}
+	down, _ := FromWaypoints(north(ptr(100), ptr(0)))
+	if got := EstimateDurationSec(down, 5); math.Abs(float64(got)-300) > 1 {
+		t.Errorf("1 km descending at 5:00/km = %d s, want 300", got)
+	}
+}
+
+func TestWriteGPX(t *testing.T) {
+	points, _ := FromWaypoints(north(ptr(100), ptr(110), ptr(120)))
+	var buf bytes.Buffer
+	if err := WriteGPXTrack(&buf, "Loop", "running", points); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := gpx.Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Name != "Loop" || len(parsed.Points) != 3 || parsed.Points[2].Ele != 120 {
+		t.Fatalf("parsed course = %+v", parsed)
+	}
+
+	buf.Reset()
+	waypoints := north(nil, ptr(110))
+	waypoints[0].Name = "Start"
+	if err := WriteGPXRoute(&buf, "Loop", waypoints); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Count(out, "<rtept") != 2 || !strings.Contains(out, "<name>Start</name>") || strings.Count(out, "<ele>") != 1 {
+		t.Fatalf("route GPX:\n%s", out)
+	}
+}
+
+func TestWriteTCX(t *testing.T) {
+	points, _ := FromWaypoints(north(ptr(0), ptr(0), ptr(0)))
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	if err := WriteTCX(&buf, "Saturday long ride", points, 2, start); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"<Name>Saturday long r</Name>",
+		"<Time>2024-06-01T08:00:00Z</Time>",
+		"<Time>2024-06-01T08:04:00Z</Time>",
+		"<TotalTimeSeconds>240</TotalTimeSeconds>",
+		"<Intensity>Active</Intensity>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("TCX lacks %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestDefaultPace(t *testing.T) {
+	if got := DefaultPace("cycling"); got != 2.4 {
+		t.Errorf("cycling pace = %v, want 2.4 (25 km/h)", got)
+	}
+	if got := DefaultPace("running"); got != 6 {
+		t.Errorf("running pace = %v, want 6", got)
+	}
+}
@@ -0,0 +1,173 @@
+package course
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+const gpxCreator = "GPX Training Analyzer"
+
+// tcxNameMax is the longest course name Garmin devices accept.
+const tcxNameMax = 15
+
+type gpxFile struct {
+	XMLName xml.Name  `xml:"gpx"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Creator string    `xml:"creator,attr"`
+	Name    string    `xml:"metadata>name"`
+	Route   *gpxRoute `xml:"rte,omitempty"`
+	Track   *gpxTrack `xml:"trk,omitempty"`
+}
+
+type gpxRoute struct {
+	Name   string     `xml:"name"`
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name"`
+	Type    string     `xml:"type,omitempty"`
+	Segment []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele,omitempty"`
+	Name string   `xml:"name,omitempty"`
+}
+
+func newGPX(name string) gpxFile {
+	return gpxFile{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: gpxCreator,
+		Name:    name,
+	}
+}
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteGPXRoute writes a course as a GPX route: the waypoints a device
+// navigates between. Courses copied from a track pass their track points.
+func WriteGPXRoute(w io.Writer, name string, waypoints []Waypoint) error {
+	rte := &gpxRoute{Name: name, Points: make([]gpxPoint, len(waypoints))}
+	for i, wp := range waypoints {
+		rte.Points[i] = gpxPoint{Lat: wp.Lat, Lon: wp.Lon, Ele: wp.Ele, Name: wp.Name}
+	}
+	f := newGPX(name)
+	f.Route = rte
+	return writeXML(w, f)
+}
+
+// WriteGPXTrack writes a course as an untimed GPX track, the form devices
+// import as a course to follow.
+func WriteGPXTrack(w io.Writer, name, sportType string, points []gpx.Point) error {
+	trk := &gpxTrack{Name: name, Type: sportType, Segment: make([]gpxPoint, len(points))}
+	for i, p := range points {
+		ele := p.Ele
+		trk.Segment[i] = gpxPoint{Lat: p.Lat, Lon: p.Lon, Ele: &ele}
+	}
+	f := newGPX(name)
+	f.Track = trk
+	return writeXML(w, f)
+}
+
+type tcxFile struct {
+	XMLName xml.Name  `xml:"TrainingCenterDatabase"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Course  tcxCourse `xml:"Courses>Course"`
+}
+
+type tcxCourse struct {
+	Name   string           `xml:"Name"`
+	Lap    tcxCourseLap     `xml:"Lap"`
+	Points []tcxCoursePoint `xml:"Track>Trackpoint"`
+}
+
+type tcxCourseLap struct {
+	TotalTimeSeconds float64     `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64     `xml:"DistanceMeters"`
+	Begin            tcxPosition `xml:"BeginPosition"`
+	End              tcxPosition `xml:"EndPosition"`
+	Intensity        string      `xml:"Intensity"`
+}
+
+type tcxPosition struct {
+	Lat float64 `xml:"LatitudeDegrees"`
+	Lon float64 `xml:"LongitudeDegrees"`
+}
+
+type tcxCoursePoint struct {
+	Time           string      `xml:"Time"`
+	Position       tcxPosition `xml:"Position"`
+	AltitudeMeters float64     `xml:"AltitudeMeters"`
+	DistanceMeters float64     `xml:"DistanceMeters"`
+}
+
+// WriteTCX writes a TCX course. Devices race a virtual partner along it, so
+// each point is timed by Schedule at paceMinPerKM from start.
+func WriteTCX(w io.Writer, name string, points []gpx.Point, paceMinPerKM float64, start time.Time) error {
+	if len(points) == 0 {
+		return ErrTooFewPoints
+	}
+	if r := []rune(name); len(r) > tcxNameMax {
+		name = string(r[:tcxNameMax])
+	}
+	schedule := Schedule(points, paceMinPerKM)
+	cum := geo.CumulativeDistances(geo.FromPoints(points))
+	first, last := points[0], points[len(points)-1]
+
+	c := tcxCourse{
+		Name: name,
+		Lap: tcxCourseLap{
+			TotalTimeSeconds: math.Round(schedule[len(schedule)-1]),
+			DistanceMeters:   math.Round(cum[len(cum)-1]*10) / 10,
+			Begin:            tcxPosition{Lat: first.Lat, Lon: first.Lon},
+			End:              tcxPosition{Lat: last.Lat, Lon: last.Lon},
+			Intensity:        "Active",
+		},
+		Points: make([]tcxCoursePoint, len(points)),
+	}
+	for i, p := range points {
+		at := start.Add(time.Duration(math.Round(schedule[i])) * time.Second)
+		c.Points[i] = tcxCoursePoint{
+			Time:           at.UTC().Format(time.RFC3339),
+			Position:       tcxPosition{Lat: p.Lat, Lon: p.Lon},
+			AltitudeMeters: p.Ele,
+			DistanceMeters: math.Round(cum[i]*10) / 10,
+		}
+	}
+	return writeXML(w, tcxFile{
+		Xmlns:  "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2",
+		Course: c,
+	})
+}
+
+// TrackWaypoints turns the points of a course into route waypoints, for
+// courses that were copied from a track rather than drawn.
+func TrackWaypoints(points []gpx.Point) []Waypoint {
+	out := make([]Waypoint, len(points))
+	for i, p := range points {
+		ele := p.Ele
+		out[i] = Waypoint{Lat: p.Lat, Lon: p.Lon, Ele: &ele}
+	}
+	return out
+}
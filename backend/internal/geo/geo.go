@@ -0,0 +1,250 @@
+// Package geo provides offline track geometry: distances, simplification and
+// curve similarity. It has no dependency on any map service.
+package geo
+
+import (
+	"math"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+const earthRadiusM = 6371000.0
+
+type Coord struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// FromPoints extracts the coordinates of a track.
+func FromPoints(points []gpx.Point) []Coord {
+	coords := make([]Coord, len(points))
+	for i, p := range points {
+		coords[i] = Coord{Lat: p.Lat, Lon: p.Lon}
+	}
+	return coords
+}
+
+// Distance returns the great-circle distance between two coordinates in metres.
+func Distance(a, b Coord) float64 {
+	dLat := toRad(b.Lat - a.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Lat))*math.Cos(toRad(b.Lat))*
+			math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusM * 2 * math.Atan2(math.Sqrt(h), math.Sqrt(1-h))
+}
+
+// PathLength returns the length of a polyline in metres.
+func PathLength(coords []Coord) float64 {
+	var total float64
+	for i := 1; i < len(coords); i++ {
+		total += Distance(coords[i-1], coords[i])
+	}
+	return total
+}
+
+// CumulativeDistances returns, for each vertex, the distance along the
+// polyline from the first vertex in metres.
+func CumulativeDistances(coords []Coord) []float64 {
+	cum := make([]float64, len(coords))
+	for i := 1; i < len(coords); i++ {
+		cum[i] = cum[i-1] + Distance(coords[i-1], coords[i])
+	}
+	return cum
+}
+
+// projection is a local equirectangular projection in metres around a
+// reference latitude. It is accurate enough for the few-kilometre extents of a
+// single activity.
+type projection struct {
+	cosLat float64
+}
+
+func newProjection(refLat float64) projection {
+	return projection{cosLat: math.Cos(toRad(refLat))}
+}
+
+func (p projection) xy(c Coord) (float64, float64) {
+	return toRad(c.Lon) * earthRadiusM * p.cosLat, toRad(c.Lat) * earthRadiusM
+}
+
+// PointSegmentDistance returns the distance in metres from p to the segment
+// a-b, and the position t in [0,1] of the closest point along the segment.
+func PointSegmentDistance(p, a, b Coord) (dist, t float64) {
+	proj := newProjection((a.Lat + b.Lat) / 2)
+	px, py := proj.xy(p)
+	ax, ay := proj.xy(a)
+	bx, by := proj.xy(b)
+	dx, dy := bx-ax, by-ay
+	lenSq := dx*dx + dy*dy
+	if lenSq > 0 {
+		t = ((px-ax)*dx + (py-ay)*dy) / lenSq
+		t = math.Max(0, math.Min(1, t))
+	}
+	cx, cy := ax+t*dx, ay+t*dy
+	return math.Hypot(px-cx, py-cy), t
+}
+
+// Interpolate returns the coordinate at fraction t between a and b.
+func Interpolate(a, b Coord, t float64) Coord {
+	return Coord{Lat: a.Lat + (b.Lat-a.Lat)*t, Lon: a.Lon + (b.Lon-a.Lon)*t}
+}
+
+// Simplify reduces a polyline with the Douglas-Peucker algorithm, keeping every
+// vertex that deviates more than toleranceM metres from the simplified line.
+func Simplify(coords []Coord, toleranceM float64) []Coord {
+	if len(coords) < 3 {
+		return append([]Coord(nil), coords...)
+	}
+	keep := make([]bool, len(coords))
+	keep[0], keep[len(coords)-1] = true, true
+
+	type span struct{ first, last int }
+	stack := []span{{0, len(coords) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		maxDist, index := 0.0, -1
+		for i := s.first + 1; i < s.last; i++ {
+			d, _ := PointSegmentDistance(coords[i], coords[s.first], coords[s.last])
+			if d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if index >= 0 && maxDist > toleranceM {
+			keep[index] = true
+			stack = append(stack, span{s.first, index}, span{index, s.last})
+		}
+	}
+
+	out := make([]Coord, 0, len(coords)/4+2)
+	for i, k := range keep {
+		if k {
+			out = append(out, coords[i])
+		}
+	}
+	return out
+}
+
+// Resample returns n points spaced evenly by distance along the polyline.
+func Resample(coords []Coord, n int) []Coord {
+	if len(coords) == 0 || n <= 0 {
+		return nil
+	}
+	if len(coords) == 1 || n == 1 {
+		return []Coord{coords[0]}
+	}
+	cum := CumulativeDistances(coords)
+	total := cum[len(cum)-1]
+	out := make([]Coord, 0, n)
+	j := 1
+	for i := 0; i < n; i++ {
+		target := total * float64(i) / float64(n-1)
+		for j < len(coords)-1 && cum[j] < target {
+			j++
+		}
+		span := cum[j] - cum[j-1]
+		t := 0.0
+		if span > 0 {
+			t = math.Max(0, math.Min(1, (target-cum[j-1])/span))
+		}
+		out = append(out, Interpolate(coords[j-1], coords[j], t))
+	}
+	return out
+}
+
+// DiscreteFrechet returns the discrete Fréchet distance between two polylines
+// in metres. It respects direction of travel, so a loop run clockwise does
+// not match the same loop run anticlockwise.
+func DiscreteFrechet(a, b []Coord) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return math.Inf(1)
+	}
+	prev := make([]float64, len(b))
+	curr := make([]float64, len(b))
+	for i := range a {
+		for j := range b {
+			d := Distance(a[i], b[j])
+			switch {
+			case i == 0 && j == 0:
+				curr[j] = d
+			case i == 0:
+				curr[j] = math.Max(curr[j-1], d)
+			case j == 0:
+				curr[j] = math.Max(prev[j], d)
+			default:
+				curr[j] = math.Max(math.Min(prev[j], math.Min(prev[j-1], curr[j-1])), d)
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)-1]
+}
+
+// Hausdorff returns the symmetric Hausdorff distance between the vertices of
+// two polylines in metres.
+func Hausdorff(a, b []Coord) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return math.Inf(1)
+	}
+	return math.Max(directedHausdorff(a, b), directedHausdorff(b, a))
+}
+
+func directedHausdorff(a, b []Coord) float64 {
+	var worst float64
+	for _, p := range a {
+		best := math.Inf(1)
+		for _, q := range b {
+			if d := Distance(p, q); d < best {
+				best = d
+			}
+		}
+		worst = math.Max(worst, best)
+	}
+	return worst
+}
+
+// BBox is a latitude/longitude bounding box.
+type BBox struct {
+	MinLat float64 `json:"minLat"`
+	MinLon float64 `json:"minLon"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLon float64 `json:"maxLon"`
+}
+
+func Bounds(coords []Coord) BBox {
+	if len(coords) == 0 {
+		return BBox{}
+	}
+	b := BBox{MinLat: coords[0].Lat, MaxLat: coords[0].Lat, MinLon: coords[0].Lon, MaxLon: coords[0].Lon}
+	for _, c := range coords[1:] {
+		b.MinLat = math.Min(b.MinLat, c.Lat)
+		b.MaxLat = math.Max(b.MaxLat, c.Lat)
+		b.MinLon = math.Min(b.MinLon, c.Lon)
+		b.MaxLon = math.Max(b.MaxLon, c.Lon)
+	}
+	return b
+}
+
+// Expand grows the box by marginM metres on every side.
+func (b BBox) Expand(marginM float64) BBox {
+	dLat := marginM / earthRadiusM * 180 / math.Pi
+	cosLat := math.Cos(toRad((b.MinLat + b.MaxLat) / 2))
+	dLon := dLat
+	if cosLat > 1e-6 {
+		dLon = dLat / cosLat
+	}
+	return BBox{MinLat: b.MinLat - dLat, MinLon: b.MinLon - dLon, MaxLat: b.MaxLat + dLat, MaxLon: b.MaxLon + dLon}
+}
+
+func (b BBox) Intersects(o BBox) bool {
+	return b.MinLat <= o.MaxLat && o.MinLat <= b.MaxLat && b.MinLon <= o.MaxLon && o.MinLon <= b.MaxLon
+}
+
+func (b BBox) Contains(c Coord) bool {
+	return c.Lat >= b.MinLat && c.Lat <= b.MaxLat && c.Lon >= b.MinLon && c.Lon <= b.MaxLon
+}
+
+func toRad(v float64) float64 {
+	return v * math.Pi / 180.0
+}
@@ -0,0 +1,140 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// loop returns a closed square loop of side ~sideM metres around (lat, lon),
+// sampled every ~10 m, optionally offset north by offsetM metres.
+func loop(lat, lon, sideM, offsetM float64, clockwise bool) []Coord {
+	dLat := sideM / earthRadiusM * 180 / math.Pi
+	dLon := dLat / math.Cos(toRad(lat))
+	off := offsetM / earthRadiusM * 180 / math.Pi
+	corners := []Coord{
+		{lat + off, lon},
+		{lat + off + dLat, lon},
+		{lat + off + dLat, lon + dLon},
+		{lat + off, lon + dLon},
+		{lat + off, lon},
+	}
+	if clockwise {
+		for i, j := 0, len(corners)-1; i < j; i, j = i+1, j-1 {
+			corners[i], corners[j] = corners[j], corners[i]
+		}
+	}
+	var out []Coord
+	steps := int(sideM / 10)
+	for i := 1; i < len(corners); i++ {
+		for s := 0; s < steps; s++ {
+			out = append(out, Interpolate(corners[i-1], corners[i], float64(s)/float64(steps)))
+		}
+	}
+	return append(out, corners[len(corners)-1])
+}
+
+func TestDistance(t *testing.T) {
+	// One degree of latitude is ~111.2 km.
+	d := Distance(Coord{0, 0}, Coord{1, 0})
+	if math.Abs(d-111195) > 100 {
+		t.Fatalf("expected ~111195 m, got %.0f", d)
+	}
+}
+
+func TestSimplify_KeepsCornersOnly(t *testing.T) {
+	track := loop(33.59, -7.62, 1000, 0, false)
+	simplified := Simplify(track, 5)
+	if len(simplified) != 5 {
+		t.Fatalf("expected the 5 corners of the square, got %d points", len(simplified))
+	}
+	if math.Abs(PathLength(simplified)-PathLength(track)) > 1 {
+		t.Fatalf("simplification changed the length: %.1f vs %.1f", PathLength(simplified), PathLength(track))
+	}
+}
+
+func TestResample_EvenSpacing(t *testing.T) {
+	line := []Coord{{0, 0}, {0, 0.01}}
+	out := Resample(line, 11)
+	if len(out) != 11 {
+		t.Fatalf("expected 11 points, got %d", len(out))
+	}
+	step := Distance(out[0], out[1])
+	for i := 2; i < len(out); i++ {
+		if math.Abs(Distance(out[i-1], out[i])-step) > 0.5 {
+			t.Fatalf("uneven spacing at %d", i)
+		}
+	}
+}
+
+func TestFrechetAndHausdorff(t *testing.T) {
+	a := loop(33.59, -7.62, 1000, 0, false)
+	b := loop(33.59, -7.62, 1000, 30, false)
+	f := DiscreteFrechet(Resample(a, 80), Resample(b, 80))
+	if f < 25 || f > 60 {
+		t.Fatalf("expected Fréchet distance around the 30 m offset, got %.1f", f)
+	}
+	h := Hausdorff(a, b)
+	if h < 25 || h > 40 {
+		t.Fatalf("expected Hausdorff distance around 30 m, got %.1f", h)
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	opts := DefaultRouteMatch
+	base := RouteShape(loop(33.59, -7.62, 1000, 0, false), opts)
+
+	same := RouteShape(loop(33.59, -7.62, 1000, 20, false), opts)
+	if _, ok := MatchRoute(base, same, opts); !ok {
+		t.Fatal("expected a GPS-offset repeat of the loop to match")
+	}
+
+	reversed := RouteShape(loop(33.59, -7.62, 1000, 0, true), opts)
+	if _, ok := MatchRoute(base, reversed, opts); ok {
+		t.Fatal("expected the loop run in the opposite direction not to match")
+	}
+
+	bigger := RouteShape(loop(33.59, -7.62, 1500, 0, false), opts)
+	if _, ok := MatchRoute(base, bigger, opts); ok {
+		t.Fatal("expected a longer loop not to match")
+	}
+}
+
+func TestBBox(t *testing.T) {
+	b := Bounds([]Coord{{33.5, -7.7}, {33.6, -7.6}})
+	if !b.Contains(Coord{33.55, -7.65}) || b.Contains(Coord{33.7, -7.65}) {
+		t.Fatal("unexpected Contains result")
+	}
+	e := b.Expand(1000)
+	if !e.Contains(Coord{33.605, -7.65}) {
+		t.Fatal("expected expanded box to contain a point 550 m north")
+	}
+	if !b.Intersects(BBox{MinLat: 33.59, MinLon: -7.61, MaxLat: 34, MaxLon: -7}) {
+		t.Fatal("expected boxes to intersect")
+	}
+}
+
+func TestMatchSegment(t *testing.T) {
+	lap := loop(33.59, -7.62, 1000, 0, false)
+	segment := Simplify(lap[:101], 5) // the first 1 km side, heading north
+
+	twoLaps := append(append([]Coord(nil), lap...), lap[1:]...)
+	matches := MatchSegment(twoLaps, segment, DefaultSegmentMatch)
+	if len(matches) != 2 {
+		t.Fatalf("expected one effort per lap, got %d", len(matches))
+	}
+	for _, m := range matches {
+		if d := PathLength(twoLaps[m.StartIndex : m.EndIndex+1]); math.Abs(d-1000) > 20 {
+			t.Fatalf("effort %v covers %.0f m, expected ~1000 m", m, d)
+		}
+	}
+
+	reversed := loop(33.59, -7.62, 1000, 0, true)
+	if matches := MatchSegment(reversed, segment, DefaultSegmentMatch); len(matches) != 0 {
+		t.Fatalf("segment run in the opposite direction must not match, got %v", matches)
+	}
+
+	parallel := loop(33.59, -7.62, 1000, 100, false)
+	if matches := MatchSegment(parallel, segment, DefaultSegmentMatch); len(matches) != 0 {
+		t.Fatalf("a path 100 m away must not match, got %v", matches)
+	}
+}
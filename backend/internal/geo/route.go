@@ -0,0 +1,46 @@
+package geo
+
+import "math"
+
+// RouteMatchOptions controls when two tracks are considered the same route.
+type RouteMatchOptions struct {
+	EndpointToleranceM float64 // max start-to-start and end-to-end distance
+	LengthTolerance    float64 // max relative difference in length (0.15 = 15%)
+	FrechetThresholdM  float64 // max discrete Fréchet distance of the shapes
+	SimplifyToleranceM float64 // Douglas-Peucker tolerance applied before comparing
+	ShapeSamples       int     // points each simplified track is resampled to
+}
+
+var DefaultRouteMatch = RouteMatchOptions{
+	EndpointToleranceM: 200,
+	LengthTolerance:    0.15,
+	FrechetThresholdM:  150,
+	SimplifyToleranceM: 15,
+	ShapeSamples:       80,
+}
+
+// RouteShape returns the simplified representation of a track used for route
+// matching and stored as a route's reference geometry.
+func RouteShape(coords []Coord, opts RouteMatchOptions) []Coord {
+	return Simplify(coords, opts.SimplifyToleranceM)
+}
+
+// MatchRoute reports whether two route shapes describe the same route. The
+// cheap start/end and length checks run first; the Fréchet distance is only
+// computed for plausible candidates and returned as the match score (lower is
+// closer).
+func MatchRoute(a, b []Coord, opts RouteMatchOptions) (float64, bool) {
+	if len(a) < 2 || len(b) < 2 {
+		return math.Inf(1), false
+	}
+	if Distance(a[0], b[0]) > opts.EndpointToleranceM ||
+		Distance(a[len(a)-1], b[len(b)-1]) > opts.EndpointToleranceM {
+		return math.Inf(1), false
+	}
+	la, lb := PathLength(a), PathLength(b)
+	if la <= 0 || lb <= 0 || math.Abs(la-lb)/math.Max(la, lb) > opts.LengthTolerance {
+		return math.Inf(1), false
+	}
+	score := DiscreteFrechet(Resample(a, opts.ShapeSamples), Resample(b, opts.ShapeSamples))
+	return score, score <= opts.FrechetThresholdM
+}
@@ -0,0 +1,109 @@
+package geo
+
+import "math"
+
+// SegmentMatchOptions controls when part of a track counts as an effort on a
+// segment.
+type SegmentMatchOptions struct {
+	EndpointToleranceM float64 // max distance from the segment's start and end
+	CorridorM          float64 // max distance between the effort and the segment path
+	MaxLengthRatio     float64 // effort length may be at most this multiple of the segment
+	Samples            int     // points along the segment checked against the effort
+}
+
+var DefaultSegmentMatch = SegmentMatchOptions{
+	EndpointToleranceM: 30,
+	CorridorM:          40,
+	MaxLengthRatio:     1.5,
+	Samples:            50,
+}
+
+// SegmentMatch is an effort on a segment: the track indices where the athlete
+// crossed the segment's start and end.
+type SegmentMatch struct {
+	StartIndex int
+	EndIndex   int
+}
+
+// MatchSegment finds every pass of track over segment, in order. A pass starts
+// at the track point closest to the segment start, ends at the point closest
+// to the segment end, and must stay within the corridor in both directions so
+// that shortcuts and detours are rejected.
+func MatchSegment(track, segment []Coord, opts SegmentMatchOptions) []SegmentMatch {
+	if len(track) < 2 || len(segment) < 2 {
+		return nil
+	}
+	start, end := segment[0], segment[len(segment)-1]
+	maxLength := PathLength(segment) * opts.MaxLengthRatio
+	cum := CumulativeDistances(track)
+	samples := Resample(segment, opts.Samples)
+
+	var matches []SegmentMatch
+	for i := 0; i < len(track); i++ {
+		if Distance(track[i], start) > opts.EndpointToleranceM {
+			continue
+		}
+		startIdx, nextI := closestInRun(track, i, start, opts.EndpointToleranceM)
+		endIdx := -1
+		for j := startIdx + 1; j < len(track) && cum[j]-cum[startIdx] <= maxLength; j++ {
+			if Distance(track[j], end) <= opts.EndpointToleranceM {
+				endIdx, _ = closestInRun(track, j, end, opts.EndpointToleranceM)
+				break
+			}
+		}
+		if endIdx > startIdx && withinCorridor(track[startIdx:endIdx+1], samples, segment, opts.CorridorM) {
+			matches = append(matches, SegmentMatch{StartIndex: startIdx, EndIndex: endIdx})
+			i = endIdx
+			continue
+		}
+		i = nextI - 1
+	}
+	return matches
+}
+
+// closestInRun scans the run of consecutive track points from i that lie
+// within tolerance of target and returns the closest one plus the index just
+// after the run.
+func closestInRun(track []Coord, i int, target Coord, tolerance float64) (best, next int) {
+	best = i
+	bestDist := Distance(track[i], target)
+	for next = i + 1; next < len(track); next++ {
+		d := Distance(track[next], target)
+		if d > tolerance {
+			break
+		}
+		if d < bestDist {
+			best, bestDist = next, d
+		}
+	}
+	return best, next
+}
+
+func withinCorridor(effort, samples, segment []Coord, corridor float64) bool {
+	for _, p := range samples {
+		if DistanceToPath(p, effort) > corridor {
+			return false
+		}
+	}
+	for _, p := range effort {
+		if DistanceToPath(p, segment) > corridor {
+			return false
+		}
+	}
+	return true
+}
+
+// DistanceToPath returns the distance in metres from p to the closest point
+// of the polyline path.
+func DistanceToPath(p Coord, path []Coord) float64 {
+	if len(path) == 1 {
+		return Distance(p, path[0])
+	}
+	best := math.Inf(1)
+	for i := 1; i < len(path); i++ {
+		if d, _ := PointSegmentDistance(p, path[i-1], path[i]); d < best {
+			best = d
+		}
+	}
+	return best
+}
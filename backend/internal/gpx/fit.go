@@ -0,0 +1,273 @@
+package gpx
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// fitEpoch is the FIT timestamp origin, 1989-12-31T00:00:00Z, in Unix seconds.
+const fitEpoch = 631065600
+
+// FIT global message numbers and field numbers read by ParseFIT.
+const (
+	fitMesgSession = 18
+	fitMesgRecord  = 20
+
+	fitFieldTimestamp = 253
+
+	fitSessionStartTime = 2
+	fitSessionSport     = 5
+
+	fitRecordLat         = 0
+	fitRecordLon         = 1
+	fitRecordAltitude    = 2
+	fitRecordHR          = 3
+	fitRecordCadence     = 4
+	fitRecordPower       = 7
+	fitRecordEnhancedAlt = 78
+)
+
+// fitSports maps the FIT sport enum onto the sports of this package.
+var fitSports = map[uint64]string{
+	1:  SportRunning,
+	2:  SportCycling,
+	3:  SportTransition,
+	5:  SportSwimming,
+	11: SportWalking,
+	17: SportHiking,
+}
+
+type fitField struct {
+	num, size byte
+}
+
+type fitDefinition struct {
+	global    uint16
+	bigEndian bool
+	fields    []fitField
+	devSize   int
+}
+
+// fitMessage holds the raw bytes of a data message's fields by number.
+type fitMessage struct {
+	order  binary.ByteOrder
+	fields map[byte][]byte
+}
+
+// uint reads an unsigned field of 1, 2 or 4 bytes; ok is false when it is
+// missing or holds the FIT invalid value.
+func (m fitMessage) uint(num byte) (uint64, bool) {
+	raw, ok := m.fields[num]
+	if !ok {
+		return 0, false
+	}
+	switch len(raw) {
+	case 1:
+		return uint64(raw[0]), raw[0] != 0xFF
+	case 2:
+		v := m.order.Uint16(raw)
+		return uint64(v), v != 0xFFFF
+	case 4:
+		v := m.order.Uint32(raw)
+		return uint64(v), v != 0xFFFFFFFF
+	}
+	return 0, false
+}
+
+// semicircles reads a position field in degrees.
+func (m fitMessage) semicircles(num byte) (float64, bool) {
+	raw, ok := m.fields[num]
+	if !ok || len(raw) != 4 {
+		return 0, false
+	}
+	v := int32(m.order.Uint32(raw))
+	if v == math.MaxInt32 {
+		return 0, false
+	}
+	return float64(v) * 180 / (1 << 31), true
+}
+
+type fitSession struct {
+	start time.Time
+	sport string
+}
+
+func isFIT(content []byte) bool {
+	return len(content) >= 12 && string(content[8:12]) == ".FIT"
+}
+
+func fitTime(ts uint64) time.Time {
+	return time.Unix(int64(ts)+fitEpoch, 0).UTC()
+}
+
+// ParseFIT reads the records and sessions of a FIT activity file. Each
+// session of a multisport file becomes a leg. Developer fields are skipped
+// and CRCs are not checked.
+func ParseFIT(content []byte) (ParsedActivity, error) {
+	if !isFIT(content) {
+		return ParsedActivity{}, errors.New("not a FIT file")
+	}
+	headerSize := int(content[0])
+	if headerSize < 12 {
+		return ParsedActivity{}, errors.New("invalid FIT header")
+	}
+	end := headerSize + int(binary.LittleEndian.Uint32(content[4:8]))
+	if end > len(content) {
+		return ParsedActivity{}, errors.New("FIT file is truncated")
+	}
+	data := content[headerSize:end]
+	errTruncated := errors.New("FIT file is truncated")
+
+	activity := ParsedActivity{Name: "Imported FIT Activity"}
+	var sessions []fitSession
+	defs := map[byte]*fitDefinition{}
+	var lastTimestamp uint32
+
+	for pos := 0; pos < len(data); {
+		header := data[pos]
+		pos++
+
+		var local byte
+		compressed := false
+		switch {
+		case header&0x80 != 0:
+			// Compressed timestamp header: a 5-bit offset from the last
+			// full timestamp.
+			local = (header >> 5) & 0x03
+			offset := uint32(header & 0x1F)
+			ts := lastTimestamp&^0x1F + offset
+			if offset < lastTimestamp&0x1F {
+				ts += 0x20
+			}
+			lastTimestamp = ts
+			compressed = true
+		case header&0x40 != 0:
+			if pos+5 > len(data) {
+				return ParsedActivity{}, errTruncated
+			}
+			def := &fitDefinition{bigEndian: data[pos+1] == 1}
+			if def.bigEndian {
+				def.global = binary.BigEndian.Uint16(data[pos+2:])
+			} else {
+				def.global = binary.LittleEndian.Uint16(data[pos+2:])
+			}
+			n := int(data[pos+4])
+			pos += 5
+			if pos+3*n > len(data) {
+				return ParsedActivity{}, errTruncated
+			}
+			for i := 0; i < n; i++ {
+				def.fields = append(def.fields, fitField{num: data[pos], size: data[pos+1]})
+				pos += 3
+			}
+			if header&0x20 != 0 {
+				if pos >= len(data) {
+					return ParsedActivity{}, errTruncated
+				}
+				n := int(data[pos])
+				pos++
+				if pos+3*n > len(data) {
+					return ParsedActivity{}, errTruncated
+				}
+				for i := 0; i < n; i++ {
+					def.devSize += int(data[pos+1])
+					pos += 3
+				}
+			}
+			defs[header&0x0F] = def
+			continue
+		default:
+			local = header & 0x0F
+		}
+
+		def, ok := defs[local]
+		if !ok {
+			return ParsedActivity{}, errors.New("FIT data message without a definition")
+		}
+		msg := fitMessage{order: binary.LittleEndian, fields: make(map[byte][]byte, len(def.fields))}
+		if def.bigEndian {
+			msg.order = binary.BigEndian
+		}
+		for _, f := range def.fields {
+			if pos+int(f.size) > len(data) {
+				return ParsedActivity{}, errTruncated
+			}
+			msg.fields[f.num] = data[pos : pos+int(f.size)]
+			pos += int(f.size)
+		}
+		pos += def.devSize
+		if pos > len(data) {
+			return ParsedActivity{}, errTruncated
+		}
+
+		ts, hasTS := msg.uint(fitFieldTimestamp)
+		if hasTS {
+			lastTimestamp = uint32(ts)
+		} else if compressed {
+			ts, hasTS = uint64(lastTimestamp), true
+		}
+
+		switch def.global {
+		case fitMesgRecord:
+			lat, okLat := msg.semicircles(fitRecordLat)
+			lon, okLon := msg.semicircles(fitRecordLon)
+			if !okLat || !okLon {
+				continue
+			}
+			point := Point{Lat: lat, Lon: lon}
+			if alt, ok := msg.uint(fitRecordEnhancedAlt); ok {
+				point.Ele = float64(alt)/5 - 500
+			} else if alt, ok := msg.uint(fitRecordAltitude); ok {
+				point.Ele = float64(alt)/5 - 500
+			}
+			if hasTS {
+				t := fitTime(ts)
+				point.Time = &t
+			}
+			if hr, ok := msg.uint(fitRecordHR); ok && hr > 0 {
+				v := int(hr)
+				point.HR = &v
+			}
+			if cad, ok := msg.uint(fitRecordCadence); ok {
+				v := int(cad)
+				point.Cadence = &v
+			}
+			if power, ok := msg.uint(fitRecordPower); ok {
+				v := int(power)
+				point.Power = &v
+			}
+			activity.Points = append(activity.Points, point)
+		case fitMesgSession:
+			start, ok := msg.uint(fitSessionStartTime)
+			if !ok {
+				continue
+			}
+			sport := SportOther
+			if raw, ok := msg.uint(fitSessionSport); ok {
+				if s, known := fitSports[raw]; known {
+					sport = s
+				}
+			}
+			sessions = append(sessions, fitSession{start: fitTime(start), sport: sport})
+		}
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].start.Before(sessions[j].start) })
+	switch {
+	case len(sessions) > 1:
+		for _, s := range sessions {
+			activity.Legs = append(activity.Legs, Leg{Sport: s.sport, Start: s.start})
+		}
+		activity.Sport = MultisportType(activity.Legs)
+	case len(sessions) == 1:
+		activity.Sport = sessions[0].sport
+	}
+
+	if len(activity.Points) < 2 {
+		return ParsedActivity{}, errors.New("FIT must contain at least 2 records with a position")
+	}
+	return activity, nil
+}
@@ -0,0 +1,165 @@
+package gpx
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// fitWriter builds FIT files for tests, one definition per message.
+type fitWriter struct {
+	records []byte
+}
+
+type fitTestField struct {
+	num   byte
+	value any // uint8, uint16, uint32 or int32
+}
+
+func (w *fitWriter) message(local byte, global uint16, fields ...fitTestField) {
+	w.records = append(w.records, 0x40|local, 0, 0)
+	w.records = binary.LittleEndian.AppendUint16(w.records, global)
+	w.records = append(w.records, byte(len(fields)))
+	var data []byte
+	for _, f := range fields {
+		switch v := f.value.(type) {
+		case uint8:
+			w.records = append(w.records, f.num, 1, 0x02)
+			data = append(data, v)
+		case uint16:
+			w.records = append(w.records, f.num, 2, 0x84)
+			data = binary.LittleEndian.AppendUint16(data, v)
+		case uint32:
+			w.records = append(w.records, f.num, 4, 0x86)
+			data = binary.LittleEndian.AppendUint32(data, v)
+		case int32:
+			w.records = append(w.records, f.num, 4, 0x85)
+			data = binary.LittleEndian.AppendUint32(data, uint32(v))
+		}
+	}
+	w.records = append(w.records, local)
+	w.records = append(w.records, data...)
+}
+
+func (w *fitWriter) bytes() []byte {
+	out := []byte{14, 0x20}
+	out = binary.LittleEndian.AppendUint16(out, 2132)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(w.records)))
+	out = append(out, ".FIT"...)
+	out = append(out, 0, 0)
+	out = append(out, w.records...)
+	return append(out, 0, 0)
+}
+
+func fitTS(t time.Time) uint32 { return uint32(t.Unix() - fitEpoch) }
+
+func semicircle(deg float64) int32 { return int32(deg * (1 << 31) / 180) }
+
+func TestParseFIT_MultisportSessions(t *testing.T) {
+	start := time.Date(2026, 5, 10, 8, 0, 0, 0, time.UTC)
+	var w fitWriter
+	for i := 0; i < 6; i++ {
+		w.message(0, fitMesgRecord,
+			fitTestField{fitFieldTimestamp, fitTS(start.Add(time.Duration(i) * time.Minute))},
+			fitTestField{fitRecordLat, semicircle(50.77 + float64(i)*0.001)},
+			fitTestField{fitRecordLon, semicircle(6.09)},
+			fitTestField{fitRecordAltitude, uint16((100 + 500) * 5)},
+			fitTestField{fitRecordHR, uint8(140 + i)},
+			fitTestField{fitRecordPower, uint16(0xFFFF)},
+		)
+	}
+	// A record without a position is skipped.
+	w.message(0, fitMesgRecord, fitTestField{fitFieldTimestamp, fitTS(start.Add(6 * time.Minute))})
+	// Sessions arrive after the records, here out of order.
+	w.message(1, fitMesgSession, fitTestField{fitSessionStartTime, fitTS(start.Add(3 * time.Minute))}, fitTestField{fitSessionSport, uint8(1)})
+	w.message(1, fitMesgSession, fitTestField{fitSessionStartTime, fitTS(start)}, fitTestField{fitSessionSport, uint8(1)})
+	w.message(1, fitMesgSession, fitTestField{fitSessionStartTime, fitTS(start.Add(1 * time.Minute))}, fitTestField{fitSessionSport, uint8(2)})
+
+	parsed, err := ParseFIT(w.bytes())
+	if err != nil {
+		t.Fatalf("ParseFIT: %v", err)
+	}
+	if len(parsed.Points) != 6 {
+		t.Fatalf("points = %d, want 6", len(parsed.Points))
+	}
+	p := parsed.Points[1]
+	if p.Time == nil || !p.Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("time = %v", p.Time)
+	}
+	if p.Lat < 50.7709 || p.Lat > 50.7711 || p.Ele != 100 {
+		t.Errorf("position = %v, %v, ele %v", p.Lat, p.Lon, p.Ele)
+	}
+	if p.HR == nil || *p.HR != 141 {
+		t.Errorf("hr = %v", p.HR)
+	}
+	if p.Power != nil {
+		t.Errorf("invalid power should be dropped, got %d", *p.Power)
+	}
+	if parsed.Sport != SportDuathlon {
+		t.Errorf("sport = %q, want %q", parsed.Sport, SportDuathlon)
+	}
+	want := []string{SportRunning, SportCycling, SportRunning}
+	if len(parsed.Legs) != 3 {
+		t.Fatalf("legs = %v", parsed.Legs)
+	}
+	for i, sport := range want {
+		if parsed.Legs[i].Sport != sport {
+			t.Errorf("leg %d = %q, want %q", i, parsed.Legs[i].Sport, sport)
+		}
+	}
+}
+
+func TestParseFIT_CompressedTimestamps(t *testing.T) {
+	// Align the start so the low 5 bits are 30 and the offset wraps.
+	base := time.Date(2026, 5, 10, 8, 0, 0, 0, time.UTC)
+	start := base.Add(time.Duration(30-int(fitTS(base)&0x1F)) * time.Second)
+	var w fitWriter
+	w.message(0, fitMesgRecord,
+		fitTestField{fitFieldTimestamp, fitTS(start)},
+		fitTestField{fitRecordLat, semicircle(50.77)},
+		fitTestField{fitRecordLon, semicircle(6.09)},
+	)
+	// Redefine local 1 without a timestamp and send it with a compressed
+	// header 5 seconds later.
+	w.message(1, fitMesgRecord, fitTestField{fitRecordLat, semicircle(50.771)}, fitTestField{fitRecordLon, semicircle(6.09)})
+	data := w.records[len(w.records)-9:]
+	w.records = w.records[:len(w.records)-9]
+	offset := byte((fitTS(start) + 5) & 0x1F)
+	w.records = append(w.records, 0x80|1<<5|offset)
+	w.records = append(w.records, data[1:]...)
+
+	parsed, err := ParseFIT(w.bytes())
+	if err != nil {
+		t.Fatalf("ParseFIT: %v", err)
+	}
+	if len(parsed.Points) != 2 {
+		t.Fatalf("points = %d, want 2", len(parsed.Points))
+	}
+	if got := parsed.Points[1].Time; got == nil || !got.Equal(start.Add(5*time.Second)) {
+		t.Errorf("compressed time = %v, want %v", got, start.Add(5*time.Second))
+	}
+}
+
+func TestParseFIT_Truncated(t *testing.T) {
+	var w fitWriter
+	w.message(0, fitMesgRecord, fitTestField{fitRecordLat, semicircle(50.77)})
+	b := w.bytes()
+	if _, err := ParseFIT(b[:len(b)-4]); err == nil {
+		t.Fatal("expected an error for a truncated file")
+	}
+}
+
+func TestParseFile_DetectsFormat(t *testing.T) {
+	var w fitWriter
+	start := time.Date(2026, 5, 10, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		w.message(0, fitMesgRecord,
+			fitTestField{fitFieldTimestamp, fitTS(start.Add(time.Duration(i) * time.Second))},
+			fitTestField{fitRecordLat, semicircle(50.77)},
+			fitTestField{fitRecordLon, semicircle(6.09)},
+		)
+	}
+	if _, err := ParseFile("upload.bin", w.bytes()); err != nil {
+		t.Errorf("FIT content not detected: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package gpx
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sports read from TCX and FIT files.
+const (
+	SportRunning    = "running"
+	SportCycling    = "cycling"
+	SportSwimming   = "swimming"
+	SportWalking    = "walking"
+	SportHiking     = "hiking"
+	SportTransition = "transition"
+	SportOther      = "other"
+)
+
+// Multisport activity types.
+const (
+	SportTriathlon  = "triathlon"
+	SportDuathlon   = "duathlon"
+	SportAquathlon  = "aquathlon"
+	SportMultisport = "multisport"
+)
+
+// Leg marks a sport change in a multisport recording: from Start on, until
+// the next leg, the athlete is doing Sport.
+type Leg struct {
+	Sport string    `json:"sport"`
+	Start time.Time `json:"start"`
+}
+
+// MultisportType names a sequence of legs: triathlon for swim, bike, run,
+// duathlon for run, bike, run, aquathlon for swim, run. Transitions are
+// ignored; a single sport is returned as is and anything else is
+// "multisport".
+func MultisportType(legs []Leg) string {
+	var sports []string
+	for _, l := range legs {
+		if l.Sport == SportTransition {
+			continue
+		}
+		if len(sports) == 0 || sports[len(sports)-1] != l.Sport {
+			sports = append(sports, l.Sport)
+		}
+	}
+	switch strings.Join(sports, ",") {
+	case "":
+		return ""
+	case "swimming,cycling,running":
+		return SportTriathlon
+	case "running,cycling,running":
+		return SportDuathlon
+	case "swimming,running":
+		return SportAquathlon
+	}
+	if len(sports) == 1 {
+		return sports[0]
+	}
+	return SportMultisport
+}
+
+// IsMultisport reports whether a sport type names a multisport activity.
+func IsMultisport(sportType string) bool {
+	switch strings.ToLower(sportType) {
+	case SportTriathlon, SportDuathlon, SportAquathlon, SportMultisport:
+		return true
+	}
+	return false
+}
+
+// ParseFile parses a GPX, TCX or FIT file, chosen by extension and, failing
+// that, by content.
+func ParseFile(fileName string, content []byte) (ParsedActivity, error) {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".fit":
+		return ParseFIT(content)
+	case ".tcx":
+		return ParseTCX(content)
+	case ".gpx":
+		return Parse(content)
+	}
+	switch {
+	case isFIT(content):
+		return ParseFIT(content)
+	case bytes.Contains(content, []byte("<TrainingCenterDatabase")):
+		return ParseTCX(content)
+	}
+	return Parse(content)
+}
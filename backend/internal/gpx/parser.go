@@ -16,11 +16,19 @@ type Point struct {
 	Time    *time.Time `json:"time,omitempty"`
 	HR      *int       `json:"hr,omitempty"`
 	Cadence *int       `json:"cadence,omitempty"`
+	Power   *int       `json:"power,omitempty"`
+	// Gap marks the first point after a stretch that was not recorded, such
+	// as between merged activities. Nothing is measured from the point before.
+	Gap bool `json:"gap,omitempty"`
 }
 
 type ParsedActivity struct {
 	Name   string  `json:"name"`
 	Points []Point `json:"points"`
+	// Sport is the sport recorded in TCX and FIT files; empty for GPX.
+	Sport string `json:"sport,omitempty"`
+	// Legs lists the sport changes of a multisport recording, in order.
+	Legs []Leg `json:"legs,omitempty"`
 }
 
 type gpxDoc struct {
@@ -51,6 +59,9 @@ type extensionsXML struct {
 var (
 	hrRe  = regexp.MustCompile(`<(?:[a-zA-Z0-9_]+:)?hr>\s*([0-9]{1,3})\s*</(?:[a-zA-Z0-9_]+:)?hr>`)
 	cadRe = regexp.MustCompile(`<(?:[a-zA-Z0-9_]+:)?cad>\s*([0-9]{1,3})\s*</(?:[a-zA-Z0-9_]+:)?cad>`)
+	// <power> is the common GPX extension; PowerInWatts and Watts come from
+	// Garmin and TCX-derived exports.
+	powerRe = regexp.MustCompile(`<(?:[a-zA-Z0-9_]+:)?(?:power|PowerInWatts|Watts)>\s*([0-9]{1,4})\s*</(?:[a-zA-Z0-9_]+:)?(?:power|PowerInWatts|Watts)>`)
 )
 
 func Parse(content []byte) (ParsedActivity, error) {
@@ -83,6 +94,9 @@ func Parse(content []byte) (ParsedActivity, error) {
 			if cad, ok := parseExtInt(cadRe, p.Extensions.Inner); ok {
 				point.Cadence = &cad
 			}
+			if power, ok := parseExtInt(powerRe, p.Extensions.Inner); ok {
+				point.Power = &power
+			}
 			activity.Points = append(activity.Points, point)
 		}
 	}
@@ -105,3 +105,24 @@ func TestParse_LessThanTwoPoints_ReturnsError(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestParse_PowerExtension(t *testing.T) {
+	input := `<?xml version="1.0"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="50.0" lon="6.0"><time>2026-02-15T08:00:00Z</time><extensions><power>245</power></extensions></trkpt>
+    <trkpt lat="50.001" lon="6.001"><time>2026-02-15T08:00:01Z</time><extensions><ns3:TPX><ns3:Watts>310</ns3:Watts></ns3:TPX></extensions></trkpt>
+  </trkseg></trk>
+</gpx>`
+
+	parsed, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse() returned unexpected error: %v", err)
+	}
+	if p := parsed.Points[0].Power; p == nil || *p != 245 {
+		t.Fatalf("expected power 245, got %v", p)
+	}
+	if p := parsed.Points[1].Power; p == nil || *p != 310 {
+		t.Fatalf("expected power 310, got %v", p)
+	}
+}
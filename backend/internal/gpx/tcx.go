@@ -0,0 +1,156 @@
+package gpx
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+type tcxDoc struct {
+	Activities struct {
+		Activities []tcxActivity   `xml:"Activity"`
+		MultiSport []tcxMultiSport `xml:"MultiSportSession"`
+	} `xml:"Activities"`
+}
+
+type tcxMultiSport struct {
+	First struct {
+		Activity tcxActivity `xml:"Activity"`
+	} `xml:"FirstSport"`
+	Next []struct {
+		Transition *tcxLap     `xml:"Transition"`
+		Activity   tcxActivity `xml:"Activity"`
+	} `xml:"NextSport"`
+}
+
+type tcxActivity struct {
+	Sport string   `xml:"Sport,attr"`
+	Notes string   `xml:"Notes"`
+	Laps  []tcxLap `xml:"Lap"`
+}
+
+type tcxLap struct {
+	StartTime string     `xml:"StartTime,attr"`
+	Tracks    []tcxTrack `xml:"Track"`
+}
+
+type tcxTrack struct {
+	Points []tcxPoint `xml:"Trackpoint"`
+}
+
+type tcxPoint struct {
+	Time     string `xml:"Time"`
+	Position *struct {
+		Lat float64 `xml:"LatitudeDegrees"`
+		Lon float64 `xml:"LongitudeDegrees"`
+	} `xml:"Position"`
+	Altitude *float64 `xml:"AltitudeMeters"`
+	HR       *struct {
+		Value int `xml:"Value"`
+	} `xml:"HeartRateBpm"`
+	Cadence    *int          `xml:"Cadence"`
+	Extensions extensionsXML `xml:"Extensions"`
+}
+
+// ParseTCX reads a Garmin Training Center file. A multisport session becomes
+// one activity with a leg per sport and per transition; otherwise the first
+// activity is read.
+func ParseTCX(content []byte) (ParsedActivity, error) {
+	var doc tcxDoc
+	if err := xml.Unmarshal(content, &doc); err != nil {
+		return ParsedActivity{}, err
+	}
+
+	var activity ParsedActivity
+	switch {
+	case len(doc.Activities.MultiSport) > 0:
+		session := doc.Activities.MultiSport[0]
+		// TCX has no swim sport; a multisport session opening with "Other"
+		// is a triathlon swim.
+		first := tcxSport(session.First.Activity.Sport)
+		if first == SportOther {
+			first = SportSwimming
+		}
+		activity.appendTCXLeg(first, session.First.Activity.Laps...)
+		for _, next := range session.Next {
+			if next.Transition != nil {
+				activity.appendTCXLeg(SportTransition, *next.Transition)
+			}
+			activity.appendTCXLeg(tcxSport(next.Activity.Sport), next.Activity.Laps...)
+		}
+		activity.Sport = MultisportType(activity.Legs)
+		if len(activity.Legs) < 2 {
+			activity.Legs = nil
+		}
+	case len(doc.Activities.Activities) > 0:
+		a := doc.Activities.Activities[0]
+		activity.Sport = tcxSport(a.Sport)
+		activity.Name = strings.TrimSpace(a.Notes)
+		for _, lap := range a.Laps {
+			activity.appendTCXLap(lap)
+		}
+	default:
+		return ParsedActivity{}, errors.New("no <Activity> found in TCX")
+	}
+
+	if activity.Name == "" {
+		activity.Name = "Imported TCX Activity"
+	}
+	if len(activity.Points) < 2 {
+		return ParsedActivity{}, errors.New("TCX must contain at least 2 track points with a position")
+	}
+	return activity, nil
+}
+
+// appendTCXLeg adds the laps of one sport, marking where the sport starts.
+// Legs without a start time cannot be placed on the track and are dropped.
+func (a *ParsedActivity) appendTCXLeg(sport string, laps ...tcxLap) {
+	if len(laps) == 0 {
+		return
+	}
+	if start := parseTime(laps[0].StartTime); start != nil {
+		a.Legs = append(a.Legs, Leg{Sport: sport, Start: start.UTC()})
+	}
+	for _, lap := range laps {
+		a.appendTCXLap(lap)
+	}
+}
+
+// appendTCXLap adds a lap's trackpoints. Points without a position, such as
+// pool swims or indoor rides, are skipped.
+func (a *ParsedActivity) appendTCXLap(lap tcxLap) {
+	for _, track := range lap.Tracks {
+		for _, p := range track.Points {
+			if p.Position == nil {
+				continue
+			}
+			point := Point{Lat: p.Position.Lat, Lon: p.Position.Lon}
+			if p.Altitude != nil {
+				point.Ele = *p.Altitude
+			}
+			point.Time = parseTime(p.Time)
+			if p.HR != nil && p.HR.Value > 0 {
+				hr := p.HR.Value
+				point.HR = &hr
+			}
+			if p.Cadence != nil {
+				cad := *p.Cadence
+				point.Cadence = &cad
+			}
+			if power, ok := parseExtInt(powerRe, p.Extensions.Inner); ok {
+				point.Power = &power
+			}
+			a.Points = append(a.Points, point)
+		}
+	}
+}
+
+func tcxSport(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "running":
+		return SportRunning
+	case "biking":
+		return SportCycling
+	}
+	return SportOther
+}
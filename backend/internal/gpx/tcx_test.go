@@ -0,0 +1,130 @@
+package gpx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTCX_SingleActivity(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2"
+  xmlns:ns3="http://www.garmin.com/xmlschemas/ActivityExtension/v2">
+  <Activities>
+    <Activity Sport="Biking">
+      <Id>2026-03-01T09:00:00Z</Id>
+      <Lap StartTime="2026-03-01T09:00:00Z">
+        <Track>
+          <Trackpoint>
+            <Time>2026-03-01T09:00:00Z</Time>
+            <Position><LatitudeDegrees>50.77</LatitudeDegrees><LongitudeDegrees>6.09</LongitudeDegrees></Position>
+            <AltitudeMeters>120</AltitudeMeters>
+            <HeartRateBpm><Value>130</Value></HeartRateBpm>
+            <Cadence>85</Cadence>
+            <Extensions><ns3:TPX><ns3:Watts>210</ns3:Watts></ns3:TPX></Extensions>
+          </Trackpoint>
+          <Trackpoint>
+            <Time>2026-03-01T09:00:05Z</Time>
+          </Trackpoint>
+          <Trackpoint>
+            <Time>2026-03-01T09:00:10Z</Time>
+            <Position><LatitudeDegrees>50.771</LatitudeDegrees><LongitudeDegrees>6.091</LongitudeDegrees></Position>
+            <AltitudeMeters>121</AltitudeMeters>
+          </Trackpoint>
+        </Track>
+      </Lap>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>`
+
+	parsed, err := ParseTCX([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseTCX: %v", err)
+	}
+	if parsed.Sport != SportCycling {
+		t.Errorf("sport = %q, want %q", parsed.Sport, SportCycling)
+	}
+	if len(parsed.Points) != 2 {
+		t.Fatalf("points = %d, want 2 (positionless point skipped)", len(parsed.Points))
+	}
+	p := parsed.Points[0]
+	if p.HR == nil || *p.HR != 130 || p.Cadence == nil || *p.Cadence != 85 || p.Power == nil || *p.Power != 210 {
+		t.Errorf("sensor data not parsed: %+v", p)
+	}
+	if len(parsed.Legs) != 0 {
+		t.Errorf("legs = %v, want none", parsed.Legs)
+	}
+}
+
+func TestParseTCX_MultiSportSession(t *testing.T) {
+	lap := func(start string) string {
+		return `<Lap StartTime="` + start + `"><Track>
+          <Trackpoint><Time>` + start + `</Time><Position><LatitudeDegrees>50.7</LatitudeDegrees><LongitudeDegrees>6.0</LongitudeDegrees></Position></Trackpoint>
+        </Track></Lap>`
+	}
+	input := `<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+  <Activities>
+    <MultiSportSession>
+      <Id>2026-06-07T07:00:00Z</Id>
+      <FirstSport><Activity Sport="Other">` + lap("2026-06-07T07:00:00Z") + `</Activity></FirstSport>
+      <NextSport>
+        <Transition StartTime="2026-06-07T07:30:00Z"><Track>
+          <Trackpoint><Time>2026-06-07T07:30:00Z</Time><Position><LatitudeDegrees>50.7</LatitudeDegrees><LongitudeDegrees>6.0</LongitudeDegrees></Position></Trackpoint>
+        </Track></Transition>
+        <Activity Sport="Biking">` + lap("2026-06-07T07:33:00Z") + `</Activity>
+      </NextSport>
+      <NextSport>
+        <Activity Sport="Running">` + lap("2026-06-07T08:45:00Z") + `</Activity>
+      </NextSport>
+    </MultiSportSession>
+  </Activities>
+</TrainingCenterDatabase>`
+
+	parsed, err := ParseTCX([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseTCX: %v", err)
+	}
+	if parsed.Sport != SportTriathlon {
+		t.Errorf("sport = %q, want %q", parsed.Sport, SportTriathlon)
+	}
+	want := []string{SportSwimming, SportTransition, SportCycling, SportRunning}
+	if len(parsed.Legs) != len(want) {
+		t.Fatalf("legs = %v, want %v", parsed.Legs, want)
+	}
+	for i, sport := range want {
+		if parsed.Legs[i].Sport != sport {
+			t.Errorf("leg %d sport = %q, want %q", i, parsed.Legs[i].Sport, sport)
+		}
+	}
+	if got := parsed.Legs[2].Start; !got.Equal(time.Date(2026, 6, 7, 7, 33, 0, 0, time.UTC)) {
+		t.Errorf("bike start = %v", got)
+	}
+	if len(parsed.Points) != 4 {
+		t.Errorf("points = %d, want 4", len(parsed.Points))
+	}
+}
+
+func TestMultisportType(t *testing.T) {
+	legs := func(sports ...string) []Leg {
+		out := make([]Leg, len(sports))
+		for i, s := range sports {
+			out[i] = Leg{Sport: s}
+		}
+		return out
+	}
+	cases := []struct {
+		legs []Leg
+		want string
+	}{
+		{legs(SportSwimming, SportTransition, SportCycling, SportTransition, SportRunning), SportTriathlon},
+		{legs(SportRunning, SportCycling, SportRunning), SportDuathlon},
+		{legs(SportSwimming, SportRunning), SportAquathlon},
+		{legs(SportCycling, SportCycling), SportCycling},
+		{legs(SportCycling, SportWalking), SportMultisport},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		if got := MultisportType(c.legs); got != c.want {
+			t.Errorf("MultisportType(%v) = %q, want %q", c.legs, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package gpx
+
+import (
+	"errors"
+	"time"
+)
+
+// MergeTracks joins tracks recorded one after another into one. The first
+// point of every track after the first is marked as a Gap. Tracks must be in
+// time order and must not overlap.
+func MergeTracks(tracks ...[]Point) ([]Point, error) {
+	var merged []Point
+	for _, t := range tracks {
+		if len(t) == 0 {
+			continue
+		}
+		if len(merged) > 0 {
+			last, first := merged[len(merged)-1].Time, t[0].Time
+			if last != nil && first != nil && first.Before(*last) {
+				return nil, errors.New("tracks overlap in time")
+			}
+		}
+		start := len(merged)
+		merged = append(merged, t...)
+		merged[start].Gap = start > 0
+	}
+	return merged, nil
+}
+
+// SplitTrack cuts a track at point index i, which ends the first part and
+// starts the second. Both parts need at least 2 points.
+func SplitTrack(points []Point, i int) ([]Point, []Point, error) {
+	if i < 1 || i > len(points)-2 {
+		return nil, nil, errors.New("split point must leave at least 2 points on each side")
+	}
+	first := append([]Point(nil), points[:i+1]...)
+	second := append([]Point(nil), points[i:]...)
+	second[0].Gap = false
+	return first, second, nil
+}
+
+// IndexAt returns the index of the first timed point at or after t, or -1
+// when the track ends before t.
+func IndexAt(points []Point, t time.Time) int {
+	for i, p := range points {
+		if p.Time != nil && !p.Time.Before(t) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,73 @@
+package gpx
+
+import (
+	"testing"
+	"time"
+)
+
+func timedTrack(start time.Time, n int) []Point {
+	points := make([]Point, n)
+	for i := range points {
+		ts := start.Add(time.Duration(i) * time.Second)
+		points[i] = Point{Lat: 50 + float64(i)*0.0001, Lon: 6, Time: &ts}
+	}
+	return points
+}
+
+func TestMergeTracks(t *testing.T) {
+	start := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+	a := timedTrack(start, 3)
+	b := timedTrack(start.Add(10*time.Minute), 2)
+
+	merged, err := MergeTracks(a, b)
+	if err != nil {
+		t.Fatalf("MergeTracks: %v", err)
+	}
+	if len(merged) != 5 {
+		t.Fatalf("points = %d, want 5", len(merged))
+	}
+	for i, p := range merged {
+		if p.Gap != (i == 3) {
+			t.Errorf("point %d gap = %v", i, p.Gap)
+		}
+	}
+	if b[0].Gap {
+		t.Error("input track was modified")
+	}
+
+	if _, err := MergeTracks(b, a); err == nil {
+		t.Error("expected an error for overlapping tracks")
+	}
+}
+
+func TestSplitTrack(t *testing.T) {
+	points := timedTrack(time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC), 5)
+	points[2].Gap = true
+
+	first, second, err := SplitTrack(points, 2)
+	if err != nil {
+		t.Fatalf("SplitTrack: %v", err)
+	}
+	if len(first) != 3 || len(second) != 3 {
+		t.Fatalf("parts = %d + %d, want 3 + 3", len(first), len(second))
+	}
+	if second[0].Gap || !points[2].Gap {
+		t.Error("the second part should start without a gap, leaving the input alone")
+	}
+	for _, i := range []int{0, 4} {
+		if _, _, err := SplitTrack(points, i); err == nil {
+			t.Errorf("split at %d: expected an error", i)
+		}
+	}
+}
+
+func TestIndexAt(t *testing.T) {
+	start := time.Date(2026, 4, 1, 9, 0, 0, 0, time.UTC)
+	points := timedTrack(start, 5)
+	if got := IndexAt(points, start.Add(2500*time.Millisecond)); got != 3 {
+		t.Errorf("IndexAt = %d, want 3", got)
+	}
+	if got := IndexAt(points, start.Add(time.Hour)); got != -1 {
+		t.Errorf("IndexAt past the end = %d, want -1", got)
+	}
+}
@@ -0,0 +1,185 @@
+// Package heatmap renders personal heatmap raster tiles in the standard
+// Web Mercator XYZ tiling scheme. Rendering is pure Go and needs no map
+// service: tiles are transparent PNGs meant to be layered over any base map.
+package heatmap
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	"gpx-training-analyzer/backend/internal/geo"
+)
+
+const (
+	TileSize = 256
+	MaxZoom  = 18
+
+	// saturation is the number of activities through a pixel that renders at
+	// full intensity. A fixed value keeps neighbouring tiles consistent.
+	saturation = 20
+	// maxGapM skips drawing across recording gaps longer than this, so a
+	// paused watch does not paint a straight line across the map.
+	maxGapM = 2000
+)
+
+var ErrInvalidTile = errors.New("tile coordinates out of range")
+
+// ValidTile reports whether z/x/y address an existing tile.
+func ValidTile(z, x, y int) bool {
+	if z < 0 || z > MaxZoom {
+		return false
+	}
+	n := 1 << z
+	return x >= 0 && x < n && y >= 0 && y < n
+}
+
+// TileBounds returns the latitude/longitude extent of a tile.
+func TileBounds(z, x, y int) geo.BBox {
+	n := float64(int(1) << z)
+	return geo.BBox{
+		MinLon: float64(x)/n*360 - 180,
+		MaxLon: float64(x+1)/n*360 - 180,
+		MaxLat: tileLat(float64(y), n),
+		MinLat: tileLat(float64(y+1), n),
+	}
+}
+
+// QueryBounds returns the tile's extent grown by the width of a drawn line, so
+// tracks just outside the tile that still paint its edge pixels are included.
+// It is also the extent to invalidate a cached tile by.
+func QueryBounds(z, x, y int) geo.BBox {
+	metresPerPixel := 2 * math.Pi * 6378137 / float64(TileSize) / float64(int(1)<<z)
+	return TileBounds(z, x, y).Expand(float64(lineRadius(z)+1) * metresPerPixel)
+}
+
+func tileLat(y, n float64) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+}
+
+// worldPixel projects a coordinate to global pixel space at zoom z.
+func worldPixel(c geo.Coord, z int) (float64, float64) {
+	scale := float64(TileSize) * float64(int(1)<<z)
+	lat := math.Max(-85.05112878, math.Min(85.05112878, c.Lat))
+	sinLat := math.Sin(lat * math.Pi / 180)
+	x := (c.Lon + 180) / 360 * scale
+	y := (0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)) * scale
+	return x, y
+}
+
+// Render draws tracks onto tile z/x/y and encodes it as PNG. Each pixel's
+// intensity reflects how many tracks pass through it, not how many points.
+func Render(z, x, y int, tracks [][]geo.Coord) ([]byte, error) {
+	if !ValidTile(z, x, y) {
+		return nil, ErrInvalidTile
+	}
+	counts := make([]uint16, TileSize*TileSize)
+	stamp := make([]int, TileSize*TileSize)
+	originX, originY := float64(x*TileSize), float64(y*TileSize)
+	radius := lineRadius(z)
+
+	for i, track := range tracks {
+		mark := i + 1
+		plot := func(px, py int) {
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					qx, qy := px+dx, py+dy
+					if qx < 0 || qy < 0 || qx >= TileSize || qy >= TileSize {
+						continue
+					}
+					idx := qy*TileSize + qx
+					if stamp[idx] != mark {
+						stamp[idx] = mark
+						if counts[idx] < math.MaxUint16 {
+							counts[idx]++
+						}
+					}
+				}
+			}
+		}
+		for j := 1; j < len(track); j++ {
+			if geo.Distance(track[j-1], track[j]) > maxGapM {
+				continue
+			}
+			ax, ay := worldPixel(track[j-1], z)
+			bx, by := worldPixel(track[j], z)
+			drawLine(ax-originX, ay-originY, bx-originX, by-originY, float64(radius), plot)
+		}
+		if len(track) == 1 {
+			px, py := worldPixel(track[0], z)
+			plot(int(px-originX), int(py-originY))
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, TileSize, TileSize))
+	for idx, c := range counts {
+		if c == 0 {
+			continue
+		}
+		v := math.Min(1, math.Log1p(float64(c))/math.Log1p(saturation))
+		img.SetNRGBA(idx%TileSize, idx/TileSize, ramp(v))
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// lineRadius thickens lines at street level so single passes stay visible.
+func lineRadius(z int) int {
+	switch {
+	case z >= 16:
+		return 2
+	case z >= 13:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// drawLine walks the segment a-b one pixel at a time. Segments entirely
+// outside the tile (plus margin) are skipped.
+func drawLine(ax, ay, bx, by, margin float64, plot func(x, y int)) {
+	lo, hi := -margin-1, float64(TileSize)+margin+1
+	if (ax < lo && bx < lo) || (ax > hi && bx > hi) || (ay < lo && by < lo) || (ay > hi && by > hi) {
+		return
+	}
+	steps := int(math.Ceil(math.Max(math.Abs(bx-ax), math.Abs(by-ay))))
+	if steps == 0 {
+		plot(int(math.Floor(ax)), int(math.Floor(ay)))
+		return
+	}
+	for s := 0; s <= steps; s++ {
+		t := float64(s) / float64(steps)
+		plot(int(math.Floor(ax+(bx-ax)*t)), int(math.Floor(ay+(by-ay)*t)))
+	}
+}
+
+// ramp maps an intensity in (0, 1] to a translucent blue → red → yellow
+// colour.
+func ramp(v float64) color.NRGBA {
+	type stop struct {
+		at      float64
+		r, g, b float64
+	}
+	stops := []stop{
+		{0, 40, 80, 255},
+		{0.5, 230, 30, 40},
+		{1, 255, 240, 60},
+	}
+	c := stops[len(stops)-1]
+	for i := 1; i < len(stops); i++ {
+		if v <= stops[i].at {
+			lo, hi := stops[i-1], stops[i]
+			f := (v - lo.at) / (hi.at - lo.at)
+			c = stop{r: lo.r + (hi.r-lo.r)*f, g: lo.g + (hi.g-lo.g)*f, b: lo.b + (hi.b-lo.b)*f}
+			break
+		}
+	}
+	alpha := 110 + 145*v
+	return color.NRGBA{R: uint8(c.r), G: uint8(c.g), B: uint8(c.b), A: uint8(alpha)}
+}
@@ -0,0 +1,67 @@
+package heatmap
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math"
+	"testing"
+
+	"gpx-training-analyzer/backend/internal/geo"
+)
+
+func tileOf(c geo.Coord, z int) (int, int) {
+	px, py := worldPixel(c, z)
+	return int(px) / TileSize, int(py) / TileSize
+}
+
+func TestTileBounds(t *testing.T) {
+	b := TileBounds(0, 0, 0)
+	if b.MinLon != -180 || b.MaxLon != 180 || math.Abs(b.MaxLat-85.0511) > 1e-3 {
+		t.Fatalf("unexpected world tile bounds %+v", b)
+	}
+	c := geo.Coord{Lat: 33.59, Lon: -7.62}
+	x, y := tileOf(c, 14)
+	if !TileBounds(14, x, y).Contains(c) {
+		t.Fatalf("tile %d/%d does not contain %+v", x, y, c)
+	}
+}
+
+func TestRender(t *testing.T) {
+	start := geo.Coord{Lat: 33.59, Lon: -7.62}
+	track := []geo.Coord{start, {Lat: 33.595, Lon: -7.615}}
+	x, y := tileOf(start, 14)
+
+	data, err := Render(14, x, y, [][]geo.Coord{track, track})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, TileSize, TileSize) {
+		t.Fatalf("unexpected tile size %v", img.Bounds())
+	}
+	px, py := worldPixel(start, 14)
+	if _, _, _, a := img.At(int(px)%TileSize, int(py)%TileSize).RGBA(); a == 0 {
+		t.Fatal("expected the track start to be painted")
+	}
+
+	empty, err := Render(14, x+2, y, [][]geo.Coord{track})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ = png.Decode(bytes.NewReader(empty))
+	for yy := 0; yy < TileSize; yy++ {
+		for xx := 0; xx < TileSize; xx++ {
+			if _, _, _, a := img.At(xx, yy).RGBA(); a != 0 {
+				t.Fatalf("expected a transparent tile, pixel %d,%d is painted", xx, yy)
+			}
+		}
+	}
+
+	if _, err := Render(2, 4, 0, nil); err != ErrInvalidTile {
+		t.Fatalf("expected ErrInvalidTile, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package live
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+type gpxFile struct {
+	XMLName xml.Name `xml:"gpx"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name"`
+	Type    string     `xml:"type,omitempty"`
+	Segment []gpxPoint `xml:"trkseg>trkpt"`
+}
+
+type gpxPoint struct {
+	Lat        float64        `xml:"lat,attr"`
+	Lon        float64        `xml:"lon,attr"`
+	Ele        float64        `xml:"ele"`
+	Time       string         `xml:"time"`
+	Extensions *gpxExtensions `xml:"extensions,omitempty"`
+}
+
+// gpxExtensions holds sensor data in the unprefixed form gpx.Parse reads.
+type gpxExtensions struct {
+	HR      *int `xml:"hr,omitempty"`
+	Cadence *int `xml:"cad,omitempty"`
+	Power   *int `xml:"power,omitempty"`
+}
+
+// WriteGPX writes the fixes of a session as a timed GPX track with heart
+// rate, cadence and power, the file the import pipeline turns into an
+// activity.
+func WriteGPX(w io.Writer, name, sportType string, points []gpx.Point) error {
+	f := gpxFile{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "GPX Training Analyzer",
+		Track:   gpxTrack{Name: name, Type: sportType, Segment: make([]gpxPoint, len(points))},
+	}
+	for i, p := range points {
+		gp := gpxPoint{Lat: p.Lat, Lon: p.Lon, Ele: p.Ele}
+		if p.Time != nil {
+			gp.Time = p.Time.UTC().Format(time.RFC3339Nano)
+		}
+		if p.HR != nil || p.Cadence != nil || p.Power != nil {
+			gp.Extensions = &gpxExtensions{HR: p.HR, Cadence: p.Cadence, Power: p.Power}
+		}
+		f.Track.Segment[i] = gp
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,151 @@
+// Package live follows activities while they happen: a device sends its
+// position fixes in batches, followers see the latest position, the distance
+// covered and an arrival estimate, and the finished session is written out as
+// a GPX track for the usual import.
+package live
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// MaxBatchPoints caps the fixes of one batch; a device that was offline
+// sends what it buffered in several.
+const MaxBatchPoints = 500
+
+// maxClockSkew is how far ahead of the server clock a fix may be dated.
+const maxClockSkew = 5 * time.Minute
+
+var (
+	ErrBatchTooLarge = fmt.Errorf("a batch has at most %d points", MaxBatchPoints)
+	// ErrTooFewPoints is returned when a session ends with too little track
+	// to import.
+	ErrTooFewPoints = errors.New("a live session needs at least 2 points to become an activity")
+)
+
+// Progress is where a session stands after the fixes received so far.
+type Progress struct {
+	Points    int        `json:"points"`
+	DistanceM float64    `json:"distanceM"`
+	StartedAt *time.Time `json:"startedAt"`
+	Last      *gpx.Point `json:"-"`
+}
+
+// Append adds a batch of fixes to a session. Fixes must be timed; they are
+// taken in time order and those not newer than the last one received are
+// dropped, so a batch sent again after a lost response adds nothing. It
+// returns the new progress and the fixes kept.
+func (p Progress) Append(batch []gpx.Point, now time.Time) (Progress, []gpx.Point, error) {
+	if len(batch) > MaxBatchPoints {
+		return p, nil, ErrBatchTooLarge
+	}
+	for i, fix := range batch {
+		if fix.Lat < -90 || fix.Lat > 90 || fix.Lon < -180 || fix.Lon > 180 {
+			return p, nil, fmt.Errorf("point %d is not a valid position", i+1)
+		}
+		if fix.Time == nil {
+			return p, nil, fmt.Errorf("point %d has no time", i+1)
+		}
+		if fix.Time.After(now.Add(maxClockSkew)) {
+			return p, nil, fmt.Errorf("point %d is dated in the future", i+1)
+		}
+	}
+	sorted := append([]gpx.Point(nil), batch...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(*sorted[j].Time) })
+
+	kept := make([]gpx.Point, 0, len(sorted))
+	for _, fix := range sorted {
+		if p.Last != nil && !fix.Time.After(*p.Last.Time) {
+			continue
+		}
+		fix.Gap = false
+		if p.Last == nil {
+			start := *fix.Time
+			p.StartedAt = &start
+		} else {
+			p.DistanceM += geo.Distance(geo.Coord{Lat: p.Last.Lat, Lon: p.Last.Lon}, geo.Coord{Lat: fix.Lat, Lon: fix.Lon})
+		}
+		last := fix
+		p.Last = &last
+		p.Points++
+		kept = append(kept, fix)
+	}
+	return p, kept, nil
+}
+
+// Estimate is how a session is going and, given a target distance, when it
+// should arrive.
+type Estimate struct {
+	DistanceKM  float64    `json:"distanceKm"`
+	ElapsedSec  int        `json:"elapsedSec"`
+	AvgSpeedKmh float64    `json:"avgSpeedKmh"`
+	RemainingKM *float64   `json:"remainingKm"`
+	ETA         *time.Time `json:"eta"`
+}
+
+// Estimate projects the average speed so far over the distance left to
+// targetM; with no target, or before the session has moved, there is no
+// arrival time.
+func (p Progress) Estimate(targetM float64) Estimate {
+	e := Estimate{DistanceKM: round2(p.DistanceM / 1000)}
+	var speed float64
+	if p.Last != nil && p.StartedAt != nil {
+		elapsed := p.Last.Time.Sub(*p.StartedAt).Seconds()
+		e.ElapsedSec = int(math.Round(elapsed))
+		if elapsed > 0 {
+			speed = p.DistanceM / elapsed
+			e.AvgSpeedKmh = round2(speed * 3.6)
+		}
+	}
+	if targetM <= 0 {
+		return e
+	}
+	remaining := math.Max(0, targetM-p.DistanceM)
+	remainingKM := round2(remaining / 1000)
+	e.RemainingKM = &remainingKM
+	if speed > 0 {
+		eta := p.Last.Time.Add(time.Duration(remaining / speed * float64(time.Second))).Truncate(time.Second)
+		e.ETA = &eta
+	}
+	return e
+}
+
+// Position is a fix as followers see it, without sensor data.
+type Position struct {
+	Lat  float64    `json:"lat"`
+	Lon  float64    `json:"lon"`
+	Ele  float64    `json:"ele"`
+	Time *time.Time `json:"time"`
+}
+
+func NewPosition(p gpx.Point) Position {
+	return Position{Lat: p.Lat, Lon: p.Lon, Ele: p.Ele, Time: p.Time}
+}
+
+// Trail returns the positions of fixes, keeping at most max of them evenly
+// spread, the first and latest included. max <= 0 keeps them all.
+func Trail(points []gpx.Point, max int) []Position {
+	n := len(points)
+	if max <= 0 || n < max {
+		max = n
+	}
+	out := make([]Position, max)
+	for i := range out {
+		idx := n - 1
+		if max > 1 {
+			idx = i * (n - 1) / (max - 1)
+		}
+		out[i] = NewPosition(points[idx])
+	}
+	return out
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,121 @@
+package live
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+var t0 = time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+// fix returns a point sec seconds after t0, km kilometres north of 45°N.
+func fix(sec int, km float64) gpx.Point {
+	ts := t0.Add(time.Duration(sec) * time.Second)
+	return gpx.Point{Lat: 45 + km*0.008993, Lon: 6, Time: &ts}
+}
+
+func TestAppend(t *testing.T) {
+	var p Progress
+	p, kept, err := p.Append([]gpx.Point{fix(60, 0.2), fix(0, 0), fix(120, 0.4)}, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 3 || !kept[0].Time.Equal(t0) || p.Points != 3 || !p.StartedAt.Equal(t0) {
+		t.Fatalf("first batch: progress %+v, kept %d", p, len(kept))
+	}
+	if math.Abs(p.DistanceM-400) > 1 {
+		t.Errorf("distance = %.1f m, want 400", p.DistanceM)
+	}
+
+	// The same batch sent again, with one new fix, adds only that fix.
+	p, kept, err = p.Append([]gpx.Point{fix(60, 0.2), fix(120, 0.4), fix(180, 0.6)}, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || p.Points != 4 || math.Abs(p.DistanceM-600) > 1 {
+		t.Fatalf("retried batch: progress %+v, kept %d", p, len(kept))
+	}
+}
+
+func TestAppendRejects(t *testing.T) {
+	var p Progress
+	untimed := fix(0, 0)
+	untimed.Time = nil
+	bad := fix(0, 0)
+	bad.Lat = 95
+	for name, batch := range map[string][]gpx.Point{
+		"untimed":  {untimed},
+		"position": {bad},
+		"future":   {fix(3600, 0)},
+		"too many": make([]gpx.Point, MaxBatchPoints+1),
+	} {
+		if _, _, err := p.Append(batch, t0); err == nil {
+			t.Errorf("%s batch accepted", name)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	var p Progress
+	p, _, _ = p.Append([]gpx.Point{fix(0, 0), fix(600, 2)}, t0.Add(time.Hour))
+	e := p.Estimate(10000)
+	if e.ElapsedSec != 600 || math.Abs(e.AvgSpeedKmh-12) > 0.05 {
+		t.Fatalf("estimate = %+v, want 600 s at 12 km/h", e)
+	}
+	if e.RemainingKM == nil || math.Abs(*e.RemainingKM-8) > 0.01 {
+		t.Fatalf("remaining = %v, want 8 km", e.RemainingKM)
+	}
+	if want := t0.Add(50 * time.Minute); e.ETA == nil || e.ETA.Sub(want).Abs() > 2*time.Second {
+		t.Errorf("eta = %v, want %v", e.ETA, want)
+	}
+
+	if e := p.Estimate(0); e.RemainingKM != nil || e.ETA != nil {
+		t.Errorf("estimate without target = %+v", e)
+	}
+	var idle Progress
+	idle, _, _ = idle.Append([]gpx.Point{fix(0, 0)}, t0)
+	if e := idle.Estimate(5000); e.ETA != nil {
+		t.Errorf("eta before moving = %v", e.ETA)
+	}
+}
+
+func TestTrail(t *testing.T) {
+	points := make([]gpx.Point, 10)
+	for i := range points {
+		points[i] = fix(i, float64(i))
+	}
+	trail := Trail(points, 4)
+	if len(trail) != 4 || trail[0].Time != points[0].Time || trail[3].Time != points[9].Time {
+		t.Fatalf("trail = %+v", trail)
+	}
+	if got := len(Trail(points, 0)); got != 10 {
+		t.Errorf("unlimited trail has %d positions", got)
+	}
+	if got := len(Trail(points[:1], 4)); got != 1 {
+		t.Errorf("one point trail has %d positions", got)
+	}
+}
+
+func TestWriteGPX(t *testing.T) {
+	hr, power := 142, 210
+	points := []gpx.Point{fix(0, 0), fix(10, 0.05)}
+	points[1].HR, points[1].Power = &hr, &power
+	var buf bytes.Buffer
+	if err := WriteGPX(&buf, "Morning ride", "cycling", points); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := gpx.Parse(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Name != "Morning ride" || len(parsed.Points) != 2 {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+	p := parsed.Points[1]
+	if p.Time == nil || !p.Time.Equal(*points[1].Time) || p.HR == nil || *p.HR != 142 || p.Power == nil || *p.Power != 210 || p.Cadence != nil {
+		t.Errorf("parsed point = %+v", p)
+	}
+}
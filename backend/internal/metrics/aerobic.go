@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"math"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// Decoupling bases: pace (speed) or normalized power against heart rate.
+const (
+	DecouplingPaHr = "pa:hr"
+	DecouplingPwHr = "pw:hr"
+)
+
+const (
+	// npWindowSec is the rolling window of normalized power.
+	npWindowSec = 30
+	// minDecouplingSec is the shortest effort whose halves are compared.
+	minDecouplingSec = 20 * 60
+	// steadySmoothSec smooths speed before judging how even an effort was.
+	steadySmoothSec = 60
+	// maxSteadySpeedCV and maxSteadyVI bound the variability of a steady
+	// session: the coefficient of variation of smoothed speed, and the ratio
+	// of normalized to average power.
+	maxSteadySpeedCV = 0.15
+	maxSteadyVI      = 1.10
+	// minSensorShare is the fraction of the track that must carry a sensor
+	// reading for it to be used.
+	minSensorShare = 0.8
+)
+
+// aerobic holds the power and heart-rate derived fields of a Result.
+type aerobic struct {
+	avgPower         float64
+	normalizedPower  float64
+	efficiencyFactor float64
+	decoupling       *float64
+	decouplingType   string
+	steady           bool
+}
+
+// computeAerobic derives efficiency factor and aerobic decoupling. EF is
+// speed in metres per minute, or normalized power, divided by average HR.
+// Decoupling compares the EF of the first and second half of the effort by
+// time; a positive value means HR drifted up relative to output.
+func computeAerobic(points []gpx.Point) aerobic {
+	var out aerobic
+	segs := newIntervalSegments(points)
+	if len(segs) == 0 {
+		return out
+	}
+	var withPower, withHR int
+	for _, s := range segs {
+		if points[s.to].Power != nil {
+			withPower++
+		}
+		if points[s.to].HR != nil {
+			withHR++
+		}
+	}
+	usePower := float64(withPower) >= float64(len(segs))*minSensorShare
+	useHR := float64(withHR) >= float64(len(segs))*minSensorShare
+
+	var power []float64
+	if usePower {
+		power = make([]float64, len(segs))
+		for i, s := range segs {
+			if p := points[s.to].Power; p != nil {
+				power[i] = float64(*p)
+			}
+		}
+		out.avgPower, out.normalizedPower = powerStats(segs, power, 0, len(segs))
+	}
+
+	elapsed := segs[len(segs)-1].mid + segs[len(segs)-1].dt/2
+	if elapsed >= minDecouplingSec {
+		if usePower {
+			out.steady = out.avgPower > 0 && out.normalizedPower/out.avgPower <= maxSteadyVI
+		} else {
+			out.steady = speedCV(segs) <= maxSteadySpeedCV
+		}
+	}
+	if !useHR {
+		return out
+	}
+
+	ef := func(from, to int) float64 {
+		var hrSum, hrTime, dist, dt float64
+		for _, s := range segs[from:to] {
+			dist += s.dist
+			dt += s.dt
+			if hr := points[s.to].HR; hr != nil && *hr > 0 {
+				hrSum += float64(*hr) * s.dt
+				hrTime += s.dt
+			}
+		}
+		if hrTime == 0 || dt == 0 {
+			return 0
+		}
+		avgHR := hrSum / hrTime
+		if usePower {
+			_, np := powerStats(segs, power, from, to)
+			return np / avgHR
+		}
+		return dist / dt * 60 / avgHR
+	}
+
+	out.efficiencyFactor = ef(0, len(segs))
+	if out.efficiencyFactor == 0 {
+		return out
+	}
+	out.decouplingType = DecouplingPaHr
+	if usePower {
+		out.decouplingType = DecouplingPwHr
+	}
+	if elapsed < minDecouplingSec {
+		return out
+	}
+	half := 0
+	for half < len(segs) && segs[half].mid < elapsed/2 {
+		half++
+	}
+	first, second := ef(0, half), ef(half, len(segs))
+	if first > 0 && second > 0 {
+		d := round((first - second) / first * 100)
+		out.decoupling = &d
+	}
+	return out
+}
+
+// powerStats returns time-weighted average and normalized power over
+// segs[from:to]. Missing readings count as zero, i.e. coasting.
+func powerStats(segs []intervalSegment, power []float64, from, to int) (avg, np float64) {
+	smoothed := smoothByTime(segs, power, npWindowSec)
+	var sum, fourth, dt float64
+	for i := from; i < to; i++ {
+		sum += power[i] * segs[i].dt
+		fourth += math.Pow(smoothed[i], 4) * segs[i].dt
+		dt += segs[i].dt
+	}
+	if dt == 0 {
+		return 0, 0
+	}
+	return sum / dt, math.Pow(fourth/dt, 0.25)
+}
+
+// speedCV is the coefficient of variation of speed smoothed over
+// steadySmoothSec, weighted by time.
+func speedCV(segs []intervalSegment) float64 {
+	speed := make([]float64, len(segs))
+	for i, s := range segs {
+		speed[i] = s.dist / s.dt
+	}
+	speed = smoothByTime(segs, speed, steadySmoothSec)
+	var sum, dt float64
+	for i, s := range segs {
+		sum += speed[i] * s.dt
+		dt += s.dt
+	}
+	avg := sum / dt
+	if avg == 0 {
+		return math.Inf(1)
+	}
+	var variance float64
+	for i, s := range segs {
+		variance += (speed[i] - avg) * (speed[i] - avg) * s.dt
+	}
+	return math.Sqrt(variance/dt) / avg
+}
+
+// TrendPerMonth fits a least-squares line through values over time and
+// returns its slope per 30 days. It returns 0 with fewer than two distinct
+// dates.
+func TrendPerMonth(dates []time.Time, values []float64) float64 {
+	if len(dates) < 2 || len(dates) != len(values) {
+		return 0
+	}
+	origin := dates[0]
+	days := make([]float64, len(dates))
+	for i, d := range dates {
+		days[i] = d.Sub(origin).Hours() / 24
+	}
+	mx, my := mean(days), mean(values)
+	var cov, vx float64
+	for i := range days {
+		cov += (days[i] - mx) * (values[i] - my)
+		vx += (days[i] - mx) * (days[i] - mx)
+	}
+	if vx == 0 {
+		return 0
+	}
+	return round3(cov / vx * 30)
+}
+
+// round3 keeps three decimals, enough for efficiency factors around 1–2.
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// withHR sets heart rate rising linearly from start to end over the track.
+func withHR(points []gpx.Point, start, end float64) []gpx.Point {
+	for i := range points {
+		hr := int(math.Round(start + (end-start)*float64(i)/float64(len(points)-1)))
+		points[i].HR = &hr
+	}
+	return points
+}
+
+func TestCompute_PaceDecoupling(t *testing.T) {
+	points := withHR(workout(block{sec: 3600, speed: 3.33}), 140, 154)
+
+	result := Compute(points)
+
+	if result.DecouplingType != DecouplingPaHr {
+		t.Fatalf("expected pa:hr decoupling, got %q", result.DecouplingType)
+	}
+	// 200 m/min at 147 bpm on average.
+	if math.Abs(result.EfficiencyFactor-1.359) > 0.01 {
+		t.Fatalf("expected efficiency factor ~1.36, got %.3f", result.EfficiencyFactor)
+	}
+	if result.DecouplingPct == nil || *result.DecouplingPct < 4 || *result.DecouplingPct > 5.5 {
+		t.Fatalf("expected decoupling ~4.7%%, got %v", result.DecouplingPct)
+	}
+	if !result.Steady {
+		t.Fatal("expected an even-paced hour to be steady")
+	}
+}
+
+func TestCompute_PowerDecoupling(t *testing.T) {
+	points := withHR(workout(block{sec: 2400, speed: 8, power: 200}), 130, 130)
+
+	result := Compute(points)
+
+	if result.DecouplingType != DecouplingPwHr {
+		t.Fatalf("expected pw:hr decoupling, got %q", result.DecouplingType)
+	}
+	if result.AvgPowerW != 200 || math.Abs(result.NormalizedPowerW-200) > 0.5 {
+		t.Fatalf("expected avg and NP of 200 W, got %.1f / %.1f", result.AvgPowerW, result.NormalizedPowerW)
+	}
+	if math.Abs(result.EfficiencyFactor-200.0/130) > 0.01 {
+		t.Fatalf("expected efficiency factor ~1.54, got %.3f", result.EfficiencyFactor)
+	}
+	if result.DecouplingPct == nil || math.Abs(*result.DecouplingPct) > 0.5 {
+		t.Fatalf("expected no decoupling at constant HR, got %v", result.DecouplingPct)
+	}
+}
+
+func TestCompute_DecouplingNeedsSteadyLongEffort(t *testing.T) {
+	short := Compute(withHR(workout(block{sec: 600, speed: 3.3}), 140, 150))
+	if short.DecouplingPct != nil || short.Steady {
+		t.Fatalf("expected no decoupling for a 10 minute run, got %v steady=%v", short.DecouplingPct, short.Steady)
+	}
+	if short.EfficiencyFactor == 0 {
+		t.Fatal("expected an efficiency factor even for a short run")
+	}
+
+	var blocks []block
+	for i := 0; i < 10; i++ {
+		blocks = append(blocks, block{sec: 120, speed: 5}, block{sec: 120, speed: 2})
+	}
+	intervals := Compute(withHR(workout(blocks...), 140, 160))
+	if intervals.Steady {
+		t.Fatal("expected an interval session not to be steady")
+	}
+
+	noHR := Compute(workout(block{sec: 1800, speed: 3.3}))
+	if noHR.EfficiencyFactor != 0 || noHR.DecouplingPct != nil || noHR.DecouplingType != "" {
+		t.Fatalf("expected no HR metrics without HR, got %+v", noHR)
+	}
+}
+
+func TestTrendPerMonth(t *testing.T) {
+	start := mustTime("2026-01-01T07:00:00Z")
+	var dates []time.Time
+	var values []float64
+	for i := 0; i < 5; i++ {
+		dates = append(dates, start.AddDate(0, 0, 15*i))
+		values = append(values, 1.40+0.01*float64(i))
+	}
+	if got := TrendPerMonth(dates, values); math.Abs(got-0.02) > 0.0005 {
+		t.Fatalf("expected +0.02 per month, got %.4f", got)
+	}
+	if got := TrendPerMonth(dates[:1], values[:1]); got != 0 {
+		t.Fatalf("expected 0 for a single session, got %.4f", got)
+	}
+}
@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// CompareSample holds both efforts at the same distance along the route.
+// Deltas are B minus A, so a positive GapSec means B is behind A.
+type CompareSample struct {
+	DistanceM float64  `json:"distanceM"`
+	TimeASec  float64  `json:"timeASec"`
+	TimeBSec  float64  `json:"timeBSec"`
+	GapSec    float64  `json:"gapSec"`
+	PaceA     float64  `json:"paceAMinPerKm"`
+	PaceB     float64  `json:"paceBMinPerKm"`
+	PaceDelta float64  `json:"paceDeltaMinPerKm"`
+	HRA       *float64 `json:"hrA,omitempty"`
+	HRB       *float64 `json:"hrB,omitempty"`
+	HRDelta   *float64 `json:"hrDelta,omitempty"`
+	EleA      float64  `json:"eleA"`
+	EleB      float64  `json:"eleB"`
+	EleDelta  float64  `json:"eleDelta"`
+}
+
+// GhostPosition is where one effort was at a given elapsed time.
+type GhostPosition struct {
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	DistanceM float64 `json:"distanceM"`
+	Finished  bool    `json:"finished"`
+}
+
+// GhostFrame places both efforts at the same elapsed time, for replaying one
+// against the other. GapM is B's distance minus A's distance.
+type GhostFrame struct {
+	ElapsedSec float64       `json:"elapsedSec"`
+	A          GhostPosition `json:"a"`
+	B          GhostPosition `json:"b"`
+	GapM       float64       `json:"gapM"`
+}
+
+type Comparison struct {
+	ComparedDistanceM float64         `json:"comparedDistanceM"`
+	StepM             float64         `json:"stepM"`
+	FinalGapSec       float64         `json:"finalGapSec"`
+	Samples           []CompareSample `json:"samples"`
+	GhostStepSec      float64         `json:"ghostStepSec"`
+	Ghost             []GhostFrame    `json:"ghost"`
+}
+
+var ErrNoTimestamps = errors.New("both activities need at least 2 timestamped points")
+
+const (
+	compareTargetSamples = 200
+	ghostTargetFrames    = 300
+)
+
+// Compare aligns two efforts by distance along the route and by elapsed time.
+// The distance comparison covers the shorter of the two efforts.
+func Compare(a, b []gpx.Point) (Comparison, error) {
+	ta := newTrackProfile(a)
+	tb := newTrackProfile(b)
+	if len(ta) < 2 || len(tb) < 2 {
+		return Comparison{}, ErrNoTimestamps
+	}
+
+	compared := math.Min(ta.distance(), tb.distance())
+	stepM := math.Max(10, compared/compareTargetSamples)
+
+	cmp := Comparison{
+		ComparedDistanceM: round(compared),
+		StepM:             round(stepM),
+		Samples:           make([]CompareSample, 0, compareTargetSamples+2),
+	}
+
+	var prevA, prevB trackSample
+	for d := 0.0; ; d += stepM {
+		if d > compared {
+			d = compared
+		}
+		sa := ta.atDistance(d)
+		sb := tb.atDistance(d)
+		sample := CompareSample{
+			DistanceM: round(d),
+			TimeASec:  round(sa.elapsed),
+			TimeBSec:  round(sb.elapsed),
+			GapSec:    round(sb.elapsed - sa.elapsed),
+			EleA:      round(sa.ele),
+			EleB:      round(sb.ele),
+			EleDelta:  round(sb.ele - sa.ele),
+		}
+		if d > 0 {
+			sample.PaceA = round(paceMinPerKM(sa.dist-prevA.dist, sa.elapsed-prevA.elapsed))
+			sample.PaceB = round(paceMinPerKM(sb.dist-prevB.dist, sb.elapsed-prevB.elapsed))
+			sample.PaceDelta = round(sample.PaceB - sample.PaceA)
+		}
+		if sa.hasHR {
+			v := round(sa.hr)
+			sample.HRA = &v
+		}
+		if sb.hasHR {
+			v := round(sb.hr)
+			sample.HRB = &v
+		}
+		if sa.hasHR && sb.hasHR {
+			v := round(sb.hr - sa.hr)
+			sample.HRDelta = &v
+		}
+		cmp.Samples = append(cmp.Samples, sample)
+		prevA, prevB = sa, sb
+		if d >= compared {
+			cmp.FinalGapSec = sample.GapSec
+			break
+		}
+	}
+
+	longest := math.Max(ta.duration(), tb.duration())
+	ghostStep := math.Max(1, math.Ceil(longest/ghostTargetFrames))
+	cmp.GhostStepSec = ghostStep
+	cmp.Ghost = make([]GhostFrame, 0, ghostTargetFrames+2)
+	for t := 0.0; ; t += ghostStep {
+		if t > longest {
+			t = longest
+		}
+		ga := ta.atElapsed(t)
+		gb := tb.atElapsed(t)
+		cmp.Ghost = append(cmp.Ghost, GhostFrame{
+			ElapsedSec: t,
+			A:          GhostPosition{Lat: ga.lat, Lon: ga.lon, DistanceM: round(ga.dist), Finished: t >= ta.duration()},
+			B:          GhostPosition{Lat: gb.lat, Lon: gb.lon, DistanceM: round(gb.dist), Finished: t >= tb.duration()},
+			GapM:       round(gb.dist - ga.dist),
+		})
+		if t >= longest {
+			break
+		}
+	}
+
+	return cmp, nil
+}
+
+func paceMinPerKM(meters, seconds float64) float64 {
+	if meters <= 0 || seconds <= 0 {
+		return 0
+	}
+	return (seconds / 60) / (meters / 1000)
+}
+
+type trackSample struct {
+	dist    float64
+	elapsed float64
+	lat     float64
+	lon     float64
+	ele     float64
+	hr      float64
+	hasHR   bool
+}
+
+// trackProfile is a timestamped track with cumulative distance and elapsed
+// time, both non-decreasing. Points without a timestamp are skipped.
+type trackProfile []trackSample
+
+func newTrackProfile(points []gpx.Point) trackProfile {
+	profile := make(trackProfile, 0, len(points))
+	var start, last *gpx.Point
+	var dist float64
+	for i := range points {
+		p := &points[i]
+		if p.Time == nil {
+			continue
+		}
+		if start == nil {
+			start = p
+		} else {
+			if p.Time.Before(*last.Time) {
+				continue
+			}
+			if !p.Gap {
+				dist += haversineMeters(last.Lat, last.Lon, p.Lat, p.Lon)
+			}
+		}
+		s := trackSample{
+			dist:    dist,
+			elapsed: p.Time.Sub(*start.Time).Seconds(),
+			lat:     p.Lat,
+			lon:     p.Lon,
+			ele:     p.Ele,
+		}
+		if p.HR != nil {
+			s.hr, s.hasHR = float64(*p.HR), true
+		}
+		profile = append(profile, s)
+		last = p
+	}
+	return profile
+}
+
+func (t trackProfile) distance() float64 { return t[len(t)-1].dist }
+func (t trackProfile) duration() float64 { return t[len(t)-1].elapsed }
+
+func (t trackProfile) atDistance(d float64) trackSample {
+	i := sort.Search(len(t), func(i int) bool { return t[i].dist >= d })
+	return t.interpolate(i, func(s trackSample) float64 { return s.dist }, d)
+}
+
+func (t trackProfile) atElapsed(sec float64) trackSample {
+	i := sort.Search(len(t), func(i int) bool { return t[i].elapsed >= sec })
+	return t.interpolate(i, func(s trackSample) float64 { return s.elapsed }, sec)
+}
+
+// interpolate returns the sample at value v of key, where i is the first
+// index whose key is >= v.
+func (t trackProfile) interpolate(i int, key func(trackSample) float64, v float64) trackSample {
+	if i <= 0 {
+		return t[0]
+	}
+	if i >= len(t) {
+		return t[len(t)-1]
+	}
+	lo, hi := t[i-1], t[i]
+	span := key(hi) - key(lo)
+	if span <= 0 {
+		return hi
+	}
+	f := (v - key(lo)) / span
+	s := trackSample{
+		dist:    lerp(lo.dist, hi.dist, f),
+		elapsed: lerp(lo.elapsed, hi.elapsed, f),
+		lat:     lerp(lo.lat, hi.lat, f),
+		lon:     lerp(lo.lon, hi.lon, f),
+		ele:     lerp(lo.ele, hi.ele, f),
+	}
+	switch {
+	case lo.hasHR && hi.hasHR:
+		s.hr, s.hasHR = lerp(lo.hr, hi.hr, f), true
+	case hi.hasHR:
+		s.hr, s.hasHR = hi.hr, true
+	case lo.hasHR:
+		s.hr, s.hasHR = lo.hr, true
+	}
+	return s
+}
+
+func lerp(a, b, f float64) float64 {
+	return a + (b-a)*f
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// straightTrack builds n points heading north every ~111 m, one point every
+// secPerPoint seconds.
+func straightTrack(n int, secPerPoint float64, hr int) []gpx.Point {
+	start := mustTime("2026-02-15T08:00:00Z")
+	points := make([]gpx.Point, n)
+	for i := range points {
+		ts := start.Add(time.Duration(float64(i) * secPerPoint * float64(time.Second)))
+		h := hr
+		points[i] = gpx.Point{Lat: 33.5 + float64(i)*0.001, Lon: -7.6, Ele: 10 + float64(i), Time: &ts, HR: &h}
+	}
+	return points
+}
+
+func TestCompare_SlowerEffortFallsBehind(t *testing.T) {
+	a := straightTrack(21, 30, 150)
+	b := straightTrack(21, 33, 160)
+
+	cmp, err := Compare(a, b)
+	if err != nil {
+		t.Fatalf("Compare() error: %v", err)
+	}
+	if len(cmp.Samples) < 2 {
+		t.Fatalf("expected several samples, got %d", len(cmp.Samples))
+	}
+	last := cmp.Samples[len(cmp.Samples)-1]
+	if math.Abs(last.DistanceM-cmp.ComparedDistanceM) > 0.01 {
+		t.Fatalf("expected last sample at compared distance %.2f, got %.2f", cmp.ComparedDistanceM, last.DistanceM)
+	}
+	if math.Abs(cmp.FinalGapSec-60) > 0.5 {
+		t.Fatalf("expected final gap ~60 s, got %.2f", cmp.FinalGapSec)
+	}
+	if last.PaceDelta <= 0 {
+		t.Fatalf("expected B to be slower (positive pace delta), got %.2f", last.PaceDelta)
+	}
+	if last.HRDelta == nil || *last.HRDelta != 10 {
+		t.Fatalf("expected HR delta 10, got %v", last.HRDelta)
+	}
+	if last.EleDelta != 0 {
+		t.Fatalf("expected no elevation delta on the same route, got %.2f", last.EleDelta)
+	}
+
+	if len(cmp.Ghost) < 2 {
+		t.Fatalf("expected ghost frames, got %d", len(cmp.Ghost))
+	}
+	mid := cmp.Ghost[len(cmp.Ghost)/2]
+	if mid.GapM >= 0 {
+		t.Fatalf("expected B behind A mid-way, got gap %.2f m", mid.GapM)
+	}
+	end := cmp.Ghost[len(cmp.Ghost)-1]
+	if !end.A.Finished || !end.B.Finished {
+		t.Fatal("expected both efforts finished in the last ghost frame")
+	}
+	if end.ElapsedSec != 660 {
+		t.Fatalf("expected ghost to run until the slower effort ends (660 s), got %.0f", end.ElapsedSec)
+	}
+}
+
+func TestCompare_WithoutTimestamps(t *testing.T) {
+	a := []gpx.Point{{Lat: 50, Lon: 6}, {Lat: 50.01, Lon: 6.01}}
+	_, err := Compare(a, straightTrack(5, 10, 120))
+	if !errors.Is(err, ErrNoTimestamps) {
+		t.Fatalf("expected ErrNoTimestamps, got %v", err)
+	}
+}
@@ -21,6 +21,22 @@ type Result struct {
 	MaxHR        int       `json:"maxHr"`
 	AvgCadence   float64   `json:"avgCadence"`
 	ActivityDate time.Time `json:"activityDate"`
+
+	AvgPowerW        float64 `json:"avgPowerW,omitempty"`
+	NormalizedPowerW float64 `json:"normalizedPowerW,omitempty"`
+	// EfficiencyFactor is speed in m/min, or normalized power, per beat.
+	EfficiencyFactor float64 `json:"efficiencyFactor,omitempty"`
+	// DecouplingPct is the drop in efficiency factor from the first half to
+	// the second; nil when the effort is too short or lacks HR.
+	DecouplingPct  *float64 `json:"decouplingPct,omitempty"`
+	DecouplingType string   `json:"decouplingType,omitempty"` // "pa:hr" | "pw:hr"
+	// Steady marks efforts even enough for their decoupling to be compared
+	// across sessions.
+	Steady bool `json:"steady"`
+
+	// CaloriesKcal is set by EstimateCalories, which needs the athlete.
+	CaloriesKcal   float64 `json:"caloriesKcal,omitempty"`
+	CaloriesMethod string  `json:"caloriesMethod,omitempty"` // "power" | "heart_rate" | "met"
 }
 
 func Compute(points []gpx.Point) Result {
@@ -48,20 +64,12 @@ func Compute(points []gpx.Point) Result {
 	var cadSum int
 	var cadCount int
 
+	var gapSec float64
+
 	for i := 1; i < len(points); i++ {
 		prev := points[i-1]
 		curr := points[i]
 
-		segmentMeters := haversineMeters(prev.Lat, prev.Lon, curr.Lat, curr.Lon)
-		totalMeters += segmentMeters
-
-		eleDiff := curr.Ele - prev.Ele
-		if eleDiff > 0 {
-			elevGain += eleDiff
-		} else {
-			elevLoss += math.Abs(eleDiff)
-		}
-
 		if curr.Ele > maxElev {
 			maxElev = curr.Ele
 		}
@@ -69,12 +77,29 @@ func Compute(points []gpx.Point) Result {
 			minElev = curr.Ele
 		}
 
-		if prev.Time != nil && curr.Time != nil {
-			deltaSec := curr.Time.Sub(*prev.Time).Seconds()
-			if deltaSec > 0 {
-				kmh := (segmentMeters / 1000.0) / (deltaSec / 3600.0)
-				if kmh > maxSpeed && kmh < 120 {
-					maxSpeed = kmh
+		if curr.Gap {
+			// Nothing was recorded since prev: no distance, climb or time.
+			if prev.Time != nil && curr.Time != nil {
+				gapSec += math.Max(0, curr.Time.Sub(*prev.Time).Seconds())
+			}
+		} else {
+			segmentMeters := haversineMeters(prev.Lat, prev.Lon, curr.Lat, curr.Lon)
+			totalMeters += segmentMeters
+
+			eleDiff := curr.Ele - prev.Ele
+			if eleDiff > 0 {
+				elevGain += eleDiff
+			} else {
+				elevLoss += math.Abs(eleDiff)
+			}
+
+			if prev.Time != nil && curr.Time != nil {
+				deltaSec := curr.Time.Sub(*prev.Time).Seconds()
+				if deltaSec > 0 {
+					kmh := (segmentMeters / 1000.0) / (deltaSec / 3600.0)
+					if kmh > maxSpeed && kmh < 120 {
+						maxSpeed = kmh
+					}
 				}
 			}
 		}
@@ -94,9 +119,9 @@ func Compute(points []gpx.Point) Result {
 
 	durationSec := 0
 	if hasStart && hasEnd {
-		dur := points[len(points)-1].Time.Sub(*points[0].Time)
+		dur := points[len(points)-1].Time.Sub(*points[0].Time).Seconds() - gapSec
 		if dur > 0 {
-			durationSec = int(dur.Seconds())
+			durationSec = int(dur)
 		}
 	}
 
@@ -117,6 +142,8 @@ func Compute(points []gpx.Point) Result {
 		avgCadence = float64(cadSum) / float64(cadCount)
 	}
 
+	aero := computeAerobic(points)
+
 	return Result{
 		DistanceKM:   round(distanceKM),
 		DurationSec:  durationSec,
@@ -131,6 +158,13 @@ func Compute(points []gpx.Point) Result {
 		MaxHR:        maxHR,
 		AvgCadence:   round(avgCadence),
 		ActivityDate: activityDate,
+
+		AvgPowerW:        round(aero.avgPower),
+		NormalizedPowerW: round(aero.normalizedPower),
+		EfficiencyFactor: round3(aero.efficiencyFactor),
+		DecouplingPct:    aero.decoupling,
+		DecouplingType:   aero.decouplingType,
+		Steady:           aero.steady,
 	}
 }
 
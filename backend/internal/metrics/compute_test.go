@@ -88,3 +88,42 @@ func mustTime(raw string) time.Time {
 	}
 	return t
 }
+
+func TestFromTotals(t *testing.T) {
+	start := mustTime("2026-03-01T18:00:00+01:00")
+	r := FromTotals(Totals{Start: start, DurationSec: 1500, DistanceKM: 5, ElevGainM: 12, AvgHR: 151, MaxHR: 170})
+
+	if r.AvgSpeedKMH != 12 || r.PaceMinPerKM != 5 {
+		t.Fatalf("expected 12 km/h at 5 min/km, got %.2f / %.2f", r.AvgSpeedKMH, r.PaceMinPerKM)
+	}
+	if !r.ActivityDate.Equal(start) || r.ActivityDate.Location() != time.UTC {
+		t.Fatalf("expected the start in UTC, got %s", r.ActivityDate)
+	}
+	if r.ElevGainM != 12 || r.AvgHR != 151 || r.MaxHR != 170 {
+		t.Fatalf("expected totals to carry over, got %+v", r)
+	}
+}
+
+func TestCompute_SkipsRecordingGaps(t *testing.T) {
+	points := workout(block{sec: 600, speed: 3})
+	second := workout(block{sec: 600, speed: 3})
+	// The second recording starts 20 minutes later, 5 km away and 100 m up.
+	for i := range second {
+		ts := second[i].Time.Add(30 * time.Minute)
+		second[i].Time = &ts
+		second[i].Lat += 0.045
+		second[i].Ele = 100
+	}
+	second[0].Gap = true
+	result := Compute(append(points, second...))
+
+	if result.DurationSec != 1200 {
+		t.Fatalf("expected 1200 s recorded, got %d", result.DurationSec)
+	}
+	if result.DistanceKM < 3.59 || result.DistanceKM > 3.61 {
+		t.Fatalf("expected 3.6 km without the jump, got %.2f", result.DistanceKM)
+	}
+	if result.ElevGainM != 0 {
+		t.Fatalf("expected no climb across the gap, got %.1f", result.ElevGainM)
+	}
+}
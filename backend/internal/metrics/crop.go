@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// Units a crop is given in.
+const (
+	CropByTime     = "time"     // seconds
+	CropByDistance = "distance" // kilometres
+)
+
+// Crop hides the ends of a track: Start is trimmed from the beginning and End
+// from the end. StartIndex and EndIndex are the kept range of the original
+// track, filled in by CropRange.
+type Crop struct {
+	By         string  `json:"by"`
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	StartIndex int     `json:"startIndex"`
+	EndIndex   int     `json:"endIndex"`
+}
+
+// CropRange returns the indices of the first and last point kept by c. By
+// distance, recording gaps add nothing.
+func CropRange(points []gpx.Point, c Crop) (from, to int, err error) {
+	if c.Start < 0 || c.End < 0 {
+		return 0, 0, errors.New("crop offsets cannot be negative")
+	}
+	from, to = -1, -1
+	switch c.By {
+	case CropByTime:
+		first, last := firstTimedPoint(points), lastTimedPoint(points)
+		if first == nil || first == last {
+			return 0, 0, errors.New("cropping by time needs a track with timestamps")
+		}
+		keepFrom := first.Time.Add(time.Duration(c.Start * float64(time.Second)))
+		keepTo := last.Time.Add(-time.Duration(c.End * float64(time.Second)))
+		for i, p := range points {
+			if p.Time == nil {
+				continue
+			}
+			if from < 0 && !p.Time.Before(keepFrom) {
+				from = i
+			}
+			if !p.Time.After(keepTo) {
+				to = i
+			}
+		}
+	case CropByDistance:
+		if len(points) < 2 {
+			return 0, 0, errors.New("cropping by distance needs a track")
+		}
+		cum := make([]float64, len(points))
+		for i := 1; i < len(points); i++ {
+			cum[i] = cum[i-1]
+			if !points[i].Gap {
+				cum[i] += haversineMeters(points[i-1].Lat, points[i-1].Lon, points[i].Lat, points[i].Lon)
+			}
+		}
+		keepFrom, keepTo := c.Start*1000, cum[len(cum)-1]-c.End*1000
+		for i, d := range cum {
+			if from < 0 && d >= keepFrom {
+				from = i
+			}
+			if d <= keepTo {
+				to = i
+			}
+		}
+	default:
+		return 0, 0, errors.New(`crop "by" must be time or distance`)
+	}
+	if from < 0 || to-from < 1 {
+		return 0, 0, errors.New("crop leaves fewer than 2 points")
+	}
+	return from, to, nil
+}
+
+func lastTimedPoint(points []gpx.Point) *gpx.Point {
+	for i := len(points) - 1; i >= 0; i-- {
+		if points[i].Time != nil {
+			return &points[i]
+		}
+	}
+	return nil
+}
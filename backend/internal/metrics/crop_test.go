@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestCropRange_ByTime(t *testing.T) {
+	// 60 s of running then 600 s of walking around the car park.
+	points := workout(block{sec: 60, speed: 1.5}, block{sec: 600, speed: 3}, block{sec: 600, speed: 1})
+	from, to, err := CropRange(points, Crop{By: CropByTime, Start: 60, End: 600})
+	if err != nil {
+		t.Fatalf("CropRange: %v", err)
+	}
+	if from != 60 || to != 660 {
+		t.Fatalf("range = %d..%d, want 60..660", from, to)
+	}
+	r := Compute(points[from : to+1])
+	if r.DurationSec != 600 {
+		t.Errorf("cropped duration = %d, want 600", r.DurationSec)
+	}
+}
+
+func TestCropRange_ByDistance(t *testing.T) {
+	points := workout(block{sec: 1000, speed: 5})
+	from, to, err := CropRange(points, Crop{By: CropByDistance, Start: 0.5, End: 1})
+	if err != nil {
+		t.Fatalf("CropRange: %v", err)
+	}
+	if from < 99 || from > 101 || to < 799 || to > 801 {
+		t.Fatalf("range = %d..%d, want about 100..800", from, to)
+	}
+}
+
+func TestCropRange_Invalid(t *testing.T) {
+	points := workout(block{sec: 60, speed: 3})
+	cases := map[string]Crop{
+		"negative":   {By: CropByTime, Start: -1},
+		"everything": {By: CropByTime, Start: 40, End: 40},
+		"unit":       {By: "laps", Start: 1},
+	}
+	for name, c := range cases {
+		if _, _, err := CropRange(points, c); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
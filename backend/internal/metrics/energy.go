@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// Calorie estimation methods, from most to least direct.
+const (
+	CaloriesPower     = "power"
+	CaloriesHeartRate = "heart_rate"
+	CaloriesMET       = "met"
+)
+
+const (
+	// grossEfficiency is the share of metabolic energy a cyclist turns into
+	// work at the pedals.
+	grossEfficiency = 0.24
+	joulesPerKcal   = 4184.0
+)
+
+// Athlete is what calorie estimation needs to know about the person. Zero
+// values mean unknown.
+type Athlete struct {
+	WeightKg float64
+	Gender   string // "male" | "female"; anything else is unknown
+	AgeYears int
+}
+
+// Energy is an activity's estimated expenditure and how it was obtained.
+type Energy struct {
+	Kcal   float64
+	Method string // "" when nothing could be estimated
+}
+
+// EstimateCalories picks the most direct method the data allows: mechanical
+// work from power, then the Keytel et al. (2005) heart-rate equations, then
+// MET values from the Compendium of Physical Activities by sport and speed.
+// Without a timed track the averages in r are used instead.
+func EstimateCalories(points []gpx.Point, sportType string, r Result, athlete Athlete) Energy {
+	segs := newIntervalSegments(points)
+	var withPower, withHR int
+	for _, s := range segs {
+		if points[s.to].Power != nil {
+			withPower++
+		}
+		if points[s.to].HR != nil {
+			withHR++
+		}
+	}
+	tracked := len(segs) > 0
+	duration := float64(r.DurationSec)
+
+	switch {
+	case tracked && float64(withPower) >= float64(len(segs))*minSensorShare:
+		var joules float64
+		for _, s := range segs {
+			if p := points[s.to].Power; p != nil {
+				joules += float64(*p) * s.dt
+			}
+		}
+		return Energy{Kcal: math.Round(joules / grossEfficiency / joulesPerKcal), Method: CaloriesPower}
+	case !tracked && r.AvgPowerW > 0 && duration > 0:
+		joules := r.AvgPowerW * duration
+		return Energy{Kcal: math.Round(joules / grossEfficiency / joulesPerKcal), Method: CaloriesPower}
+	}
+
+	if athlete.WeightKg <= 0 {
+		return Energy{}
+	}
+	if athlete.AgeYears > 0 && (athlete.Gender == "male" || athlete.Gender == "female") {
+		switch {
+		case tracked && float64(withHR) >= float64(len(segs))*minSensorShare:
+			var kcal, covered, total float64
+			for _, s := range segs {
+				total += s.dt
+				if hr := points[s.to].HR; hr != nil {
+					kcal += keytelKcalPerMin(float64(*hr), athlete) * s.dt / 60
+					covered += s.dt
+				}
+			}
+			// Scale up for stretches without a reading.
+			return Energy{Kcal: math.Round(kcal * total / covered), Method: CaloriesHeartRate}
+		case !tracked && r.AvgHR > 0 && duration > 0:
+			return Energy{Kcal: math.Round(keytelKcalPerMin(r.AvgHR, athlete) * duration / 60), Method: CaloriesHeartRate}
+		}
+	}
+
+	if duration <= 0 {
+		return Energy{}
+	}
+	met := metValue(sportType, r.AvgSpeedKMH)
+	return Energy{Kcal: math.Round(met * athlete.WeightKg * duration / 3600), Method: CaloriesMET}
+}
+
+// keytelKcalPerMin is the energy expenditure equation of Keytel et al. (2005)
+// without VO2max, clamped at zero for heart rates near rest.
+func keytelKcalPerMin(hr float64, a Athlete) float64 {
+	w, age := a.WeightKg, float64(a.AgeYears)
+	var kj float64
+	if a.Gender == "male" {
+		kj = -55.0969 + 0.6309*hr + 0.1988*w + 0.2017*age
+	} else {
+		kj = -20.4022 + 0.4472*hr - 0.1263*w + 0.074*age
+	}
+	return math.Max(0, kj/4.184)
+}
+
+// metPoint is a MET value at a given speed in km/h.
+type metPoint struct {
+	speed, met float64
+}
+
+// metTables hold Compendium of Physical Activities values by speed.
+var metTables = map[string][]metPoint{
+	"running": {{6.4, 6.0}, {8.0, 8.3}, {9.7, 9.8}, {10.8, 10.5}, {11.3, 11.0}, {12.1, 11.8}, {12.9, 12.3}, {13.8, 12.8}, {14.5, 14.5}, {16.1, 16.0}, {17.7, 19.0}, {19.3, 19.8}, {20.9, 23.0}},
+	"cycling": {{16.0, 4.0}, {17.7, 6.8}, {20.9, 8.0}, {24.1, 10.0}, {28.2, 12.0}, {32.2, 15.8}},
+	"walking": {{3.2, 2.8}, {4.0, 3.0}, {4.8, 3.5}, {5.6, 4.3}, {6.4, 5.0}, {7.2, 7.0}, {8.0, 8.3}},
+}
+
+// flatMET covers sports whose intensity does not follow speed, and the
+// fallback for unknown sports.
+var flatMET = map[string]float64{
+	"hiking":   6.0,
+	"swimming": 5.8,
+	"strength": 5.0,
+	"":         6.0,
+}
+
+// metValue interpolates the MET table of a sport at speedKMH, clamping to
+// the ends of the table.
+func metValue(sportType string, speedKMH float64) float64 {
+	family := sportFamily(sportType)
+	table, ok := metTables[family]
+	if !ok {
+		return flatMET[family]
+	}
+	i := sort.Search(len(table), func(i int) bool { return table[i].speed >= speedKMH })
+	switch {
+	case i == 0:
+		return table[0].met
+	case i == len(table):
+		return table[len(table)-1].met
+	}
+	lo, hi := table[i-1], table[i]
+	return lo.met + (hi.met-lo.met)*(speedKMH-lo.speed)/(hi.speed-lo.speed)
+}
+
+// sportFamily maps free-form sport types onto the MET tables.
+func sportFamily(sportType string) string {
+	s := strings.ToLower(sportType)
+	switch {
+	case strings.Contains(s, "run"):
+		return "running"
+	case strings.Contains(s, "cycl"), strings.Contains(s, "bik"), strings.Contains(s, "ride"):
+		return "cycling"
+	case strings.Contains(s, "walk"):
+		return "walking"
+	case strings.Contains(s, "hik"):
+		return "hiking"
+	case strings.Contains(s, "swim"):
+		return "swimming"
+	case strings.Contains(s, "gym"), strings.Contains(s, "strength"), strings.Contains(s, "weight"):
+		return "strength"
+	}
+	return ""
+}
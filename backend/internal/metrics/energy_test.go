@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateCalories_Power(t *testing.T) {
+	points := workout(block{sec: 3600, speed: 8, power: 200})
+
+	e := EstimateCalories(points, "cycling", Compute(points), Athlete{})
+
+	// 720 kJ of work at 24% efficiency.
+	if e.Method != CaloriesPower || math.Abs(e.Kcal-717) > 1 {
+		t.Fatalf("expected ~717 kcal from power, got %.0f via %q", e.Kcal, e.Method)
+	}
+}
+
+func TestEstimateCalories_HeartRate(t *testing.T) {
+	points := withHR(workout(block{sec: 3600, speed: 3}), 150, 150)
+	r := Compute(points)
+
+	male := EstimateCalories(points, "running", r, Athlete{WeightKg: 70, Gender: "male", AgeYears: 35})
+	if male.Method != CaloriesHeartRate || math.Abs(male.Kcal-868) > 2 {
+		t.Fatalf("expected ~868 kcal from HR, got %.0f via %q", male.Kcal, male.Method)
+	}
+	female := EstimateCalories(points, "running", r, Athlete{WeightKg: 70, Gender: "female", AgeYears: 35})
+	if female.Method != CaloriesHeartRate || female.Kcal >= male.Kcal {
+		t.Fatalf("expected a lower female estimate, got %.0f via %q", female.Kcal, female.Method)
+	}
+}
+
+func TestEstimateCalories_METFallback(t *testing.T) {
+	points := withHR(workout(block{sec: 3600, speed: 3}), 150, 150)
+	r := Compute(points)
+
+	// HR without a known sex falls back to the MET table: 10.8 km/h running.
+	e := EstimateCalories(points, "running", r, Athlete{WeightKg: 70})
+	if e.Method != CaloriesMET || math.Abs(e.Kcal-735) > 2 {
+		t.Fatalf("expected ~735 kcal from METs, got %.0f via %q", e.Kcal, e.Method)
+	}
+
+	if none := EstimateCalories(points, "running", r, Athlete{}); none.Method != "" || none.Kcal != 0 {
+		t.Fatalf("expected no estimate without weight, got %+v", none)
+	}
+}
+
+func TestMETValue(t *testing.T) {
+	cases := []struct {
+		sport string
+		speed float64
+		want  float64
+	}{
+		{"running", 10.8, 10.5},
+		{"trail_running", 3, 6.0},
+		{"cycling", 40, 15.8},
+		{"walking", 4.4, 3.25},
+		{"swimming", 3, 5.8},
+		{"unknown", 12, 6.0},
+	}
+	for _, c := range cases {
+		if got := metValue(c.sport, c.speed); math.Abs(got-c.want) > 0.01 {
+			t.Errorf("metValue(%q, %.1f) = %.2f, want %.2f", c.sport, c.speed, got, c.want)
+		}
+	}
+}
+
+func TestEstimateCalories_WithoutTrack(t *testing.T) {
+	r := FromTotals(Totals{Start: mustTime("2026-03-01T18:00:00Z"), DurationSec: 3600, AvgHR: 150, AvgPowerW: 200})
+
+	if e := EstimateCalories(nil, "indoor_cycling", r, Athlete{}); e.Method != CaloriesPower || math.Abs(e.Kcal-717) > 1 {
+		t.Fatalf("expected ~717 kcal from average power, got %.0f via %q", e.Kcal, e.Method)
+	}
+	r.AvgPowerW = 0
+	e := EstimateCalories(nil, "gym", r, Athlete{WeightKg: 70, Gender: "male", AgeYears: 35})
+	if e.Method != CaloriesHeartRate || math.Abs(e.Kcal-868) > 2 {
+		t.Fatalf("expected ~868 kcal from average HR, got %.0f via %q", e.Kcal, e.Method)
+	}
+	if e := EstimateCalories(nil, "gym", r, Athlete{WeightKg: 70}); e.Method != CaloriesMET || e.Kcal != 350 {
+		t.Fatalf("expected 350 kcal from METs, got %.0f via %q", e.Kcal, e.Method)
+	}
+}
@@ -0,0 +1,470 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// Rep kinds.
+const (
+	RepWarmup   = "warmup"
+	RepWork     = "work"
+	RepRest     = "rest"
+	RepCooldown = "cooldown"
+)
+
+// RepBoundary delimits one rep by track point indices. Consecutive reps share
+// their boundary point.
+type RepBoundary struct {
+	StartIndex int    `json:"startIndex"`
+	EndIndex   int    `json:"endIndex"`
+	Kind       string `json:"kind"`
+}
+
+type Rep struct {
+	RepBoundary
+	StartSec     float64  `json:"startSec"`
+	DurationSec  float64  `json:"durationSec"`
+	DistanceM    float64  `json:"distanceM"`
+	PaceMinPerKM float64  `json:"paceMinPerKm"`
+	AvgPowerW    *float64 `json:"avgPowerW,omitempty"`
+	AvgHR        *float64 `json:"avgHr,omitempty"`
+}
+
+// Intervals is the workout structure of an activity. Signal names the data
+// the reps were detected from ("power", "pace" or "hr"); it is empty when no
+// structure was found or the reps were set by hand.
+type Intervals struct {
+	Signal  string `json:"signal,omitempty"`
+	Summary string `json:"summary"`
+	Reps    []Rep  `json:"reps"`
+}
+
+var ErrInvalidReps = errors.New("reps must be ordered, non-overlapping ranges of the activity's points with kind warmup, work, rest or cooldown")
+
+const (
+	intervalSmoothSec = 20   // moving-average window applied to the signal
+	minRepSec         = 15   // shorter blocks are merged into their neighbours
+	minSpeedRatio     = 1.4  // work/rest separation needed for pace and power
+	minHRGap          = 12.0 // work/rest separation needed for heart rate, bpm
+	minWorkReps       = 2
+)
+
+// DetectIntervals splits an activity into warm-up, work, rest and cool-down
+// blocks. Power is used when most points carry it, then pace, then heart
+// rate. Activities without a clear two-level structure return no reps.
+func DetectIntervals(points []gpx.Point) Intervals {
+	segs := newIntervalSegments(points)
+	if len(segs) < 4 {
+		return Intervals{Reps: []Rep{}}
+	}
+
+	signal, values := pickSignal(points, segs)
+	if signal == "" {
+		return Intervals{Reps: []Rep{}}
+	}
+	smoothed := smoothByTime(segs, values, intervalSmoothSec)
+
+	lo, hi, threshold := splitTwoLevels(segs, smoothed)
+	separated := hi >= lo*minSpeedRatio
+	if signal == "hr" {
+		separated = hi-lo >= minHRGap
+	}
+	if !separated {
+		return Intervals{Reps: []Rep{}}
+	}
+
+	runs := make([]levelRun, 0)
+	for i, v := range smoothed {
+		high := v >= threshold
+		if n := len(runs); n > 0 && runs[n-1].high == high {
+			runs[n-1].to = i
+			runs[n-1].dur += segs[i].dt
+			continue
+		}
+		runs = append(runs, levelRun{high: high, from: i, to: i, dur: segs[i].dt})
+	}
+	runs = mergeShortRuns(runs, minRepSec)
+
+	boundaries := make([]RepBoundary, 0, len(runs))
+	work := 0
+	for i, r := range runs {
+		kind := RepRest
+		switch {
+		case r.high:
+			kind = RepWork
+			work++
+		case i == 0:
+			kind = RepWarmup
+		case i == len(runs)-1:
+			kind = RepCooldown
+		}
+		boundaries = append(boundaries, RepBoundary{
+			StartIndex: segs[r.from].from,
+			EndIndex:   segs[r.to].to,
+			Kind:       kind,
+		})
+	}
+	if work < minWorkReps {
+		return Intervals{Reps: []Rep{}}
+	}
+
+	reps, _ := BuildReps(points, boundaries)
+	return Intervals{Signal: signal, Summary: SummarizeReps(reps), Reps: reps}
+}
+
+// ShiftReps moves reps given against one track onto a track that starts
+// offset points later in the same recording and has n points, dropping reps
+// that do not fit in it entirely.
+func ShiftReps(boundaries []RepBoundary, offset, n int) []RepBoundary {
+	shifted := make([]RepBoundary, 0, len(boundaries))
+	for _, b := range boundaries {
+		b.StartIndex -= offset
+		b.EndIndex -= offset
+		if b.StartIndex < 0 || b.EndIndex >= n {
+			continue
+		}
+		shifted = append(shifted, b)
+	}
+	return shifted
+}
+
+// BuildReps computes the stats of reps given by hand, for example after a user
+// corrects detected intervals.
+func BuildReps(points []gpx.Point, boundaries []RepBoundary) ([]Rep, error) {
+	reps := make([]Rep, 0, len(boundaries))
+	prevEnd := 0
+	for i, b := range boundaries {
+		switch b.Kind {
+		case RepWarmup, RepWork, RepRest, RepCooldown:
+		default:
+			return nil, ErrInvalidReps
+		}
+		if b.StartIndex < 0 || b.EndIndex >= len(points) || b.StartIndex >= b.EndIndex ||
+			(i > 0 && b.StartIndex < prevEnd) {
+			return nil, ErrInvalidReps
+		}
+		prevEnd = b.EndIndex
+		reps = append(reps, repStats(points, b))
+	}
+	return reps, nil
+}
+
+func repStats(points []gpx.Point, b RepBoundary) Rep {
+	rep := Rep{RepBoundary: b}
+	start, end := points[b.StartIndex], points[b.EndIndex]
+	if first := firstTimedPoint(points); first != nil && start.Time != nil {
+		rep.StartSec = start.Time.Sub(*first.Time).Seconds()
+	}
+	if start.Time != nil && end.Time != nil {
+		rep.DurationSec = math.Max(0, end.Time.Sub(*start.Time).Seconds())
+	}
+
+	var powerSum, hrSum float64
+	var powerN, hrN int
+	for i := b.StartIndex + 1; i <= b.EndIndex; i++ {
+		if !points[i].Gap {
+			rep.DistanceM += haversineMeters(points[i-1].Lat, points[i-1].Lon, points[i].Lat, points[i].Lon)
+		}
+		if p := points[i].Power; p != nil {
+			powerSum += float64(*p)
+			powerN++
+		}
+		if hr := points[i].HR; hr != nil {
+			hrSum += float64(*hr)
+			hrN++
+		}
+	}
+	rep.DistanceM = round(rep.DistanceM)
+	rep.PaceMinPerKM = round(paceMinPerKM(rep.DistanceM, rep.DurationSec))
+	if powerN > 0 {
+		v := round(powerSum / float64(powerN))
+		rep.AvgPowerW = &v
+	}
+	if hrN > 0 {
+		v := round(hrSum / float64(hrN))
+		rep.AvgHR = &v
+	}
+	return rep
+}
+
+func firstTimedPoint(points []gpx.Point) *gpx.Point {
+	for i := range points {
+		if points[i].Time != nil {
+			return &points[i]
+		}
+	}
+	return nil
+}
+
+// SummarizeReps describes the work reps, e.g. "6×400 m / 90 s" for equal
+// distances with equal recoveries, or "5×3 min" for timed efforts. Reps with
+// power data are described by duration first, as power sessions are
+// prescribed in time.
+func SummarizeReps(reps []Rep) string {
+	var work, rest []Rep
+	for i, r := range reps {
+		switch r.Kind {
+		case RepWork:
+			work = append(work, r)
+		case RepRest:
+			// Only recoveries between two work reps describe the session.
+			if i > 0 && i < len(reps)-1 {
+				rest = append(rest, r)
+			}
+		}
+	}
+	if len(work) == 0 {
+		return ""
+	}
+
+	distances := make([]float64, len(work))
+	durations := make([]float64, len(work))
+	var total float64
+	timed := true
+	for i, r := range work {
+		distances[i], durations[i] = r.DistanceM, r.DurationSec
+		total += r.DistanceM
+		timed = timed && r.AvgPowerW != nil
+	}
+
+	var b strings.Builder
+	switch {
+	case timed && spread(durations) <= 0.1:
+		fmt.Fprintf(&b, "%d×%s", len(work), formatRepDuration(mean(durations)))
+	case spread(distances) <= 0.1:
+		fmt.Fprintf(&b, "%d×%s", len(work), formatRepDistance(mean(distances)))
+	case spread(durations) <= 0.1:
+		fmt.Fprintf(&b, "%d×%s", len(work), formatRepDuration(mean(durations)))
+	default:
+		fmt.Fprintf(&b, "%d reps, %s of work", len(work), formatRepDistance(total))
+	}
+
+	if len(rest) > 0 {
+		restDurations := make([]float64, len(rest))
+		for i, r := range rest {
+			restDurations[i] = r.DurationSec
+		}
+		if spread(restDurations) <= 0.2 {
+			fmt.Fprintf(&b, " / %s", formatRepDuration(mean(restDurations)))
+		}
+	}
+	return b.String()
+}
+
+// formatRepDistance rounds to the distances coaches prescribe: 50 m steps
+// below 1 km, 0.1 km above.
+func formatRepDistance(m float64) string {
+	if m < 1000 {
+		return fmt.Sprintf("%.0f m", math.Max(50, math.Round(m/50)*50))
+	}
+	km := math.Round(m/100) / 10
+	if km == math.Trunc(km) {
+		return fmt.Sprintf("%.0f km", km)
+	}
+	return fmt.Sprintf("%.1f km", km)
+}
+
+// formatRepDuration rounds to 15 s steps: "90 s" below two minutes, then
+// "3 min" or "2:30 min".
+func formatRepDuration(sec float64) string {
+	sec = math.Max(15, math.Round(sec/15)*15)
+	if sec < 120 {
+		return fmt.Sprintf("%.0f s", sec)
+	}
+	m, s := int(sec)/60, int(sec)%60
+	if s == 0 {
+		return fmt.Sprintf("%d min", m)
+	}
+	return fmt.Sprintf("%d:%02d min", m, s)
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// spread is the largest relative deviation from the mean.
+func spread(values []float64) float64 {
+	m := mean(values)
+	if m <= 0 {
+		return math.Inf(1)
+	}
+	var worst float64
+	for _, v := range values {
+		worst = math.Max(worst, math.Abs(v-m)/m)
+	}
+	return worst
+}
+
+// intervalSegment is the stretch between two consecutive timestamped points.
+type intervalSegment struct {
+	from, to int // point indices
+	dt, dist float64
+	mid      float64 // elapsed time at the segment's midpoint
+}
+
+func newIntervalSegments(points []gpx.Point) []intervalSegment {
+	segs := make([]intervalSegment, 0, len(points))
+	first := firstTimedPoint(points)
+	if first == nil {
+		return segs
+	}
+	prev := -1
+	for i := range points {
+		if points[i].Time == nil {
+			continue
+		}
+		if prev >= 0 && !points[i].Gap {
+			dt := points[i].Time.Sub(*points[prev].Time).Seconds()
+			if dt > 0 {
+				segs = append(segs, intervalSegment{
+					from: prev,
+					to:   i,
+					dt:   dt,
+					dist: haversineMeters(points[prev].Lat, points[prev].Lon, points[i].Lat, points[i].Lon),
+					mid:  points[prev].Time.Sub(*first.Time).Seconds() + dt/2,
+				})
+			}
+		}
+		prev = i
+	}
+	return segs
+}
+
+// pickSignal returns the name and per-segment values of the best available
+// intensity signal, higher meaning harder.
+func pickSignal(points []gpx.Point, segs []intervalSegment) (string, []float64) {
+	var withPower, withHR int
+	for _, s := range segs {
+		if points[s.to].Power != nil {
+			withPower++
+		}
+		if points[s.to].HR != nil {
+			withHR++
+		}
+	}
+	values := make([]float64, len(segs))
+	switch {
+	case withPower*10 >= len(segs)*8:
+		for i, s := range segs {
+			if p := points[s.to].Power; p != nil {
+				values[i] = float64(*p)
+			}
+		}
+		return "power", values
+	case totalDistance(segs) > 0:
+		for i, s := range segs {
+			values[i] = s.dist / s.dt
+		}
+		return "pace", values
+	case withHR*10 >= len(segs)*8:
+		for i, s := range segs {
+			if hr := points[s.to].HR; hr != nil {
+				values[i] = float64(*hr)
+			}
+		}
+		return "hr", values
+	}
+	return "", nil
+}
+
+func totalDistance(segs []intervalSegment) float64 {
+	var total float64
+	for _, s := range segs {
+		total += s.dist
+	}
+	return total
+}
+
+// smoothByTime averages each value with the values whose segment midpoints
+// lie within window/2 seconds, weighted by segment duration.
+func smoothByTime(segs []intervalSegment, values []float64, window float64) []float64 {
+	out := make([]float64, len(values))
+	lo := 0
+	for i := range segs {
+		for segs[lo].mid < segs[i].mid-window/2 {
+			lo++
+		}
+		var sum, weight float64
+		for j := lo; j < len(segs) && segs[j].mid <= segs[i].mid+window/2; j++ {
+			sum += values[j] * segs[j].dt
+			weight += segs[j].dt
+		}
+		out[i] = sum / weight
+	}
+	return out
+}
+
+// splitTwoLevels clusters the values into a low and a high group (1-D
+// two-means weighted by duration) and returns both means and the threshold
+// between them.
+func splitTwoLevels(segs []intervalSegment, values []float64) (lo, hi, threshold float64) {
+	lo, hi = values[0], values[0]
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	for iter := 0; iter < 50; iter++ {
+		threshold = (lo + hi) / 2
+		var loSum, loW, hiSum, hiW float64
+		for i, v := range values {
+			if v >= threshold {
+				hiSum += v * segs[i].dt
+				hiW += segs[i].dt
+			} else {
+				loSum += v * segs[i].dt
+				loW += segs[i].dt
+			}
+		}
+		if loW == 0 || hiW == 0 {
+			break
+		}
+		newLo, newHi := loSum/loW, hiSum/hiW
+		if newLo == lo && newHi == hi {
+			break
+		}
+		lo, hi = newLo, newHi
+	}
+	return lo, hi, (lo + hi) / 2
+}
+
+type levelRun struct {
+	high     bool
+	from, to int // segment indices, inclusive
+	dur      float64
+}
+
+// mergeShortRuns repeatedly absorbs the shortest run below minSec into its
+// neighbours until every run is long enough.
+func mergeShortRuns(runs []levelRun, minSec float64) []levelRun {
+	for len(runs) > 1 {
+		shortest := -1
+		for i, r := range runs {
+			if r.dur < minSec && (shortest < 0 || r.dur < runs[shortest].dur) {
+				shortest = i
+			}
+		}
+		if shortest < 0 {
+			break
+		}
+		runs[shortest].high = !runs[shortest].high
+		merged := runs[:0]
+		for _, r := range runs {
+			if n := len(merged); n > 0 && merged[n-1].high == r.high {
+				merged[n-1].to = r.to
+				merged[n-1].dur += r.dur
+				continue
+			}
+			merged = append(merged, r)
+		}
+		runs = merged
+	}
+	return runs
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// block is a stretch run at a constant speed, sampled every second.
+type block struct {
+	sec   int
+	speed float64 // m/s
+	power int
+}
+
+// workout lays blocks out northwards along a meridian.
+func workout(blocks ...block) []gpx.Point {
+	start := mustTime("2026-02-21T06:30:00Z")
+	const metresPerDegree = 111195.0
+	lat := 30.4
+	points := []gpx.Point{{Lat: lat, Lon: -9.6, Time: &start}}
+	elapsed := 0
+	for _, b := range blocks {
+		for s := 0; s < b.sec; s++ {
+			elapsed++
+			lat += b.speed / metresPerDegree
+			ts := start.Add(time.Duration(elapsed) * time.Second)
+			p := gpx.Point{Lat: lat, Lon: -9.6, Time: &ts}
+			if b.power > 0 {
+				w := b.power
+				p.Power = &w
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func TestDetectIntervals_DistanceReps(t *testing.T) {
+	blocks := []block{{sec: 300, speed: 2.8}}
+	for i := 0; i < 6; i++ {
+		blocks = append(blocks, block{sec: 80, speed: 5})
+		if i < 5 {
+			blocks = append(blocks, block{sec: 90, speed: 1.5})
+		}
+	}
+	blocks = append(blocks, block{sec: 300, speed: 2.5})
+
+	got := DetectIntervals(workout(blocks...))
+	if got.Signal != "pace" {
+		t.Fatalf("expected pace signal, got %q", got.Signal)
+	}
+	if got.Summary != "6×400 m / 90 s" {
+		t.Fatalf("unexpected summary %q", got.Summary)
+	}
+	if got.Reps[0].Kind != RepWarmup || got.Reps[len(got.Reps)-1].Kind != RepCooldown {
+		t.Fatalf("expected warm-up first and cool-down last, got %s … %s", got.Reps[0].Kind, got.Reps[len(got.Reps)-1].Kind)
+	}
+	for _, r := range got.Reps {
+		if r.Kind == RepWork && (r.PaceMinPerKM < 3.1 || r.PaceMinPerKM > 3.6) {
+			t.Fatalf("work rep pace %.2f min/km, expected ~3:20", r.PaceMinPerKM)
+		}
+	}
+}
+
+func TestDetectIntervals_PowerReps(t *testing.T) {
+	var blocks []block
+	for i := 0; i < 5; i++ {
+		blocks = append(blocks, block{sec: 180, speed: 10, power: 320}, block{sec: 120, speed: 8, power: 150})
+	}
+	got := DetectIntervals(workout(blocks...))
+	if got.Signal != "power" {
+		t.Fatalf("expected power signal, got %q", got.Signal)
+	}
+	if got.Summary != "5×3 min / 2 min" {
+		t.Fatalf("unexpected summary %q", got.Summary)
+	}
+	if p := got.Reps[0].AvgPowerW; p == nil || *p < 300 {
+		t.Fatalf("expected ~320 W on the first rep, got %v", p)
+	}
+}
+
+func TestDetectIntervals_SteadyRunHasNoStructure(t *testing.T) {
+	got := DetectIntervals(workout(block{sec: 1800, speed: 3}))
+	if len(got.Reps) != 0 || got.Summary != "" {
+		t.Fatalf("expected no intervals, got %q with %d reps", got.Summary, len(got.Reps))
+	}
+}
+
+func TestBuildReps_Validation(t *testing.T) {
+	points := workout(block{sec: 100, speed: 3})
+	if _, err := BuildReps(points, []RepBoundary{{0, 50, RepWork}, {50, 100, RepRest}}); err != nil {
+		t.Fatalf("adjacent reps must be accepted: %v", err)
+	}
+	for _, bad := range [][]RepBoundary{
+		{{0, 101, RepWork}},
+		{{10, 5, RepWork}},
+		{{0, 50, RepWork}, {40, 60, RepRest}},
+		{{0, 50, "sprint"}},
+	} {
+		if _, err := BuildReps(points, bad); err != ErrInvalidReps {
+			t.Fatalf("%v: expected ErrInvalidReps, got %v", bad, err)
+		}
+	}
+}
+
+func TestShiftReps(t *testing.T) {
+	reps := []RepBoundary{{0, 20, RepWarmup}, {20, 50, RepWork}, {50, 80, RepRest}, {80, 100, RepCooldown}}
+
+	// Cropping 10 points off the start and keeping 75 drops the warm-up and
+	// the cool-down.
+	got := ShiftReps(reps, 10, 75)
+	want := []RepBoundary{{10, 40, RepWork}, {40, 70, RepRest}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	// Removing the crop again moves the reps back.
+	back := ShiftReps(got, -10, 101)
+	if back[0] != reps[1] || back[1] != reps[2] {
+		t.Fatalf("uncrop: got %v", back)
+	}
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// Leg is one sport, or a transition, of a multisport activity. StartIndex and
+// EndIndex bound its points; EndIndex is shared with the next leg so that leg
+// distances add up to the whole track.
+type Leg struct {
+	SportType  string    `json:"sportType"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	StartIndex int       `json:"startIndex"`
+	EndIndex   int       `json:"endIndex"`
+	Metrics    Result    `json:"metrics"`
+}
+
+// SportTotal is the time and distance spent on one sport over all its legs.
+type SportTotal struct {
+	SportType   string  `json:"sportType"`
+	DurationSec int     `json:"durationSec"`
+	DistanceKM  float64 `json:"distanceKm"`
+}
+
+// MultisportTotals are the race totals of a multisport activity.
+type MultisportTotals struct {
+	DurationSec   int          `json:"durationSec"`
+	DistanceKM    float64      `json:"distanceKm"`
+	TransitionSec int          `json:"transitionSec"`
+	Sports        []SportTotal `json:"sports"`
+}
+
+// BuildLegs cuts a timed track at the start of each leg and computes every
+// leg's metrics. A leg's duration runs from its start to the next leg's, so
+// stretches without points, such as a swim recorded without GPS, still count.
+// Points before the first leg are given to it.
+func BuildLegs(points []gpx.Point, splits []gpx.Leg) ([]Leg, error) {
+	if len(splits) < 2 {
+		return nil, errors.New("at least 2 legs are required")
+	}
+	if len(points) < 2 || points[0].Time == nil || points[len(points)-1].Time == nil {
+		return nil, errors.New("legs need a track with timestamps")
+	}
+	first, last := *points[0].Time, *points[len(points)-1].Time
+	for i, s := range splits {
+		if s.Sport == "" {
+			return nil, errors.New("every leg needs a sport")
+		}
+		if i > 0 && !s.Start.After(splits[i-1].Start) {
+			return nil, errors.New("legs must start in time order")
+		}
+		if !s.Start.Before(last) {
+			return nil, errors.New("leg starts after the end of the track")
+		}
+	}
+
+	starts := make([]time.Time, len(splits))
+	idx := make([]int, len(splits)+1)
+	for i, s := range splits {
+		starts[i] = s.Start
+		if i == 0 && first.Before(s.Start) {
+			starts[i] = first
+		}
+		idx[i] = firstPointFrom(points, starts[i], idx[max(i-1, 0)])
+	}
+	idx[len(splits)] = len(points) - 1
+
+	legs := make([]Leg, len(splits))
+	for i, s := range splits {
+		end := last
+		if i+1 < len(splits) {
+			end = starts[i+1]
+		}
+		from, to := idx[i], idx[i+1]
+		if from > to {
+			from = to
+		}
+		r := Compute(points[from : to+1])
+		r.ActivityDate = starts[i].UTC()
+		r.DurationSec = int(end.Sub(starts[i]).Seconds())
+		r.AvgSpeedKMH, r.PaceMinPerKM = 0, 0
+		if r.DurationSec > 0 && r.DistanceKM > 0 {
+			r.AvgSpeedKMH = round(r.DistanceKM / (float64(r.DurationSec) / 3600))
+			r.PaceMinPerKM = round(float64(r.DurationSec) / 60 / r.DistanceKM)
+		}
+		legs[i] = Leg{
+			SportType:  s.Sport,
+			Start:      starts[i].UTC(),
+			End:        end.UTC(),
+			StartIndex: from,
+			EndIndex:   to,
+			Metrics:    r,
+		}
+	}
+	return legs, nil
+}
+
+// firstPointFrom returns the index of the first timed point at or after t,
+// searching from index from.
+func firstPointFrom(points []gpx.Point, t time.Time, from int) int {
+	for i := from; i < len(points); i++ {
+		if points[i].Time != nil && !points[i].Time.Before(t) {
+			return i
+		}
+	}
+	return len(points) - 1
+}
+
+// SumLegs adds up legs into race totals, with time and distance per sport in
+// the order the sports first appear. Transitions count towards the race time
+// but are reported apart.
+func SumLegs(legs []Leg) MultisportTotals {
+	var t MultisportTotals
+	if len(legs) == 0 {
+		return t
+	}
+	t.DurationSec = int(legs[len(legs)-1].End.Sub(legs[0].Start).Seconds())
+	bySport := map[string]int{}
+	var distance float64
+	for _, l := range legs {
+		distance += l.Metrics.DistanceKM
+		if l.SportType == gpx.SportTransition {
+			t.TransitionSec += l.Metrics.DurationSec
+			continue
+		}
+		i, ok := bySport[l.SportType]
+		if !ok {
+			i = len(t.Sports)
+			bySport[l.SportType] = i
+			t.Sports = append(t.Sports, SportTotal{SportType: l.SportType})
+		}
+		t.Sports[i].DurationSec += l.Metrics.DurationSec
+		t.Sports[i].DistanceKM = round(t.Sports[i].DistanceKM + l.Metrics.DistanceKM)
+	}
+	t.DistanceKM = round(distance)
+	return t
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+func TestBuildLegs_Triathlon(t *testing.T) {
+	points := workout(block{sec: 600, speed: 1}, block{sec: 120, speed: 1}, block{sec: 1200, speed: 10}, block{sec: 600, speed: 3.5})
+	start := *points[0].Time
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	legs, err := BuildLegs(points, []gpx.Leg{
+		{Sport: gpx.SportSwimming, Start: at(0)},
+		{Sport: gpx.SportTransition, Start: at(600)},
+		{Sport: gpx.SportCycling, Start: at(720)},
+		{Sport: gpx.SportRunning, Start: at(1920)},
+	})
+	if err != nil {
+		t.Fatalf("BuildLegs: %v", err)
+	}
+	wantDur := []int{600, 120, 1200, 600}
+	wantKM := []float64{0.6, 0.12, 12, 2.1}
+	for i, l := range legs {
+		if l.Metrics.DurationSec != wantDur[i] {
+			t.Errorf("leg %d duration = %d, want %d", i, l.Metrics.DurationSec, wantDur[i])
+		}
+		if math.Abs(l.Metrics.DistanceKM-wantKM[i]) > 0.02 {
+			t.Errorf("leg %d distance = %.2f, want %.2f", i, l.Metrics.DistanceKM, wantKM[i])
+		}
+	}
+	if legs[0].EndIndex != legs[1].StartIndex {
+		t.Errorf("legs should share their boundary point: %d vs %d", legs[0].EndIndex, legs[1].StartIndex)
+	}
+	if got := legs[2].Metrics.AvgSpeedKMH; math.Abs(got-36) > 0.5 {
+		t.Errorf("bike speed = %.2f, want 36", got)
+	}
+
+	totals := SumLegs(legs)
+	if totals.DurationSec != 2520 || totals.TransitionSec != 120 {
+		t.Errorf("totals = %+v", totals)
+	}
+	if len(totals.Sports) != 3 || totals.Sports[1].SportType != gpx.SportCycling {
+		t.Errorf("sports = %+v", totals.Sports)
+	}
+	whole := Compute(points)
+	if math.Abs(totals.DistanceKM-whole.DistanceKM) > 0.011 {
+		t.Errorf("leg distances add up to %.2f, track is %.2f", totals.DistanceKM, whole.DistanceKM)
+	}
+}
+
+func TestBuildLegs_GapWithoutPoints(t *testing.T) {
+	points := workout(block{sec: 300, speed: 3})
+	start := *points[0].Time
+	late := start.Add(time.Hour)
+	points = append(points, gpx.Point{Lat: points[len(points)-1].Lat, Lon: -9.6, Time: &late})
+	legs, err := BuildLegs(points, []gpx.Leg{
+		{Sport: gpx.SportRunning, Start: start.Add(-10 * time.Minute)},
+		{Sport: gpx.SportSwimming, Start: start.Add(20 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("BuildLegs: %v", err)
+	}
+	// The first leg starts before any point was recorded.
+	if !legs[0].Start.Equal(start.Add(-10*time.Minute)) || legs[0].Metrics.DurationSec != 1800 {
+		t.Errorf("first leg = %v, %ds", legs[0].Start, legs[0].Metrics.DurationSec)
+	}
+	if legs[1].Metrics.DurationSec != 2400 || legs[1].Metrics.DistanceKM != 0 {
+		t.Errorf("swim leg = %+v", legs[1].Metrics)
+	}
+}
+
+func TestBuildLegs_Invalid(t *testing.T) {
+	points := workout(block{sec: 60, speed: 3})
+	start := *points[0].Time
+	cases := map[string][]gpx.Leg{
+		"single leg":   {{Sport: gpx.SportRunning, Start: start}},
+		"out of order": {{Sport: gpx.SportRunning, Start: start.Add(30 * time.Second)}, {Sport: gpx.SportCycling, Start: start}},
+		"after end":    {{Sport: gpx.SportRunning, Start: start}, {Sport: gpx.SportCycling, Start: start.Add(time.Hour)}},
+		"no sport":     {{Sport: gpx.SportRunning, Start: start}, {Start: start.Add(30 * time.Second)}},
+	}
+	for name, splits := range cases {
+		if _, err := BuildLegs(points, splits); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
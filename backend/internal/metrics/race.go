@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"math"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// RaceDistance is a distance race times are predicted for.
+type RaceDistance struct {
+	Name      string
+	DistanceM float64
+}
+
+var RaceDistances = []RaceDistance{
+	{"5K", 5000},
+	{"10K", 10000},
+	{"Half marathon", 21097.5},
+	{"Marathon", 42195},
+}
+
+// effortDistances are the distances best efforts are searched for. Daniels'
+// formula is fitted to races from 1500 m to the marathon.
+var effortDistances = []float64{1500, 1609.34, 3000, 5000, 10000, 15000, 21097.5, 42195}
+
+const (
+	// maxRunSpeed drops GPS jumps faster than any runner from best efforts.
+	maxRunSpeed = 12.0 // m/s
+	// riegelExponent is Riegel's fatigue factor.
+	riegelExponent = 1.06
+	// MinPredictionRuns and MinReferenceDistanceM define sparse data: fewer
+	// runs in the window, or no effort at least this long.
+	MinPredictionRuns     = 3
+	MinReferenceDistanceM = 3000
+)
+
+// Effort is the fastest stretch of an activity covering a distance.
+type Effort struct {
+	DistanceM   float64 `json:"distanceM"`
+	DurationSec float64 `json:"durationSec"`
+	StartIndex  int     `json:"startIndex"`
+	EndIndex    int     `json:"endIndex"`
+}
+
+// Prediction is a predicted finish time by both models.
+type Prediction struct {
+	Name       string  `json:"name"`
+	DistanceM  float64 `json:"distanceM"`
+	RiegelSec  int     `json:"riegelSec"`
+	DanielsSec int     `json:"danielsSec"`
+}
+
+// BestEfforts returns the fastest time over each standard distance the track
+// covers. Times are pro-rated when the fastest window overshoots the distance
+// between samples.
+func BestEfforts(points []gpx.Point) []Effort {
+	var idx []int
+	var cum, secs []float64
+	for i, p := range points {
+		if p.Time == nil {
+			continue
+		}
+		if len(idx) == 0 {
+			idx, cum, secs = append(idx, i), append(cum, 0), append(secs, 0)
+			continue
+		}
+		prev := points[idx[len(idx)-1]]
+		// A recording gap joins its two sides without time or distance.
+		var d, dt float64
+		if !p.Gap {
+			dt = p.Time.Sub(*prev.Time).Seconds()
+			if dt <= 0 {
+				continue
+			}
+			d = haversineMeters(prev.Lat, prev.Lon, p.Lat, p.Lon)
+			if d/dt > maxRunSpeed {
+				d = 0
+			}
+		}
+		idx = append(idx, i)
+		cum = append(cum, cum[len(cum)-1]+d)
+		secs = append(secs, secs[len(secs)-1]+dt)
+	}
+
+	var efforts []Effort
+	for _, target := range effortDistances {
+		if len(cum) == 0 || cum[len(cum)-1] < target {
+			break
+		}
+		best := Effort{DurationSec: math.Inf(1)}
+		start := 0
+		for end := 1; end < len(cum); end++ {
+			if cum[end] < target {
+				continue
+			}
+			for start+1 < end && cum[end]-cum[start+1] >= target {
+				start++
+			}
+			d := cum[end] - cum[start]
+			t := (secs[end] - secs[start]) * target / d
+			if t < best.DurationSec {
+				best = Effort{DistanceM: target, DurationSec: t, StartIndex: idx[start], EndIndex: idx[end]}
+			}
+		}
+		best.DurationSec = math.Round(best.DurationSec)
+		efforts = append(efforts, best)
+	}
+	return efforts
+}
+
+// VDOT is Daniels' effective VO2max for running distanceM in durationSec.
+func VDOT(distanceM, durationSec float64) float64 {
+	if distanceM <= 0 || durationSec <= 0 {
+		return 0
+	}
+	minutes := durationSec / 60
+	v := distanceM / minutes
+	vo2 := -4.60 + 0.182258*v + 0.000104*v*v
+	pct := 0.8 + 0.1894393*math.Exp(-0.012778*minutes) + 0.2989558*math.Exp(-0.1932605*minutes)
+	return vo2 / pct
+}
+
+// PredictDaniels returns the time in seconds at which running distanceM
+// scores vdot.
+func PredictDaniels(vdot, distanceM float64) float64 {
+	lo, hi := 60.0, 24*3600.0
+	for i := 0; i < 60; i++ {
+		mid := (lo + hi) / 2
+		if VDOT(distanceM, mid) > vdot {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// PredictRiegel scales a known time to another distance with Riegel's
+// formula T2 = T1 × (D2/D1)^1.06.
+func PredictRiegel(distanceM, durationSec, targetM float64) float64 {
+	return durationSec * math.Pow(targetM/distanceM, riegelExponent)
+}
+
+// BestVDOT returns the effort scoring the highest VDOT, which reflects the
+// hardest effort relative to its distance. ok is false without efforts.
+func BestVDOT(efforts []Effort) (best Effort, vdot float64, ok bool) {
+	for _, e := range efforts {
+		if v := VDOT(e.DistanceM, e.DurationSec); v > vdot {
+			best, vdot, ok = e, v, true
+		}
+	}
+	return best, vdot, ok
+}
+
+// PredictRaces predicts each of RaceDistances from a reference effort.
+func PredictRaces(ref Effort) []Prediction {
+	vdot := VDOT(ref.DistanceM, ref.DurationSec)
+	out := make([]Prediction, 0, len(RaceDistances))
+	for _, r := range RaceDistances {
+		out = append(out, Prediction{
+			Name:       r.Name,
+			DistanceM:  r.DistanceM,
+			RiegelSec:  int(math.Round(PredictRiegel(ref.DistanceM, ref.DurationSec, r.DistanceM))),
+			DanielsSec: int(math.Round(PredictDaniels(vdot, r.DistanceM))),
+		})
+	}
+	return out
+}
+
+// SparseReason explains why predictions are unreliable, or returns "" when
+// there is enough data: at least MinPredictionRuns runs and an effort of
+// MinReferenceDistanceM or more among efforts.
+func SparseReason(runs int, efforts []Effort) string {
+	if runs < MinPredictionRuns {
+		return "fewer than 3 runs in the period"
+	}
+	longest := 0.0
+	for _, e := range efforts {
+		longest = math.Max(longest, e.DistanceM)
+	}
+	if longest < MinReferenceDistanceM {
+		return "no run of 3 km or more in the period"
+	}
+	return ""
+}
+
+// IsRunning reports whether a sport type is a kind of running.
+func IsRunning(sportType string) bool {
+	return sportFamily(sportType) == "running"
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestVDOT_MatchesDanielsTables(t *testing.T) {
+	// Daniels' tables list 19:57 for 5K at VDOT 50.
+	if v := VDOT(5000, 19*60+57); math.Abs(v-50) > 0.2 {
+		t.Fatalf("expected VDOT ~50, got %.2f", v)
+	}
+	cases := []struct {
+		distance float64
+		want     float64 // seconds at VDOT 50
+	}{
+		{10000, 41*60 + 21},
+		{21097.5, 1*3600 + 31*60 + 35},
+		{42195, 3*3600 + 10*60 + 49},
+	}
+	for _, c := range cases {
+		if got := PredictDaniels(50, c.distance); math.Abs(got-c.want) > 30 {
+			t.Errorf("PredictDaniels(50, %.0f) = %.0f s, want ~%.0f s", c.distance, got, c.want)
+		}
+	}
+}
+
+func TestPredictRiegel(t *testing.T) {
+	if got := PredictRiegel(5000, 1200, 10000); math.Abs(got-2501.6) > 0.5 {
+		t.Fatalf("expected 2501.6 s, got %.1f", got)
+	}
+}
+
+func TestBestEfforts_FindsFastestWindow(t *testing.T) {
+	// 2 km easy, 5 km hard, 2 km easy.
+	points := workout(block{sec: 600, speed: 3.33}, block{sec: 1250, speed: 4}, block{sec: 600, speed: 3.33})
+
+	efforts := BestEfforts(points)
+
+	var five *Effort
+	for i := range efforts {
+		if efforts[i].DistanceM == 5000 {
+			five = &efforts[i]
+		}
+	}
+	if five == nil {
+		t.Fatalf("expected a 5K effort, got %+v", efforts)
+	}
+	if math.Abs(five.DurationSec-1250) > 2 {
+		t.Fatalf("expected the 5K to take ~1250 s, got %.0f", five.DurationSec)
+	}
+	if last := efforts[len(efforts)-1]; last.DistanceM != 5000 {
+		t.Fatalf("expected no effort longer than the 9 km run allows, got %.0f m", last.DistanceM)
+	}
+
+	best, vdot, ok := BestVDOT(efforts)
+	if !ok || best.DistanceM < 1500 || vdot < 45 {
+		t.Fatalf("expected a VDOT from the hard block, got %.1f from %+v", vdot, best)
+	}
+}
+
+func TestSparseReason(t *testing.T) {
+	long := []Effort{{DistanceM: 1500}, {DistanceM: 5000}}
+	if r := SparseReason(5, long); r != "" {
+		t.Fatalf("expected enough data, got %q", r)
+	}
+	if r := SparseReason(2, long); r == "" {
+		t.Fatal("expected too few runs to be sparse")
+	}
+	if r := SparseReason(5, long[:1]); r == "" {
+		t.Fatal("expected only short efforts to be sparse")
+	}
+}
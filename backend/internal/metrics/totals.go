@@ -0,0 +1,35 @@
+package metrics
+
+import "time"
+
+// Totals are the figures of an activity entered by hand, without a track.
+type Totals struct {
+	Start       time.Time
+	DurationSec int
+	DistanceKM  float64
+	ElevGainM   float64
+	AvgHR       float64
+	MaxHR       int
+	AvgPowerW   float64
+}
+
+// FromTotals builds the Result of an activity without a track, deriving
+// speed and pace from distance and duration the same way Compute does.
+func FromTotals(t Totals) Result {
+	avgSpeed, pace := 0.0, 0.0
+	if t.DurationSec > 0 && t.DistanceKM > 0 {
+		avgSpeed = t.DistanceKM / (float64(t.DurationSec) / 3600.0)
+		pace = (float64(t.DurationSec) / 60.0) / t.DistanceKM
+	}
+	return Result{
+		DistanceKM:   round(t.DistanceKM),
+		DurationSec:  t.DurationSec,
+		AvgSpeedKMH:  round(avgSpeed),
+		PaceMinPerKM: round(pace),
+		ElevGainM:    round(t.ElevGainM),
+		AvgHR:        round(t.AvgHR),
+		MaxHR:        t.MaxHR,
+		ActivityDate: t.Start.UTC(),
+		AvgPowerW:    round(t.AvgPowerW),
+	}
+}
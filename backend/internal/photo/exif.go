@@ -0,0 +1,243 @@
+// Package photo reads the EXIF time and position of activity photos, places
+// them on a track, strips their location for public copies and renders
+// thumbnails, all in pure Go.
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrNoEXIF is returned for images without EXIF metadata.
+var ErrNoEXIF = errors.New("no EXIF metadata")
+
+// EXIF tags read by ReadEXIF.
+const (
+	tagOrientation    = 0x0112
+	tagDateTime       = 0x0132
+	tagExifIFD        = 0x8769
+	tagGPSIFD         = 0x8825
+	tagDateTimeOrig   = 0x9003
+	tagOffsetTimeOrig = 0x9011
+
+	tagGPSLatitudeRef  = 1
+	tagGPSLatitude     = 2
+	tagGPSLongitudeRef = 3
+	tagGPSLongitude    = 4
+	tagGPSTimeStamp    = 7
+	tagGPSDateStamp    = 29
+)
+
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// Meta is what a photo's EXIF says about when and where it was taken.
+type Meta struct {
+	// Time is when the photo was taken. Cameras often record local time
+	// without a zone; Zoned is false then and Time holds the local clock
+	// reading as if it were UTC.
+	Time  *time.Time
+	Zoned bool
+	// Lat and Lon are set when the photo is geotagged.
+	Lat, Lon *float64
+	// Orientation is the EXIF orientation, 1 (upright) to 8.
+	Orientation int
+}
+
+// tiff reads the TIFF structure that holds EXIF data.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value []byte // the value bytes, inline or at their offset
+}
+
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// ifd reads the entries of the IFD at offset.
+func (t tiff) ifd(offset uint32) map[uint16]ifdEntry {
+	entries := map[uint16]ifdEntry{}
+	if int(offset)+2 > len(t.data) {
+		return entries
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	pos := int(offset) + 2
+	for i := 0; i < n && pos+12 <= len(t.data); i, pos = i+1, pos+12 {
+		e := ifdEntry{typ: t.order.Uint16(t.data[pos+2:]), count: t.order.Uint32(t.data[pos+4:])}
+		size := typeSizes[e.typ] * int(e.count)
+		if size == 0 || size > len(t.data) {
+			continue
+		}
+		if size <= 4 {
+			e.value = t.data[pos+8 : pos+8+size]
+		} else {
+			off := int(t.order.Uint32(t.data[pos+8:]))
+			if off+size > len(t.data) {
+				continue
+			}
+			e.value = t.data[off : off+size]
+		}
+		entries[t.order.Uint16(t.data[pos:])] = e
+	}
+	return entries
+}
+
+func (t tiff) uint(e ifdEntry) (uint32, bool) {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(t.order.Uint16(e.value)), true
+	case e.typ == 4 && len(e.value) >= 4:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+func (t tiff) rationals(e ifdEntry) []float64 {
+	if e.typ != 5 {
+		return nil
+	}
+	out := make([]float64, 0, e.count)
+	for i := 0; i+8 <= len(e.value); i += 8 {
+		num, den := t.order.Uint32(e.value[i:]), t.order.Uint32(e.value[i+4:])
+		if den == 0 {
+			return nil
+		}
+		out = append(out, float64(num)/float64(den))
+	}
+	return out
+}
+
+func ascii(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// exifSegment returns the TIFF data of a JPEG's EXIF APP1 segment.
+func exifSegment(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrNoEXIF
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return nil, ErrNoEXIF
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // image data starts
+			return nil, ErrNoEXIF
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + size
+		if size < 2 || end > len(data) {
+			return nil, ErrNoEXIF
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[pos+4:end], []byte("Exif\x00\x00")) {
+			return data[pos+10 : end], nil
+		}
+		pos = end
+	}
+	return nil, ErrNoEXIF
+}
+
+// ReadEXIF reads the time, position and orientation of a JPEG photo.
+func ReadEXIF(data []byte) (Meta, error) {
+	raw, err := exifSegment(data)
+	if err != nil {
+		return Meta{}, err
+	}
+	if len(raw) < 8 {
+		return Meta{}, ErrNoEXIF
+	}
+	t := tiff{data: raw}
+	switch string(raw[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return Meta{}, ErrNoEXIF
+	}
+	ifd0 := t.ifd(t.order.Uint32(raw[4:]))
+
+	m := Meta{Orientation: 1}
+	if o, ok := t.uint(ifd0[tagOrientation]); ok && o >= 1 && o <= 8 {
+		m.Orientation = int(o)
+	}
+
+	var exif, gps map[uint16]ifdEntry
+	if off, ok := t.uint(ifd0[tagExifIFD]); ok {
+		exif = t.ifd(off)
+	}
+	if off, ok := t.uint(ifd0[tagGPSIFD]); ok {
+		gps = t.ifd(off)
+	}
+
+	// The original time with its offset, then the GPS time (always UTC),
+	// then the original or file time on its own.
+	local := ascii(exif[tagDateTimeOrig])
+	if local == "" {
+		local = ascii(ifd0[tagDateTime])
+	}
+	if offset := ascii(exif[tagOffsetTimeOrig]); local != "" && offset != "" {
+		if ts, err := time.Parse(exifDateLayout+"-07:00", local+offset); err == nil {
+			ts = ts.UTC()
+			m.Time, m.Zoned = &ts, true
+		}
+	}
+	if m.Time == nil {
+		if ts, ok := gpsTime(t, gps); ok {
+			m.Time, m.Zoned = &ts, true
+		}
+	}
+	if m.Time == nil && local != "" {
+		if ts, err := time.Parse(exifDateLayout, local); err == nil {
+			m.Time = &ts
+		}
+	}
+
+	lat, okLat := gpsCoord(t, gps[tagGPSLatitude], ascii(gps[tagGPSLatitudeRef]), "S")
+	lon, okLon := gpsCoord(t, gps[tagGPSLongitude], ascii(gps[tagGPSLongitudeRef]), "W")
+	if okLat && okLon && (lat != 0 || lon != 0) {
+		m.Lat, m.Lon = &lat, &lon
+	}
+	return m, nil
+}
+
+// gpsCoord reads degrees, minutes and seconds, negated for the south or west
+// reference.
+func gpsCoord(t tiff, e ifdEntry, ref, negative string) (float64, bool) {
+	dms := t.rationals(e)
+	if len(dms) != 3 {
+		return 0, false
+	}
+	v := dms[0] + dms[1]/60 + dms[2]/3600
+	if strings.EqualFold(ref, negative) {
+		v = -v
+	}
+	limit := 90.0
+	if negative == "W" {
+		limit = 180
+	}
+	return v, !math.IsNaN(v) && math.Abs(v) <= limit
+}
+
+func gpsTime(t tiff, gps map[uint16]ifdEntry) (time.Time, bool) {
+	date, err := time.Parse("2006:01:02", ascii(gps[tagGPSDateStamp]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	hms := t.rationals(gps[tagGPSTimeStamp])
+	if len(hms) != 3 {
+		return time.Time{}, false
+	}
+	secs := hms[0]*3600 + hms[1]*60 + hms[2]
+	return date.Add(time.Duration(secs * float64(time.Second))).UTC(), true
+}
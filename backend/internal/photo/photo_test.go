@@ -0,0 +1,288 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+type exifTag struct {
+	id, typ uint16
+	count   uint32
+	data    []byte
+}
+
+func asciiTag(id uint16, s string) exifTag {
+	return exifTag{id, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func shortTag(id, v uint16) exifTag {
+	return exifTag{id, 3, 1, binary.BigEndian.AppendUint16(nil, v)}
+}
+
+func rationalTag(id uint16, vals ...[2]uint32) exifTag {
+	var b []byte
+	for _, v := range vals {
+		b = binary.BigEndian.AppendUint32(b, v[0])
+		b = binary.BigEndian.AppendUint32(b, v[1])
+	}
+	return exifTag{id, 5, uint32(len(vals)), b}
+}
+
+// buildEXIF lays out a big-endian TIFF with IFD0 and, when given, the Exif
+// and GPS IFDs, and wraps it in an APP1 segment.
+func buildEXIF(ifd0, exif, gps []exifTag) []byte {
+	ifdSize := func(tags []exifTag) int { return 2 + 12*len(tags) + 4 }
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, exifTag{tagExifIFD, 4, 1, nil})
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, exifTag{tagGPSIFD, 4, 1, nil})
+	}
+	exifOff := 8 + ifdSize(ifd0)
+	gpsOff := exifOff
+	if len(exif) > 0 {
+		gpsOff += ifdSize(exif)
+	}
+	dataOff := gpsOff
+	if len(gps) > 0 {
+		dataOff += ifdSize(gps)
+	}
+
+	var ifds, extra []byte
+	writeIFD := func(tags []exifTag) {
+		ifds = binary.BigEndian.AppendUint16(ifds, uint16(len(tags)))
+		for _, t := range tags {
+			switch t.id {
+			case tagExifIFD:
+				t.data = binary.BigEndian.AppendUint32(nil, uint32(exifOff))
+			case tagGPSIFD:
+				t.data = binary.BigEndian.AppendUint32(nil, uint32(gpsOff))
+			}
+			ifds = binary.BigEndian.AppendUint16(ifds, t.id)
+			ifds = binary.BigEndian.AppendUint16(ifds, t.typ)
+			ifds = binary.BigEndian.AppendUint32(ifds, t.count)
+			if len(t.data) <= 4 {
+				ifds = append(ifds, append(t.data, make([]byte, 4-len(t.data))...)...)
+			} else {
+				ifds = binary.BigEndian.AppendUint32(ifds, uint32(dataOff+len(extra)))
+				extra = append(extra, t.data...)
+			}
+		}
+		ifds = append(ifds, 0, 0, 0, 0)
+	}
+	writeIFD(ifd0)
+	if len(exif) > 0 {
+		writeIFD(exif)
+	}
+	if len(gps) > 0 {
+		writeIFD(gps)
+	}
+
+	payload := append([]byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08"), ifds...)
+	payload = append(payload, extra...)
+	seg := []byte{0xFF, 0xE1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// testJPEG encodes a w×h image, red on its left half, with an EXIF segment.
+func testJPEG(t *testing.T, w, h int, app1 []byte) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{0, 0, 255, 255}
+			if x < w/2 {
+				c = color.NRGBA{255, 0, 0, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+// geotagged is the EXIF of a photo taken at 2024-05-04 10:30:00 +02:00 near
+// Annecy, turned a quarter.
+func geotagged() []byte {
+	return buildEXIF(
+		[]exifTag{shortTag(tagOrientation, 6)},
+		[]exifTag{asciiTag(tagDateTimeOrig, "2024:05:04 10:30:00"), asciiTag(tagOffsetTimeOrig, "+02:00")},
+		[]exifTag{
+			asciiTag(tagGPSLatitudeRef, "N"),
+			rationalTag(tagGPSLatitude, [2]uint32{45, 1}, [2]uint32{54, 1}, [2]uint32{0, 1}),
+			asciiTag(tagGPSLongitudeRef, "E"),
+			rationalTag(tagGPSLongitude, [2]uint32{6, 1}, [2]uint32{7, 1}, [2]uint32{30, 1}),
+		},
+	)
+}
+
+func TestReadEXIF(t *testing.T) {
+	m, err := ReadEXIF(testJPEG(t, 8, 8, geotagged()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 5, 4, 8, 30, 0, 0, time.UTC)
+	if m.Time == nil || !m.Time.Equal(want) || !m.Zoned {
+		t.Fatalf("time = %v (zoned %v), want %v", m.Time, m.Zoned, want)
+	}
+	if m.Lat == nil || m.Lon == nil || *m.Lat != 45.9 || *m.Lon != 6.125 {
+		t.Fatalf("position = %v, %v", m.Lat, m.Lon)
+	}
+	if m.Orientation != 6 {
+		t.Fatalf("orientation = %d, want 6", m.Orientation)
+	}
+}
+
+func TestReadEXIF_GPSTimeAndLocalTime(t *testing.T) {
+	gpsTimed := buildEXIF(nil,
+		[]exifTag{asciiTag(tagDateTimeOrig, "2024:05:04 10:30:00")},
+		[]exifTag{
+			asciiTag(tagGPSDateStamp, "2024:05:04"),
+			rationalTag(tagGPSTimeStamp, [2]uint32{8, 1}, [2]uint32{29, 1}, [2]uint32{59, 1}),
+		},
+	)
+	m, err := ReadEXIF(testJPEG(t, 8, 8, gpsTimed))
+	if err != nil || m.Time == nil || !m.Zoned || !m.Time.Equal(time.Date(2024, 5, 4, 8, 29, 59, 0, time.UTC)) {
+		t.Fatalf("GPS time = %v (zoned %v), %v", m.Time, m.Zoned, err)
+	}
+
+	localOnly := buildEXIF(nil, []exifTag{asciiTag(tagDateTimeOrig, "2024:05:04 10:30:00")}, nil)
+	m, err = ReadEXIF(testJPEG(t, 8, 8, localOnly))
+	if err != nil || m.Time == nil || m.Zoned || !m.Time.Equal(time.Date(2024, 5, 4, 10, 30, 0, 0, time.UTC)) {
+		t.Fatalf("local time = %v (zoned %v), %v", m.Time, m.Zoned, err)
+	}
+
+	if _, err := ReadEXIF(testJPEG(t, 8, 8, nil)); err != ErrNoEXIF {
+		t.Fatalf("JPEG without EXIF: %v, want ErrNoEXIF", err)
+	}
+}
+
+func TestStripLocation(t *testing.T) {
+	xmp := append([]byte{0xFF, 0xE1, 0, 0}, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")...)
+	binary.BigEndian.PutUint16(xmp[2:], uint16(len(xmp)-2))
+	data := testJPEG(t, 8, 8, append(geotagged(), xmp...))
+
+	stripped, err := StripLocation(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadEXIF(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Lat != nil || m.Time != nil || m.Orientation != 6 {
+		t.Fatalf("stripped meta = %+v, want only the orientation", m)
+	}
+	if bytes.Contains(stripped, []byte("xmpmeta")) {
+		t.Fatal("XMP was kept")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatalf("stripped JPEG does not decode: %v", err)
+	}
+	if _, err := StripLocation([]byte("GIF89a")); err != ErrUnsupported {
+		t.Fatalf("GIF: %v, want ErrUnsupported", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	// 400×200 stored, turned a quarter clockwise when shown: 200×400 with
+	// red on top.
+	data := testJPEG(t, 400, 200, buildEXIF([]exifTag{shortTag(tagOrientation, 6)}, nil, nil))
+	thumb, size, err := Thumbnail(data, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != (Size{Width: 200, Height: 400}) {
+		t.Fatalf("size = %+v, want upright 200×400", size)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 50 || b.Dy() != 100 {
+		t.Fatalf("thumbnail is %dx%d, want 50x100", b.Dx(), b.Dy())
+	}
+	top, bottom := img.At(25, 10), img.At(25, 90)
+	if r, _, b, _ := top.RGBA(); r < b {
+		t.Fatalf("top of the upright thumbnail is not red: %v", top)
+	}
+	if r, _, b, _ := bottom.RGBA(); b < r {
+		t.Fatalf("bottom of the upright thumbnail is not blue: %v", bottom)
+	}
+	if _, err := ReadEXIF(thumb); err != ErrNoEXIF {
+		t.Fatalf("thumbnail keeps EXIF: %v", err)
+	}
+}
+
+func track(start time.Time, lon float64) []gpx.Point {
+	points := make([]gpx.Point, 61)
+	for i := range points {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		points[i] = gpx.Point{Lat: 45.9 + float64(i)*0.001, Lon: lon, Time: &ts}
+	}
+	return points
+}
+
+func TestPlace(t *testing.T) {
+	start := time.Date(2024, 5, 4, 8, 0, 0, 0, time.UTC)
+	points := track(start, 6.1)
+
+	at := func(ts time.Time, zoned bool) Meta { return Meta{Time: &ts, Zoned: zoned} }
+	lat, lon := 45.9201, 6.1
+
+	cases := []struct {
+		name string
+		meta Meta
+		want Placement
+		ok   bool
+	}{
+		{"zoned time", at(start.Add(20*time.Minute+10*time.Second), true), Placement{20, PlacedByTime}, true},
+		// Clock at 08:45 local, solar zone of 6.1°E is UTC+0.
+		{"local time", at(time.Date(2024, 5, 4, 8, 45, 0, 0, time.UTC), false), Placement{45, PlacedByTime}, true},
+		{"just before the start", at(start.Add(-10*time.Minute), true), Placement{0, PlacedByTime}, true},
+		{"GPS when the time is off the track", Meta{Time: &time.Time{}, Zoned: true, Lat: &lat, Lon: &lon}, Placement{20, PlacedByGPS}, true},
+		{"nothing usable", at(start.Add(5*time.Hour), true), Placement{}, false},
+	}
+	for _, c := range cases {
+		got, ok := Place(points, c.meta)
+		if ok != c.ok || got != c.want {
+			t.Errorf("%s: Place = %+v, %v; want %+v, %v", c.name, got, ok, c.want, c.ok)
+		}
+	}
+
+	// 45°E is solar UTC+3: a camera showing 11:10 took the photo at 08:10 UTC.
+	east := track(start, 45)
+	if got, ok := Place(east, at(time.Date(2024, 5, 4, 11, 10, 0, 0, time.UTC), false)); !ok || got.Index != 10 {
+		t.Fatalf("local time east: %+v, %v; want index 10", got, ok)
+	}
+}
+
+func TestPlaceOn(t *testing.T) {
+	start := time.Date(2024, 5, 4, 8, 0, 0, 0, time.UTC)
+	first := track(start, 6.1)
+	second := track(start.Add(2*time.Hour), 6.1)
+
+	ts := start.Add(2*time.Hour + 15*time.Minute)
+	track, got, ok := PlaceOn([][]gpx.Point{first, second}, Meta{Time: &ts, Zoned: true})
+	if !ok || track != 1 || got != (Placement{15, PlacedByTime}) {
+		t.Fatalf("PlaceOn = %d, %+v, %v; want 1, index 15 by time", track, got, ok)
+	}
+
+	// Between the tracks the nearer end wins.
+	ts = start.Add(70 * time.Minute)
+	if track, got, ok := PlaceOn([][]gpx.Point{first, second}, Meta{Time: &ts, Zoned: true}); !ok || track != 0 || got.Index != 60 {
+		t.Fatalf("between tracks: %d, %+v, %v; want end of track 0", track, got, ok)
+	}
+}
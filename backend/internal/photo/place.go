@@ -0,0 +1,109 @@
+package photo
+
+import (
+	"math"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// How a photo was placed on its track.
+const (
+	PlacedByTime = "time"
+	PlacedByGPS  = "gps"
+)
+
+const (
+	// placeTimeSlack is how far before the start or after the end of a track
+	// a photo may be taken and still be placed at its nearest end.
+	placeTimeSlack = 30 * time.Minute
+	// placeMaxDistanceM is how far from the track a geotagged photo may be.
+	placeMaxDistanceM = 500
+)
+
+// Placement is the track point a photo is shown at.
+type Placement struct {
+	Index int
+	By    string
+}
+
+// Place finds the track point nearest in time to when the photo was taken.
+// A time without a zone is read in the solar time zone of the track's start,
+// which is right to the hour in most places. Photos without a usable time
+// are placed at the point nearest their GPS position. ok is false when the
+// photo belongs nowhere on the track.
+func Place(points []gpx.Point, m Meta) (Placement, bool) {
+	if m.Time != nil && len(points) > 0 {
+		t := *m.Time
+		if !m.Zoned {
+			hours := math.Round(points[0].Lon / 15)
+			t = t.Add(-time.Duration(hours) * time.Hour)
+		}
+		if i, ok := nearestInTime(points, t); ok {
+			return Placement{Index: i, By: PlacedByTime}, true
+		}
+	}
+	if m.Lat != nil && m.Lon != nil {
+		if i, ok := nearestInSpace(points, geo.Coord{Lat: *m.Lat, Lon: *m.Lon}); ok {
+			return Placement{Index: i, By: PlacedByGPS}, true
+		}
+	}
+	return Placement{}, false
+}
+
+func nearestInTime(points []gpx.Point, t time.Time) (int, bool) {
+	best, bestGap := -1, time.Duration(math.MaxInt64)
+	var first, last *time.Time
+	for i, p := range points {
+		if p.Time == nil {
+			continue
+		}
+		if first == nil {
+			first = p.Time
+		}
+		last = p.Time
+		gap := p.Time.Sub(t)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap < bestGap {
+			best, bestGap = i, gap
+		}
+	}
+	if best < 0 || t.Before(first.Add(-placeTimeSlack)) || t.After(last.Add(placeTimeSlack)) {
+		return 0, false
+	}
+	return best, true
+}
+
+func nearestInSpace(points []gpx.Point, c geo.Coord) (int, bool) {
+	best, bestDist := -1, math.Inf(1)
+	for i, p := range points {
+		if d := geo.Distance(c, geo.Coord{Lat: p.Lat, Lon: p.Lon}); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best, best >= 0 && bestDist <= placeMaxDistanceM
+}
+
+// PlaceOn places a photo on the one of several tracks it belongs to, such as
+// the parts of a split activity. The tracks are searched as one, so a photo
+// taken between two of them goes to the nearer point.
+func PlaceOn(tracks [][]gpx.Point, m Meta) (track int, p Placement, ok bool) {
+	var all []gpx.Point
+	for _, t := range tracks {
+		all = append(all, t...)
+	}
+	p, ok = Place(all, m)
+	if !ok {
+		return 0, Placement{}, false
+	}
+	for track = range tracks {
+		if p.Index < len(tracks[track]) {
+			break
+		}
+		p.Index -= len(tracks[track])
+	}
+	return track, p, true
+}
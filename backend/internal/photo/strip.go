@@ -0,0 +1,96 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrUnsupported is returned for images other than JPEG and PNG.
+var ErrUnsupported = errors.New("only JPEG and PNG photos are supported")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// StripLocation returns a copy of a JPEG or PNG photo without the metadata
+// that can hold where it was taken: EXIF (GPS included), XMP and IPTC. A
+// JPEG keeps its orientation so that it still displays upright. Pixels are
+// not re-encoded.
+func StripLocation(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return stripJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNG(data)
+	}
+	return nil, ErrUnsupported
+}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	orientation := 1
+	if m, err := ReadEXIF(data); err == nil {
+		orientation = m.Orientation
+	}
+	errCorrupt := errors.New("corrupt JPEG")
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	if orientation != 1 {
+		out.Write(orientationSegment(orientation))
+	}
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, errCorrupt
+		}
+		marker := data[pos+1]
+		if marker == 0xDA { // image data follows to the end
+			out.Write(data[pos:])
+			return out.Bytes(), nil
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) || end < pos+4 {
+			return nil, errCorrupt
+		}
+		// APP1 holds EXIF and XMP, APP13 IPTC.
+		if marker != 0xE1 && marker != 0xED {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+}
+
+// orientationSegment is an EXIF APP1 segment holding only the orientation.
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // big-endian header, IFD0 at offset 8
+		0, 1, // one entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, // Orientation SHORT
+		0, 0, 0, 0, // no next IFD
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// stripPNG drops the eXIf chunk and text chunks, where XMP lives.
+func stripPNG(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	for pos := len(pngSignature); pos < len(data); {
+		if pos+12 > len(data) {
+			return nil, errors.New("corrupt PNG")
+		}
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		if end > len(data) || end < pos+12 {
+			return nil, errors.New("corrupt PNG")
+		}
+		switch string(data[pos+4 : pos+8]) {
+		case "eXIf", "tEXt", "iTXt", "zTXt":
+		default:
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,136 @@
+package photo
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // register the PNG decoder
+)
+
+// MaxPixels bounds the images Thumbnail decodes, to cap memory use: a
+// decoded photo takes up to 4 bytes per pixel.
+const MaxPixels = 50_000_000
+
+// decodeSlots bounds how many photos are decoded at once across requests.
+var decodeSlots = make(chan struct{}, 2)
+
+// Size is the upright size of a photo in pixels.
+type Size struct {
+	Width, Height int
+}
+
+// Thumbnail renders a photo upright, shrunk to fit in size×size, as a JPEG
+// without metadata. It also returns the upright size of the original.
+func Thumbnail(data []byte, size int) ([]byte, Size, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, Size{}, ErrUnsupported
+	}
+	if format != "jpeg" && format != "png" {
+		return nil, Size{}, ErrUnsupported
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, Size{}, errors.New("photo has too many pixels")
+	}
+	decodeSlots <- struct{}{}
+	defer func() { <-decodeSlots }()
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, Size{}, err
+	}
+	orientation := 1
+	if m, err := ReadEXIF(data); err == nil {
+		orientation = m.Orientation
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientation >= 5 { // rotated a quarter turn
+		w, h = h, w
+	}
+	info := Size{Width: w, Height: h}
+	tw, th := w, h
+	if tw > size || th > size {
+		if tw >= th {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	// Sample in upright coordinates, mapping back to the source through
+	// the orientation.
+	if orientation >= 5 {
+		tw, th = th, tw
+	}
+	small := shrink(src, tw, th)
+	upright := orient(small, orientation)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, upright, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, Size{}, err
+	}
+	return buf.Bytes(), info, nil
+}
+
+// shrink scales src down to w×h by averaging the source pixels under each
+// target pixel.
+func shrink(src image.Image, w, h int) *image.NRGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			c := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)}
+			dst.Set(x, y, c)
+		}
+	}
+	return dst
+}
+
+// orient turns an image stored with an EXIF orientation upright.
+func orient(src *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				dx, dy = x, h-1-y
+			case 5: // mirrored, turned
+				dx, dy = y, x
+			case 6: // needs a quarter turn clockwise
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8: // needs a quarter turn counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.SetNRGBA(dx, dy, src.NRGBAAt(x, y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,393 @@
+// Package resumable keeps partial uploads on the local filesystem so that a
+// client whose connection drops can carry on from the last byte received, as
+// in the tus protocol. Uploads expire when left idle.
+package resumable
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound       = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset does not match the bytes received")
+	ErrTooLarge       = errors.New("chunk goes past the upload length")
+	ErrBusy           = errors.New("another chunk of this upload is being received")
+	ErrLimit          = errors.New("too many unfinished uploads")
+)
+
+const (
+	// DefaultTTL is how long an upload may stay idle before it expires.
+	DefaultTTL = 24 * time.Hour
+	// MaxOpenUploads and MaxReservedBytes cap the uploads a user may have
+	// open at once, and the bytes they announced, counting those not yet
+	// handed to a job.
+	MaxOpenUploads   = 5
+	MaxReservedBytes = 256 << 20
+)
+
+// Upload is the state of a resumable upload. Offset is how many bytes were
+// received so far.
+type Upload struct {
+	ID        string            `json:"id"`
+	UserID    int64             `json:"userId"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"-"`
+	Metadata  map[string]string `json:"metadata"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	// JobID is the import job started once the upload was complete.
+	JobID int64 `json:"jobId,omitempty"`
+}
+
+// Complete reports whether every byte was received.
+func (u Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+type Store struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// NewLocal returns a store keeping uploads in dir, expiring them after ttl
+// without activity.
+func NewLocal(dir string, ttl time.Duration) *Store {
+	return &Store{dir: dir, ttl: ttl, now: time.Now, busy: map[string]bool{}}
+}
+
+// NewFromEnv uses UPLOAD_DIR, defaulting to a directory under the system temp
+// dir, and UPLOAD_TTL, defaulting to DefaultTTL.
+func NewFromEnv() *Store {
+	dir := strings.TrimSpace(os.Getenv("UPLOAD_DIR"))
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "gpx-uploads")
+	}
+	ttl := DefaultTTL
+	if d, err := time.ParseDuration(os.Getenv("UPLOAD_TTL")); err == nil && d > 0 {
+		ttl = d
+	}
+	return NewLocal(dir, ttl)
+}
+
+// paths returns the data and info files of an upload, rejecting ids that
+// were not made by Create.
+func (s *Store) paths(id string) (data, info string, err error) {
+	if len(id) != 32 || strings.Trim(id, "0123456789abcdef") != "" {
+		return "", "", ErrNotFound
+	}
+	base := filepath.Join(s.dir, id)
+	return base + ".bin", base + ".json", nil
+}
+
+// Create starts an upload of length bytes. It returns ErrLimit when the user
+// would have more than MaxOpenUploads open, or more than MaxReservedBytes
+// announced.
+func (s *Store) Create(userID, length int64, metadata map[string]string) (Upload, error) {
+	// Held while the upload is written, so that concurrent creations count
+	// each other.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads, err := s.list()
+	if err != nil {
+		return Upload{}, err
+	}
+	open, reserved := 0, length
+	for _, u := range uploads {
+		if u.UserID == userID && u.JobID == 0 {
+			open++
+			reserved += u.Length
+		}
+	}
+	if open >= MaxOpenUploads || reserved > MaxReservedBytes {
+		return Upload{}, ErrLimit
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Upload{}, err
+	}
+	u := Upload{
+		ID:        hex.EncodeToString(b),
+		UserID:    userID,
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: s.now().Add(s.ttl).UTC(),
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return Upload{}, err
+	}
+	data, _, _ := s.paths(u.ID)
+	if err := os.WriteFile(data, nil, 0o600); err != nil {
+		return Upload{}, err
+	}
+	if err := s.writeInfo(u); err != nil {
+		os.Remove(data)
+		return Upload{}, err
+	}
+	return u, nil
+}
+
+// Get returns an upload, or ErrNotFound once it expired.
+func (s *Store) Get(id string) (Upload, error) {
+	data, info, err := s.paths(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	raw, err := os.ReadFile(info)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Upload{}, ErrNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	var u Upload
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return Upload{}, err
+	}
+	if !u.ExpiresAt.After(s.now()) {
+		s.Remove(id) //nolint:errcheck
+		return Upload{}, ErrNotFound
+	}
+	if u.JobID != 0 {
+		// Its bytes went to the job; see SetJob.
+		u.Offset = u.Length
+		return u, nil
+	}
+	st, err := os.Stat(data)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.Remove(id) //nolint:errcheck
+		return Upload{}, ErrNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	u.Offset = st.Size()
+	return u, nil
+}
+
+// Append writes a chunk that must start at offset. Whatever arrives before
+// the reader fails is kept, so the returned upload tells the client where to
+// resume even when err is not nil.
+func (s *Store) Append(id string, offset int64, r io.Reader) (Upload, error) {
+	if !s.hold(id) {
+		return Upload{}, ErrBusy
+	}
+	defer s.release(id)
+
+	u, err := s.Get(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	if offset != u.Offset {
+		return u, ErrOffsetMismatch
+	}
+	data, _, _ := s.paths(id)
+	f, err := os.OpenFile(data, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return u, err
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(r, u.Length-u.Offset))
+	closeErr := f.Close()
+	u.Offset += n
+	u.ExpiresAt = s.now().Add(s.ttl).UTC()
+	if err := s.writeInfo(u); err != nil {
+		return u, err
+	}
+	switch {
+	case copyErr != nil:
+		return u, copyErr
+	case closeErr != nil:
+		return u, closeErr
+	}
+	if u.Complete() {
+		var extra [1]byte
+		if n, _ := r.Read(extra[:]); n > 0 {
+			return u, ErrTooLarge
+		}
+	}
+	return u, nil
+}
+
+// SetJob records the job an upload was handed to and empties its data file,
+// which the job no longer needs. The upload still reports every byte
+// received, and its job, until it expires.
+func (s *Store) SetJob(id string, jobID int64) (Upload, error) {
+	u, err := s.Get(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	u.JobID = jobID
+	if err := s.writeInfo(u); err != nil {
+		return u, err
+	}
+	data, _, _ := s.paths(id)
+	return u, os.Truncate(data, 0)
+}
+
+// Finish hands a complete upload to queue, which returns the job that now
+// holds its bytes, and records the job as SetJob does. The upload is held as
+// in Append, so it is handed over once; an upload that is incomplete or was
+// handed over already is returned as it is.
+func (s *Store) Finish(id string, queue func(u Upload, data []byte) (jobID int64, err error)) (Upload, error) {
+	if !s.hold(id) {
+		return Upload{}, ErrBusy
+	}
+	defer s.release(id)
+
+	u, err := s.Get(id)
+	if err != nil || !u.Complete() || u.JobID != 0 {
+		return u, err
+	}
+	data, err := s.Data(id)
+	if err != nil {
+		return u, err
+	}
+	jobID, err := queue(u, data)
+	if err != nil {
+		return u, err
+	}
+	return s.SetJob(id, jobID)
+}
+
+// Unfinished returns the complete uploads that were not handed to a job, as
+// when queueing one failed.
+func (s *Store) Unfinished() ([]Upload, error) {
+	uploads, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var unfinished []Upload
+	for _, u := range uploads {
+		if u.Complete() && u.JobID == 0 {
+			unfinished = append(unfinished, u)
+		}
+	}
+	return unfinished, nil
+}
+
+// hold marks an upload as busy, reporting false when it already was.
+func (s *Store) hold(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+func (s *Store) release(id string) {
+	s.mu.Lock()
+	delete(s.busy, id)
+	s.mu.Unlock()
+}
+
+// Data returns the bytes received for an upload.
+func (s *Store) Data(id string) ([]byte, error) {
+	data, _, err := s.paths(id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(data)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Remove deletes an upload. Removing a missing upload is not an error.
+func (s *Store) Remove(id string) error {
+	data, info, err := s.paths(id)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{info, data} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sweep removes expired uploads and returns how many it removed.
+func (s *Store) Sweep() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if _, err := s.Get(id); errors.Is(err, ErrNotFound) {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// list returns the uploads that have not expired, skipping those that cannot
+// be read.
+func (s *Store) list() ([]Upload, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var uploads []Upload
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if u, err := s.Get(id); err == nil {
+			uploads = append(uploads, u)
+		}
+	}
+	return uploads, nil
+}
+
+// writeInfo saves an upload's state atomically.
+func (s *Store) writeInfo(u Upload) error {
+	_, info, err := s.paths(u.ID)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), info)
+}
@@ -0,0 +1,189 @@
+package resumable
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader yields data, then fails like a dropped connection.
+type failingReader struct {
+	r io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestStore_ResumeAfterDroppedChunk(t *testing.T) {
+	s := NewLocal(t.TempDir(), time.Hour)
+	u, err := s.Create(7, 10, map[string]string{"filename": "ride.gpx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err = s.Append(u.ID, 0, failingReader{strings.NewReader("0123")})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || u.Offset != 4 {
+		t.Fatalf("dropped chunk: offset %d, err %v; want 4 bytes kept", u.Offset, err)
+	}
+	if _, err := s.Append(u.ID, 0, strings.NewReader("0123456789")); !errors.Is(err, ErrOffsetMismatch) {
+		t.Fatalf("append at a stale offset: %v, want ErrOffsetMismatch", err)
+	}
+	u, err = s.Append(u.ID, 4, strings.NewReader("456789"))
+	if err != nil || !u.Complete() {
+		t.Fatalf("resumed chunk: %+v, %v", u, err)
+	}
+
+	got, err := s.Get(u.ID)
+	if err != nil || got.Offset != 10 || got.UserID != 7 || got.Metadata["filename"] != "ride.gpx" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	data, err := s.Data(u.ID)
+	if err != nil || string(data) != "0123456789" {
+		t.Fatalf("Data = %q, %v", data, err)
+	}
+}
+
+func TestStore_SetJobDropsData(t *testing.T) {
+	s := NewLocal(t.TempDir(), time.Hour)
+	u, _ := s.Create(1, 3, nil)
+	if _, err := s.Append(u.ID, 0, strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetJob(u.ID, 42); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(u.ID)
+	if err != nil || !got.Complete() || got.JobID != 42 {
+		t.Fatalf("Get after SetJob = %+v, %v", got, err)
+	}
+	if data, err := s.Data(u.ID); err != nil || len(data) != 0 {
+		t.Fatalf("Data after SetJob = %q, %v; want it dropped", data, err)
+	}
+	if _, err := s.Append(u.ID, 3, strings.NewReader("d")); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("append past a handed-off upload: %v, want ErrTooLarge", err)
+	}
+}
+
+func TestStore_RejectsBytesPastLength(t *testing.T) {
+	s := NewLocal(t.TempDir(), time.Hour)
+	u, _ := s.Create(1, 3, nil)
+	u, err := s.Append(u.ID, 0, strings.NewReader("abcd"))
+	if !errors.Is(err, ErrTooLarge) || u.Offset != 3 {
+		t.Fatalf("offset %d, err %v; want 3 and ErrTooLarge", u.Offset, err)
+	}
+}
+
+func TestStore_Expiry(t *testing.T) {
+	s := NewLocal(t.TempDir(), time.Hour)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	old, _ := s.Create(1, 5, nil)
+	now = now.Add(50 * time.Minute)
+	fresh, _ := s.Create(1, 5, nil)
+	// A chunk pushes the expiry back.
+	if _, err := s.Append(old.ID, 0, strings.NewReader("ab")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(55 * time.Minute)
+	if _, err := s.Get(old.ID); err != nil {
+		t.Fatalf("upload resumed 55 minutes ago expired: %v", err)
+	}
+	stale, _ := s.Create(1, 5, nil)
+	now = now.Add(61 * time.Minute)
+
+	if n, err := s.Sweep(); err != nil || n != 3 {
+		t.Fatalf("Sweep = %d, %v; want 3", n, err)
+	}
+	for _, id := range []string{old.ID, fresh.ID, stale.ID} {
+		if _, err := s.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get(%s) after expiry = %v", id, err)
+		}
+	}
+}
+
+func TestStore_RejectsForeignIDs(t *testing.T) {
+	s := NewLocal(t.TempDir(), time.Hour)
+	for _, id := range []string{"", "../etc/passwd", "0123456789abcdef0123456789ABCDEF"} {
+		if _, err := s.Get(id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func TestStore_CreateLimits(t *testing.T) {
+	s := NewLocal(t.TempDir(), time.Hour)
+	if _, err := s.Create(1, MaxReservedBytes+1, nil); !errors.Is(err, ErrLimit) {
+		t.Fatalf("create past the byte limit: %v, want ErrLimit", err)
+	}
+	var first Upload
+	for i := range MaxOpenUploads {
+		u, err := s.Create(1, 1, nil)
+		if err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+		if i == 0 {
+			first = u
+		}
+	}
+	if _, err := s.Create(1, 1, nil); !errors.Is(err, ErrLimit) {
+		t.Fatalf("create past the upload limit: %v, want ErrLimit", err)
+	}
+	if _, err := s.Create(2, 1, nil); err != nil {
+		t.Fatalf("another user's upload: %v", err)
+	}
+
+	// An upload handed to a job no longer counts.
+	if _, err := s.Append(first.ID, 0, strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetJob(first.ID, 42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(1, 1, nil); err != nil {
+		t.Fatalf("create after an upload was handed over: %v", err)
+	}
+}
+
+func TestStore_FinishQueuesOnce(t *testing.T) {
+	s := NewLocal(t.TempDir(), time.Hour)
+	u, _ := s.Create(1, 3, nil)
+	if _, err := s.Append(u.ID, 0, strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := func(Upload, []byte) (int64, error) { return 0, errors.New("queue down") }
+	if _, err := s.Finish(u.ID, failing); err == nil {
+		t.Fatal("Finish with a failing queue succeeded")
+	}
+	unfinished, err := s.Unfinished()
+	if err != nil || len(unfinished) != 1 || unfinished[0].ID != u.ID {
+		t.Fatalf("Unfinished = %+v, %v; want the upload", unfinished, err)
+	}
+
+	calls := 0
+	queue := func(got Upload, data []byte) (int64, error) {
+		calls++
+		if string(data) != "abc" {
+			t.Errorf("queued %q, want abc", data)
+		}
+		return 42, nil
+	}
+	for range 2 {
+		if got, err := s.Finish(u.ID, queue); err != nil || got.JobID != 42 {
+			t.Fatalf("Finish = %+v, %v; want job 42", got, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("queued %d times, want once", calls)
+	}
+	if unfinished, _ := s.Unfinished(); len(unfinished) != 0 {
+		t.Fatalf("Unfinished after Finish = %+v", unfinished)
+	}
+}
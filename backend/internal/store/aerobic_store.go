@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+// AerobicSession is one steady session on a user's aerobic trend.
+type AerobicSession struct {
+	ActivityID       int64     `json:"activityId"`
+	Name             string    `json:"name"`
+	SportType        string    `json:"sportType"`
+	ActivityDate     time.Time `json:"activityDate"`
+	DurationSec      int       `json:"durationSec"`
+	EfficiencyFactor float64   `json:"efficiencyFactor"`
+	DecouplingPct    *float64  `json:"decouplingPct,omitempty"`
+	DecouplingType   string    `json:"decouplingType"`
+}
+
+type AerobicFilter struct {
+	SportType      string
+	DecouplingType string
+	From, To       *time.Time
+}
+
+// ListAerobicSessions returns the user's steady sessions with an efficiency
+// factor, oldest first.
+func (s *Store) ListAerobicSessions(ctx context.Context, userID int64, f AerobicFilter) ([]AerobicSession, error) {
+	args := []any{userID}
+	where := `user_id = $1 AND steady AND efficiency_factor > 0`
+	if f.SportType != "" {
+		args = append(args, f.SportType)
+		where += ` AND sport_type = $` + itoa(len(args))
+	}
+	if f.DecouplingType != "" {
+		args = append(args, f.DecouplingType)
+		where += ` AND decoupling_type = $` + itoa(len(args))
+	}
+	if f.From != nil {
+		args = append(args, *f.From)
+		where += ` AND activity_date >= $` + itoa(len(args))
+	}
+	if f.To != nil {
+		args = append(args, *f.To)
+		where += ` AND activity_date < $` + itoa(len(args))
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, activity_name, sport_type, activity_date, duration_sec,
+		       efficiency_factor, decoupling_pct, decoupling_type
+		FROM activities
+		WHERE `+where+`
+		ORDER BY activity_date, id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []AerobicSession{}
+	for rows.Next() {
+		var a AerobicSession
+		if err := rows.Scan(&a.ActivityID, &a.Name, &a.SportType, &a.ActivityDate, &a.DurationSec,
+			&a.EfficiencyFactor, &a.DecouplingPct, &a.DecouplingType); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, a)
+	}
+	return sessions, rows.Err()
+}
+
+// ListAerobicPending returns up to limit of the user's activities whose
+// aerobic metrics were never computed, with their tracks.
+func (s *Store) ListAerobicPending(ctx context.Context, userID int64, limit int) ([]Activity, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+activityColumns+`, a.track_points
+		FROM activities a
+		WHERE a.user_id = $1 AND NOT a.aerobic_computed
+		ORDER BY a.id
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activities []Activity
+	for rows.Next() {
+		a, err := scanActivityWithTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, a)
+	}
+	return activities, rows.Err()
+}
+
+func (s *Store) SaveAerobicMetrics(ctx context.Context, activityID int64, m metrics.Result) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE activities SET
+			avg_power_w = $2, normalized_power_w = $3, efficiency_factor = $4,
+			decoupling_pct = $5, decoupling_type = $6, steady = $7, aerobic_computed = true
+		WHERE id = $1
+	`, activityID, m.AvgPowerW, m.NormalizedPowerW, m.EfficiencyFactor, m.DecouplingPct, m.DecouplingType, m.Steady)
+	return err
+}
@@ -0,0 +1,330 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Why an activity was archived.
+const (
+	ArchivedByMerge = "merge"
+	ArchivedBySplit = "split"
+)
+
+// ErrArchiveSuperseded is returned when restoring originals whose replacement
+// was itself merged or split since; that must be restored first.
+var ErrArchiveSuperseded = errors.New("the activities made from the originals were merged or split again")
+
+// ArchivedActivity is an activity replaced by a merge or split, kept with its
+// track so it can be restored.
+type ArchivedActivity struct {
+	Activity   Activity  `json:"activity"`
+	Reason     string    `json:"reason"`
+	ReplacedBy []int64   `json:"replacedBy"`
+	ArchivedAt time.Time `json:"archivedAt"`
+
+	// row is the activity as stored, and dependents the rows archived with
+	// it; both are missing from activities archived before they were kept.
+	row        []byte
+	dependents activityDependents
+}
+
+// archivedDependents are the tables whose rows are dropped with an activity,
+// so they are archived and restored with it. A row is only restored when
+// restoreIf holds for it, as r. Segment efforts are not kept: matching the
+// restored activity finds them again.
+var archivedDependents = []struct{ table, restoreIf string }{
+	{"activity_share_links", "true"},
+	{"activity_intervals", "true"},
+	{"activity_legs", "true"},
+	// A course deleted meanwhile took its attempts with it.
+	{"course_attempts", "EXISTS (SELECT 1 FROM courses c WHERE c.id = r.course_id)"},
+}
+
+// archivedReferences are the columns that point at an activity and would be
+// cleared by deleting it. They point at its first replacement while it is
+// archived, and back at it once it is restored.
+var archivedReferences = []struct{ table, column string }{
+	{"community_posts", "activity_id"},
+	{"segments", "source_activity_id"},
+	{"courses", "activity_id"},
+}
+
+// activityDependents are the rows archived with an activity: the rows of each
+// of archivedDependents, as JSON, and the ids of the rows of each of
+// archivedReferences, keyed by "table.column".
+type activityDependents struct {
+	Rows       map[string]json.RawMessage `json:"rows"`
+	References map[string][]int64         `json:"references"`
+}
+
+// ReplaceActivities stores replacements and moves originals to the archive in
+// one transaction: each original keeps its row as stored and the rows that go
+// with it. It returns the stored replacements.
+func (s *Store) ReplaceActivities(ctx context.Context, userID int64, reason string, originals, replacements []Activity) ([]Activity, error) {
+	created := make([]Activity, 0, len(replacements))
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		gearIDs := map[int64]bool{}
+		ids := make([]int64, 0, len(replacements))
+		for _, r := range replacements {
+			a, err := insertActivity(ctx, tx, userID, r)
+			if err != nil {
+				return err
+			}
+			created = append(created, a)
+			ids = append(ids, a.ID)
+			if a.GearID != nil {
+				gearIDs[*a.GearID] = true
+			}
+		}
+		originalIDs := make([]int64, len(originals))
+		for i, o := range originals {
+			originalIDs[i] = o.ID
+		}
+		if len(ids) > 0 {
+			if err := movePhotos(ctx, tx, originalIDs, ids[0]); err != nil {
+				return err
+			}
+		}
+		var replacement *int64
+		if len(ids) > 0 {
+			replacement = &ids[0]
+		}
+		for _, o := range originals {
+			activityJSON, err := json.Marshal(o)
+			if err != nil {
+				return err
+			}
+			dependents, err := archiveDependents(ctx, tx, o.ID, replacement)
+			if err != nil {
+				return err
+			}
+			dependentsJSON, err := json.Marshal(dependents)
+			if err != nil {
+				return err
+			}
+			var row []byte
+			err = tx.QueryRow(ctx,
+				`DELETE FROM activities a WHERE id = $1 AND user_id = $2 RETURNING to_jsonb(a)`,
+				o.ID, userID,
+			).Scan(&row)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO archived_activities (id, user_id, activity, reason, replaced_by, activity_row, dependents)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+			`, o.ID, userID, activityJSON, reason, ids, row, dependentsJSON); err != nil {
+				return err
+			}
+			if o.GearID != nil {
+				gearIDs[*o.GearID] = true
+			}
+		}
+		return refreshGearSet(ctx, tx, gearIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// archiveDependents reads the rows that go with activity id before it is
+// deleted, and points the references to it at replacement.
+func archiveDependents(ctx context.Context, tx pgx.Tx, id int64, replacement *int64) (activityDependents, error) {
+	d := activityDependents{Rows: map[string]json.RawMessage{}, References: map[string][]int64{}}
+	for _, dep := range archivedDependents {
+		var rows json.RawMessage
+		err := tx.QueryRow(ctx,
+			`SELECT COALESCE(jsonb_agg(to_jsonb(t)), '[]') FROM `+dep.table+` t WHERE t.activity_id = $1`,
+			id,
+		).Scan(&rows)
+		if err != nil {
+			return d, err
+		}
+		d.Rows[dep.table] = rows
+	}
+	for _, ref := range archivedReferences {
+		rows, err := tx.Query(ctx,
+			`UPDATE `+ref.table+` SET `+ref.column+` = $2 WHERE `+ref.column+` = $1 RETURNING id`,
+			id, replacement,
+		)
+		if err != nil {
+			return d, err
+		}
+		refIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+		if err != nil {
+			return d, err
+		}
+		d.References[ref.table+"."+ref.column] = refIDs
+	}
+	return d, nil
+}
+
+// restoreArchivedRow puts an archived activity back as it was stored. Gear or
+// a route deleted meanwhile is left unset.
+func restoreArchivedRow(ctx context.Context, tx pgx.Tx, userID int64, row []byte) (Activity, error) {
+	return scanActivityWithTrack(tx.QueryRow(ctx, `
+		INSERT INTO activities AS a
+		SELECT r.* FROM jsonb_populate_record(NULL::activities, $2::jsonb || jsonb_build_object(
+			'gear_id', (SELECT g.id FROM gear g WHERE g.id = ($2::jsonb->>'gear_id')::bigint),
+			'route_id', (SELECT rt.id FROM routes rt WHERE rt.id = ($2::jsonb->>'route_id')::bigint)
+		)) r
+		WHERE r.user_id = $1
+		RETURNING `+activityColumns+`, a.track_points
+	`, userID, row))
+}
+
+// restoreDependents puts back the rows archived with activity id, and points
+// the references that moved to one of removed back at it.
+func restoreDependents(ctx context.Context, tx pgx.Tx, id int64, d activityDependents, removed []int64) error {
+	for _, dep := range archivedDependents {
+		rows, ok := d.Rows[dep.table]
+		if !ok {
+			continue
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO `+dep.table+` SELECT r.* FROM jsonb_populate_recordset(NULL::`+dep.table+`, $1) r WHERE `+dep.restoreIf,
+			rows,
+		); err != nil {
+			return err
+		}
+	}
+	for _, ref := range archivedReferences {
+		refIDs := d.References[ref.table+"."+ref.column]
+		if len(refIDs) == 0 {
+			continue
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE `+ref.table+` SET `+ref.column+` = $1 WHERE id = ANY($2) AND (`+ref.column+` IS NULL OR `+ref.column+` = ANY($3))`,
+			id, refIDs, removed,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListArchivedActivities returns the originals activityID was made from.
+func (s *Store) ListArchivedActivities(ctx context.Context, userID, activityID int64) ([]ArchivedActivity, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT activity, reason, replaced_by, archived_at, activity_row, dependents FROM archived_activities
+		WHERE user_id = $1 AND $2 = ANY(replaced_by)
+		ORDER BY id
+	`, userID, activityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ArchivedActivity
+	for rows.Next() {
+		var a ArchivedActivity
+		var activityJSON, dependentsJSON []byte
+		if err := rows.Scan(&activityJSON, &a.Reason, &a.ReplacedBy, &a.ArchivedAt, &a.row, &dependentsJSON); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(activityJSON, &a.Activity); err != nil {
+			return nil, err
+		}
+		if len(dependentsJSON) > 0 {
+			if err := json.Unmarshal(dependentsJSON, &a.dependents); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// RestoreActivities undoes the merge or split that made activityID: the
+// activities it made are deleted and the originals come back under their
+// ids, with the rows archived with them. It returns the restored originals and the ids of the deleted
+// activities, or ErrNotFound when activityID was not made by either.
+func (s *Store) RestoreActivities(ctx context.Context, userID, activityID int64) ([]Activity, []int64, error) {
+	archived, err := s.ListArchivedActivities(ctx, userID, activityID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(archived) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	// All originals of one merge or split share their replacements.
+	removed := archived[0].ReplacedBy
+
+	var restored []Activity
+	err = s.WithTx(ctx, func(tx pgx.Tx) error {
+		gearIDs := map[int64]bool{}
+		for _, a := range archived {
+			var restoredActivity Activity
+			if a.row == nil {
+				restoredActivity, err = insertActivity(ctx, tx, userID, a.Activity)
+			} else {
+				restoredActivity, err = restoreArchivedRow(ctx, tx, userID, a.row)
+			}
+			if err != nil {
+				return err
+			}
+			if err := restoreDependents(ctx, tx, restoredActivity.ID, a.dependents, removed); err != nil {
+				return err
+			}
+			restored = append(restored, restoredActivity)
+			if a.Activity.GearID != nil {
+				gearIDs[*a.Activity.GearID] = true
+			}
+		}
+		if err := movePhotos(ctx, tx, removed, restored[0].ID); err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx,
+			`DELETE FROM activities WHERE user_id = $1 AND id = ANY($2) RETURNING gear_id`,
+			userID, removed,
+		)
+		if err != nil {
+			return err
+		}
+		deleted := 0
+		for rows.Next() {
+			var gearID *int64
+			if err := rows.Scan(&gearID); err != nil {
+				rows.Close()
+				return err
+			}
+			deleted++
+			if gearID != nil {
+				gearIDs[*gearID] = true
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if deleted != len(removed) {
+			return ErrArchiveSuperseded
+		}
+
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM archived_activities WHERE user_id = $1 AND $2 = ANY(replaced_by)`,
+			userID, activityID,
+		); err != nil {
+			return err
+		}
+		return refreshGearSet(ctx, tx, gearIDs)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return restored, removed, nil
+}
+
+func refreshGearSet(ctx context.Context, tx pgx.Tx, gearIDs map[int64]bool) error {
+	for id := range gearIDs {
+		if err := refreshGearTotals(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
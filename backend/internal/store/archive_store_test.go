@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+func TestRestoreActivities_BringsBackArchivedRows(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+
+	user, err := s.CreateUser(ctx, "Archive", "Test", fmt.Sprintf("archive-%d@example.com", time.Now().UnixNano()), "x")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { _ = s.DeleteUser(context.Background(), user.ID) })
+
+	start := time.Date(2026, 6, 1, 7, 0, 0, 0, time.UTC)
+	points := make([]gpx.Point, 20)
+	for i := range points {
+		ts := start.Add(time.Duration(i) * 10 * time.Second)
+		points[i] = gpx.Point{Lat: 48.85, Lon: 2.35 + float64(i)*0.0002, Time: &ts}
+	}
+	original, err := s.CreateActivity(ctx, user.ID, Activity{
+		FileName:  "brick.fit",
+		SportType: "running",
+		Name:      "Brick",
+		Metrics:   metrics.Compute(points),
+		Points:    points,
+		FileHash:  "hash-of-brick",
+	})
+	if err != nil {
+		t.Fatalf("create activity: %v", err)
+	}
+	link, err := s.CreateShareLink(ctx, original.ID, user.ID)
+	if err != nil {
+		t.Fatalf("create share link: %v", err)
+	}
+	legs := []gpx.Leg{{Sport: "cycling", Start: start}, {Sport: "running", Start: start.Add(time.Minute)}}
+	if err := s.SaveActivityLegs(ctx, original.ID, "duathlon", legs, LegsManual); err != nil {
+		t.Fatalf("save legs: %v", err)
+	}
+	original, err = s.GetActivity(ctx, original.ID, user.ID)
+	if err != nil {
+		t.Fatalf("reload activity: %v", err)
+	}
+
+	created, err := s.ReplaceActivities(ctx, user.ID, ArchivedBySplit, []Activity{original}, []Activity{
+		{FileName: "brick.fit", SportType: "cycling", Name: "Brick 1", Metrics: metrics.Compute(points[:10]), Points: points[:10]},
+		{FileName: "brick.fit", SportType: "running", Name: "Brick 2", Metrics: metrics.Compute(points[10:]), Points: points[10:]},
+	})
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+
+	restored, removed, err := s.RestoreActivities(ctx, user.ID, created[0].ID)
+	if err != nil || len(restored) != 1 || len(removed) != 2 {
+		t.Fatalf("restore = %d activities, %v removed, %v", len(restored), removed, err)
+	}
+	if restored[0].ID != original.ID || restored[0].SportType != "duathlon" {
+		t.Fatalf("restored %+v, want activity %d as duathlon", restored[0], original.ID)
+	}
+	if id, err := s.FindDuplicateActivity(ctx, user.ID, "hash-of-brick", nil); err != nil || id != original.ID {
+		t.Fatalf("file hash lookup = %d, %v; want %d", id, err, original.ID)
+	}
+	links, err := s.ListShareLinks(ctx, original.ID, user.ID)
+	if err != nil || len(links) != 1 || links[0].ID != link.ID {
+		t.Fatalf("share links = %+v, %v; want link %d", links, err, link.ID)
+	}
+	if got, source, err := s.GetActivityLegs(ctx, original.ID); err != nil || len(got) != 2 || source != LegsManual {
+		t.Fatalf("legs = %+v, %q, %v; want the manual legs", got, source, err)
+	}
+}
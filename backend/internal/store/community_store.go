@@ -18,6 +18,7 @@ type CommunityPost struct {
 	UpdatedAt    time.Time `json:"updatedAt"`
 	Reactions    []ReactionCount `json:"reactions"`
 	CommentCount int       `json:"commentCount"`
+	Photos       []ActivityPhoto `json:"photos"`
 }
 
 type ReactionCount struct {
@@ -71,18 +72,25 @@ func (s *Store) CreatePost(ctx context.Context, authorID int64, content string,
 	return p, nil
 }
 
-func (s *Store) GetPost(ctx context.Context, id int64) (CommunityPost, error) {
+// postActivitySQL selects a post's activity_id only when the viewer bound to
+// viewerArg may read that activity; otherwise the reference is hidden.
+func postActivitySQL(viewerArg string) string {
+	return `CASE WHEN a.id IS NOT NULL AND ` + visibleToSQL("a", viewerArg) + ` THEN p.activity_id END`
+}
+
+func (s *Store) GetPost(ctx context.Context, id, viewerID int64) (CommunityPost, error) {
 	query := `
 		SELECT p.id, p.author_id, CONCAT(u.first_name, ' ', u.last_name) AS author_name,
 			u.avatar_url,
-			p.activity_id, p.content, p.pinned, p.created_at, p.updated_at,
+			` + postActivitySQL("$2") + `, p.content, p.pinned, p.created_at, p.updated_at,
 			(SELECT COUNT(*) FROM community_comments WHERE post_id = p.id) AS comment_count
 		FROM community_posts p
 		JOIN users u ON u.id = p.author_id
+		LEFT JOIN activities a ON a.id = p.activity_id
 		WHERE p.id = $1
 	`
 	var p CommunityPost
-	err := s.pool.QueryRow(ctx, query, id).Scan(
+	err := s.pool.QueryRow(ctx, query, id, viewerID).Scan(
 		&p.ID, &p.AuthorID, &p.AuthorName, &p.AuthorAvatar, &p.ActivityID, &p.Content,
 		&p.Pinned, &p.CreatedAt, &p.UpdatedAt, &p.CommentCount,
 	)
@@ -106,13 +114,14 @@ func (s *Store) ListPosts(ctx context.Context, cursor *int64, limit int, current
 	query := `
 		SELECT p.id, p.author_id, CONCAT(u.first_name, ' ', u.last_name) AS author_name,
 			u.avatar_url,
-			p.activity_id, p.content, p.pinned, p.created_at, p.updated_at,
+			` + postActivitySQL("$1") + `, p.content, p.pinned, p.created_at, p.updated_at,
 			(SELECT COUNT(*) FROM community_comments WHERE post_id = p.id) AS comment_count
 		FROM community_posts p
 		JOIN users u ON u.id = p.author_id
+		LEFT JOIN activities a ON a.id = p.activity_id
 	`
-	args := make([]any, 0)
-	argN := 1
+	args := []any{currentUserID}
+	argN := 2
 	conditions := []string{}
 
 	if cursor != nil {
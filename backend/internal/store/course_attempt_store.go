@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/course"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// CourseAttempt is an activity linked to a course, with how closely it
+// followed it.
+type CourseAttempt struct {
+	ActivityID        int64     `json:"activityId"`
+	CourseID          int64     `json:"courseId"`
+	ActivityName      string    `json:"activityName"`
+	ActivityDate      time.Time `json:"activityDate"`
+	CompletedPct      float64   `json:"completedPct"`
+	CoveredKM         float64   `json:"coveredKm"`
+	OffCourseKM       float64   `json:"offCourseKm"`
+	OffCourseSections int       `json:"offCourseSections"`
+	MaxDeviationM     float64   `json:"maxDeviationM"`
+	LinkedAt          time.Time `json:"linkedAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+const courseAttemptColumns = `
+	ca.activity_id, ca.course_id, a.activity_name, a.activity_date,
+	ca.completed_pct, ca.covered_km, ca.off_course_km, ca.off_course_sections, ca.max_deviation_m,
+	ca.linked_at, ca.updated_at
+`
+
+func scanCourseAttempt(row pgx.Row) (CourseAttempt, error) {
+	var c CourseAttempt
+	err := row.Scan(
+		&c.ActivityID, &c.CourseID, &c.ActivityName, &c.ActivityDate,
+		&c.CompletedPct, &c.CoveredKM, &c.OffCourseKM, &c.OffCourseSections, &c.MaxDeviationM,
+		&c.LinkedAt, &c.UpdatedAt,
+	)
+	return c, err
+}
+
+// SaveCourseAttempt links an activity to a course, or refreshes the link,
+// with the summary of adherence.
+func (s *Store) SaveCourseAttempt(ctx context.Context, userID, activityID, courseID int64, a course.Adherence) (CourseAttempt, error) {
+	if _, err := s.pool.Exec(ctx, `
+		INSERT INTO course_attempts (
+			activity_id, course_id, user_id,
+			completed_pct, covered_km, off_course_km, off_course_sections, max_deviation_m
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (activity_id) DO UPDATE SET
+			course_id = EXCLUDED.course_id,
+			completed_pct = EXCLUDED.completed_pct,
+			covered_km = EXCLUDED.covered_km,
+			off_course_km = EXCLUDED.off_course_km,
+			off_course_sections = EXCLUDED.off_course_sections,
+			max_deviation_m = EXCLUDED.max_deviation_m,
+			linked_at = CASE WHEN course_attempts.course_id = EXCLUDED.course_id
+				THEN course_attempts.linked_at ELSE now() END,
+			updated_at = now()
+	`, activityID, courseID, userID,
+		a.CompletedPct, a.CoveredKM, a.OffCourseKM, len(a.Sections), a.MaxDeviationM,
+	); err != nil {
+		return CourseAttempt{}, err
+	}
+	return s.GetCourseAttempt(ctx, activityID, userID)
+}
+
+func (s *Store) GetCourseAttempt(ctx context.Context, activityID, userID int64) (CourseAttempt, error) {
+	return scanCourseAttempt(s.pool.QueryRow(ctx, `
+		SELECT `+courseAttemptColumns+`
+		FROM course_attempts ca
+		JOIN activities a ON a.id = ca.activity_id
+		WHERE ca.activity_id = $1 AND ca.user_id = $2
+	`, activityID, userID))
+}
+
+// ListCourseAttempts returns the activities linked to a course, newest
+// first.
+func (s *Store) ListCourseAttempts(ctx context.Context, courseID, userID int64) ([]CourseAttempt, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+courseAttemptColumns+`
+		FROM course_attempts ca
+		JOIN activities a ON a.id = ca.activity_id
+		WHERE ca.course_id = $1 AND ca.user_id = $2
+		ORDER BY a.activity_date DESC
+	`, courseID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]CourseAttempt, 0)
+	for rows.Next() {
+		c, err := scanCourseAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+func (s *Store) DeleteCourseAttempt(ctx context.Context, activityID, userID int64) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM course_attempts WHERE activity_id = $1 AND user_id = $2`,
+		activityID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
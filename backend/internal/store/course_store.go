@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/course"
+	"gpx-training-analyzer/backend/internal/gpx"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Course is a planned course. Drawn courses keep their waypoints; courses
+// copied from an activity keep its id while it exists. Lists leave out the
+// points.
+type Course struct {
+	ID         int64             `json:"id"`
+	UserID     int64             `json:"userId"`
+	Name       string            `json:"name"`
+	SportType  string            `json:"sportType"`
+	ActivityID *int64            `json:"activityId"`
+	Waypoints  []course.Waypoint `json:"waypoints"`
+	Points     []gpx.Point       `json:"points,omitempty"`
+	course.Summary
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const courseColumns = `
+	c.id, c.user_id, c.name, c.sport_type, c.activity_id, c.waypoints,
+	c.distance_km, c.elev_gain_m, c.elev_loss_m, c.min_elev_m, c.max_elev_m,
+	c.created_at, c.updated_at
+`
+
+func scanCourse(row pgx.Row, extra ...any) (Course, error) {
+	var c Course
+	var waypointsJSON []byte
+	dest := []any{
+		&c.ID, &c.UserID, &c.Name, &c.SportType, &c.ActivityID, &waypointsJSON,
+		&c.DistanceKM, &c.ElevGainM, &c.ElevLossM, &c.MinElevM, &c.MaxElevM,
+		&c.CreatedAt, &c.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return Course{}, err
+	}
+	if err := json.Unmarshal(waypointsJSON, &c.Waypoints); err != nil {
+		return Course{}, err
+	}
+	return c, nil
+}
+
+// scanCourseWithPoints scans courseColumns followed by c.points.
+func scanCourseWithPoints(row pgx.Row) (Course, error) {
+	var pointsJSON []byte
+	c, err := scanCourse(row, &pointsJSON)
+	if err != nil {
+		return Course{}, err
+	}
+	if err := json.Unmarshal(pointsJSON, &c.Points); err != nil {
+		return Course{}, err
+	}
+	return c, nil
+}
+
+func courseJSON(c Course) (waypoints, points []byte, err error) {
+	if c.Waypoints == nil {
+		c.Waypoints = []course.Waypoint{}
+	}
+	if waypoints, err = json.Marshal(c.Waypoints); err != nil {
+		return nil, nil, err
+	}
+	points, err = json.Marshal(c.Points)
+	return waypoints, points, err
+}
+
+func (s *Store) CreateCourse(ctx context.Context, c Course) (Course, error) {
+	waypointsJSON, pointsJSON, err := courseJSON(c)
+	if err != nil {
+		return Course{}, err
+	}
+	return scanCourseWithPoints(s.pool.QueryRow(ctx, `
+		INSERT INTO courses AS c (
+			user_id, name, sport_type, activity_id, waypoints, points,
+			distance_km, elev_gain_m, elev_loss_m, min_elev_m, max_elev_m
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+courseColumns+`, c.points`,
+		c.UserID, c.Name, c.SportType, c.ActivityID, waypointsJSON, pointsJSON,
+		c.DistanceKM, c.ElevGainM, c.ElevLossM, c.MinElevM, c.MaxElevM,
+	))
+}
+
+func (s *Store) ListCourses(ctx context.Context, userID int64) ([]Course, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+courseColumns+`
+		FROM courses c
+		WHERE c.user_id = $1
+		ORDER BY c.updated_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]Course, 0)
+	for rows.Next() {
+		c, err := scanCourse(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+func (s *Store) GetCourse(ctx context.Context, id, userID int64) (Course, error) {
+	return scanCourseWithPoints(s.pool.QueryRow(ctx,
+		`SELECT `+courseColumns+`, c.points FROM courses c WHERE c.id = $1 AND c.user_id = $2`,
+		id, userID,
+	))
+}
+
+// UpdateCourse saves the name, sport type, waypoints, points and summary of
+// a course.
+func (s *Store) UpdateCourse(ctx context.Context, c Course) (Course, error) {
+	waypointsJSON, pointsJSON, err := courseJSON(c)
+	if err != nil {
+		return Course{}, err
+	}
+	return scanCourseWithPoints(s.pool.QueryRow(ctx, `
+		UPDATE courses AS c SET
+			name = $3, sport_type = $4, waypoints = $5, points = $6,
+			distance_km = $7, elev_gain_m = $8, elev_loss_m = $9, min_elev_m = $10, max_elev_m = $11,
+			updated_at = now()
+		WHERE c.id = $1 AND c.user_id = $2
+		RETURNING `+courseColumns+`, c.points`,
+		c.ID, c.UserID, c.Name, c.SportType, waypointsJSON, pointsJSON,
+		c.DistanceKM, c.ElevGainM, c.ElevLossM, c.MinElevM, c.MaxElevM,
+	))
+}
+
+func (s *Store) DeleteCourse(ctx context.Context, id, userID int64) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM courses WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+// GetOriginalTrack returns an activity's track as uploaded, before any crop.
+func (s *Store) GetOriginalTrack(ctx context.Context, activityID, userID int64) ([]gpx.Point, error) {
+	var trackJSON []byte
+	err := s.pool.QueryRow(ctx,
+		`SELECT COALESCE(original_points, track_points) FROM activities WHERE id = $1 AND user_id = $2`,
+		activityID, userID,
+	).Scan(&trackJSON)
+	if err != nil {
+		return nil, err
+	}
+	var points []gpx.Point
+	if err := json.Unmarshal(trackJSON, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// UpdateActivityTrack replaces the visible track of an activity and its
+// metrics. With a crop the original track is kept aside, once; without one
+// it is dropped, so points must then be the original. Segment efforts and
+// the route are cleared for matching again, saved interval corrections are
+// moved onto the new track, and gear totals refreshed.
+func (s *Store) UpdateActivityTrack(ctx context.Context, activityID, userID int64, points []gpx.Point, m metrics.Result, crop *metrics.Crop) error {
+	pointsJSON, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+	bounds := boxArgs(geo.FromPoints(points))
+	return s.WithTx(ctx, func(tx pgx.Tx) error {
+		var previous *metrics.Crop
+		err := tx.QueryRow(ctx,
+			`SELECT crop FROM activities WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+			activityID, userID,
+		).Scan(&previous)
+		if err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, `
+			UPDATE activities SET
+				original_points = CASE WHEN $3::jsonb IS NULL THEN NULL ELSE COALESCE(original_points, track_points) END,
+				track_points = $2, crop = $3,
+				bounds = box(point($4, $5), point($6, $7)), route_id = NULL,
+				activity_date = $8, distance_km = $9, duration_sec = $10,
+				avg_speed_kmh = $11, max_speed_kmh = $12, pace_min_km = $13,
+				elev_gain_m = $14, elev_loss_m = $15, max_elev_m = $16, min_elev_m = $17,
+				avg_hr = $18, max_hr = $19, avg_cadence = $20,
+				avg_power_w = $21, normalized_power_w = $22, efficiency_factor = $23,
+				decoupling_pct = $24, decoupling_type = $25, steady = $26, aerobic_computed = true,
+				calories_kcal = $27, calories_method = $28
+			WHERE id = $1 AND user_id = $29
+		`, activityID, pointsJSON, crop,
+			bounds[0], bounds[1], bounds[2], bounds[3],
+			m.ActivityDate, m.DistanceKM, m.DurationSec,
+			m.AvgSpeedKMH, m.MaxSpeedKMH, m.PaceMinPerKM,
+			m.ElevGainM, m.ElevLossM, m.MaxElevM, m.MinElevM,
+			m.AvgHR, m.MaxHR, m.AvgCadence,
+			m.AvgPowerW, m.NormalizedPowerW, m.EfficiencyFactor,
+			m.DecouplingPct, m.DecouplingType, m.Steady,
+			m.CaloriesKcal, m.CaloriesMethod, userID,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM segment_efforts WHERE activity_id = $1`, activityID); err != nil {
+			return err
+		}
+		if err := shiftIntervalCorrection(ctx, tx, activityID, cropStart(crop)-cropStart(previous), len(points)); err != nil {
+			return err
+		}
+		var gearID *int64
+		if err := tx.QueryRow(ctx, `SELECT gear_id FROM activities WHERE id = $1`, activityID).Scan(&gearID); err != nil {
+			return err
+		}
+		if gearID == nil {
+			return nil
+		}
+		return refreshGearTotals(ctx, tx, *gearID)
+	})
+}
+
+// cropStart is the index in the original track of the first point kept by c.
+func cropStart(c *metrics.Crop) int {
+	if c == nil {
+		return 0
+	}
+	return c.StartIndex
+}
+
+// shiftIntervalCorrection moves the reps saved for an activity onto its track
+// after the first offset points were removed (or, when negative, brought
+// back), dropping reps outside the n points left. Without any rep left the
+// correction is removed and detection takes over again.
+func shiftIntervalCorrection(ctx context.Context, tx pgx.Tx, activityID int64, offset, n int) error {
+	var repsJSON []byte
+	err := tx.QueryRow(ctx,
+		`SELECT reps FROM activity_intervals WHERE activity_id = $1 FOR UPDATE`, activityID,
+	).Scan(&repsJSON)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var reps []metrics.RepBoundary
+	if err := json.Unmarshal(repsJSON, &reps); err != nil {
+		return err
+	}
+	reps = metrics.ShiftReps(reps, offset, n)
+	if len(reps) == 0 {
+		_, err = tx.Exec(ctx, `DELETE FROM activity_intervals WHERE activity_id = $1`, activityID)
+		return err
+	}
+	if repsJSON, err = json.Marshal(reps); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE activity_intervals SET reps = $2, updated_at = now() WHERE activity_id = $1`,
+		activityID, repsJSON,
+	)
+	return err
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+// testStore connects to the migrated database in TEST_DATABASE_URL, skipping
+// the test when it is not set.
+func testStore(t *testing.T) *Store {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	t.Setenv("DATABASE_URL", dsn)
+	s, err := New(context.Background())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestUpdateActivityTrack_ShiftsIntervalCorrection(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+
+	user, err := s.CreateUser(ctx, "Crop", "Test", fmt.Sprintf("crop-%d@example.com", time.Now().UnixNano()), "x")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { _ = s.DeleteUser(context.Background(), user.ID) })
+
+	start := time.Date(2026, 5, 1, 7, 0, 0, 0, time.UTC)
+	original := make([]gpx.Point, 101)
+	for i := range original {
+		ts := start.Add(time.Duration(i) * 5 * time.Second)
+		original[i] = gpx.Point{Lat: 48.85, Lon: 2.35 + float64(i)*0.0002, Time: &ts}
+	}
+	activity, err := s.CreateActivity(ctx, user.ID, Activity{
+		FileName:  "crop.gpx",
+		SportType: "running",
+		Name:      "Crop",
+		Metrics:   metrics.Compute(original),
+		Points:    original,
+	})
+	if err != nil {
+		t.Fatalf("create activity: %v", err)
+	}
+	saved := []metrics.RepBoundary{
+		{StartIndex: 0, EndIndex: 20, Kind: metrics.RepWarmup},
+		{StartIndex: 20, EndIndex: 50, Kind: metrics.RepWork},
+		{StartIndex: 50, EndIndex: 80, Kind: metrics.RepRest},
+		{StartIndex: 80, EndIndex: 100, Kind: metrics.RepCooldown},
+	}
+	if err := s.SaveIntervalCorrection(ctx, activity.ID, saved); err != nil {
+		t.Fatalf("save correction: %v", err)
+	}
+
+	crop := &metrics.Crop{By: "time", StartIndex: 10, EndIndex: 84}
+	cropped := original[10:85]
+	if err := s.UpdateActivityTrack(ctx, activity.ID, user.ID, cropped, metrics.Compute(cropped), crop); err != nil {
+		t.Fatalf("crop: %v", err)
+	}
+	got, err := s.GetIntervalCorrection(ctx, activity.ID)
+	if err != nil {
+		t.Fatalf("get correction: %v", err)
+	}
+	want := []metrics.RepBoundary{
+		{StartIndex: 10, EndIndex: 40, Kind: metrics.RepWork},
+		{StartIndex: 40, EndIndex: 70, Kind: metrics.RepRest},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("after crop: got %v, want %v", got, want)
+	}
+
+	// A crop that keeps none of the reps drops the correction.
+	crop = &metrics.Crop{By: "time", StartIndex: 45, EndIndex: 60}
+	cropped = original[45:61]
+	if err := s.UpdateActivityTrack(ctx, activity.ID, user.ID, cropped, metrics.Compute(cropped), crop); err != nil {
+		t.Fatalf("recrop: %v", err)
+	}
+	if _, err := s.GetIntervalCorrection(ctx, activity.ID); err != ErrNotFound {
+		t.Fatalf("after recrop: expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Gear is a piece of equipment (shoes, bike…) whose wear is tracked through
+// the activities assigned to it.
+type Gear struct {
+	ID                   int64      `json:"id"`
+	UserID               int64      `json:"userId"`
+	GearType             string     `json:"gearType"` // shoes | bike | other
+	Name                 string     `json:"name"`
+	Brand                string     `json:"brand"`
+	Model                string     `json:"model"`
+	StartDistanceKM      float64    `json:"startDistanceKm"`
+	RetireAtKM           *float64   `json:"retireAtKm"`
+	Retired              bool       `json:"retired"`
+	ActivityCount        int        `json:"activityCount"`
+	TrackedDistanceKM    float64    `json:"trackedDistanceKm"`
+	TrackedDurationSec   int64      `json:"trackedDurationSec"`
+	TotalDistanceKM      float64    `json:"totalDistanceKm"`
+	RetirementNotifiedAt *time.Time `json:"retirementNotifiedAt"`
+	CreatedAt            time.Time  `json:"createdAt"`
+	UpdatedAt            time.Time  `json:"updatedAt"`
+}
+
+// GearDefault maps a sport type to the gear applied automatically on upload.
+type GearDefault struct {
+	SportType string `json:"sportType"`
+	GearID    int64  `json:"gearId"`
+}
+
+const gearColumns = `
+	id, user_id, gear_type, name, brand, model,
+	start_distance_km, retire_at_km, retired,
+	activity_count, tracked_distance_km, tracked_duration_sec,
+	retirement_notified_at, created_at, updated_at
+`
+
+func scanGear(row pgx.Row) (Gear, error) {
+	var g Gear
+	err := row.Scan(
+		&g.ID, &g.UserID, &g.GearType, &g.Name, &g.Brand, &g.Model,
+		&g.StartDistanceKM, &g.RetireAtKM, &g.Retired,
+		&g.ActivityCount, &g.TrackedDistanceKM, &g.TrackedDurationSec,
+		&g.RetirementNotifiedAt, &g.CreatedAt, &g.UpdatedAt,
+	)
+	if err != nil {
+		return Gear{}, err
+	}
+	g.TotalDistanceKM = math.Round((g.StartDistanceKM+g.TrackedDistanceKM)*100) / 100
+	return g, nil
+}
+
+func (s *Store) ListGear(ctx context.Context, userID int64) ([]Gear, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+gearColumns+` FROM gear WHERE user_id = $1 ORDER BY retired ASC, created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]Gear, 0)
+	for rows.Next() {
+		g, err := scanGear(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, g)
+	}
+	return items, rows.Err()
+}
+
+func (s *Store) GetGear(ctx context.Context, id, userID int64) (Gear, error) {
+	return scanGear(s.pool.QueryRow(ctx,
+		`SELECT `+gearColumns+` FROM gear WHERE id = $1 AND user_id = $2`,
+		id, userID,
+	))
+}
+
+func (s *Store) CreateGear(ctx context.Context, userID int64, g Gear) (Gear, error) {
+	return scanGear(s.pool.QueryRow(ctx, `
+		INSERT INTO gear (user_id, gear_type, name, brand, model, start_distance_km, retire_at_km, retired)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+gearColumns,
+		userID, g.GearType, g.Name, g.Brand, g.Model, g.StartDistanceKM, g.RetireAtKM, g.Retired,
+	))
+}
+
+// UpdateGear updates the editable fields of a gear item. Changing the
+// retirement threshold re-arms the retirement alert.
+func (s *Store) UpdateGear(ctx context.Context, id, userID int64, g Gear) (Gear, error) {
+	return scanGear(s.pool.QueryRow(ctx, `
+		UPDATE gear SET
+			gear_type         = $3,
+			name              = $4,
+			brand             = $5,
+			model             = $6,
+			start_distance_km = $7,
+			retire_at_km      = $8,
+			retired           = $9,
+			retirement_notified_at = CASE
+				WHEN retire_at_km IS DISTINCT FROM $8 THEN NULL
+				ELSE retirement_notified_at
+			END,
+			updated_at        = now()
+		WHERE id = $1 AND user_id = $2
+		RETURNING `+gearColumns,
+		id, userID, g.GearType, g.Name, g.Brand, g.Model, g.StartDistanceKM, g.RetireAtKM, g.Retired,
+	))
+}
+
+func (s *Store) DeleteGear(ctx context.Context, id, userID int64) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM gear WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListGearDefaults(ctx context.Context, userID int64) ([]GearDefault, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT sport_type, gear_id FROM gear_defaults WHERE user_id = $1 ORDER BY sport_type`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]GearDefault, 0)
+	for rows.Next() {
+		var d GearDefault
+		if err := rows.Scan(&d.SportType, &d.GearID); err != nil {
+			return nil, err
+		}
+		items = append(items, d)
+	}
+	return items, rows.Err()
+}
+
+// SetGearDefault sets the default gear for a sport type. A nil gearID removes
+// the default.
+func (s *Store) SetGearDefault(ctx context.Context, userID int64, sportType string, gearID *int64) error {
+	if gearID == nil {
+		_, err := s.pool.Exec(ctx,
+			`DELETE FROM gear_defaults WHERE user_id = $1 AND sport_type = $2`,
+			userID, sportType,
+		)
+		return err
+	}
+	if _, err := s.GetGear(ctx, *gearID, userID); err != nil {
+		return err
+	}
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO gear_defaults (user_id, sport_type, gear_id) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, sport_type) DO UPDATE SET gear_id = EXCLUDED.gear_id
+	`, userID, sportType, *gearID)
+	return err
+}
+
+// SetActivityGear assigns (or, with a nil gearID, unassigns) gear to an
+// activity and refreshes the totals of both the previous and the new gear.
+// It returns the newly assigned gear with its refreshed totals.
+func (s *Store) SetActivityGear(ctx context.Context, activityID, userID int64, gearID *int64) (*Gear, error) {
+	var assigned *Gear
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		var previous *int64
+		err := tx.QueryRow(ctx,
+			`SELECT gear_id FROM activities WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+			activityID, userID,
+		).Scan(&previous)
+		if err != nil {
+			return err
+		}
+
+		if gearID != nil {
+			var owner int64
+			err := tx.QueryRow(ctx, `SELECT user_id FROM gear WHERE id = $1`, *gearID).Scan(&owner)
+			if err != nil {
+				return err
+			}
+			if owner != userID {
+				return ErrNotFound
+			}
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE activities SET gear_id = $1 WHERE id = $2`, gearID, activityID); err != nil {
+			return err
+		}
+		if previous != nil && (gearID == nil || *previous != *gearID) {
+			if err := refreshGearTotals(ctx, tx, *previous); err != nil {
+				return err
+			}
+		}
+		if gearID != nil {
+			if err := refreshGearTotals(ctx, tx, *gearID); err != nil {
+				return err
+			}
+			g, err := scanGear(tx.QueryRow(ctx, `SELECT `+gearColumns+` FROM gear WHERE id = $1`, *gearID))
+			if err != nil {
+				return err
+			}
+			assigned = &g
+		}
+		return nil
+	})
+	return assigned, err
+}
+
+// ApplyDefaultGear assigns the user's default gear for sportType to the
+// activity. It returns nil when no default is configured.
+func (s *Store) ApplyDefaultGear(ctx context.Context, activityID, userID int64, sportType string) (*Gear, error) {
+	var gearID int64
+	err := s.pool.QueryRow(ctx, `
+		SELECT d.gear_id FROM gear_defaults d
+		JOIN gear g ON g.id = d.gear_id
+		WHERE d.user_id = $1 AND d.sport_type = $2 AND NOT g.retired
+	`, userID, sportType).Scan(&gearID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.SetActivityGear(ctx, activityID, userID, &gearID)
+}
+
+// RefreshGearTotalsForActivity recomputes the totals of the gear assigned to
+// an activity, for use after the activity's metrics change.
+func (s *Store) RefreshGearTotalsForActivity(ctx context.Context, activityID int64) error {
+	return s.WithTx(ctx, func(tx pgx.Tx) error {
+		var gearID *int64
+		if err := tx.QueryRow(ctx, `SELECT gear_id FROM activities WHERE id = $1`, activityID).Scan(&gearID); err != nil {
+			return err
+		}
+		if gearID == nil {
+			return nil
+		}
+		return refreshGearTotals(ctx, tx, *gearID)
+	})
+}
+
+// ClaimGearRetirementAlert marks the retirement alert of a shoe as sent if it
+// has passed its retirement distance and has not been alerted yet. It returns
+// true exactly once per threshold crossing, so callers can notify safely.
+func (s *Store) ClaimGearRetirementAlert(ctx context.Context, gearID int64) (bool, error) {
+	var id int64
+	err := s.pool.QueryRow(ctx, `
+		UPDATE gear SET retirement_notified_at = now()
+		WHERE id = $1
+		  AND gear_type = 'shoes'
+		  AND NOT retired
+		  AND retire_at_km IS NOT NULL
+		  AND start_distance_km + tracked_distance_km >= retire_at_km
+		  AND retirement_notified_at IS NULL
+		RETURNING id
+	`, gearID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func refreshGearTotals(ctx context.Context, tx pgx.Tx, gearID int64) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE gear SET
+			activity_count       = t.cnt,
+			tracked_distance_km  = t.dist,
+			tracked_duration_sec = t.dur,
+			updated_at           = now()
+		FROM (
+			SELECT COUNT(*) AS cnt,
+				COALESCE(SUM(distance_km), 0) AS dist,
+				COALESCE(SUM(duration_sec), 0) AS dur
+			FROM activities WHERE gear_id = $1
+		) t
+		WHERE gear.id = $1
+	`, gearID)
+	return err
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+)
+
+// HeatmapFilter narrows the activities drawn on a heatmap. Zero values do not
+// filter; To is exclusive.
+type HeatmapFilter struct {
+	SportType string
+	From      *time.Time
+	To        *time.Time
+}
+
+// ListHeatmapTracks returns the coordinates of the user's activities whose
+// bounding box overlaps box.
+func (s *Store) ListHeatmapTracks(ctx context.Context, userID int64, box geo.BBox, f HeatmapFilter) ([][]geo.Coord, error) {
+	args := []any{userID, box.MinLon, box.MinLat, box.MaxLon, box.MaxLat}
+	where := `user_id = $1 AND bounds && box(point($2, $3), point($4, $5))`
+	if f.SportType != "" {
+		args = append(args, f.SportType)
+		where += ` AND sport_type = $` + itoa(len(args))
+	}
+	if f.From != nil {
+		args = append(args, *f.From)
+		where += ` AND activity_date >= $` + itoa(len(args))
+	}
+	if f.To != nil {
+		args = append(args, *f.To)
+		where += ` AND activity_date < $` + itoa(len(args))
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT track_points FROM activities WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := make([][]geo.Coord, 0)
+	for rows.Next() {
+		var trackJSON []byte
+		if err := rows.Scan(&trackJSON); err != nil {
+			return nil, err
+		}
+		var coords []geo.Coord
+		if err := json.Unmarshal(trackJSON, &coords); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, coords)
+	}
+	return tracks, rows.Err()
+}
+
+func (s *Store) GetHeatmapTile(ctx context.Context, userID int64, key string) ([]byte, error) {
+	var data []byte
+	err := s.pool.QueryRow(ctx,
+		`SELECT png FROM heatmap_tiles WHERE user_id = $1 AND tile_key = $2`,
+		userID, key,
+	).Scan(&data)
+	return data, err
+}
+
+func (s *Store) SaveHeatmapTile(ctx context.Context, userID int64, key string, box geo.BBox, data []byte) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO heatmap_tiles (user_id, tile_key, bounds, png)
+		VALUES ($1, $2, box(point($3, $4), point($5, $6)), $7)
+		ON CONFLICT (user_id, tile_key) DO UPDATE
+		SET png = EXCLUDED.png, created_at = now()
+	`, userID, key, box.MinLon, box.MinLat, box.MaxLon, box.MaxLat, data)
+	return err
+}
+
+// InvalidateHeatmapTiles drops the user's cached tiles overlapping box, at
+// every zoom level and for every filter.
+func (s *Store) InvalidateHeatmapTiles(ctx context.Context, userID int64, box geo.BBox) error {
+	_, err := s.pool.Exec(ctx,
+		`DELETE FROM heatmap_tiles WHERE user_id = $1 AND bounds && box(point($2, $3), point($4, $5))`,
+		userID, box.MinLon, box.MinLat, box.MaxLon, box.MaxLat,
+	)
+	return err
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// IdempotentRequest is what is known of a request made with an idempotency
+// key: its fingerprint and, once it completed, its response.
+type IdempotentRequest struct {
+	Fingerprint string
+	Completed   bool
+	Status      int
+	Header      map[string]string
+	Body        []byte
+}
+
+// ReserveIdempotencyKey claims key for a request, holding it for lease. It
+// returns reserved = true when the key is new, had expired or was held by a
+// request whose lease lapsed; otherwise it returns the request that holds the
+// key.
+func (s *Store) ReserveIdempotencyKey(ctx context.Context, userID int64, key, fingerprint string, lease, ttl time.Duration) (IdempotentRequest, bool, error) {
+	if _, err := s.pool.Exec(ctx,
+		`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND expires_at <= now()`,
+		userID, key,
+	); err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, locked_until, expires_at)
+		VALUES ($1, $2, $3, now() + make_interval(secs => $4), now() + make_interval(secs => $5))
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, locked_until = EXCLUDED.locked_until,
+		    created_at = now(), expires_at = EXCLUDED.expires_at
+		WHERE NOT idempotency_keys.completed AND idempotency_keys.locked_until <= now()
+	`, userID, key, fingerprint, lease.Seconds(), ttl.Seconds())
+	if err != nil {
+		return IdempotentRequest{}, false, err
+	}
+	if tag.RowsAffected() == 1 {
+		return IdempotentRequest{Fingerprint: fingerprint}, true, nil
+	}
+
+	var req IdempotentRequest
+	var status *int
+	var headerJSON []byte
+	err = s.pool.QueryRow(ctx, `
+		SELECT fingerprint, completed, response_status, response_headers, response_body
+		FROM idempotency_keys WHERE user_id = $1 AND key = $2
+	`, userID, key).Scan(&req.Fingerprint, &req.Completed, &status, &headerJSON, &req.Body)
+	if err != nil {
+		// ErrNotFound when the key was released between the insert and the
+		// read.
+		return IdempotentRequest{}, false, err
+	}
+	if status != nil {
+		req.Status = *status
+	}
+	if len(headerJSON) > 0 {
+		if err := json.Unmarshal(headerJSON, &req.Header); err != nil {
+			return IdempotentRequest{}, false, err
+		}
+	}
+	return req, false, nil
+}
+
+// RenewIdempotencyKey extends the lease on key of a request that is still
+// running.
+func (s *Store) RenewIdempotencyKey(ctx context.Context, userID int64, key string, lease time.Duration) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE idempotency_keys SET locked_until = now() + make_interval(secs => $3)
+		WHERE user_id = $1 AND key = $2 AND NOT completed
+	`, userID, key, lease.Seconds())
+	return err
+}
+
+// CompleteIdempotencyKey stores the response of the request holding key.
+func (s *Store) CompleteIdempotencyKey(ctx context.Context, userID int64, key string, status int, header map[string]string, body []byte) error {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET completed = true, response_status = $3, response_headers = $4, response_body = $5
+		WHERE user_id = $1 AND key = $2
+	`, userID, key, status, headerJSON, body)
+	return err
+}
+
+// ReleaseIdempotencyKey frees key so that the request can be retried.
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`, userID, key)
+	return err
+}
+
+func (s *Store) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+	return tag.RowsAffected(), err
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReserveIdempotencyKey_TakesOverLapsedLease(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+
+	user, err := s.CreateUser(ctx, "Idem", "Test", fmt.Sprintf("idem-%d@example.com", time.Now().UnixNano()), "x")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { _ = s.DeleteUser(context.Background(), user.ID) })
+
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, user.ID, "held", "a", time.Minute, time.Hour); err != nil || !reserved {
+		t.Fatalf("first reserve = %v, %v; want reserved", reserved, err)
+	}
+	prior, reserved, err := s.ReserveIdempotencyKey(ctx, user.ID, "held", "a", time.Minute, time.Hour)
+	if err != nil || reserved || prior.Completed {
+		t.Fatalf("reserve while held = %+v, %v, %v; want the running request", prior, reserved, err)
+	}
+
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, user.ID, "lapsed", "a", 0, time.Hour); err != nil || !reserved {
+		t.Fatalf("first reserve = %v, %v; want reserved", reserved, err)
+	}
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, user.ID, "lapsed", "a", time.Minute, time.Hour); err != nil || !reserved {
+		t.Fatalf("reserve after the lease lapsed = %v, %v; want reserved", reserved, err)
+	}
+
+	if err := s.CompleteIdempotencyKey(ctx, user.ID, "lapsed", 201, nil, []byte("{}")); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if err := s.RenewIdempotencyKey(ctx, user.ID, "lapsed", 0); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	prior, reserved, err = s.ReserveIdempotencyKey(ctx, user.ID, "lapsed", "a", time.Minute, time.Hour)
+	if err != nil || reserved || !prior.Completed || prior.Status != 201 {
+		t.Fatalf("reserve after completion = %+v, %v, %v; want the stored response", prior, reserved, err)
+	}
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Import statuses.
+const (
+	ImportPending = "pending"
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// ImportProgress counts the files of an import handled so far.
+type ImportProgress struct {
+	Total      int `json:"total"`
+	Processed  int `json:"processed"`
+	Created    int `json:"created"`
+	Duplicates int `json:"duplicates"`
+	Failed     int `json:"failed"`
+}
+
+// Import is a bulk upload or an account export being imported, or imported,
+// one job per file. Progress is counted from the jobs.
+type Import struct {
+	ID       int64  `json:"id"`
+	FileName string `json:"fileName"`
+	Format   string `json:"format"`
+	Status   string `json:"status"`
+	ImportProgress
+	// Results are the outcomes of files turned down before any job was
+	// queued, such as unreadable archive entries or copies within a batch.
+	Results    json.RawMessage `json:"results,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	ArchiveKey string          `json:"-"` // blob holding the export or upload
+	CreatedAt  time.Time       `json:"createdAt"`
+	FinishedAt *time.Time      `json:"finishedAt"`
+}
+
+// importColumns adds the files turned down at intake, all failed or
+// duplicates, to the counts of the import's jobs.
+const importColumns = `
+	i.id, i.file_name, i.format, i.status,
+	p.total + jsonb_array_length(i.results),
+	p.processed + jsonb_array_length(i.results),
+	p.created,
+	p.duplicates + (SELECT COUNT(*) FROM jsonb_array_elements(i.results) r WHERE r->>'status' = 'duplicate'),
+	p.failed + (SELECT COUNT(*) FROM jsonb_array_elements(i.results) r WHERE r->>'status' = 'failed'),
+	i.error, i.archive_key, i.created_at, i.finished_at
+`
+
+const importFrom = `
+	FROM imports i CROSS JOIN LATERAL (
+		SELECT COUNT(*) AS total,
+		       COUNT(*) FILTER (WHERE j.status IN ('succeeded', 'failed', 'dead')) AS processed,
+		       COUNT(*) FILTER (WHERE j.status = 'succeeded' AND NOT j.duplicate) AS created,
+		       COUNT(*) FILTER (WHERE j.status = 'succeeded' AND j.duplicate) AS duplicates,
+		       COUNT(*) FILTER (WHERE j.status IN ('failed', 'dead')) AS failed
+		FROM jobs j WHERE j.import_id = i.id
+	) p
+`
+
+func scanImport(row pgx.Row, extra ...any) (Import, error) {
+	var im Import
+	dest := []any{
+		&im.ID, &im.FileName, &im.Format, &im.Status,
+		&im.Total, &im.Processed, &im.Created, &im.Duplicates, &im.Failed,
+		&im.Error, &im.ArchiveKey, &im.CreatedAt, &im.FinishedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return im, err
+}
+
+// CreateImport records an account export or bulk upload kept in blob storage
+// under archiveKey, waiting for StartImport.
+func (s *Store) CreateImport(ctx context.Context, userID int64, fileName, archiveKey string) (Import, error) {
+	var id int64
+	err := s.pool.QueryRow(ctx,
+		`INSERT INTO imports (user_id, file_name, archive_key) VALUES ($1, $2, $3) RETURNING id`,
+		userID, fileName, archiveKey,
+	).Scan(&id)
+	if err != nil {
+		return Import{}, err
+	}
+	return s.GetImport(ctx, userID, id)
+}
+
+// StartImport records the format of a pending import and the outcomes of the
+// files turned down while listing it, and queues a job for each other file.
+// It reports false, queuing nothing, when the import was started before.
+func (s *Store) StartImport(ctx context.Context, userID, id int64, format string, rejected any, jobs []NewJob, maxAttempts int) (bool, error) {
+	resultsJSON, err := json.Marshal(rejected)
+	if err != nil {
+		return false, err
+	}
+	started := false
+	err = s.WithTx(ctx, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx, `SELECT status FROM imports WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+		if err != nil || status != ImportPending {
+			return err
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE imports SET status = $2, format = $3, results = $4 WHERE id = $1`,
+			id, ImportRunning, format, resultsJSON,
+		); err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			if _, err := insertJob(ctx, tx, userID, &id, j, maxAttempts); err != nil {
+				return err
+			}
+		}
+		started = true
+		return nil
+	})
+	return started, err
+}
+
+// finishImportsSQL ends running imports none of whose jobs is left to run.
+const finishImportsSQL = `
+	UPDATE imports i SET status = 'done', finished_at = now()
+	WHERE i.status = 'running'
+	  AND NOT EXISTS (SELECT 1 FROM jobs j WHERE j.import_id = i.id AND j.status IN ('queued', 'running'))
+`
+
+// FinishImport ends an import once its last job ended. It reports whether
+// the import ended, and with it the blob key of its export, if any.
+func (s *Store) FinishImport(ctx context.Context, id int64) (archiveKey string, done bool, err error) {
+	err = s.pool.QueryRow(ctx, finishImportsSQL+` AND i.id = $1 RETURNING i.archive_key`, id).Scan(&archiveKey)
+	if errors.Is(err, ErrNotFound) {
+		return "", false, nil
+	}
+	return archiveKey, err == nil, err
+}
+
+// FinishDoneImports ends every import whose jobs all ended, in case the
+// worker running the last one stopped before FinishImport, and returns the
+// blob keys of their exports.
+func (s *Store) FinishDoneImports(ctx context.Context) ([]string, error) {
+	rows, err := s.pool.Query(ctx, finishImportsSQL+` RETURNING i.archive_key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// FailImport ends an import that could not be started with errMsg and
+// returns the blob key of its export.
+func (s *Store) FailImport(ctx context.Context, id int64, errMsg string) (string, error) {
+	var archiveKey string
+	err := s.pool.QueryRow(ctx, `
+		UPDATE imports SET status = $2, error = $3, finished_at = now()
+		WHERE id = $1 AND status IN ('pending', 'running')
+		RETURNING archive_key
+	`, id, ImportFailed, errMsg).Scan(&archiveKey)
+	return archiveKey, err
+}
+
+// GetImport returns an import of the user with the results of the files
+// turned down at intake.
+func (s *Store) GetImport(ctx context.Context, userID, id int64) (Import, error) {
+	return getImport(ctx, s.pool, userID, id)
+}
+
+func getImport(ctx context.Context, q rowQuerier, userID, id int64) (Import, error) {
+	var results []byte
+	im, err := scanImport(q.QueryRow(ctx,
+		`SELECT `+importColumns+`, i.results `+importFrom+` WHERE i.id = $1 AND i.user_id = $2`,
+		id, userID,
+	), &results)
+	if err != nil {
+		return Import{}, err
+	}
+	im.Results = results
+	return im, nil
+}
+
+// ListImports returns the user's imports, newest first, without results.
+func (s *Store) ListImports(ctx context.Context, userID int64) ([]Import, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+importColumns+importFrom+` WHERE i.user_id = $1 ORDER BY i.created_at DESC LIMIT 50`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]Import, 0)
+	for rows.Next() {
+		im, err := scanImport(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, im)
+	}
+	return items, rows.Err()
+}
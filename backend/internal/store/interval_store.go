@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+// GetIntervalCorrection returns the reps saved by the athlete for an
+// activity, or ErrNotFound when the detected intervals are in use.
+func (s *Store) GetIntervalCorrection(ctx context.Context, activityID int64) ([]metrics.RepBoundary, error) {
+	var repsJSON []byte
+	err := s.pool.QueryRow(ctx,
+		`SELECT reps FROM activity_intervals WHERE activity_id = $1`, activityID,
+	).Scan(&repsJSON)
+	if err != nil {
+		return nil, err
+	}
+	var reps []metrics.RepBoundary
+	if err := json.Unmarshal(repsJSON, &reps); err != nil {
+		return nil, err
+	}
+	return reps, nil
+}
+
+func (s *Store) SaveIntervalCorrection(ctx context.Context, activityID int64, reps []metrics.RepBoundary) error {
+	repsJSON, err := json.Marshal(reps)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO activity_intervals (activity_id, reps) VALUES ($1, $2)
+		ON CONFLICT (activity_id) DO UPDATE SET reps = EXCLUDED.reps, updated_at = now()
+	`, activityID, repsJSON)
+	return err
+}
+
+func (s *Store) DeleteIntervalCorrection(ctx context.Context, activityID int64) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM activity_intervals WHERE activity_id = $1`, activityID)
+	return err
+}
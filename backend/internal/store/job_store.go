@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Job statuses. A failed job failed for good, e.g. on an invalid file; a dead
+// job ran out of attempts.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobDead      = "dead"
+)
+
+// Job is a unit of background work, such as importing an uploaded file.
+type Job struct {
+	ID          int64           `json:"id"`
+	UserID      int64           `json:"-"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Payload     json.RawMessage `json:"-"`
+	Data        []byte          `json:"-"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"`
+	Progress    int             `json:"progress"`
+	Stage       string          `json:"stage"`
+	LastError   string          `json:"error,omitempty"`
+	ActivityID  *int64          `json:"activityId"`
+	// Duplicate marks a succeeded job whose file was imported before, as
+	// ActivityID.
+	Duplicate  bool       `json:"duplicate"`
+	ImportID   *int64     `json:"importId,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
+const jobColumns = `
+	id, user_id, kind, status, payload, attempts, max_attempts, run_at,
+	progress, stage, last_error, activity_id, duplicate, import_id, created_at, updated_at, finished_at
+`
+
+func scanJob(row pgx.Row, extra ...any) (Job, error) {
+	var j Job
+	dest := []any{
+		&j.ID, &j.UserID, &j.Kind, &j.Status, &j.Payload, &j.Attempts, &j.MaxAttempts, &j.RunAt,
+		&j.Progress, &j.Stage, &j.LastError, &j.ActivityID, &j.Duplicate, &j.ImportID, &j.CreatedAt, &j.UpdatedAt, &j.FinishedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return j, err
+}
+
+// EnqueueJob queues a job to run as soon as a worker is free.
+func (s *Store) EnqueueJob(ctx context.Context, userID int64, kind string, payload any, data []byte, maxAttempts int) (Job, error) {
+	return enqueueJob(ctx, s.pool, userID, kind, payload, data, maxAttempts)
+}
+
+func enqueueJob(ctx context.Context, q rowQuerier, userID int64, kind string, payload any, data []byte, maxAttempts int) (Job, error) {
+	return insertJob(ctx, q, userID, nil, NewJob{Kind: kind, Payload: payload, Data: data}, maxAttempts)
+}
+
+// EnqueueUniqueJob queues a job to run at runAt unless one of the same kind
+// and key is still queued for the user, in which case it returns that one.
+// Delaying runAt lets work asked for in bursts run once.
+func (s *Store) EnqueueUniqueJob(ctx context.Context, userID int64, kind, key string, payload any, runAt time.Time, maxAttempts int) (Job, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, err
+	}
+	// The queued job may be claimed between the insert and the lookup; the
+	// next insert then goes through.
+	for range 3 {
+		j, err := scanJob(s.pool.QueryRow(ctx, `
+			INSERT INTO jobs (user_id, kind, payload, max_attempts, run_at, dedupe_key)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (user_id, kind, dedupe_key) WHERE status = 'queued' AND dedupe_key IS NOT NULL DO NOTHING
+			RETURNING `+jobColumns,
+			userID, kind, payloadJSON, maxAttempts, runAt, key,
+		))
+		if !errors.Is(err, ErrNotFound) {
+			return j, err
+		}
+		j, err = scanJob(s.pool.QueryRow(ctx, `
+			SELECT `+jobColumns+` FROM jobs
+			WHERE user_id = $1 AND kind = $2 AND dedupe_key = $3 AND status = 'queued'
+		`, userID, kind, key))
+		if !errors.Is(err, ErrNotFound) {
+			return j, err
+		}
+	}
+	return Job{}, errors.New("queued job kept changing")
+}
+
+// NewJob is a job to queue as part of an import.
+type NewJob struct {
+	Kind    string
+	Payload any
+	Data    []byte
+}
+
+func insertJob(ctx context.Context, q rowQuerier, userID int64, importID *int64, j NewJob, maxAttempts int) (Job, error) {
+	payloadJSON, err := json.Marshal(j.Payload)
+	if err != nil {
+		return Job{}, err
+	}
+	return scanJob(q.QueryRow(ctx, `
+		INSERT INTO jobs (user_id, kind, payload, data, max_attempts, import_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+jobColumns,
+		userID, j.Kind, payloadJSON, j.Data, maxAttempts, importID,
+	))
+}
+
+// ClaimJob marks the next due job as running and returns it with its data,
+// or ErrNotFound when none is due. SKIP LOCKED lets workers claim
+// concurrently. A claimed job no longer takes in requests for its key.
+func (s *Store) ClaimJob(ctx context.Context) (Job, error) {
+	var data []byte
+	j, err := scanJob(s.pool.QueryRow(ctx, `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, dedupe_key = NULL, locked_at = now(), updated_at = now()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = 'queued' AND run_at <= now()
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING `+jobColumns+`, data`,
+	), &data)
+	if err != nil {
+		return Job{}, err
+	}
+	j.Data = data
+	return j, nil
+}
+
+// SetJobProgress records how far a running job got.
+func (s *Store) SetJobProgress(ctx context.Context, id int64, progress int, stage string) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE jobs SET progress = $2, stage = $3, locked_at = now(), updated_at = now()
+		WHERE id = $1 AND status = 'running'
+	`, id, progress, stage)
+	return err
+}
+
+// CompleteJob marks a job as succeeded and drops its data.
+func (s *Store) CompleteJob(ctx context.Context, id int64, activityID *int64, duplicate bool) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE jobs
+		SET status = 'succeeded', progress = 100, stage = 'done', activity_id = $2, duplicate = $3, data = NULL,
+		    last_error = '', locked_at = NULL, updated_at = now(), finished_at = now()
+		WHERE id = $1
+	`, id, activityID, duplicate)
+	return err
+}
+
+// FailJob records a failed attempt and returns the job's new status. A
+// retryable job with attempts left is queued again at retryAt; otherwise it
+// ends failed, or dead when it ran out of attempts, and its data is dropped.
+func (s *Store) FailJob(ctx context.Context, id int64, errMsg string, retryable bool, retryAt time.Time) (string, error) {
+	var status string
+	err := s.pool.QueryRow(ctx, `
+		UPDATE jobs
+		SET status = CASE
+		        WHEN NOT $3 THEN 'failed'
+		        WHEN attempts >= max_attempts THEN 'dead'
+		        ELSE 'queued'
+		    END,
+		    run_at = CASE WHEN $3 AND attempts < max_attempts THEN $4 ELSE run_at END,
+		    finished_at = CASE WHEN $3 AND attempts < max_attempts THEN NULL ELSE now() END,
+		    data = CASE WHEN $3 AND attempts < max_attempts THEN data END,
+		    last_error = $2, locked_at = NULL, updated_at = now()
+		WHERE id = $1
+		RETURNING status
+	`, id, errMsg, retryable, retryAt).Scan(&status)
+	return status, err
+}
+
+// RequeueStaleJobs returns to the queue running jobs whose worker stopped
+// reporting, e.g. because the server restarted mid-job. Jobs out of attempts
+// are left dead, without their data.
+func (s *Store) RequeueStaleJobs(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'queued' END,
+		    finished_at = CASE WHEN attempts >= max_attempts THEN now() ELSE NULL END,
+		    data = CASE WHEN attempts < max_attempts THEN data END,
+		    last_error = 'worker stopped before the job finished',
+		    locked_at = NULL, updated_at = now()
+		WHERE status = 'running' AND locked_at < $1
+	`, lockedBefore)
+	return tag.RowsAffected(), err
+}
+
+// GetJob returns a job of the user.
+func (s *Store) GetJob(ctx context.Context, userID, id int64) (Job, error) {
+	return scanJob(s.pool.QueryRow(ctx,
+		`SELECT `+jobColumns+` FROM jobs WHERE id = $1 AND user_id = $2`,
+		id, userID,
+	))
+}
+
+// ListImportJobs returns the jobs of an import in the order they were queued.
+func (s *Store) ListImportJobs(ctx context.Context, importID int64) ([]Job, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+jobColumns+` FROM jobs WHERE import_id = $1 ORDER BY id`,
+		importID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]Job, 0)
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, j)
+	}
+	return items, rows.Err()
+}
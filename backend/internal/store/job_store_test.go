@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEnqueueUniqueJob_JoinsQueuedJob(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+
+	user, err := s.CreateUser(ctx, "Job", "Test", fmt.Sprintf("job-%d@example.com", time.Now().UnixNano()), "x")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { _ = s.DeleteUser(context.Background(), user.ID) })
+
+	later := time.Now().Add(time.Hour)
+	first, err := s.EnqueueUniqueJob(ctx, user.ID, "test", "k", nil, later, 1)
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	again, err := s.EnqueueUniqueJob(ctx, user.ID, "test", "k", nil, later, 1)
+	if err != nil || again.ID != first.ID {
+		t.Fatalf("second enqueue = %d, %v; want job %d", again.ID, err, first.ID)
+	}
+	other, err := s.EnqueueUniqueJob(ctx, user.ID, "test", "other", nil, later, 1)
+	if err != nil || other.ID == first.ID {
+		t.Fatalf("enqueue with another key = %d, %v; want a new job", other.ID, err)
+	}
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+)
+
+// Where the legs of a multisport activity came from.
+const (
+	LegsFromFile = "file"
+	LegsManual   = "manual"
+)
+
+// GetActivityLegs returns the sport changes of a multisport activity and
+// their source, or ErrNotFound for a single-sport activity.
+func (s *Store) GetActivityLegs(ctx context.Context, activityID int64) ([]gpx.Leg, string, error) {
+	var legsJSON []byte
+	var source string
+	err := s.pool.QueryRow(ctx,
+		`SELECT legs, source FROM activity_legs WHERE activity_id = $1`, activityID,
+	).Scan(&legsJSON, &source)
+	if err != nil {
+		return nil, "", err
+	}
+	var legs []gpx.Leg
+	if err := json.Unmarshal(legsJSON, &legs); err != nil {
+		return nil, "", err
+	}
+	return legs, source, nil
+}
+
+// SaveActivityLegs stores the legs of an activity and sets its sport type,
+// e.g. to triathlon.
+func (s *Store) SaveActivityLegs(ctx context.Context, activityID int64, sportType string, legs []gpx.Leg, source string) error {
+	legsJSON, err := json.Marshal(legs)
+	if err != nil {
+		return err
+	}
+	return s.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO activity_legs (activity_id, legs, source) VALUES ($1, $2, $3)
+			ON CONFLICT (activity_id) DO UPDATE
+			SET legs = EXCLUDED.legs, source = EXCLUDED.source, updated_at = now()
+		`, activityID, legsJSON, source); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `UPDATE activities SET sport_type = $1 WHERE id = $2`, sportType, activityID)
+		return err
+	})
+}
+
+func (s *Store) DeleteActivityLegs(ctx context.Context, activityID int64) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM activity_legs WHERE activity_id = $1`, activityID)
+	return err
+}
@@ -0,0 +1,295 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/live"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Live session statuses. A discarded session ended with too little track to
+// become an activity.
+const (
+	LiveActive    = "active"
+	LiveFinished  = "finished"
+	LiveDiscarded = "discarded"
+)
+
+var ErrLiveSessionEnded = errors.New("live session has ended")
+
+// LiveSession is an activity tracked while it happens. TargetKM, copied from
+// the course when one is followed, is what arrival is estimated against.
+type LiveSession struct {
+	ID         int64    `json:"id"`
+	UserID     int64    `json:"-"`
+	OwnerName  string   `json:"ownerName"`
+	Name       string   `json:"name"`
+	SportType  string   `json:"sportType"`
+	Visibility string   `json:"visibility"`
+	CourseID   *int64   `json:"courseId"`
+	TargetKM   *float64 `json:"targetKm"`
+	Status     string   `json:"status"`
+	live.Progress
+	Batches    int        `json:"batches"`
+	JobID      *int64     `json:"jobId"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
+const liveSessionColumns = `
+	l.id, l.user_id, CONCAT(u.first_name, ' ', u.last_name), l.name, l.sport_type,
+	l.visibility, l.course_id, l.target_km, l.status, l.point_count, l.distance_m,
+	l.started_at, l.last_point, l.batches, l.job_id, l.created_at, l.updated_at,
+	l.finished_at
+`
+
+func scanLiveSession(row pgx.Row) (LiveSession, error) {
+	var l LiveSession
+	var lastJSON []byte
+	err := row.Scan(
+		&l.ID, &l.UserID, &l.OwnerName, &l.Name, &l.SportType,
+		&l.Visibility, &l.CourseID, &l.TargetKM, &l.Status, &l.Points, &l.DistanceM,
+		&l.StartedAt, &lastJSON, &l.Batches, &l.JobID, &l.CreatedAt, &l.UpdatedAt,
+		&l.FinishedAt,
+	)
+	if err != nil {
+		return LiveSession{}, err
+	}
+	if lastJSON != nil {
+		if err := json.Unmarshal(lastJSON, &l.Last); err != nil {
+			return LiveSession{}, err
+		}
+	}
+	return l, nil
+}
+
+func (s *Store) CreateLiveSession(ctx context.Context, l LiveSession) (LiveSession, error) {
+	return scanLiveSession(s.pool.QueryRow(ctx, `
+		WITH l AS (
+			INSERT INTO live_sessions (user_id, name, sport_type, visibility, course_id, target_km)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING *
+		)
+		SELECT `+liveSessionColumns+` FROM l JOIN users u ON u.id = l.user_id`,
+		l.UserID, l.Name, l.SportType, l.Visibility, l.CourseID, l.TargetKM,
+	))
+}
+
+// GetLiveSession returns a session whatever its status. Callers holding a
+// session token need no owner check.
+func (s *Store) GetLiveSession(ctx context.Context, id int64) (LiveSession, error) {
+	return scanLiveSession(s.pool.QueryRow(ctx, `
+		SELECT `+liveSessionColumns+`
+		FROM live_sessions l JOIN users u ON u.id = l.user_id
+		WHERE l.id = $1
+	`, id))
+}
+
+func (s *Store) ListLiveSessions(ctx context.Context, userID int64) ([]LiveSession, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+liveSessionColumns+`
+		FROM live_sessions l JOIN users u ON u.id = l.user_id
+		WHERE l.user_id = $1
+		ORDER BY l.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]LiveSession, 0)
+	for rows.Next() {
+		l, err := scanLiveSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, l)
+	}
+	return items, rows.Err()
+}
+
+// lockActiveLiveSession locks an active session for the rest of tx.
+func lockActiveLiveSession(ctx context.Context, tx pgx.Tx, id int64) (LiveSession, error) {
+	l, err := scanLiveSession(tx.QueryRow(ctx, `
+		SELECT `+liveSessionColumns+`
+		FROM live_sessions l JOIN users u ON u.id = l.user_id
+		WHERE l.id = $1
+		FOR UPDATE OF l
+	`, id))
+	if err == nil && l.Status != LiveActive {
+		return LiveSession{}, ErrLiveSessionEnded
+	}
+	return l, err
+}
+
+// AppendLivePoints adds a batch to an active session. apply, run with the
+// session locked, returns its new progress and the points to store; a batch
+// that adds no points is not stored.
+func (s *Store) AppendLivePoints(ctx context.Context, id int64, apply func(live.Progress) (live.Progress, []gpx.Point, error)) (LiveSession, error) {
+	var l LiveSession
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		if l, err = lockActiveLiveSession(ctx, tx, id); err != nil {
+			return err
+		}
+		progress, points, err := apply(l.Progress)
+		if err != nil || len(points) == 0 {
+			return err
+		}
+		pointsJSON, err := json.Marshal(points)
+		if err != nil {
+			return err
+		}
+		lastJSON, err := json.Marshal(progress.Last)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO live_points (session_id, seq, points) VALUES ($1, $2, $3)`,
+			id, l.Batches+1, pointsJSON,
+		); err != nil {
+			return err
+		}
+		err = tx.QueryRow(ctx, `
+			UPDATE live_sessions
+			SET point_count = $2, distance_m = $3, started_at = $4, last_point = $5,
+				batches = batches + 1, updated_at = now()
+			WHERE id = $1
+			RETURNING batches, updated_at
+		`, id, progress.Points, progress.DistanceM, progress.StartedAt, lastJSON,
+		).Scan(&l.Batches, &l.UpdatedAt)
+		l.Progress = progress
+		return err
+	})
+	return l, err
+}
+
+// ListLivePoints returns the points of a session's batches after seq afterSeq,
+// in order.
+func (s *Store) ListLivePoints(ctx context.Context, id int64, afterSeq int) ([]gpx.Point, error) {
+	return listLivePoints(ctx, s.pool, id, afterSeq)
+}
+
+func listLivePoints(ctx context.Context, q rowsQuerier, id int64, afterSeq int) ([]gpx.Point, error) {
+	rows, err := q.Query(ctx,
+		`SELECT points FROM live_points WHERE session_id = $1 AND seq > $2 ORDER BY seq`,
+		id, afterSeq,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := make([]gpx.Point, 0)
+	for rows.Next() {
+		var pointsJSON []byte
+		if err := rows.Scan(&pointsJSON); err != nil {
+			return nil, err
+		}
+		var batch []gpx.Point
+		if err := json.Unmarshal(pointsJSON, &batch); err != nil {
+			return nil, err
+		}
+		points = append(points, batch...)
+	}
+	return points, rows.Err()
+}
+
+// FinishLiveSession ends an active session. export turns its points into
+// the payload and file of a job of kind, queued in the same transaction so
+// that a session is imported once; when export gives no file the session
+// is discarded instead.
+func (s *Store) FinishLiveSession(ctx context.Context, id int64, kind string, maxAttempts int, export func(LiveSession, []gpx.Point) (payload any, data []byte, err error)) (LiveSession, error) {
+	var finished LiveSession
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		l, err := lockActiveLiveSession(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		points, err := listLivePoints(ctx, tx, id, 0)
+		if err != nil {
+			return err
+		}
+		payload, data, err := export(l, points)
+		if err != nil {
+			return err
+		}
+		status := LiveDiscarded
+		var jobID *int64
+		if data != nil {
+			job, err := enqueueJob(ctx, tx, l.UserID, kind, payload, data, maxAttempts)
+			if err != nil {
+				return err
+			}
+			status, jobID = LiveFinished, &job.ID
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE live_sessions
+			SET status = $2, job_id = $3, finished_at = now(), updated_at = now()
+			WHERE id = $1
+		`, id, status, jobID); err != nil {
+			return err
+		}
+		finished, err = scanLiveSession(tx.QueryRow(ctx, `
+			SELECT `+liveSessionColumns+`
+			FROM live_sessions l JOIN users u ON u.id = l.user_id
+			WHERE l.id = $1
+		`, id))
+		return err
+	})
+	return finished, err
+}
+
+// ListIdleLiveSessions returns the ids of active sessions that have not
+// received a point since before.
+func (s *Store) ListIdleLiveSessions(ctx context.Context, before time.Time) ([]int64, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id FROM live_sessions WHERE status = 'active' AND updated_at < $1 ORDER BY id`,
+		before,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// PurgeLivePoints drops the stored batches of sessions that ended before
+// before; their activity holds the track by then.
+func (s *Store) PurgeLivePoints(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM live_points p
+		USING live_sessions l
+		WHERE l.id = p.session_id AND l.status <> 'active' AND l.finished_at < $1
+	`, before)
+	return tag.RowsAffected(), err
+}
+
+func (s *Store) DeleteLiveSession(ctx context.Context, id, userID int64) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM live_sessions WHERE id = $1 AND user_id = $2`,
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
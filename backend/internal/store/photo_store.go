@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ActivityPhoto is a photo attached to an activity. Lat and Lon are those of
+// the track point it is placed at; the EXIF position is kept for placement
+// only.
+type ActivityPhoto struct {
+	ID           int64      `json:"id"`
+	ActivityID   int64      `json:"activityId"`
+	UserID       int64      `json:"-"`
+	Caption      string     `json:"caption"`
+	ContentType  string     `json:"contentType"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	TakenAt      *time.Time `json:"takenAt"`
+	TakenZoned   bool       `json:"-"`
+	ExifLat      *float64   `json:"-"`
+	ExifLon      *float64   `json:"-"`
+	PointIndex   *int       `json:"pointIndex"`
+	Lat          *float64   `json:"lat"`
+	Lon          *float64   `json:"lon"`
+	PlacedBy     string     `json:"placedBy,omitempty"`
+	URL          string     `json:"url"`
+	ThumbnailURL string     `json:"thumbnailUrl"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+const photoColumns = `
+	id, activity_id, user_id, caption, content_type, width, height,
+	taken_at, taken_zoned, exif_lat, exif_lon, point_index, lat, lon, placed_by, created_at
+`
+
+func scanPhoto(row pgx.Row, extra ...any) (ActivityPhoto, error) {
+	var p ActivityPhoto
+	dest := []any{
+		&p.ID, &p.ActivityID, &p.UserID, &p.Caption, &p.ContentType, &p.Width, &p.Height,
+		&p.TakenAt, &p.TakenZoned, &p.ExifLat, &p.ExifLon, &p.PointIndex, &p.Lat, &p.Lon, &p.PlacedBy, &p.CreatedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return p, err
+}
+
+func (s *Store) CreateActivityPhoto(ctx context.Context, p ActivityPhoto) (ActivityPhoto, error) {
+	return scanPhoto(s.pool.QueryRow(ctx, `
+		INSERT INTO activity_photos (
+			activity_id, user_id, caption, content_type, width, height,
+			taken_at, taken_zoned, exif_lat, exif_lon, point_index, lat, lon, placed_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING `+photoColumns,
+		p.ActivityID, p.UserID, p.Caption, p.ContentType, p.Width, p.Height,
+		p.TakenAt, p.TakenZoned, p.ExifLat, p.ExifLon, p.PointIndex, p.Lat, p.Lon, p.PlacedBy,
+	))
+}
+
+func collectPhotos(rows pgx.Rows) ([]ActivityPhoto, error) {
+	defer rows.Close()
+	items := make([]ActivityPhoto, 0)
+	for rows.Next() {
+		p, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, p)
+	}
+	return items, rows.Err()
+}
+
+// ListActivityPhotos returns the photos of activities, in the order they
+// were taken along each activity.
+func (s *Store) ListActivityPhotos(ctx context.Context, activityIDs ...int64) ([]ActivityPhoto, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+photoColumns+` FROM activity_photos
+		WHERE activity_id = ANY($1)
+		ORDER BY activity_id, point_index NULLS LAST, taken_at NULLS LAST, id
+	`, activityIDs)
+	if err != nil {
+		return nil, err
+	}
+	return collectPhotos(rows)
+}
+
+// ListPhotoPreviews returns up to perActivity photos of each activity, for
+// feeds.
+func (s *Store) ListPhotoPreviews(ctx context.Context, activityIDs []int64, perActivity int) (map[int64][]ActivityPhoto, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+photoColumns+` FROM (
+			SELECT *, row_number() OVER (
+				PARTITION BY activity_id ORDER BY point_index NULLS LAST, taken_at NULLS LAST, id
+			) AS n
+			FROM activity_photos WHERE activity_id = ANY($1)
+		) p
+		WHERE n <= $2
+		ORDER BY activity_id, n
+	`, activityIDs, perActivity)
+	if err != nil {
+		return nil, err
+	}
+	photos, err := collectPhotos(rows)
+	if err != nil {
+		return nil, err
+	}
+	out := map[int64][]ActivityPhoto{}
+	for _, p := range photos {
+		out[p.ActivityID] = append(out[p.ActivityID], p)
+	}
+	return out, nil
+}
+
+func (s *Store) GetActivityPhoto(ctx context.Context, activityID, photoID int64) (ActivityPhoto, error) {
+	return scanPhoto(s.pool.QueryRow(ctx,
+		`SELECT `+photoColumns+` FROM activity_photos WHERE id = $1 AND activity_id = $2`,
+		photoID, activityID,
+	))
+}
+
+// PlacePhoto moves a photo to an activity and a point of its track; a nil
+// pointIndex leaves it unplaced.
+func (s *Store) PlacePhoto(ctx context.Context, photoID, activityID int64, pointIndex *int, lat, lon *float64, placedBy string) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE activity_photos
+		SET activity_id = $2, point_index = $3, lat = $4, lon = $5, placed_by = $6
+		WHERE id = $1
+	`, photoID, activityID, pointIndex, lat, lon, placedBy)
+	return err
+}
+
+func (s *Store) DeleteActivityPhoto(ctx context.Context, userID, activityID, photoID int64) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM activity_photos WHERE id = $1 AND activity_id = $2 AND user_id = $3`,
+		photoID, activityID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// movePhotos hands the photos of activities about to be deleted to another,
+// so that a merge, split or restore keeps them.
+func movePhotos(ctx context.Context, tx pgx.Tx, from []int64, to int64) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE activity_photos SET activity_id = $2, point_index = NULL, lat = NULL, lon = NULL, placed_by = ''
+		 WHERE activity_id = ANY($1)`,
+		from, to,
+	)
+	return err
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+// RacePrediction is a runner's fitness estimate on a day.
+type RacePrediction struct {
+	ComputedOn          string               `json:"computedOn"` // YYYY-MM-DD
+	WindowDays          int                  `json:"windowDays"`
+	VDOT                float64              `json:"vdot"`
+	Sparse              bool                 `json:"sparse"`
+	SparseReason        string               `json:"sparseReason,omitempty"`
+	ReferenceActivityID *int64               `json:"referenceActivityId,omitempty"`
+	ReferenceEffort     *metrics.Effort      `json:"referenceEffort,omitempty"`
+	Predictions         []metrics.Prediction `json:"predictions"`
+}
+
+// ListRunTracksSince returns the user's runs since a time, with tracks.
+func (s *Store) ListRunTracksSince(ctx context.Context, userID int64, since time.Time) ([]Activity, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+activityColumns+`, a.track_points
+		FROM activities a
+		WHERE a.user_id = $1 AND a.activity_date >= $2 AND a.sport_type ILIKE '%run%'
+		ORDER BY a.activity_date
+	`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activities []Activity
+	for rows.Next() {
+		a, err := scanActivityWithTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, a)
+	}
+	return activities, rows.Err()
+}
+
+// SaveRacePrediction stores the day's snapshot, replacing an earlier one
+// from the same day.
+func (s *Store) SaveRacePrediction(ctx context.Context, userID int64, p RacePrediction) error {
+	effortJSON, err := json.Marshal(p.ReferenceEffort)
+	if err != nil {
+		return err
+	}
+	predictionsJSON, err := json.Marshal(p.Predictions)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO race_predictions (
+			user_id, computed_on, window_days, vdot, sparse, sparse_reason,
+			reference_activity_id, reference_effort, predictions
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		ON CONFLICT (user_id, computed_on) DO UPDATE SET
+			window_days = EXCLUDED.window_days,
+			vdot = EXCLUDED.vdot,
+			sparse = EXCLUDED.sparse,
+			sparse_reason = EXCLUDED.sparse_reason,
+			reference_activity_id = EXCLUDED.reference_activity_id,
+			reference_effort = EXCLUDED.reference_effort,
+			predictions = EXCLUDED.predictions,
+			updated_at = now()
+	`, userID, p.ComputedOn, p.WindowDays, p.VDOT, p.Sparse, p.SparseReason,
+		p.ReferenceActivityID, effortJSON, predictionsJSON)
+	return err
+}
+
+// ListRacePredictions returns the user's snapshots in date order, optionally
+// limited to [from, to).
+func (s *Store) ListRacePredictions(ctx context.Context, userID int64, from, to *time.Time) ([]RacePrediction, error) {
+	args := []any{userID}
+	where := `user_id = $1`
+	if from != nil {
+		args = append(args, *from)
+		where += ` AND computed_on >= $` + itoa(len(args))
+	}
+	if to != nil {
+		args = append(args, *to)
+		where += ` AND computed_on < $` + itoa(len(args))
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT to_char(computed_on, 'YYYY-MM-DD'), window_days, vdot, sparse, sparse_reason,
+		       reference_activity_id, reference_effort, predictions
+		FROM race_predictions
+		WHERE `+where+`
+		ORDER BY computed_on
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []RacePrediction{}
+	for rows.Next() {
+		var p RacePrediction
+		var effortJSON, predictionsJSON []byte
+		if err := rows.Scan(&p.ComputedOn, &p.WindowDays, &p.VDOT, &p.Sparse, &p.SparseReason,
+			&p.ReferenceActivityID, &effortJSON, &predictionsJSON); err != nil {
+			return nil, err
+		}
+		if effortJSON != nil {
+			if err := json.Unmarshal(effortJSON, &p.ReferenceEffort); err != nil {
+				return nil, err
+			}
+		}
+		if err := json.Unmarshal(predictionsJSON, &p.Predictions); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
@@ -33,6 +33,11 @@ type AthleteProfile struct {
 	TwitterURL   string `json:"twitterUrl"`
 	YoutubeURL   string `json:"youtubeUrl"`
 	LinkedinURL  string `json:"linkedinUrl"`
+	// Visibility applied to newly uploaded activities.
+	DefaultActivityVisibility string    `json:"defaultActivityVisibility"`
+	// Display units: "metric" | "imperial", and "pace" | "speed" per sport type.
+	UnitSystem   string            `json:"unitSystem"`
+	SportDisplay map[string]string `json:"sportDisplay"`
 	CreatedAt    time.Time `json:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt"`
 }
@@ -62,6 +67,9 @@ func (s *Store) GetProfile(ctx context.Context, userID int64) (AthleteProfile, e
 			COALESCE(ap.twitter_url, ''),
 			COALESCE(ap.youtube_url, ''),
 			COALESCE(ap.linkedin_url, ''),
+			ap.default_activity_visibility,
+			ap.unit_system,
+			ap.sport_display,
 			ap.created_at,
 			ap.updated_at
 		FROM athlete_profiles ap
@@ -79,6 +87,8 @@ func (s *Store) GetProfile(ctx context.Context, userID int64) (AthleteProfile, e
 		&p.AvatarURL, &p.SportPhotoURL,
 		&p.WebsiteURL, &p.StravaURL, &p.InstagramURL,
 		&p.TwitterURL, &p.YoutubeURL, &p.LinkedinURL,
+		&p.DefaultActivityVisibility,
+		&p.UnitSystem, &p.SportDisplay,
 		&p.CreatedAt, &p.UpdatedAt,
 	)
 	if err != nil {
@@ -94,6 +104,9 @@ func (s *Store) GetProfile(ctx context.Context, userID int64) (AthleteProfile, e
 	if p.SecondarySports == nil {
 		p.SecondarySports = []string{}
 	}
+	if p.SportDisplay == nil {
+		p.SportDisplay = map[string]string{}
+	}
 	return p, nil
 }
 
@@ -106,6 +119,9 @@ func (s *Store) defaultProfile(ctx context.Context, userID int64) (AthleteProfil
 		ExperienceLevel: "intermediate",
 		SecondarySports: []string{},
 		AvatarURL:       avatarURL,
+		DefaultActivityVisibility: VisibilityPrivate,
+		UnitSystem:      "metric",
+		SportDisplay:    map[string]string{},
 	}, nil
 }
 
@@ -125,6 +141,15 @@ func (s *Store) UpsertProfile(ctx context.Context, userID int64, p AthleteProfil
 	if p.ExperienceLevel == "" {
 		p.ExperienceLevel = "intermediate"
 	}
+	if p.DefaultActivityVisibility == "" {
+		p.DefaultActivityVisibility = VisibilityPrivate
+	}
+	if p.UnitSystem == "" {
+		p.UnitSystem = "metric"
+	}
+	if p.SportDisplay == nil {
+		p.SportDisplay = map[string]string{}
+	}
 
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
@@ -137,8 +162,9 @@ func (s *Store) UpsertProfile(ctx context.Context, userID int64, p AthleteProfil
 			user_id, bio, phone, date_of_birth, gender, country, city,
 			height_cm, weight_kg, primary_sport, secondary_sports,
 			experience_level, weekly_goal_hours, sport_photo_url,
-			website_url, strava_url, instagram_url, twitter_url, youtube_url, linkedin_url
-		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20)
+			website_url, strava_url, instagram_url, twitter_url, youtube_url, linkedin_url,
+			default_activity_visibility, unit_system, sport_display
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23)
 		ON CONFLICT (user_id) DO UPDATE SET
 			bio               = EXCLUDED.bio,
 			phone             = EXCLUDED.phone,
@@ -159,17 +185,38 @@ func (s *Store) UpsertProfile(ctx context.Context, userID int64, p AthleteProfil
 			twitter_url       = EXCLUDED.twitter_url,
 			youtube_url       = EXCLUDED.youtube_url,
 			linkedin_url      = EXCLUDED.linkedin_url,
+			default_activity_visibility = EXCLUDED.default_activity_visibility,
+			unit_system       = EXCLUDED.unit_system,
+			sport_display     = EXCLUDED.sport_display,
 			updated_at        = now()
 	`,
 		userID, p.Bio, p.Phone, dob, p.Gender, p.Country, p.City,
 		p.Height, p.Weight, p.PrimarySport, p.SecondarySports,
 		p.ExperienceLevel, p.WeeklyGoalHours, p.SportPhotoURL,
 		p.WebsiteURL, p.StravaURL, p.InstagramURL, p.TwitterURL, p.YoutubeURL, p.LinkedinURL,
+		p.DefaultActivityVisibility, p.UnitSystem, p.SportDisplay,
 	)
 	if err != nil {
 		return AthleteProfile{}, err
 	}
 
+	// A changed weight starts a new entry in the weight history.
+	if p.Weight != nil && *p.Weight > 0 {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO weight_history (user_id, recorded_on, weight_kg)
+			SELECT $1, CURRENT_DATE, $2
+			WHERE $2::numeric(5,1) IS DISTINCT FROM (
+				SELECT weight_kg FROM weight_history
+				WHERE user_id = $1 AND recorded_on <= CURRENT_DATE
+				ORDER BY recorded_on DESC LIMIT 1
+			)
+			ON CONFLICT (user_id, recorded_on) DO UPDATE SET weight_kg = EXCLUDED.weight_kg
+		`, userID, *p.Weight)
+		if err != nil {
+			return AthleteProfile{}, err
+		}
+	}
+
 	// Sync avatar to users table if provided
 	if strings.TrimSpace(p.AvatarURL) != "" {
 		_, _ = tx.Exec(ctx, `UPDATE users SET avatar_url = $1 WHERE id = $2`, p.AvatarURL, userID)
@@ -0,0 +1,279 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+	"gpx-training-analyzer/backend/internal/gpx"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Route is a cluster of a user's activities that follow the same path.
+type Route struct {
+	ID              int64       `json:"id"`
+	UserID          int64       `json:"userId"`
+	Name            string      `json:"name"`
+	SportType       string      `json:"sportType"`
+	DistanceKM      float64     `json:"distanceKm"`
+	Start           geo.Coord   `json:"start"`
+	End             geo.Coord   `json:"end"`
+	Shape           []geo.Coord `json:"shape"`
+	EffortCount     int         `json:"effortCount"`
+	BestDurationSec *int        `json:"bestDurationSec"`
+	LastEffortAt    *time.Time  `json:"lastEffortAt"`
+	CreatedAt       time.Time   `json:"createdAt"`
+	UpdatedAt       time.Time   `json:"updatedAt"`
+}
+
+// RouteEffort is one activity on a route. Rank is 1 for the fastest effort.
+type RouteEffort struct {
+	ActivityID   int64     `json:"activityId"`
+	Name         string    `json:"name"`
+	ActivityDate time.Time `json:"activityDate"`
+	DurationSec  int       `json:"durationSec"`
+	DistanceKM   float64   `json:"distanceKm"`
+	PaceMinPerKM float64   `json:"paceMinPerKm"`
+	AvgHR        float64   `json:"avgHr"`
+	Rank         int       `json:"rank"`
+}
+
+// RouteCandidate is an activity not yet assigned to a route that may form a
+// new route together with a freshly uploaded activity.
+type RouteCandidate struct {
+	ActivityID int64
+	Name       string
+	Points     []gpx.Point
+}
+
+const routeColumns = `
+	r.id, r.user_id, r.name, r.sport_type, r.distance_km,
+	r.start_lat, r.start_lon, r.end_lat, r.end_lon, r.shape,
+	(SELECT COUNT(*) FROM activities e WHERE e.route_id = r.id),
+	(SELECT MIN(e.duration_sec) FROM activities e WHERE e.route_id = r.id AND e.duration_sec > 0),
+	(SELECT MAX(e.activity_date) FROM activities e WHERE e.route_id = r.id),
+	r.created_at, r.updated_at
+`
+
+func scanRoute(row pgx.Row) (Route, error) {
+	var r Route
+	var shapeJSON []byte
+	err := row.Scan(
+		&r.ID, &r.UserID, &r.Name, &r.SportType, &r.DistanceKM,
+		&r.Start.Lat, &r.Start.Lon, &r.End.Lat, &r.End.Lon, &shapeJSON,
+		&r.EffortCount, &r.BestDurationSec, &r.LastEffortAt,
+		&r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return Route{}, err
+	}
+	if err := json.Unmarshal(shapeJSON, &r.Shape); err != nil {
+		return Route{}, err
+	}
+	return r, nil
+}
+
+func (s *Store) ListRoutes(ctx context.Context, userID int64) ([]Route, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+routeColumns+`
+		FROM routes r
+		WHERE r.user_id = $1
+		ORDER BY r.updated_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]Route, 0)
+	for rows.Next() {
+		r, err := scanRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, r)
+	}
+	return items, rows.Err()
+}
+
+func (s *Store) GetRoute(ctx context.Context, id, userID int64) (Route, error) {
+	return scanRoute(s.pool.QueryRow(ctx,
+		`SELECT `+routeColumns+` FROM routes r WHERE r.id = $1 AND r.user_id = $2`,
+		id, userID,
+	))
+}
+
+func (s *Store) RenameRoute(ctx context.Context, id, userID int64, name string) (Route, error) {
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE routes SET name = $1, updated_at = now() WHERE id = $2 AND user_id = $3`,
+		name, id, userID,
+	)
+	if err != nil {
+		return Route{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return Route{}, ErrNotFound
+	}
+	return s.GetRoute(ctx, id, userID)
+}
+
+// ListRouteEfforts returns the efforts on a route in chronological order.
+func (s *Store) ListRouteEfforts(ctx context.Context, routeID, userID int64) ([]RouteEffort, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, activity_name, activity_date, duration_sec, distance_km, pace_min_km, avg_hr,
+			RANK() OVER (ORDER BY CASE WHEN duration_sec > 0 THEN duration_sec END ASC NULLS LAST)
+		FROM activities
+		WHERE route_id = $1 AND user_id = $2
+		ORDER BY activity_date ASC
+	`, routeID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]RouteEffort, 0)
+	for rows.Next() {
+		var e RouteEffort
+		if err := rows.Scan(&e.ActivityID, &e.Name, &e.ActivityDate, &e.DurationSec,
+			&e.DistanceKM, &e.PaceMinPerKM, &e.AvgHR, &e.Rank); err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+// ListRouteCandidates returns the user's routes for sportType that start near
+// start and have a similar length.
+func (s *Store) ListRouteCandidates(ctx context.Context, userID int64, sportType string, start geo.Coord, distanceKM float64, toleranceM float64) ([]Route, error) {
+	box := geo.Bounds([]geo.Coord{start}).Expand(toleranceM)
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+routeColumns+`
+		FROM routes r
+		WHERE r.user_id = $1 AND r.sport_type = $2
+		  AND r.start_lat BETWEEN $3 AND $4
+		  AND r.start_lon BETWEEN $5 AND $6
+		  AND r.distance_km BETWEEN $7 * 0.8 AND $7 * 1.25
+		LIMIT 50
+	`, userID, sportType, box.MinLat, box.MaxLat, box.MinLon, box.MaxLon, distanceKM)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]Route, 0)
+	for rows.Next() {
+		r, err := scanRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, r)
+	}
+	return items, rows.Err()
+}
+
+// ListUnroutedCandidates returns the user's activities of sportType that are
+// not on a route yet, start near start and have a similar length.
+func (s *Store) ListUnroutedCandidates(ctx context.Context, userID, excludeID int64, sportType string, start geo.Coord, distanceKM float64, toleranceM float64) ([]RouteCandidate, error) {
+	box := geo.Bounds([]geo.Coord{start}).Expand(toleranceM)
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, activity_name, track_points
+		FROM activities
+		WHERE user_id = $1 AND id <> $2 AND sport_type = $3 AND route_id IS NULL
+		  AND distance_km BETWEEN $8 * 0.8 AND $8 * 1.25
+		  AND (track_points->0->>'lat')::float8 BETWEEN $4 AND $5
+		  AND (track_points->0->>'lon')::float8 BETWEEN $6 AND $7
+		ORDER BY activity_date DESC
+		LIMIT 20
+	`, userID, excludeID, sportType, box.MinLat, box.MaxLat, box.MinLon, box.MaxLon, distanceKM)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]RouteCandidate, 0)
+	for rows.Next() {
+		var c RouteCandidate
+		var trackJSON []byte
+		if err := rows.Scan(&c.ActivityID, &c.Name, &trackJSON); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(trackJSON, &c.Points); err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+// CreateRoute stores a new route and assigns the given activities to it.
+func (s *Store) CreateRoute(ctx context.Context, userID int64, r Route, activityIDs []int64) (Route, error) {
+	shapeJSON, err := json.Marshal(r.Shape)
+	if err != nil {
+		return Route{}, err
+	}
+	var id int64
+	err = s.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO routes (user_id, name, sport_type, distance_km, start_lat, start_lon, end_lat, end_lon, shape)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id
+		`, userID, r.Name, r.SportType, r.DistanceKM,
+			r.Start.Lat, r.Start.Lon, r.End.Lat, r.End.Lon, shapeJSON,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx,
+			`UPDATE activities SET route_id = $1 WHERE id = ANY($2) AND user_id = $3`,
+			id, activityIDs, userID,
+		)
+		return err
+	})
+	if err != nil {
+		return Route{}, err
+	}
+	return s.GetRoute(ctx, id, userID)
+}
+
+func (s *Store) AssignActivityRoute(ctx context.Context, activityID, routeID int64) error {
+	return s.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `UPDATE activities SET route_id = $1 WHERE id = $2`, routeID, activityID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `UPDATE routes SET updated_at = now() WHERE id = $1`, routeID)
+		return err
+	})
+}
+
+// ListUnroutedActivityIDs returns the user's activities without a route,
+// oldest first, for back-filling routes from history.
+func (s *Store) ListUnroutedActivityIDs(ctx context.Context, userID int64) ([]int64, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id FROM activities WHERE user_id = $1 AND route_id IS NULL ORDER BY activity_date ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetActivityRouteID returns the route an activity is on, if any.
+func (s *Store) GetActivityRouteID(ctx context.Context, activityID int64) (*int64, error) {
+	var routeID *int64
+	err := s.pool.QueryRow(ctx, `SELECT route_id FROM activities WHERE id = $1`, activityID).Scan(&routeID)
+	return routeID, err
+}
@@ -0,0 +1,344 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/geo"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Segment is a stretch of road or trail defined by an athlete from one of
+// their activities. Segments are shared with everyone allowed to see the
+// source activity, and those members' uploads are matched against them.
+type Segment struct {
+	ID               int64       `json:"id"`
+	UserID           int64       `json:"userId"`
+	CreatorName      string      `json:"creatorName"`
+	SourceActivityID *int64      `json:"sourceActivityId"`
+	Name             string      `json:"name"`
+	SportType        string      `json:"sportType"`
+	DistanceM        float64     `json:"distanceM"`
+	ElevGainM        float64     `json:"elevGainM"`
+	Start            geo.Coord   `json:"start"`
+	End              geo.Coord   `json:"end"`
+	Path             []geo.Coord `json:"path"`
+	EffortCount      int         `json:"effortCount"`
+	CreatedAt        time.Time   `json:"createdAt"`
+}
+
+// SegmentEffort is one pass over a segment within an activity.
+type SegmentEffort struct {
+	ID           int64     `json:"id"`
+	SegmentID    int64     `json:"segmentId"`
+	SegmentName  string    `json:"segmentName,omitempty"`
+	ActivityID   int64     `json:"activityId"`
+	UserID       int64     `json:"userId"`
+	ElapsedSec   float64   `json:"elapsedSec"`
+	StartIndex   int       `json:"startIndex"`
+	EndIndex     int       `json:"endIndex"`
+	ActivityDate time.Time `json:"activityDate"`
+}
+
+// LeaderboardEntry is an athlete's best effort on a segment.
+type LeaderboardEntry struct {
+	Rank         int       `json:"rank"`
+	UserID       int64     `json:"userId"`
+	AthleteName  string    `json:"athleteName"`
+	ActivityID   int64     `json:"activityId"`
+	ElapsedSec   float64   `json:"elapsedSec"`
+	ActivityDate time.Time `json:"activityDate"`
+}
+
+// LeaderboardFilter narrows a segment leaderboard. Zero values do not filter.
+type LeaderboardFilter struct {
+	Gender      string
+	MinAge      int
+	MaxAge      int   // inclusive; 0 means no upper bound
+	FollowersOf int64 // restrict to this user and the athletes they follow
+}
+
+// ActivityRef identifies an activity together with its owner.
+type ActivityRef struct {
+	ID     int64
+	UserID int64
+}
+
+const segmentColumns = `
+	s.id, s.user_id, u.first_name || ' ' || u.last_name, s.source_activity_id,
+	s.name, s.sport_type, s.distance_m, s.elev_gain_m,
+	s.start_lat, s.start_lon, s.end_lat, s.end_lon, s.path,
+	(SELECT COUNT(*) FROM segment_efforts e WHERE e.segment_id = s.id),
+	s.created_at
+`
+
+func scanSegment(row pgx.Row) (Segment, error) {
+	var sg Segment
+	var pathJSON []byte
+	err := row.Scan(
+		&sg.ID, &sg.UserID, &sg.CreatorName, &sg.SourceActivityID,
+		&sg.Name, &sg.SportType, &sg.DistanceM, &sg.ElevGainM,
+		&sg.Start.Lat, &sg.Start.Lon, &sg.End.Lat, &sg.End.Lon, &pathJSON,
+		&sg.EffortCount, &sg.CreatedAt,
+	)
+	if err != nil {
+		return Segment{}, err
+	}
+	if err := json.Unmarshal(pathJSON, &sg.Path); err != nil {
+		return Segment{}, err
+	}
+	return sg, nil
+}
+
+func collectSegments(rows pgx.Rows) ([]Segment, error) {
+	defer rows.Close()
+	items := make([]Segment, 0)
+	for rows.Next() {
+		sg, err := scanSegment(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, sg)
+	}
+	return items, rows.Err()
+}
+
+func (s *Store) CreateSegment(ctx context.Context, sg Segment) (Segment, error) {
+	pathJSON, err := json.Marshal(sg.Path)
+	if err != nil {
+		return Segment{}, err
+	}
+	bounds := boxArgs(sg.Path)
+	var id int64
+	err = s.pool.QueryRow(ctx, `
+		INSERT INTO segments (
+			user_id, source_activity_id, name, sport_type, distance_m, elev_gain_m,
+			start_lat, start_lon, end_lat, end_lon, path, bounds
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, box(point($12, $13), point($14, $15)))
+		RETURNING id
+	`, sg.UserID, sg.SourceActivityID, sg.Name, sg.SportType, sg.DistanceM, sg.ElevGainM,
+		sg.Start.Lat, sg.Start.Lon, sg.End.Lat, sg.End.Lon, pathJSON,
+		bounds[0], bounds[1], bounds[2], bounds[3],
+	).Scan(&id)
+	if err != nil {
+		return Segment{}, err
+	}
+	return s.GetSegment(ctx, id, sg.UserID)
+}
+
+// segmentVisibleToSQL returns a SQL predicate that is true when the segment
+// aliased as s may be read by the viewer bound to viewerArg. Segments inherit
+// the visibility of the activity they were cut from; once that activity is
+// deleted only the creator sees them.
+func segmentVisibleToSQL(viewerArg string) string {
+	return `(s.user_id = ` + viewerArg + ` OR EXISTS (
+		SELECT 1 FROM activities sa
+		WHERE sa.id = s.source_activity_id AND ` + visibleToSQL("sa", viewerArg) + `))`
+}
+
+// GetSegment returns a segment if viewerID is allowed to read it. Segments
+// the viewer cannot see are reported as ErrNotFound.
+func (s *Store) GetSegment(ctx context.Context, id, viewerID int64) (Segment, error) {
+	return scanSegment(s.pool.QueryRow(ctx, `
+		SELECT `+segmentColumns+`
+		FROM segments s JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND `+segmentVisibleToSQL("$2"),
+		id, viewerID))
+}
+
+// ListSegments returns the segments visible to the user overlapping box, or,
+// when box is nil, the ones the user created or has an effort on.
+func (s *Store) ListSegments(ctx context.Context, userID int64, box *geo.BBox) ([]Segment, error) {
+	if box != nil {
+		rows, err := s.pool.Query(ctx, `
+			SELECT `+segmentColumns+`
+			FROM segments s JOIN users u ON u.id = s.user_id
+			WHERE s.bounds && box(point($1, $2), point($3, $4)) AND `+segmentVisibleToSQL("$5")+`
+			ORDER BY s.created_at DESC
+			LIMIT 200
+		`, box.MinLon, box.MinLat, box.MaxLon, box.MaxLat, userID)
+		if err != nil {
+			return nil, err
+		}
+		return collectSegments(rows)
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+segmentColumns+`
+		FROM segments s JOIN users u ON u.id = s.user_id
+		WHERE (s.user_id = $1
+		   OR EXISTS (SELECT 1 FROM segment_efforts e WHERE e.segment_id = s.id AND e.user_id = $1))
+		  AND `+segmentVisibleToSQL("$1")+`
+		ORDER BY s.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return collectSegments(rows)
+}
+
+// ListSegmentsInBounds returns segments for sportType visible to viewerID
+// whose bounding box overlaps box. It is served by the GiST index on
+// segments.bounds.
+func (s *Store) ListSegmentsInBounds(ctx context.Context, box geo.BBox, sportType string, viewerID int64) ([]Segment, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+segmentColumns+`
+		FROM segments s JOIN users u ON u.id = s.user_id
+		WHERE s.bounds && box(point($1, $2), point($3, $4)) AND s.sport_type = $5
+		  AND `+segmentVisibleToSQL("$6"),
+		box.MinLon, box.MinLat, box.MaxLon, box.MaxLat, sportType, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	return collectSegments(rows)
+}
+
+// ListActivitiesInBounds returns activities for the segment's sport whose
+// bounding box overlaps box and whose owner may see the segment, for matching
+// a new segment against existing uploads.
+func (s *Store) ListActivitiesInBounds(ctx context.Context, box geo.BBox, segmentID int64) ([]ActivityRef, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT act.id, act.user_id
+		FROM activities act JOIN segments s ON s.id = $5
+		WHERE act.bounds && box(point($1, $2), point($3, $4))
+		  AND act.sport_type = s.sport_type AND act.user_id IS NOT NULL
+		  AND `+segmentVisibleToSQL("act.user_id"),
+		box.MinLon, box.MinLat, box.MaxLon, box.MaxLat, segmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]ActivityRef, 0)
+	for rows.Next() {
+		var ref ActivityRef
+		if err := rows.Scan(&ref.ID, &ref.UserID); err != nil {
+			return nil, err
+		}
+		items = append(items, ref)
+	}
+	return items, rows.Err()
+}
+
+func (s *Store) DeleteSegment(ctx context.Context, id, userID int64) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM segments WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SaveSegmentEffort records the effort of an activity on a segment, keeping
+// the fastest one if the activity passes the segment more than once.
+func (s *Store) SaveSegmentEffort(ctx context.Context, e SegmentEffort) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO segment_efforts (segment_id, activity_id, user_id, elapsed_sec, start_index, end_index, activity_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (segment_id, activity_id) DO UPDATE
+		SET elapsed_sec = EXCLUDED.elapsed_sec,
+		    start_index = EXCLUDED.start_index,
+		    end_index   = EXCLUDED.end_index
+		WHERE EXCLUDED.elapsed_sec < segment_efforts.elapsed_sec
+	`, e.SegmentID, e.ActivityID, e.UserID, e.ElapsedSec, e.StartIndex, e.EndIndex, e.ActivityDate)
+	return err
+}
+
+// ListActivitySegmentEfforts returns the segment efforts found in an activity.
+func (s *Store) ListActivitySegmentEfforts(ctx context.Context, activityID int64) ([]SegmentEffort, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT e.id, e.segment_id, s.name, e.activity_id, e.user_id, e.elapsed_sec, e.start_index, e.end_index, e.activity_date
+		FROM segment_efforts e JOIN segments s ON s.id = e.segment_id
+		WHERE e.activity_id = $1
+		ORDER BY e.start_index
+	`, activityID)
+	if err != nil {
+		return nil, err
+	}
+	return collectSegmentEfforts(rows)
+}
+
+// ListUserSegmentEfforts returns a user's efforts on a segment, fastest first.
+func (s *Store) ListUserSegmentEfforts(ctx context.Context, segmentID, userID int64) ([]SegmentEffort, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT e.id, e.segment_id, '', e.activity_id, e.user_id, e.elapsed_sec, e.start_index, e.end_index, e.activity_date
+		FROM segment_efforts e
+		WHERE e.segment_id = $1 AND e.user_id = $2
+		ORDER BY e.elapsed_sec ASC
+	`, segmentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return collectSegmentEfforts(rows)
+}
+
+func collectSegmentEfforts(rows pgx.Rows) ([]SegmentEffort, error) {
+	defer rows.Close()
+	items := make([]SegmentEffort, 0)
+	for rows.Next() {
+		var e SegmentEffort
+		if err := rows.Scan(&e.ID, &e.SegmentID, &e.SegmentName, &e.ActivityID, &e.UserID,
+			&e.ElapsedSec, &e.StartIndex, &e.EndIndex, &e.ActivityDate); err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+// SegmentLeaderboard ranks each athlete's best effort on a segment. Only
+// efforts on activities the viewer is allowed to see are counted.
+func (s *Store) SegmentLeaderboard(ctx context.Context, segmentID, viewerID int64, f LeaderboardFilter, limit int) ([]LeaderboardEntry, error) {
+	args := []any{segmentID, viewerID}
+	where := `e.segment_id = $1 AND ` + visibleToSQL("a", "$2")
+	if f.Gender != "" {
+		args = append(args, f.Gender)
+		where += ` AND LOWER(ap.gender) = LOWER($` + itoa(len(args)) + `)`
+	}
+	if f.MinAge > 0 {
+		args = append(args, f.MinAge)
+		where += ` AND date_part('year', age(ap.date_of_birth)) >= $` + itoa(len(args))
+	}
+	if f.MaxAge > 0 {
+		args = append(args, f.MaxAge)
+		where += ` AND date_part('year', age(ap.date_of_birth)) <= $` + itoa(len(args))
+	}
+	if f.FollowersOf > 0 {
+		args = append(args, f.FollowersOf)
+		n := itoa(len(args))
+		where += ` AND (e.user_id = $` + n + ` OR e.user_id IN (SELECT followee_id FROM follows WHERE follower_id = $` + n + `))`
+	}
+	args = append(args, limit)
+
+	rows, err := s.pool.Query(ctx, `
+		WITH best AS (
+			SELECT DISTINCT ON (e.user_id) e.user_id, e.activity_id, e.elapsed_sec, e.activity_date
+			FROM segment_efforts e
+			JOIN activities a ON a.id = e.activity_id
+			LEFT JOIN athlete_profiles ap ON ap.user_id = e.user_id
+			WHERE `+where+`
+			ORDER BY e.user_id, e.elapsed_sec ASC, e.activity_date ASC
+		)
+		SELECT RANK() OVER (ORDER BY b.elapsed_sec), b.user_id, u.first_name || ' ' || u.last_name,
+			b.activity_id, b.elapsed_sec, b.activity_date
+		FROM best b JOIN users u ON u.id = b.user_id
+		ORDER BY b.elapsed_sec ASC, b.activity_date ASC
+		LIMIT $`+itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]LeaderboardEntry, 0)
+	for rows.Next() {
+		var le LeaderboardEntry
+		if err := rows.Scan(&le.Rank, &le.UserID, &le.AthleteName, &le.ActivityID, &le.ElapsedSec, &le.ActivityDate); err != nil {
+			return nil, err
+		}
+		items = append(items, le)
+	}
+	return items, rows.Err()
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"time"
 
+	"gpx-training-analyzer/backend/internal/geo"
 	"gpx-training-analyzer/backend/internal/gpx"
 	"gpx-training-analyzer/backend/internal/metrics"
 
@@ -24,6 +25,13 @@ type Activity struct {
 	Name         string         `json:"name"`
 	ActivityDate time.Time      `json:"activityDate"`
 	Metrics      metrics.Result `json:"metrics"`
+	GearID       *int64         `json:"gearId,omitempty"`
+	RouteID      *int64         `json:"routeId,omitempty"`
+	Visibility   string         `json:"visibility"`
+	Source       string         `json:"source"` // "file" | "manual"
+	Notes        string         `json:"notes,omitempty"`
+	Crop         *metrics.Crop  `json:"crop,omitempty"`
+	FileHash     string         `json:"-"` // SHA-256 of the uploaded file
 	Points       []gpx.Point    `json:"points"`
 	CreatedAt    time.Time      `json:"createdAt"`
 }
@@ -33,6 +41,9 @@ var (
 	errTokenExpired     = errors.New("token expired")
 	ErrTokenAlreadyUsed = errTokenAlreadyUsed
 	ErrTokenExpired     = errTokenExpired
+	// ErrNotFound is returned when a row does not exist or is not owned by
+	// the requesting user. It aliases pgx.ErrNoRows so either can be matched.
+	ErrNotFound = pgx.ErrNoRows
 )
 
 type Store struct {
@@ -129,99 +140,212 @@ func newPaginated[T any](items []T, total, page, pageSize int) PaginatedResult[T
 	}
 }
 
-func (s *Store) CreateActivity(ctx context.Context, userID int64, fileName, sportType string, parsed gpx.ParsedActivity, m metrics.Result) (Activity, error) {
-	pointsJSON, err := json.Marshal(parsed.Points)
+// Activity sources.
+const (
+	SourceFile   = "file"
+	SourceManual = "manual"
+)
+
+// rowQuerier is satisfied by both the pool and a transaction.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// rowsQuerier is the multi-row counterpart of rowQuerier.
+type rowsQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// CreateActivity stores an activity read from a file. Gear, if set, must be
+// the user's own.
+func (s *Store) CreateActivity(ctx context.Context, userID int64, a Activity) (Activity, error) {
+	a.Source = SourceFile
+	if a.GearID == nil {
+		return insertActivity(ctx, s.pool, userID, a)
+	}
+	var created Activity
+	err := s.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		created, err = insertActivity(ctx, tx, userID, a)
+		if err != nil {
+			return err
+		}
+		return refreshGearTotals(ctx, tx, *a.GearID)
+	})
+	return created, err
+}
+
+// FindDuplicateActivity returns the id of a file activity of the user with
+// the same file hash or, when start is known, the same start time.
+func (s *Store) FindDuplicateActivity(ctx context.Context, userID int64, fileHash string, start *time.Time) (int64, error) {
+	var id int64
+	err := s.pool.QueryRow(ctx, `
+		SELECT id FROM activities
+		WHERE user_id = $1 AND (file_sha256 = $2 OR (source = 'file' AND activity_date = $3))
+		ORDER BY id LIMIT 1
+	`, userID, fileHash, start).Scan(&id)
+	return id, err
+}
+
+// CreateManualActivity stores an activity entered by hand. It has no track.
+// An empty visibility defaults to the owner's profile preference.
+func (s *Store) CreateManualActivity(ctx context.Context, userID int64, sportType, name, notes, visibility string, m metrics.Result) (Activity, error) {
+	return insertActivity(ctx, s.pool, userID, Activity{
+		SportType:  sportType,
+		Name:       name,
+		Notes:      notes,
+		Visibility: visibility,
+		Source:     SourceManual,
+		Metrics:    m,
+		Points:     []gpx.Point{},
+	})
+}
+
+// insertActivity stores a new activity for userID from the descriptive
+// fields, gear, metrics and track of a. A non-zero a.ID is kept, so archived
+// activities come back under their old id; an empty visibility defaults to
+// the owner's profile preference.
+func insertActivity(ctx context.Context, q rowQuerier, userID int64, a Activity) (Activity, error) {
+	pointsJSON, err := json.Marshal(a.Points)
 	if err != nil {
 		return Activity{}, err
 	}
+	var id *int64
+	if a.ID != 0 {
+		id = &a.ID
+	}
+
+	bounds := boxArgs(geo.FromPoints(a.Points))
+	m := a.Metrics
 
 	query := `
 		INSERT INTO activities (
-			user_id,
+			id, user_id, gear_id,
 			file_name, sport_type, activity_name, activity_date,
 			distance_km, duration_sec, avg_speed_kmh, max_speed_kmh, pace_min_km,
 			elev_gain_m, elev_loss_m, max_elev_m, min_elev_m,
-			avg_hr, max_hr, avg_cadence, track_points
+			avg_hr, max_hr, avg_cadence, track_points, visibility, bounds,
+			avg_power_w, normalized_power_w, efficiency_factor, decoupling_pct, decoupling_type, steady,
+			aerobic_computed, calories_kcal, calories_method,
+			source, notes, file_sha256
 		) VALUES (
-			$1,$2,$3,$4,$5,
+			COALESCE($33::bigint, nextval(pg_get_serial_sequence('activities', 'id'))), $1, $35,
+			$2,$3,$4,$5,
 			$6,$7,$8,$9,$10,
 			$11,$12,$13,$14,
-			$15,$16,$17,$18
+			$15,$16,$17,$18,
+			COALESCE(NULLIF($34, ''), (SELECT default_activity_visibility FROM athlete_profiles WHERE user_id = $1), 'private'),
+			box(point($19, $20), point($21, $22)),
+			$23,$24,$25,$26,$27,$28,
+			true, $29, $30,
+			$31, $32, NULLIF($36, '')
 		)
-		RETURNING id, created_at
+		RETURNING id, visibility, created_at
 	`
 
-	var id int64
-	var createdAt time.Time
-	err = s.pool.QueryRow(ctx, query,
-		userID, fileName, sportType, parsed.Name, m.ActivityDate,
+	err = q.QueryRow(ctx, query,
+		userID, a.FileName, a.SportType, a.Name, m.ActivityDate,
 		m.DistanceKM, m.DurationSec, m.AvgSpeedKMH, m.MaxSpeedKMH, m.PaceMinPerKM,
 		m.ElevGainM, m.ElevLossM, m.MaxElevM, m.MinElevM,
 		m.AvgHR, m.MaxHR, m.AvgCadence, pointsJSON,
-	).Scan(&id, &createdAt)
+		bounds[0], bounds[1], bounds[2], bounds[3],
+		m.AvgPowerW, m.NormalizedPowerW, m.EfficiencyFactor, m.DecouplingPct, m.DecouplingType, m.Steady,
+		m.CaloriesKcal, m.CaloriesMethod,
+		a.Source, a.Notes,
+		id, a.Visibility, a.GearID, a.FileHash,
+	).Scan(&a.ID, &a.Visibility, &a.CreatedAt)
 	if err != nil {
 		return Activity{}, err
 	}
-
-	return Activity{
-		ID:           id,
-		UserID:       &userID,
-		FileName:     fileName,
-		SportType:    sportType,
-		Name:         parsed.Name,
-		ActivityDate: m.ActivityDate,
-		Metrics:      m,
-		Points:       parsed.Points,
-		CreatedAt:    createdAt,
-	}, nil
+	a.UserID = &userID
+	a.ActivityDate = m.ActivityDate
+	return a, nil
 }
 
-func (s *Store) GetActivity(ctx context.Context, id, userID int64) (Activity, error) {
-	query := `
-		SELECT id, user_id, file_name, sport_type, activity_name, activity_date,
-			distance_km, duration_sec, avg_speed_kmh, max_speed_kmh, pace_min_km,
-			elev_gain_m, elev_loss_m, max_elev_m, min_elev_m,
-			avg_hr, max_hr, avg_cadence, track_points, created_at
-		FROM activities
-		WHERE id = $1 AND user_id = $2
-	`
+// activityColumns lists the summary columns of an activity, read with
+// scanActivity. Queries must alias the activities table as "a".
+const activityColumns = `
+	a.id, a.user_id, a.file_name, a.sport_type, a.activity_name, a.activity_date,
+	a.distance_km, a.duration_sec, a.avg_speed_kmh, a.max_speed_kmh, a.pace_min_km,
+	a.elev_gain_m, a.elev_loss_m, a.max_elev_m, a.min_elev_m,
+	a.avg_hr, a.max_hr, a.avg_cadence,
+	a.avg_power_w, a.normalized_power_w, a.efficiency_factor, a.decoupling_pct, a.decoupling_type, a.steady,
+	a.calories_kcal, a.calories_method,
+	a.gear_id, a.route_id, a.visibility, a.source, a.notes, a.crop, a.created_at
+`
+
+// scanActivity scans activityColumns followed by any extra destinations.
+func scanActivity(row pgx.Row, extra ...any) (Activity, error) {
+	var a Activity
+	dest := []any{
+		&a.ID,
+		&a.UserID,
+		&a.FileName,
+		&a.SportType,
+		&a.Name,
+		&a.ActivityDate,
+		&a.Metrics.DistanceKM,
+		&a.Metrics.DurationSec,
+		&a.Metrics.AvgSpeedKMH,
+		&a.Metrics.MaxSpeedKMH,
+		&a.Metrics.PaceMinPerKM,
+		&a.Metrics.ElevGainM,
+		&a.Metrics.ElevLossM,
+		&a.Metrics.MaxElevM,
+		&a.Metrics.MinElevM,
+		&a.Metrics.AvgHR,
+		&a.Metrics.MaxHR,
+		&a.Metrics.AvgCadence,
+		&a.Metrics.AvgPowerW,
+		&a.Metrics.NormalizedPowerW,
+		&a.Metrics.EfficiencyFactor,
+		&a.Metrics.DecouplingPct,
+		&a.Metrics.DecouplingType,
+		&a.Metrics.Steady,
+		&a.Metrics.CaloriesKcal,
+		&a.Metrics.CaloriesMethod,
+		&a.GearID,
+		&a.RouteID,
+		&a.Visibility,
+		&a.Source,
+		&a.Notes,
+		&a.Crop,
+		&a.CreatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return Activity{}, err
+	}
+	a.Metrics.ActivityDate = a.ActivityDate
+	return a, nil
+}
 
-	var activity Activity
+// scanActivityWithTrack scans activityColumns followed by a.track_points.
+func scanActivityWithTrack(row pgx.Row) (Activity, error) {
 	var trackJSON []byte
-	err := s.pool.QueryRow(ctx, query, id, userID).Scan(
-		&activity.ID,
-		&activity.UserID,
-		&activity.FileName,
-		&activity.SportType,
-		&activity.Name,
-		&activity.ActivityDate,
-		&activity.Metrics.DistanceKM,
-		&activity.Metrics.DurationSec,
-		&activity.Metrics.AvgSpeedKMH,
-		&activity.Metrics.MaxSpeedKMH,
-		&activity.Metrics.PaceMinPerKM,
-		&activity.Metrics.ElevGainM,
-		&activity.Metrics.ElevLossM,
-		&activity.Metrics.MaxElevM,
-		&activity.Metrics.MinElevM,
-		&activity.Metrics.AvgHR,
-		&activity.Metrics.MaxHR,
-		&activity.Metrics.AvgCadence,
-		&trackJSON,
-		&activity.CreatedAt,
-	)
+	activity, err := scanActivity(row, &trackJSON)
 	if err != nil {
 		return Activity{}, err
 	}
-	activity.Metrics.ActivityDate = activity.ActivityDate
-
-	if err := json.Unmarshal(trackJSON, &activity.Points); err != nil {
+	if err := unmarshalTrack(trackJSON, &activity); err != nil {
 		return Activity{}, err
 	}
-
 	return activity, nil
 }
 
+func unmarshalTrack(trackJSON []byte, activity *Activity) error {
+	return json.Unmarshal(trackJSON, &activity.Points)
+}
+
+// GetActivity returns an activity owned by userID.
+func (s *Store) GetActivity(ctx context.Context, id, userID int64) (Activity, error) {
+	query := `
+		SELECT ` + activityColumns + `, a.track_points
+		FROM activities a
+		WHERE a.id = $1 AND a.user_id = $2
+	`
+	return scanActivityWithTrack(s.pool.QueryRow(ctx, query, id, userID))
+}
+
 func (s *Store) CountActivities(ctx context.Context, userID int64) (int, error) {
 	var count int
 	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM activities WHERE user_id = $1`, userID).Scan(&count)
@@ -243,13 +367,10 @@ func (s *Store) ListActivities(ctx context.Context, userID int64, page, pageSize
 	}
 
 	query := `
-		SELECT id, user_id, file_name, sport_type, activity_name, activity_date,
-			distance_km, duration_sec, avg_speed_kmh, max_speed_kmh, pace_min_km,
-			elev_gain_m, elev_loss_m, max_elev_m, min_elev_m,
-			avg_hr, max_hr, avg_cadence, created_at
-		FROM activities
-		WHERE user_id = $1
-		ORDER BY activity_date DESC
+		SELECT ` + activityColumns + `
+		FROM activities a
+		WHERE a.user_id = $1
+		ORDER BY a.activity_date DESC
 		LIMIT $2 OFFSET $3
 	`
 
@@ -261,32 +382,10 @@ func (s *Store) ListActivities(ctx context.Context, userID int64, page, pageSize
 
 	activities := make([]Activity, 0)
 	for rows.Next() {
-		var a Activity
-		err := rows.Scan(
-			&a.ID,
-			&a.UserID,
-			&a.FileName,
-			&a.SportType,
-			&a.Name,
-			&a.ActivityDate,
-			&a.Metrics.DistanceKM,
-			&a.Metrics.DurationSec,
-			&a.Metrics.AvgSpeedKMH,
-			&a.Metrics.MaxSpeedKMH,
-			&a.Metrics.PaceMinPerKM,
-			&a.Metrics.ElevGainM,
-			&a.Metrics.ElevLossM,
-			&a.Metrics.MaxElevM,
-			&a.Metrics.MinElevM,
-			&a.Metrics.AvgHR,
-			&a.Metrics.MaxHR,
-			&a.Metrics.AvgCadence,
-			&a.CreatedAt,
-		)
+		a, err := scanActivity(rows)
 		if err != nil {
 			return PaginatedResult[Activity]{}, err
 		}
-		a.Metrics.ActivityDate = a.ActivityDate
 		activities = append(activities, a)
 	}
 	if err := rows.Err(); err != nil {
@@ -295,16 +394,49 @@ func (s *Store) ListActivities(ctx context.Context, userID int64, page, pageSize
 	return newPaginated(activities, total, page, pageSize), nil
 }
 
+// ListActivityIDs returns the ids of all of a user's activities.
+func (s *Store) ListActivityIDs(ctx context.Context, userID int64) ([]int64, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id FROM activities WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 type UserPublicStats struct {
 	ActivityCount   int     `json:"activityCount"`
 	TotalDistanceKm float64 `json:"totalDistanceKm"`
 }
 
-func (s *Store) GetUserPublicStats(ctx context.Context, userID int64) (UserPublicStats, error) {
+// GetUserPublicStats totals the activities of userID that viewerID is
+// allowed to read.
+func (s *Store) GetUserPublicStats(ctx context.Context, userID, viewerID int64) (UserPublicStats, error) {
 	var st UserPublicStats
 	err := s.pool.QueryRow(ctx,
-		`SELECT COUNT(*), COALESCE(SUM(distance_km), 0) FROM activities WHERE user_id = $1`,
-		userID,
+		`SELECT COUNT(*), COALESCE(SUM(a.distance_km), 0) FROM activities a
+		 WHERE a.user_id = $1 AND `+visibleToSQL("a", "$2"),
+		userID, viewerID,
 	).Scan(&st.ActivityCount, &st.TotalDistanceKm)
 	return st, err
 }
+
+// boxArgs returns min lon, min lat, max lon, max lat of coords as query
+// arguments for box(point($n, $n+1), point($n+2, $n+3)). They are all nil for
+// an empty track, which makes the box NULL.
+func boxArgs(coords []geo.Coord) [4]any {
+	if len(coords) == 0 {
+		return [4]any{}
+	}
+	b := geo.Bounds(coords)
+	return [4]any{b.MinLon, b.MinLat, b.MaxLon, b.MaxLat}
+}
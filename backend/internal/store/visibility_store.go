@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Activity visibility levels, from most to least restrictive.
+const (
+	VisibilityPrivate   = "private"   // owner only
+	VisibilityFollowers = "followers" // owner and users following the owner
+	VisibilityCommunity = "community" // any approved member
+	VisibilityPublic    = "public"    // anyone, including anonymous visitors
+)
+
+func ValidVisibility(v string) bool {
+	switch v {
+	case VisibilityPrivate, VisibilityFollowers, VisibilityCommunity, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// visibleToSQL returns a SQL predicate that is true when the activity aliased
+// as alias may be read by the viewer bound to viewerArg (e.g. "$2").
+// Authenticated viewers are always approved members, so "community" only
+// requires a viewer.
+func visibleToSQL(alias, viewerArg string) string {
+	return `(` + alias + `.user_id = ` + viewerArg +
+		` OR ` + alias + `.visibility IN ('community', 'public')` +
+		` OR (` + alias + `.visibility = 'followers' AND EXISTS (
+			SELECT 1 FROM follows f
+			WHERE f.follower_id = ` + viewerArg + ` AND f.followee_id = ` + alias + `.user_id)))`
+}
+
+// GetVisibleActivity returns an activity if viewerID is allowed to read it.
+// Activities the viewer cannot see are reported as ErrNotFound.
+func (s *Store) GetVisibleActivity(ctx context.Context, id, viewerID int64) (Activity, error) {
+	query := `
+		SELECT ` + activityColumns + `, a.track_points
+		FROM activities a
+		WHERE a.id = $1 AND ` + visibleToSQL("a", "$2")
+	return scanActivityWithTrack(s.pool.QueryRow(ctx, query, id, viewerID))
+}
+
+// GetPublicActivity returns an activity only if its visibility is public.
+func (s *Store) GetPublicActivity(ctx context.Context, id int64) (Activity, error) {
+	query := `
+		SELECT ` + activityColumns + `, a.track_points
+		FROM activities a
+		WHERE a.id = $1 AND a.visibility = 'public'
+	`
+	return scanActivityWithTrack(s.pool.QueryRow(ctx, query, id))
+}
+
+func (s *Store) SetActivityVisibility(ctx context.Context, id, userID int64, visibility string) error {
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE activities SET visibility = $1 WHERE id = $2 AND user_id = $3`,
+		visibility, id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ---- Follows ----
+
+type FollowCounts struct {
+	Followers int  `json:"followerCount"`
+	Following int  `json:"followingCount"`
+	IsFollow  bool `json:"isFollowing"`
+}
+
+func (s *Store) Follow(ctx context.Context, followerID, followeeID int64) error {
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO follows (follower_id, followee_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		followerID, followeeID,
+	)
+	return err
+}
+
+func (s *Store) Unfollow(ctx context.Context, followerID, followeeID int64) error {
+	_, err := s.pool.Exec(ctx,
+		`DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2`,
+		followerID, followeeID,
+	)
+	return err
+}
+
+// GetFollowCounts returns follower/following counts for userID and whether
+// viewerID follows them.
+func (s *Store) GetFollowCounts(ctx context.Context, userID, viewerID int64) (FollowCounts, error) {
+	var c FollowCounts
+	err := s.pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM follows WHERE followee_id = $1),
+			(SELECT COUNT(*) FROM follows WHERE follower_id = $1),
+			EXISTS(SELECT 1 FROM follows WHERE follower_id = $2 AND followee_id = $1)
+	`, userID, viewerID).Scan(&c.Followers, &c.Following, &c.IsFollow)
+	return c, err
+}
+
+// ListFollowingIDs returns the ids of the users userID follows.
+func (s *Store) ListFollowingIDs(ctx context.Context, userID int64) ([]int64, error) {
+	rows, err := s.pool.Query(ctx, `SELECT followee_id FROM follows WHERE follower_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ---- Share links ----
+
+type ShareLink struct {
+	ID         int64      `json:"id"`
+	ActivityID int64      `json:"activityId"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+func (s *Store) CreateShareLink(ctx context.Context, activityID, userID int64) (ShareLink, error) {
+	var l ShareLink
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO activity_share_links (activity_id, user_id)
+		SELECT id, user_id FROM activities WHERE id = $1 AND user_id = $2
+		RETURNING id, activity_id, created_at, revoked_at
+	`, activityID, userID).Scan(&l.ID, &l.ActivityID, &l.CreatedAt, &l.RevokedAt)
+	return l, err
+}
+
+func (s *Store) ListShareLinks(ctx context.Context, activityID, userID int64) ([]ShareLink, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, activity_id, created_at, revoked_at
+		FROM activity_share_links
+		WHERE activity_id = $1 AND user_id = $2
+		ORDER BY created_at DESC
+	`, activityID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]ShareLink, 0)
+	for rows.Next() {
+		var l ShareLink
+		if err := rows.Scan(&l.ID, &l.ActivityID, &l.CreatedAt, &l.RevokedAt); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+func (s *Store) RevokeShareLink(ctx context.Context, linkID, activityID, userID int64) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE activity_share_links SET revoked_at = now()
+		WHERE id = $1 AND activity_id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`, linkID, activityID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetSharedActivity returns the activity behind a non-revoked share link,
+// together with its owner's display name.
+func (s *Store) GetSharedActivity(ctx context.Context, linkID int64) (Activity, string, error) {
+	query := `
+		SELECT ` + activityColumns + `, a.track_points, CONCAT(u.first_name, ' ', u.last_name)
+		FROM activity_share_links l
+		JOIN activities a ON a.id = l.activity_id
+		JOIN users u ON u.id = a.user_id
+		WHERE l.id = $1 AND l.revoked_at IS NULL
+	`
+	var trackJSON []byte
+	var ownerName string
+	activity, err := scanActivity(s.pool.QueryRow(ctx, query, linkID), &trackJSON, &ownerName)
+	if err != nil {
+		return Activity{}, "", err
+	}
+	if err := unmarshalTrack(trackJSON, &activity); err != nil {
+		return Activity{}, "", err
+	}
+	return activity, ownerName, nil
+}
+
+// GetActivityOwnerName returns the display name of an activity's owner.
+func (s *Store) GetActivityOwnerName(ctx context.Context, activityID int64) (string, error) {
+	var name string
+	err := s.pool.QueryRow(ctx, `
+		SELECT CONCAT(u.first_name, ' ', u.last_name)
+		FROM activities a JOIN users u ON u.id = a.user_id
+		WHERE a.id = $1
+	`, activityID).Scan(&name)
+	return name, err
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"gpx-training-analyzer/backend/internal/gpx"
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+// WeightEntry is the body weight recorded on a date. It applies from that
+// date until the next entry.
+type WeightEntry struct {
+	Date     string  `json:"date"` // YYYY-MM-DD
+	WeightKg float64 `json:"weightKg"`
+}
+
+// ListWeightHistory returns the user's weight entries, oldest first.
+func (s *Store) ListWeightHistory(ctx context.Context, userID int64) ([]WeightEntry, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT to_char(recorded_on, 'YYYY-MM-DD'), weight_kg::float8
+		FROM weight_history
+		WHERE user_id = $1
+		ORDER BY recorded_on
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []WeightEntry{}
+	for rows.Next() {
+		var e WeightEntry
+		if err := rows.Scan(&e.Date, &e.WeightKg); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// SaveWeightEntry records a weight on a date, replacing any entry for that
+// date. When the date is the latest in the history the profile's current
+// weight follows it.
+func (s *Store) SaveWeightEntry(ctx context.Context, userID int64, e WeightEntry) error {
+	_, err := s.pool.Exec(ctx, `
+		WITH saved AS (
+			INSERT INTO weight_history (user_id, recorded_on, weight_kg) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, recorded_on) DO UPDATE SET weight_kg = EXCLUDED.weight_kg
+		)
+		UPDATE athlete_profiles SET weight_kg = $3, updated_at = now()
+		WHERE user_id = $1
+		  AND NOT EXISTS (SELECT 1 FROM weight_history WHERE user_id = $1 AND recorded_on > $2)
+	`, userID, e.Date, e.WeightKg)
+	return err
+}
+
+func (s *Store) DeleteWeightEntry(ctx context.Context, userID int64, date string) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM weight_history WHERE user_id = $1 AND recorded_on = $2`, userID, date)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CalorieInput is what recomputing an activity's calories needs.
+type CalorieInput struct {
+	ID           int64
+	SportType    string
+	ActivityDate time.Time
+	Metrics      metrics.Result
+	Points       []gpx.Point
+}
+
+// ListCalorieInputs returns up to limit of the user's activities with id
+// greater than afterID, optionally only those on or after from.
+func (s *Store) ListCalorieInputs(ctx context.Context, userID int64, from *time.Time, afterID int64, limit int) ([]CalorieInput, error) {
+	args := []any{userID, afterID, limit}
+	where := `a.user_id = $1 AND a.id > $2`
+	if from != nil {
+		args = append(args, *from)
+		where += ` AND a.activity_date >= $` + itoa(len(args))
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+activityColumns+`, a.track_points
+		FROM activities a
+		WHERE `+where+`
+		ORDER BY a.id
+		LIMIT $3
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inputs []CalorieInput
+	for rows.Next() {
+		a, err := scanActivityWithTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, CalorieInput{
+			ID:           a.ID,
+			SportType:    a.SportType,
+			ActivityDate: a.ActivityDate,
+			Metrics:      a.Metrics,
+			Points:       a.Points,
+		})
+	}
+	return inputs, rows.Err()
+}
+
+func (s *Store) SaveActivityCalories(ctx context.Context, activityID int64, e metrics.Energy) error {
+	_, err := s.pool.Exec(ctx,
+		`UPDATE activities SET calories_kcal = $2, calories_method = $3 WHERE id = $1`,
+		activityID, e.Kcal, e.Method)
+	return err
+}
@@ -0,0 +1,175 @@
+// Package units converts the canonical SI values the store keeps into the
+// unit system an athlete prefers. Conversion only happens on the way out;
+// nothing in the database is stored in imperial units.
+package units
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+type System string
+
+const (
+	Metric   System = "metric"
+	Imperial System = "imperial"
+)
+
+// How speed is shown for a sport.
+const (
+	ShowPace  = "pace"
+	ShowSpeed = "speed"
+)
+
+const (
+	kmPerMile  = 1.609344
+	feetPerM   = 3.28084
+	defaultSys = Metric
+)
+
+// ParseSystem accepts "metric" or "imperial", case-insensitively.
+func ParseSystem(s string) (System, bool) {
+	switch System(strings.ToLower(strings.TrimSpace(s))) {
+	case Metric:
+		return Metric, true
+	case Imperial:
+		return Imperial, true
+	}
+	return "", false
+}
+
+// Preferences is an athlete's unit system plus, per sport type, whether to
+// show pace or speed.
+type Preferences struct {
+	System       System            `json:"unitSystem"`
+	SportDisplay map[string]string `json:"sportDisplay"`
+}
+
+// Display returns ShowPace or ShowSpeed for a sport: the athlete's choice if
+// set, otherwise pace for running, walking and hiking and speed for the rest.
+func (p Preferences) Display(sportType string) string {
+	if d := p.SportDisplay[sportType]; d == ShowPace || d == ShowSpeed {
+		return d
+	}
+	s := strings.ToLower(sportType)
+	if strings.Contains(s, "run") || strings.Contains(s, "walk") || strings.Contains(s, "hik") {
+		return ShowPace
+	}
+	return ShowSpeed
+}
+
+func (p Preferences) system() System {
+	if p.System == Imperial {
+		return Imperial
+	}
+	return defaultSys
+}
+
+// Quantity is a value with its unit label.
+type Quantity struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// Distance converts kilometres.
+func Distance(km float64, s System) Quantity {
+	if s == Imperial {
+		return Quantity{round(km / kmPerMile), "mi"}
+	}
+	return Quantity{round(km), "km"}
+}
+
+// Elevation converts metres.
+func Elevation(m float64, s System) Quantity {
+	if s == Imperial {
+		return Quantity{round(m * feetPerM), "ft"}
+	}
+	return Quantity{round(m), "m"}
+}
+
+// Speed converts km/h.
+func Speed(kmh float64, s System) Quantity {
+	if s == Imperial {
+		return Quantity{round(kmh / kmPerMile), "mph"}
+	}
+	return Quantity{round(kmh), "km/h"}
+}
+
+// Pace converts minutes per kilometre.
+func Pace(minPerKM float64, s System) Quantity {
+	if s == Imperial {
+		return Quantity{round(minPerKM * kmPerMile), "min/mi"}
+	}
+	return Quantity{round(minPerKM), "min/km"}
+}
+
+// Summary is an activity's headline metrics in the requested units.
+type Summary struct {
+	Units    System    `json:"units"`
+	Distance Quantity  `json:"distance"`
+	Pace     *Quantity `json:"pace,omitempty"`
+	AvgSpeed *Quantity `json:"avgSpeed,omitempty"`
+	MaxSpeed Quantity  `json:"maxSpeed"`
+	ElevGain Quantity  `json:"elevGain"`
+	ElevLoss Quantity  `json:"elevLoss"`
+	MaxElev  Quantity  `json:"maxElev"`
+	MinElev  Quantity  `json:"minElev"`
+}
+
+// Summarize converts a Result. Pace or average speed is included according
+// to the sport's display preference.
+func Summarize(r metrics.Result, sportType string, p Preferences) Summary {
+	s := p.system()
+	out := Summary{
+		Units:    s,
+		Distance: Distance(r.DistanceKM, s),
+		MaxSpeed: Speed(r.MaxSpeedKMH, s),
+		ElevGain: Elevation(r.ElevGainM, s),
+		ElevLoss: Elevation(r.ElevLossM, s),
+		MaxElev:  Elevation(r.MaxElevM, s),
+		MinElev:  Elevation(r.MinElevM, s),
+	}
+	if p.Display(sportType) == ShowPace {
+		pace := Pace(r.PaceMinPerKM, s)
+		out.Pace = &pace
+	} else {
+		speed := Speed(r.AvgSpeedKMH, s)
+		out.AvgSpeed = &speed
+	}
+	return out
+}
+
+// FormatDistance formats kilometres for text, e.g. "12.4 mi".
+func FormatDistance(km float64, s System, decimals int) string {
+	q := Distance(km, s)
+	return fmt.Sprintf("%.*f %s", decimals, q.Value, q.Unit)
+}
+
+// FormatElevation formats metres for text, e.g. "4101 ft".
+func FormatElevation(m float64, s System) string {
+	q := Elevation(m, s)
+	return fmt.Sprintf("%.0f %s", q.Value, q.Unit)
+}
+
+// FormatSpeed formats km/h for text, e.g. "18.2 mph".
+func FormatSpeed(kmh float64, s System) string {
+	q := Speed(kmh, s)
+	return fmt.Sprintf("%.1f %s", q.Value, q.Unit)
+}
+
+// FormatPace formats minutes per kilometre as m:ss per unit, e.g. "8:03 /mi".
+func FormatPace(minPerKM float64, s System) string {
+	perUnit, unit := minPerKM, "/km"
+	if s == Imperial {
+		perUnit, unit = minPerKM*kmPerMile, "/mi"
+	}
+	sec := int(perUnit*60 + 0.5)
+	return fmt.Sprintf("%d:%02d %s", sec/60, sec%60, unit)
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,59 @@
+package units
+
+import (
+	"testing"
+
+	"gpx-training-analyzer/backend/internal/metrics"
+)
+
+func TestConversions(t *testing.T) {
+	if q := Distance(42.195, Imperial); q.Value != 26.22 || q.Unit != "mi" {
+		t.Fatalf("expected 26.22 mi, got %+v", q)
+	}
+	if q := Elevation(1000, Imperial); q.Value != 3280.84 || q.Unit != "ft" {
+		t.Fatalf("expected 3280.84 ft, got %+v", q)
+	}
+	if q := Speed(30, Imperial); q.Value != 18.64 || q.Unit != "mph" {
+		t.Fatalf("expected 18.64 mph, got %+v", q)
+	}
+	if q := Pace(5, Metric); q.Value != 5 || q.Unit != "min/km" {
+		t.Fatalf("expected 5 min/km, got %+v", q)
+	}
+	if got := FormatPace(5, Imperial); got != "8:03 /mi" {
+		t.Fatalf("expected 8:03 /mi, got %q", got)
+	}
+	if got := FormatDistance(10, Imperial, 1); got != "6.2 mi" {
+		t.Fatalf("expected 6.2 mi, got %q", got)
+	}
+}
+
+func TestSummarize_FollowsSportDisplay(t *testing.T) {
+	r := metrics.Result{DistanceKM: 10, AvgSpeedKMH: 12, PaceMinPerKM: 5, ElevGainM: 100}
+
+	run := Summarize(r, "running", Preferences{System: Imperial})
+	if run.Pace == nil || run.AvgSpeed != nil || run.Pace.Unit != "min/mi" {
+		t.Fatalf("expected pace in min/mi for a run, got %+v", run)
+	}
+	if run.Distance.Unit != "mi" || run.ElevGain.Unit != "ft" || run.Units != Imperial {
+		t.Fatalf("expected imperial units, got %+v", run)
+	}
+
+	ride := Summarize(r, "cycling", Preferences{})
+	if ride.AvgSpeed == nil || ride.Pace != nil || ride.AvgSpeed.Unit != "km/h" || ride.Units != Metric {
+		t.Fatalf("expected metric speed for a ride, got %+v", ride)
+	}
+
+	prefs := Preferences{System: Metric, SportDisplay: map[string]string{"cycling": ShowPace}}
+	if got := Summarize(r, "cycling", prefs); got.Pace == nil {
+		t.Fatalf("expected the sport preference to show pace, got %+v", got)
+	}
+}
+
+func TestParseSystem(t *testing.T) {
+	if s, ok := ParseSystem(" Imperial "); !ok || s != Imperial {
+		t.Fatalf("expected imperial, got %q %v", s, ok)
+	}
+	if _, ok := ParseSystem("furlongs"); ok {
+		t.Fatal("expected unknown systems to be rejected")
+	}
+}
@@ -0,0 +1,36 @@
+-- 014_gear.sql
+-- Gear tracking: shoes, bikes and other equipment with mileage totals.
+
+CREATE TABLE IF NOT EXISTS gear (
+    id                     BIGSERIAL PRIMARY KEY,
+    user_id                BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    gear_type              TEXT NOT NULL CHECK (gear_type IN ('shoes', 'bike', 'other')),
+    name                   TEXT NOT NULL,
+    brand                  TEXT NOT NULL DEFAULT '',
+    model                  TEXT NOT NULL DEFAULT '',
+    start_distance_km      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    retire_at_km           DOUBLE PRECISION,                       -- NULL = no retirement threshold
+    retired                BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Totals maintained from assigned activities (see refreshGearTotals).
+    activity_count         INTEGER NOT NULL DEFAULT 0,
+    tracked_distance_km    DOUBLE PRECISION NOT NULL DEFAULT 0,
+    tracked_duration_sec   BIGINT NOT NULL DEFAULT 0,
+    retirement_notified_at TIMESTAMPTZ,
+    created_at             TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at             TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_gear_user_id ON gear(user_id);
+
+-- Default gear applied automatically on upload, one per (user, sport type).
+CREATE TABLE IF NOT EXISTS gear_defaults (
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    sport_type TEXT NOT NULL,
+    gear_id    BIGINT NOT NULL REFERENCES gear(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, sport_type)
+);
+
+ALTER TABLE activities
+    ADD COLUMN IF NOT EXISTS gear_id BIGINT REFERENCES gear(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_activities_gear_id ON activities(gear_id) WHERE gear_id IS NOT NULL;
@@ -0,0 +1,32 @@
+-- 015_activity_visibility.sql
+-- Per-activity visibility, follower relationships and revocable share links.
+
+ALTER TABLE activities
+    ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'private'
+        CHECK (visibility IN ('private', 'followers', 'community', 'public'));
+
+ALTER TABLE athlete_profiles
+    ADD COLUMN IF NOT EXISTS default_activity_visibility TEXT NOT NULL DEFAULT 'private'
+        CHECK (default_activity_visibility IN ('private', 'followers', 'community', 'public'));
+
+-- follower_id follows followee_id; "followers" visibility is granted to follower_id.
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    followee_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_follows_followee ON follows(followee_id);
+
+-- Share links are signed with JWT_SECRET; the row exists so a link can be revoked.
+CREATE TABLE IF NOT EXISTS activity_share_links (
+    id          BIGSERIAL PRIMARY KEY,
+    activity_id BIGINT NOT NULL REFERENCES activities(id) ON DELETE CASCADE,
+    user_id     BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_activity_share_links_activity ON activity_share_links(activity_id);
@@ -0,0 +1,24 @@
+-- 016_routes.sql
+-- Repeated routes: activities clustered by geometric similarity.
+
+CREATE TABLE IF NOT EXISTS routes (
+    id             BIGSERIAL PRIMARY KEY,
+    user_id        BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name           TEXT NOT NULL,
+    sport_type     TEXT NOT NULL,
+    distance_km    DOUBLE PRECISION NOT NULL,
+    start_lat      DOUBLE PRECISION NOT NULL,
+    start_lon      DOUBLE PRECISION NOT NULL,
+    end_lat        DOUBLE PRECISION NOT NULL,
+    end_lon        DOUBLE PRECISION NOT NULL,
+    shape          JSONB NOT NULL,   -- simplified reference track [{lat, lon}, …]
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_routes_user_start ON routes(user_id, start_lat, start_lon);
+
+ALTER TABLE activities
+    ADD COLUMN IF NOT EXISTS route_id BIGINT REFERENCES routes(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_activities_route_id ON activities(route_id, activity_date) WHERE route_id IS NOT NULL;
@@ -0,0 +1,54 @@
+-- 017_segments.sql
+-- User-defined segments and the efforts matched against them.
+-- Bounding boxes use the built-in box type (x = lon, y = lat) with GiST
+-- indexes so matching only looks at segments that overlap an activity.
+
+CREATE TABLE IF NOT EXISTS segments (
+    id                 BIGSERIAL PRIMARY KEY,
+    user_id            BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source_activity_id BIGINT REFERENCES activities(id) ON DELETE SET NULL,
+    name               TEXT NOT NULL,
+    sport_type         TEXT NOT NULL,
+    distance_m         DOUBLE PRECISION NOT NULL,
+    elev_gain_m        DOUBLE PRECISION NOT NULL DEFAULT 0,
+    start_lat          DOUBLE PRECISION NOT NULL,
+    start_lon          DOUBLE PRECISION NOT NULL,
+    end_lat            DOUBLE PRECISION NOT NULL,
+    end_lon            DOUBLE PRECISION NOT NULL,
+    path               JSONB NOT NULL,   -- simplified path [{lat, lon}, …]
+    bounds             BOX NOT NULL,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_segments_bounds ON segments USING gist (bounds);
+
+CREATE TABLE IF NOT EXISTS segment_efforts (
+    id            BIGSERIAL PRIMARY KEY,
+    segment_id    BIGINT NOT NULL REFERENCES segments(id) ON DELETE CASCADE,
+    activity_id   BIGINT NOT NULL REFERENCES activities(id) ON DELETE CASCADE,
+    user_id       BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    elapsed_sec   DOUBLE PRECISION NOT NULL,
+    start_index   INT NOT NULL,
+    end_index     INT NOT NULL,
+    activity_date TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (segment_id, activity_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_segment_efforts_segment ON segment_efforts(segment_id, elapsed_sec);
+CREATE INDEX IF NOT EXISTS idx_segment_efforts_activity ON segment_efforts(activity_id);
+
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS bounds BOX;
+
+UPDATE activities a SET bounds = b.bounds
+FROM (
+    SELECT id, box(
+        point(MIN((p->>'lon')::float8), MIN((p->>'lat')::float8)),
+        point(MAX((p->>'lon')::float8), MAX((p->>'lat')::float8))
+    ) AS bounds
+    FROM activities, jsonb_array_elements(track_points) p
+    GROUP BY id
+) b
+WHERE a.id = b.id AND a.bounds IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_activities_bounds ON activities USING gist (bounds);
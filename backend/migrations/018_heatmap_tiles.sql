@@ -0,0 +1,14 @@
+-- 018_heatmap_tiles.sql
+-- Rendered personal heatmap tiles. A tile is invalidated when an activity
+-- whose bounding box overlaps it changes.
+
+CREATE TABLE IF NOT EXISTS heatmap_tiles (
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tile_key   TEXT NOT NULL,    -- z/x/y plus the filter, e.g. "12/2035/1631?sport=run"
+    bounds     BOX NOT NULL,
+    png        BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, tile_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_heatmap_tiles_bounds ON heatmap_tiles USING gist (bounds);
@@ -0,0 +1,9 @@
+-- 019_activity_intervals.sql
+-- Interval structure corrected by the athlete. Activities without a row use
+-- automatic detection.
+
+CREATE TABLE IF NOT EXISTS activity_intervals (
+    activity_id BIGINT PRIMARY KEY REFERENCES activities(id) ON DELETE CASCADE,
+    reps        JSONB NOT NULL,   -- [{startIndex, endIndex, kind}, …]
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,15 @@
+-- 020_aerobic_metrics.sql
+-- Power, efficiency factor and aerobic decoupling per activity. Rows that
+-- predate this migration have aerobic_computed = false and are filled in from
+-- their track the first time the owner requests the aerobic trend.
+
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS avg_power_w        DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS normalized_power_w DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS efficiency_factor  DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS decoupling_pct     DOUBLE PRECISION;
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS decoupling_type    TEXT NOT NULL DEFAULT '';
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS steady             BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS aerobic_computed   BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_activities_user_steady
+    ON activities(user_id, activity_date) WHERE steady AND efficiency_factor > 0;
@@ -0,0 +1,22 @@
+-- 021_calories_weight_history.sql
+-- Estimated energy expenditure per activity, and body weight as a dated
+-- history so older activities use the weight in force on their date.
+
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS calories_kcal   DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS calories_method TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS weight_history (
+    user_id     BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recorded_on DATE NOT NULL,
+    weight_kg   NUMERIC(5,1) NOT NULL CHECK (weight_kg > 0),
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, recorded_on)
+);
+
+-- Seed the history with the current profile weight, dated when the profile
+-- was created.
+INSERT INTO weight_history (user_id, recorded_on, weight_kg)
+SELECT user_id, created_at::date, weight_kg
+FROM athlete_profiles
+WHERE weight_kg IS NOT NULL AND weight_kg > 0
+ON CONFLICT DO NOTHING;
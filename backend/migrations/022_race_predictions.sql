@@ -0,0 +1,17 @@
+-- 022_race_predictions.sql
+-- Daily snapshots of each runner's VDOT and predicted race times, so fitness
+-- can be followed over time. Recomputed after every run upload.
+
+CREATE TABLE IF NOT EXISTS race_predictions (
+    user_id               BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    computed_on           DATE NOT NULL,
+    window_days           INTEGER NOT NULL,
+    vdot                  DOUBLE PRECISION NOT NULL DEFAULT 0,
+    sparse                BOOLEAN NOT NULL,
+    sparse_reason         TEXT NOT NULL DEFAULT '',
+    reference_activity_id BIGINT REFERENCES activities(id) ON DELETE SET NULL,
+    reference_effort      JSONB,
+    predictions           JSONB NOT NULL DEFAULT '[]',
+    updated_at            TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, computed_on)
+);
@@ -0,0 +1,8 @@
+-- 023_unit_preferences.sql
+-- Display units per athlete. Values are always stored in SI units; these only
+-- affect how API responses, exports and messages present them.
+
+ALTER TABLE athlete_profiles ADD COLUMN IF NOT EXISTS unit_system TEXT NOT NULL DEFAULT 'metric'
+    CHECK (unit_system IN ('metric', 'imperial'));
+-- sport type → 'pace' | 'speed'
+ALTER TABLE athlete_profiles ADD COLUMN IF NOT EXISTS sport_display JSONB NOT NULL DEFAULT '{}';
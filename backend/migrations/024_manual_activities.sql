@@ -0,0 +1,6 @@
+-- 024_manual_activities.sql
+-- Activities entered by hand (swims, gym sessions, treadmill and trainer
+-- workouts) have no track: track_points is an empty array and bounds is NULL.
+
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'file';
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS notes  TEXT NOT NULL DEFAULT '';
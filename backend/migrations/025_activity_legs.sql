@@ -0,0 +1,11 @@
+-- 025_activity_legs.sql
+-- Sport changes of multisport activities (swim, T1, bike, T2, run), read from
+-- the uploaded file or set by the athlete. Leg metrics are computed from the
+-- track when read.
+
+CREATE TABLE IF NOT EXISTS activity_legs (
+    activity_id BIGINT PRIMARY KEY REFERENCES activities(id) ON DELETE CASCADE,
+    legs        JSONB NOT NULL,                 -- [{sport, start}, …]
+    source      TEXT NOT NULL DEFAULT 'file',   -- file | manual
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,15 @@
+-- 026_activity_archive.sql
+-- Activities replaced by a merge or split, kept whole so they can be
+-- restored under their original id.
+
+CREATE TABLE IF NOT EXISTS archived_activities (
+    id          BIGINT PRIMARY KEY,         -- the original activity id
+    user_id     BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    activity    JSONB NOT NULL,             -- the activity with its track
+    reason      TEXT NOT NULL,              -- merge | split
+    replaced_by BIGINT[] NOT NULL,          -- activities made from it
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_archived_activities_replaced_by
+    ON archived_activities USING GIN (replaced_by);
@@ -0,0 +1,6 @@
+-- 027_activity_crop.sql
+-- Non-destructive cropping: track_points holds the kept range and
+-- original_points the track as uploaded, until the crop is removed.
+
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS original_points JSONB;
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS crop JSONB;  -- {by, start, end, startIndex, endIndex}
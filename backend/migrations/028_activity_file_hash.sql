@@ -0,0 +1,7 @@
+-- 028_activity_file_hash.sql
+-- SHA-256 of the uploaded file, so bulk imports can skip files imported
+-- before.
+
+ALTER TABLE activities ADD COLUMN IF NOT EXISTS file_sha256 TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_activities_user_file_sha256 ON activities (user_id, file_sha256);
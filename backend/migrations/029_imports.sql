@@ -0,0 +1,22 @@
+-- 029_imports.sql
+-- Strava and Garmin account export imports, with their progress so the
+-- athlete can follow a long import.
+
+CREATE TABLE IF NOT EXISTS imports (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_name   TEXT NOT NULL,
+    format      TEXT NOT NULL DEFAULT '',          -- strava | garmin | files
+    status      TEXT NOT NULL DEFAULT 'pending',   -- pending | running | done | failed
+    total       INT NOT NULL DEFAULT 0,
+    processed   INT NOT NULL DEFAULT 0,
+    created     INT NOT NULL DEFAULT 0,
+    duplicates  INT NOT NULL DEFAULT 0,
+    failed      INT NOT NULL DEFAULT 0,
+    results     JSONB NOT NULL DEFAULT '[]',       -- per-activity outcomes
+    error       TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_imports_user ON imports (user_id, created_at DESC);
@@ -0,0 +1,28 @@
+-- 030_jobs.sql
+-- Background job queue. Workers claim due jobs with SKIP LOCKED; failed
+-- jobs are retried with backoff until max_attempts, then left dead for
+-- inspection.
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind         TEXT NOT NULL,                     -- upload | segment_match
+    status       TEXT NOT NULL DEFAULT 'queued',    -- queued | running | succeeded | failed | dead
+    payload      JSONB NOT NULL DEFAULT '{}',
+    data         BYTEA,                             -- the uploaded file, dropped once the job ends
+    attempts     INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 5,
+    run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_at    TIMESTAMPTZ,
+    progress     INT NOT NULL DEFAULT 0,            -- percent
+    stage        TEXT NOT NULL DEFAULT '',
+    last_error   TEXT NOT NULL DEFAULT '',
+    activity_id  BIGINT REFERENCES activities(id) ON DELETE SET NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs (run_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_jobs_running ON jobs (locked_at) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_jobs_user ON jobs (user_id, created_at DESC);
@@ -0,0 +1,18 @@
+-- 031_idempotency_keys.sql
+-- Responses to POST requests sent with an Idempotency-Key, replayed when a
+-- client retries the same request.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id          BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key              TEXT NOT NULL,
+    fingerprint      TEXT NOT NULL,             -- SHA-256 of method, path and body
+    completed        BOOLEAN NOT NULL DEFAULT false,
+    response_status  INT,
+    response_headers JSONB,
+    response_body    BYTEA,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at       TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys (expires_at);
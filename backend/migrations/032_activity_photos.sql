@@ -0,0 +1,24 @@
+-- 032_activity_photos.sql
+-- Photos attached to activities. Files live in blob storage; the row keeps
+-- what EXIF said and where on the track the photo was placed.
+
+CREATE TABLE IF NOT EXISTS activity_photos (
+    id           BIGSERIAL PRIMARY KEY,
+    activity_id  BIGINT NOT NULL REFERENCES activities(id) ON DELETE CASCADE,
+    user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    caption      TEXT NOT NULL DEFAULT '',
+    content_type TEXT NOT NULL,                 -- image/jpeg | image/png
+    width        INT NOT NULL,
+    height       INT NOT NULL,
+    taken_at     TIMESTAMPTZ,                   -- EXIF time
+    taken_zoned  BOOLEAN NOT NULL DEFAULT false, -- false: local clock reading stored as UTC
+    exif_lat     DOUBLE PRECISION,              -- EXIF position, never shown publicly
+    exif_lon     DOUBLE PRECISION,
+    point_index  INT,                           -- track point the photo is shown at
+    lat          DOUBLE PRECISION,              -- that point's position
+    lon          DOUBLE PRECISION,
+    placed_by    TEXT NOT NULL DEFAULT '',      -- time | gps | '' when not placed
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_activity_photos_activity ON activity_photos (activity_id, id);
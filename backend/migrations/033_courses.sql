@@ -0,0 +1,22 @@
+-- 033_courses.sql
+-- Planned courses: drawn through waypoints or copied from an activity's track,
+-- exported to devices as GPX or TCX.
+
+CREATE TABLE IF NOT EXISTS courses (
+    id          BIGSERIAL PRIMARY KEY,
+    user_id     BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name        TEXT NOT NULL,
+    sport_type  TEXT NOT NULL,
+    activity_id BIGINT REFERENCES activities(id) ON DELETE SET NULL, -- copied from this activity's track
+    waypoints   JSONB NOT NULL DEFAULT '[]',  -- drawn courses only
+    points      JSONB NOT NULL,               -- the course track, without times
+    distance_km DOUBLE PRECISION NOT NULL,
+    elev_gain_m DOUBLE PRECISION NOT NULL,
+    elev_loss_m DOUBLE PRECISION NOT NULL,
+    min_elev_m  DOUBLE PRECISION NOT NULL,
+    max_elev_m  DOUBLE PRECISION NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_courses_user ON courses (user_id, updated_at DESC);
@@ -0,0 +1,18 @@
+-- 034_course_attempts.sql
+-- Activities linked to the course they were meant to follow, with the summary
+-- of how closely they did. The point-by-point comparison is computed on read.
+
+CREATE TABLE IF NOT EXISTS course_attempts (
+    activity_id         BIGINT PRIMARY KEY REFERENCES activities(id) ON DELETE CASCADE,
+    course_id           BIGINT NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+    user_id             BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    completed_pct       DOUBLE PRECISION NOT NULL,
+    covered_km          DOUBLE PRECISION NOT NULL,
+    off_course_km       DOUBLE PRECISION NOT NULL,
+    off_course_sections INT NOT NULL,
+    max_deviation_m     DOUBLE PRECISION NOT NULL,
+    linked_at           TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_course_attempts_course ON course_attempts (course_id);
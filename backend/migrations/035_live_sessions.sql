@@ -0,0 +1,35 @@
+-- 035_live_sessions.sql
+-- Live tracking. A device posts batches of fixes to a session; the summary
+-- columns are kept up to date so followers read one row, and the batches are
+-- kept in order until the session ends and is queued for import as a GPX file.
+
+CREATE TABLE IF NOT EXISTS live_sessions (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    sport_type   TEXT NOT NULL,
+    visibility   TEXT NOT NULL DEFAULT '',
+    course_id    BIGINT REFERENCES courses(id) ON DELETE SET NULL,
+    target_km    DOUBLE PRECISION,
+    status       TEXT NOT NULL DEFAULT 'active',    -- active | finished | discarded
+    point_count  INT NOT NULL DEFAULT 0,
+    distance_m   DOUBLE PRECISION NOT NULL DEFAULT 0,
+    started_at   TIMESTAMPTZ,
+    last_point   JSONB,
+    batches      INT NOT NULL DEFAULT 0,
+    job_id       BIGINT REFERENCES jobs(id) ON DELETE SET NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_live_sessions_user ON live_sessions (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_live_sessions_active ON live_sessions (updated_at) WHERE status = 'active';
+
+CREATE TABLE IF NOT EXISTS live_points (
+    session_id  BIGINT NOT NULL REFERENCES live_sessions(id) ON DELETE CASCADE,
+    seq         INT NOT NULL,
+    points      JSONB NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (session_id, seq)
+);
@@ -0,0 +1,17 @@
+-- 036_import_jobs.sql
+-- Bulk uploads and account imports run as one job per file. An import's
+-- progress is counted from its jobs; results only keeps the files turned
+-- down before any job was queued. Account exports are kept in blob storage
+-- under archive_key until their last job ends.
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS import_id BIGINT REFERENCES imports(id) ON DELETE CASCADE;
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS duplicate BOOLEAN NOT NULL DEFAULT false;  -- succeeded on a file imported before
+
+CREATE INDEX IF NOT EXISTS idx_jobs_import ON jobs (import_id) WHERE import_id IS NOT NULL;
+
+ALTER TABLE imports ADD COLUMN IF NOT EXISTS archive_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE imports DROP COLUMN IF EXISTS total;
+ALTER TABLE imports DROP COLUMN IF EXISTS processed;
+ALTER TABLE imports DROP COLUMN IF EXISTS created;
+ALTER TABLE imports DROP COLUMN IF EXISTS duplicates;
+ALTER TABLE imports DROP COLUMN IF EXISTS failed;
@@ -0,0 +1,5 @@
+-- 037_job_data_retention.sql
+-- Jobs drop their data once they end, failed or dead as well as succeeded.
+-- Clear what ended jobs kept before.
+
+UPDATE jobs SET data = NULL WHERE status IN ('failed', 'dead') AND data IS NOT NULL;
@@ -0,0 +1,9 @@
+-- 038_job_dedupe.sql
+-- Work asked for again while it is still queued, such as a recompute after
+-- each file of a bulk import, joins the queued job instead of adding one.
+-- The key is cleared once a worker claims the job.
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS dedupe_key TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_dedupe ON jobs (user_id, kind, dedupe_key)
+    WHERE status = 'queued' AND dedupe_key IS NOT NULL;
@@ -0,0 +1,7 @@
+-- 039_idempotency_lease.sql
+-- A request holds its idempotency key under a short lease that it renews
+-- while it runs. A retry takes over a key whose request stopped renewing,
+-- for instance because the server crashed, instead of waiting for it to
+-- expire.
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,8 @@
+-- 040_activity_archive_rows.sql
+-- An archived activity keeps its row as stored, so that a restore brings back
+-- every column, and the rows deleting it would drop or clear: share links,
+-- interval corrections, legs and course attempts, and the posts, segments
+-- and courses pointing at it.
+
+ALTER TABLE archived_activities ADD COLUMN IF NOT EXISTS activity_row JSONB;  -- to_jsonb of the activities row
+ALTER TABLE archived_activities ADD COLUMN IF NOT EXISTS dependents JSONB;    -- {rows: {table: [...]}, references: {"table.column": [ids]}}
@@ -36,8 +36,8 @@ export default function UploadPage() {
   const currentStep = result ? 3 : file ? 2 : 1;
 
   const handleFile = (f: File) => {
-    if (!f.name.toLowerCase().endsWith(".gpx")) {
-      setError("Only .gpx files are accepted.");
+    if (!/\.(gpx|tcx|fit)$/i.test(f.name)) {
+      setError("Only .gpx, .tcx and .fit files are accepted.");
       return;
     }
     setFile(f);
@@ -187,7 +187,7 @@ export default function UploadPage() {
                     <p className="text-xs text-muted-foreground">or click to browse</p>
                   </>
                 )}
-                <input ref={fileRef} type="file" accept=".gpx" className="hidden" onChange={(e) => e.target.files?.[0] && handleFile(e.target.files[0])} />
+                <input ref={fileRef} type="file" accept=".gpx,.tcx,.fit" className="hidden" onChange={(e) => e.target.files?.[0] && handleFile(e.target.files[0])} />
               </div>
 
               {/* Sport type toggle */}
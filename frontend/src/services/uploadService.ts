@@ -1,5 +1,5 @@
 import { Activity, SportType } from "@/types";
-import { getToken, parseApiError } from "./api";
+import { apiFetch, getToken, parseApiError } from "./api";
 
 type BackendMetrics = {
   distanceKm: number;
@@ -41,7 +41,31 @@ function mapActivity(a: BackendActivity): Activity {
   };
 }
 
+type BackendJob = {
+  id: number;
+  status: "queued" | "running" | "succeeded" | "failed" | "dead";
+  progress: number;
+  error?: string;
+  activity?: BackendActivity;
+};
+
 const API_BASE = import.meta.env.VITE_API_BASE ?? "";
+const JOB_POLL_MS = 1000;
+
+// Uploads are processed in the background; poll the job until it ends.
+async function waitForJob(jobId: number, onProgress: (pct: number) => void): Promise<BackendActivity> {
+  for (;;) {
+    const job = await apiFetch<BackendJob>(`/api/jobs/${jobId}`, undefined, true);
+    if (job.status === "succeeded" && job.activity) {
+      return job.activity;
+    }
+    if (job.status === "failed" || job.status === "dead") {
+      throw new Error(job.error || "Upload processing failed");
+    }
+    onProgress(50 + Math.round(job.progress * 0.45));
+    await new Promise((resolve) => window.setTimeout(resolve, JOB_POLL_MS));
+  }
+}
 
 export const uploadService = {
   async uploadGPX(file: File, sportType: SportType, onProgress: (pct: number) => void): Promise<Activity> {
@@ -58,7 +82,7 @@ export const uploadService = {
     onProgress(currentProgress);
 
     const progressTimer = window.setInterval(() => {
-      currentProgress = Math.min(currentProgress + 7, 90);
+      currentProgress = Math.min(currentProgress + 7, 45);
       onProgress(currentProgress);
     }, 180);
 
@@ -76,8 +100,10 @@ export const uploadService = {
       throw await parseApiError(res);
     }
 
+    const job = (await res.json()) as BackendJob;
+    onProgress(50);
+    const activity = await waitForJob(job.id, onProgress);
     onProgress(100);
-    const activity = (await res.json()) as BackendActivity;
     return mapActivity(activity);
   },
 };